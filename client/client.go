@@ -16,8 +16,14 @@
 package client // import "tapr.space/client"
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"hash"
 	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
 
 	pb "github.com/golang/protobuf/proto"
 
@@ -32,6 +38,9 @@ import (
 type Client struct {
 	config tapr.Config
 	client rpc.Client
+
+	// user and host owning the transactions of the client
+	owner string
 }
 
 var _ tapr.Client = (*Client)(nil)
@@ -39,7 +48,7 @@ var _ tapr.Client = (*Client)(nil)
 // New creates a Client that uses the given configuration to
 // access the various Tapr servers.
 func New(config tapr.Config) tapr.Client {
-	cl := &Client{config: config}
+	cl := &Client{config: config, owner: owner()}
 
 	client, err := rpc.NewClient(config, "localhost:8080")
 	if err != nil {
@@ -51,6 +60,21 @@ func New(config tapr.Config) tapr.Client {
 	return cl
 }
 
+// owner returns the user and host running the client.
+func owner() string {
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return username + "@" + host
+}
+
 // Stat implements tapr.Client.
 func (c *Client) Stat(name tapr.PathName) (*tapr.FileInfo, error) {
 	statReq := &proto.StatRequest{
@@ -64,7 +88,71 @@ func (c *Client) Stat(name tapr.PathName) (*tapr.FileInfo, error) {
 
 	log.Debug.Print("client: stat ok")
 
-	return &tapr.FileInfo{Size: statResp.Size}, nil
+	return proto.TaprFileInfo(&statResp), nil
+}
+
+// List implements tapr.Client.
+func (c *Client) List(prefix tapr.PathName, recursive bool, glob string) ([]*tapr.FileInfo, error) {
+	listReq := &proto.ListRequest{
+		Prefix:    string(prefix),
+		Recursive: recursive,
+		Glob:      glob,
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	stream := make(rpc.ListStream)
+
+	if err := c.client.Receive("io/list", listReq, stream, done); err != nil {
+		return nil, err
+	}
+
+	var fis []*tapr.FileInfo
+	for ent := range stream {
+		if ent.Error != nil {
+			return nil, errors.UnmarshalError(ent.Error)
+		}
+
+		fis = append(fis, proto.TaprFileInfo(ent.Info))
+	}
+
+	log.Debug.Printf("client: list ok (%d entries)", len(fis))
+
+	return fis, nil
+}
+
+// Remove implements tapr.Client.
+func (c *Client) Remove(name tapr.PathName) error {
+	removeReq := &proto.RemoveRequest{
+		Name: string(name),
+	}
+
+	var removeResp proto.RemoveResponse
+	if err := c.client.Invoke("io/remove", removeReq, &removeResp); err != nil {
+		return err
+	}
+
+	log.Debug.Print("client: remove ok")
+
+	return nil
+}
+
+// Rename implements tapr.Client.
+func (c *Client) Rename(oldname, newname tapr.PathName) error {
+	renameReq := &proto.RenameRequest{
+		Oldname: string(oldname),
+		Newname: string(newname),
+	}
+
+	var renameResp proto.RenameResponse
+	if err := c.client.Invoke("io/rename", renameReq, &renameResp); err != nil {
+		return err
+	}
+
+	log.Debug.Print("client: rename ok")
+
+	return nil
 }
 
 // Pull implements tapr.Client.
@@ -72,11 +160,18 @@ func (c *Client) Pull(name tapr.PathName, w io.Writer) error {
 	return c.PullFile(name, w, 0 /* offset */)
 }
 
-// PullFile implements tapr.Client.
+// PullFile implements tapr.Client. Each chunk is checked against its CRC32C
+// checksum and, if the whole file is pulled, the data is checked against
+// the SHA-256 digest sent by the server before PullFile returns. A pull
+// starting at an offset relies on the server checking the whole file
+// against its recorded checksum.
 func (c *Client) PullFile(name tapr.PathName, w io.Writer, offset int64) error {
+	const op = "client.PullFile"
+
 	prepareReq := &proto.PullPrepareRequest{
 		Name:   string(name),
 		Offset: offset,
+		Owner:  c.owner,
 	}
 
 	var prepareResp proto.PullPrepareResponse
@@ -89,26 +184,48 @@ func (c *Client) PullFile(name tapr.PathName, w io.Writer, offset int64) error {
 	log.Debug.Printf("client: pull/prepare ok (tx: %v)", tx.String())
 
 	done := make(chan struct{})
+	defer close(done)
+
 	stream := make(rpc.ChunkStream)
 
-	pullReq := &proto.PullRequest{Tx: prepareResp.Tx}
+	pullReq := &proto.PullRequest{Tx: prepareResp.Tx, Owner: c.owner}
 
 	// setup the stream
 	if err := c.client.Receive("io/pull", pullReq, stream, done); err != nil {
 		return err
 	}
 
+	h := sha256.New()
+
 	// process the received chunks
 	for cnk := range stream {
+		if cnk.Error != nil {
+			return errors.E(op, name, errors.UnmarshalError(cnk.Error))
+		}
+
+		if err := cnk.Verify(); err != nil {
+			return errors.E(op, name, err)
+		}
+
 		if _, err := w.Write(cnk.Data); err != nil {
 			log.Debug.Printf("client.PullFile: could not write: %v", err)
 			return err
 		}
 
+		h.Write(cnk.Data)
+
 		log.Debug.Printf("client.PullFile: received %d bytes", len(cnk.Data))
+
+		if cnk.Last {
+			if offset == 0 && cnk.Digest != nil && !bytes.Equal(h.Sum(nil), cnk.Digest) {
+				return errors.E(op, name, errors.Invalid, errors.Str("contents do not match the checksum sent by the server"))
+			}
+
+			return nil
+		}
 	}
 
-	return nil
+	return errors.E(op, name, errors.IO, errors.Str("pull interrupted"))
 }
 
 // Append implements tapr.Client.
@@ -121,29 +238,132 @@ func (c *Client) Push(name tapr.PathName, rd io.Reader) error {
 	return c.PushFile(name, rd, false /* append */)
 }
 
-// PushFile implements tapr.Client.
+// maxResumes is the number of times an interrupted push is resumed.
+const maxResumes = 3
+
+// PushFile implements tapr.Client. PushFile returns once the server has
+// acknowledged that all data has been written to stable storage. If the
+// push is interrupted and rd is an io.Seeker, the push is resumed from the
+// last offset acknowledged by the server.
 func (c *Client) PushFile(name tapr.PathName, rd io.Reader, append bool) error {
+	const op = "client.PushFile"
+
+	tx, start, err := c.pushPrepare(name, append, false /* resume */, 0)
+	if err != nil {
+		return errors.E(op, name, err)
+	}
+
+	// origin is the reader position corresponding to the start offset
+	seeker, resumable := rd.(io.Seeker)
+	var origin int64
+	if resumable {
+		if origin, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			resumable = false
+		}
+	}
+
+	// the digest of the whole file is only known if it is pushed in full
+	var h hash.Hash
+	if !append {
+		h = sha256.New()
+	}
+
+	acked := start
+	for attempt := 0; ; attempt++ {
+		if acked, err = c.push(tx, acked, rd, h); err == nil {
+			log.Debug.Printf("%s: %v acknowledged up to offset %d", op, name, acked)
+			return nil
+		}
+
+		if !resumable || attempt == maxResumes || !errors.Is(errors.IO, err) {
+			return errors.E(op, name, err)
+		}
+
+		log.Debug.Printf("%s: %v interrupted: %v; resuming at offset %d", op, name, err, acked)
+
+		if tx, acked, err = c.pushPrepare(name, true /* append */, true /* resume */, acked); err != nil {
+			return errors.E(op, name, err)
+		}
+
+		if _, err := seeker.Seek(origin, io.SeekStart); err != nil {
+			return errors.E(op, name, err)
+		}
+
+		// digest the data kept by the server again
+		var w io.Writer = ioutil.Discard
+		if h != nil {
+			h.Reset()
+			w = h
+		}
+
+		if _, err := io.CopyN(w, rd, acked-start); err != nil {
+			return errors.E(op, name, err)
+		}
+	}
+}
+
+// pushPrepare prepares a push of the named file and returns the transaction
+// along with the file offset at which the pushed data is written. A resumed
+// push continues at the given offset.
+func (c *Client) pushPrepare(name tapr.PathName, append, resume bool, offset int64) (rpc.Tx, int64, error) {
 	prepareReq := &proto.PushPrepareRequest{
 		Name:   string(name),
+		Offset: offset,
 		Append: append,
+		Resume: resume,
+		Owner:  c.owner,
 	}
 
 	var prepareResp proto.PushPrepareResponse
 	if err := c.client.Invoke("io/push/prepare", prepareReq, &prepareResp); err != nil {
-		return err
+		return rpc.Tx{}, 0, err
 	}
 
 	tx := rpc.MakeTx(prepareResp.Tx)
 
-	log.Debug.Printf("client.Push: prepare ok (tx: %s)", tx)
+	log.Debug.Printf("client.Push: prepare ok (tx: %s, offset: %d)", tx, prepareResp.Offset)
 
+	return tx, prepareResp.Offset, nil
+}
+
+// push streams the data from rd in the transaction started at the given
+// file offset and waits for the push log to acknowledge it. If h is not
+// nil, the data is added to it and the resulting digest is sent in the last
+// chunk. It returns the last acknowledged file offset. Errors of the
+// transport are of kind errors.IO.
+func (c *Client) push(tx rpc.Tx, start int64, rd io.Reader, h hash.Hash) (int64, error) {
 	done := make(chan struct{})
+	defer close(done)
+
+	stream := make(rpc.LogStream)
+
+	logRequest := &proto.PushLogRequest{Tx: tx[:], Owner: c.owner}
+
+	if err := c.client.Receive("io/push/log", logRequest, stream, done); err != nil {
+		return start, transport(err)
+	}
 
 	pr, pw := io.Pipe()
 
+	// the caller may reposition rd once push returns, so wait for the
+	// reader to stop; closing the pipe makes any pending write fail
+	stopped := make(chan struct{})
+	defer func() {
+		pr.Close()
+		<-stopped
+	}()
+
+	// errs receives errors from transmitting and reading the data
+	errs := make(chan error, 2)
+
 	go func() {
 		var pushResp proto.PushResponse
-		c.client.Transmit("io/push", pr, &pushResp, done)
+		if err := c.client.Transmit("io/push", pr, &pushResp, done); err != nil {
+			log.Debug.Printf("client.Push: error: %v", err)
+			errs <- transport(err)
+			return
+		}
+
 		if pushResp.Error != nil {
 			log.Debug.Printf("client.Push: error: %v", errors.UnmarshalError(pushResp.Error))
 			return
@@ -152,67 +372,114 @@ func (c *Client) PushFile(name tapr.PathName, rd io.Reader, append bool) error {
 		log.Debug.Printf("client.Push: push done")
 	}()
 
-	// write the transaction identifier
-	if _, err := pw.Write(tx[:]); err != nil {
-		log.Debug.Printf("client.Push: could not write to pipe: %v", err)
-		return err
-	}
-
 	go func() {
-		var lenBytes [4]byte // stores a uint32, the length of each output message
-		buf := make([]byte, 4096)
-		for {
-			n, err := rd.Read(buf)
-			if err != nil {
-				if err == io.EOF {
-					log.Debug.Printf("client.Push: EOF reached, writer shutting down")
-					close(done)
-					break
-				} else if err != nil {
-					log.Error.Printf("client.Push: %v", err)
-					break
-				}
-			}
+		defer close(stopped)
 
-			b, err := pb.Marshal(&proto.Chunk{
-				Data: buf[:n],
-			})
+		var lenBytes [4]byte // stores a uint32, the length of each output message
+		send := func(msg pb.Message) bool {
+			b, err := pb.Marshal(msg)
 			if err != nil {
 				log.Error.Printf("client.Push: error marshalling proto: %v", err)
-				break
+				errs <- err
+				pw.CloseWithError(err)
+				return false
 			}
 
 			binary.BigEndian.PutUint32(lenBytes[:], uint32(len(b)))
 
 			if _, err := pw.Write(lenBytes[:]); err != nil {
 				log.Debug.Printf("client.Push: could not write to pipe: %v", err)
-				break
+				return false
 			}
 
 			if _, err := pw.Write(b); err != nil {
 				log.Debug.Printf("client.Push: could not write to pipe: %v", err)
-				break
+				return false
 			}
+
+			return true
 		}
-	}()
 
-	stream := make(rpc.LogStream)
+		// the stream starts with the transaction
+		if !send(&proto.PushRequest{Tx: tx[:], Owner: c.owner}) {
+			return
+		}
 
-	logRequest := &proto.PushLogRequest{Tx: prepareResp.Tx}
+		buf := make([]byte, 4096)
+		for {
+			n, err := rd.Read(buf)
+			if n > 0 {
+				if h != nil {
+					h.Write(buf[:n])
+				}
 
-	if err := c.client.Receive("io/push/log", logRequest, stream, done); err != nil {
-		return err
-	}
+				if !send(proto.NewChunk(buf[:n])) {
+					return
+				}
+			}
+
+			if err == io.EOF {
+				log.Debug.Printf("client.Push: EOF reached, writer shutting down")
+
+				last := &proto.Chunk{Last: true}
+				if h != nil {
+					last.Digest = h.Sum(nil)
+				}
+
+				if send(last) {
+					pw.Close()
+				}
+
+				return
+			}
 
-	for entry := range stream {
-		if entry.Error != nil {
-			log.Debug.Printf("client.Push: error: %v", errors.UnmarshalError(entry.Error))
-			continue
+			if err != nil {
+				log.Error.Printf("client.Push: %v", err)
+				errs <- err
+				pw.CloseWithError(err)
+				return
+			}
 		}
+	}()
+
+	// the push log reports errors of the push itself
+	acked := start
+	for {
+		select {
+		case entry, ok := <-stream:
+			if !ok {
+				return acked, errors.E(errors.IO, errors.Strf("push interrupted at offset %d", acked))
+			}
+
+			// the last entry of a failed push carries the offset
+			// acknowledged before the failure
+			if entry.Offset > acked {
+				acked = entry.Offset
+			}
+
+			if entry.Error != nil {
+				return acked, errors.UnmarshalError(entry.Error)
+			}
+
+			log.Debug.Printf("client.Push: log received: %d (offset %d)", entry.Seq, entry.Offset)
 
-		log.Debug.Printf("client.Push: log received: %v", entry.Seq)
+			if entry.Done {
+				return acked, nil
+			}
 
+		case err := <-errs:
+			return acked, err
+		}
 	}
+}
 
-	return nil
+// transport classifies an error returned by the rpc client. Errors reported
+// by the server keep their kind; any other error is a transport error and
+// of kind errors.IO.
+func transport(err error) error {
+	if e, ok := err.(*errors.Error); ok && e.Kind != errors.Other {
+		return err
+	}
+
+	return errors.E(errors.IO, err)
 }
@@ -0,0 +1,149 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	pb "github.com/golang/protobuf/proto"
+
+	"tapr.space"
+	"tapr.space/config"
+	"tapr.space/errors"
+	"tapr.space/proto"
+	"tapr.space/rpc"
+	"tapr.space/rpc/ioserver"
+	fs "tapr.space/store/fs/service"
+)
+
+// cutter passes requests to an io server, cutting the body of the first
+// push after a number of bytes. The push prepare requests are recorded.
+type cutter struct {
+	http.Handler
+
+	mu       sync.Mutex
+	cut      int64
+	prepares []proto.PushPrepareRequest
+}
+
+func (c *cutter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/io/push/prepare"):
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var req proto.PushPrepareRequest
+		if err := pb.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		c.prepares = append(c.prepares, req)
+		c.mu.Unlock()
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	case strings.HasSuffix(r.URL.Path, "/io/push"):
+		c.mu.Lock()
+		if c.cut > 0 {
+			r.Body = ioutil.NopCloser(&brokenReader{r: io.LimitReader(r.Body, c.cut)})
+			c.cut = 0
+		}
+		c.mu.Unlock()
+	}
+
+	c.Handler.ServeHTTP(w, r)
+}
+
+// brokenReader fails once the underlying reader is exhausted.
+type brokenReader struct {
+	r io.Reader
+}
+
+func (br *brokenReader) Read(p []byte) (int, error) {
+	n, err := br.r.Read(p)
+	if err == io.EOF {
+		err = errors.Str("connection reset")
+	}
+
+	return n, err
+}
+
+func TestPushResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	st, err := fs.New("default", config.StoreConfig{Embedded: fs.Config{Root: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cut the first push after the first acknowledgement
+	h := &cutter{Handler: ioserver.New(config.New(), st), cut: 5 << 20}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	rc, err := rpc.NewClient(config.New(), tapr.NetAddr(strings.TrimPrefix(srv.URL, "http://")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Client{client: rc, owner: "test@localhost"}
+
+	data := make([]byte, 6<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	if err := c.Push("/f", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("stored %d bytes differing from the %d bytes pushed", len(got), len(data))
+	}
+
+	if len(h.prepares) != 2 {
+		t.Fatalf("got %d push prepare requests, want 2", len(h.prepares))
+	}
+
+	// the push is resumed at the offset acknowledged before the cut, not
+	// at the end of the data the server received
+	if req := h.prepares[1]; !req.Resume || req.Offset != 4<<20 {
+		t.Errorf("resumed with %+v, want resume at offset %d", req, 4<<20)
+	}
+}
@@ -15,10 +15,17 @@
 package client
 
 import (
+	"encoding/hex"
+	"time"
+
 	"tapr.space"
+	"tapr.space/errors"
 	"tapr.space/mgnt"
+	taprproto "tapr.space/proto"
 	"tapr.space/rpc"
 	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
 	"tapr.space/store/tape/proto"
 )
 
@@ -52,5 +59,146 @@ func (m *ManagementClient) Volumes() ([]tape.Volume, error) {
 		return nil, err
 	}
 
+	if len(resp.Error) != 0 {
+		return nil, errors.UnmarshalError(resp.Error)
+	}
+
 	return proto.TaprVolumes(resp.Volumes), nil
 }
+
+// Migrate implements mgnt.Client.
+func (m *ManagementClient) Migrate() (int, error) {
+	var resp proto.MigrateResponse
+	if err := m.client.Invoke("inv/migrate", &proto.MigrateRequest{}, &resp); err != nil {
+		return 0, err
+	}
+
+	if len(resp.Error) != 0 {
+		return 0, errors.UnmarshalError(resp.Error)
+	}
+
+	return int(resp.Version), nil
+}
+
+// Version implements mgnt.Client.
+func (m *ManagementClient) Version() (current, latest int, err error) {
+	var resp proto.VersionResponse
+	if err := m.client.Invoke("inv/version", &proto.VersionRequest{}, &resp); err != nil {
+		return 0, 0, err
+	}
+
+	if len(resp.Error) != 0 {
+		return 0, 0, errors.UnmarshalError(resp.Error)
+	}
+
+	return int(resp.Current), int(resp.Latest), nil
+}
+
+// History implements mgnt.Client.
+func (m *ManagementClient) History(serial tape.Serial) ([]tape.Transition, error) {
+	var resp proto.HistoryResponse
+	if err := m.client.Invoke("inv/history", &proto.HistoryRequest{Serial: string(serial)}, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Error) != 0 {
+		return nil, errors.UnmarshalError(resp.Error)
+	}
+
+	return proto.TaprTransitions(resp.Transitions), nil
+}
+
+// Import implements mgnt.Client.
+func (m *ManagementClient) Import(changer string) ([]tape.Volume, error) {
+	var resp proto.ImportResponse
+	if err := m.client.Invoke("inv/import", &proto.ImportRequest{Changer: changer}, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Error) != 0 {
+		return proto.TaprVolumes(resp.Volumes), errors.UnmarshalError(resp.Error)
+	}
+
+	return proto.TaprVolumes(resp.Volumes), nil
+}
+
+// Export implements mgnt.Client.
+func (m *ManagementClient) Export(serials ...tape.Serial) ([]tape.Volume, error) {
+	req := &proto.ExportRequest{}
+	for _, serial := range serials {
+		req.Serials = append(req.Serials, string(serial))
+	}
+
+	var resp proto.ExportResponse
+	if err := m.client.Invoke("inv/export", req, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Error) != 0 {
+		return proto.TaprVolumes(resp.Volumes), errors.UnmarshalError(resp.Error)
+	}
+
+	return proto.TaprVolumes(resp.Volumes), nil
+}
+
+// Audit implements mgnt.Client.
+func (m *ManagementClient) Audit(changer string, dryRun bool) ([]inv.AuditResult, error) {
+	var resp proto.AuditResponse
+	if err := m.client.Invoke("inv/audit", &proto.AuditRequest{Changer: changer, DryRun: dryRun}, &resp); err != nil {
+		return nil, err
+	}
+
+	var results []inv.AuditResult
+	for _, pb := range resp.Results {
+		results = append(results, proto.TaprAuditResult(pb))
+	}
+
+	if len(resp.Error) != 0 {
+		return results, errors.UnmarshalError(resp.Error)
+	}
+
+	return results, nil
+}
+
+// Queues implements mgnt.Client.
+func (m *ManagementClient) Queues(chgr string) (map[string]changer.QueueStats, error) {
+	var resp proto.QueueResponse
+	if err := m.client.Invoke("inv/queue", &proto.QueueRequest{Changer: chgr}, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Error) != 0 {
+		return nil, errors.UnmarshalError(resp.Error)
+	}
+
+	stats := make(map[string]changer.QueueStats, len(resp.Stats))
+	for _, pb := range resp.Stats {
+		stats[pb.Changer] = proto.TaprQueueStats(pb)
+	}
+
+	return stats, nil
+}
+
+// Transactions implements mgnt.Client.
+func (m *ManagementClient) Transactions() ([]mgnt.Tx, error) {
+	var resp taprproto.TxListResponse
+	if err := m.client.Invoke("inv/tx/list", &taprproto.TxListRequest{}, &resp); err != nil {
+		return nil, err
+	}
+
+	txs := make([]mgnt.Tx, len(resp.Txs))
+	for i, tx := range resp.Txs {
+		txs[i] = mgnt.Tx{
+			ID:       hex.EncodeToString(tx.Tx),
+			Kind:     tx.Kind,
+			Name:     tapr.PathName(tx.Name),
+			Owner:    tx.Owner,
+			Created:  time.Unix(0, tx.Created),
+			Deadline: time.Unix(0, tx.Deadline),
+			Bytes:    tx.Bytes,
+			Active:   tx.Active,
+		}
+	}
+
+	return txs, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"tapr.space"
+)
+
+func (s *State) ls(args ...string) {
+	const help = `
+The ls command lists the named file or the contents of the named directory.
+If no path is given, the root directory is listed.
+
+Use the -R flag to list subdirectories recursively. The -glob flag restricts
+the listing to entries with base names matching the pattern. The -l flag
+adds the mode, size, modification time and residency of each entry.
+`
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	longFlag := fs.Bool("l", false, "long format")
+	recursiveFlag := fs.Bool("R", false, "recursively list subdirectories")
+	globFlag := fs.String("glob", "", "only list entries matching the `pattern`")
+	s.ParseFlags(fs, args, help, "ls [-l] [-R] [-glob=pattern] [path]")
+
+	if fs.NArg() > 1 {
+		usageAndExit(fs)
+	}
+
+	prefix := tapr.PathName("/")
+	if fs.NArg() == 1 {
+		prefix = tapr.PathName(fs.Arg(0))
+	}
+
+	fis, err := s.Client.List(prefix, *recursiveFlag, *globFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !*longFlag {
+		for _, fi := range fis {
+			fmt.Println(fi.Name)
+		}
+
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	for _, fi := range fis {
+		residency := fi.Residency.String()
+		if fi.IsDir {
+			residency = "-"
+		}
+
+		fmt.Fprintf(tw, "%v\t%d\t%s\t%s\t%s\n", fi.Mode, fi.Size, fi.ModTime.Format("Jan _2 15:04"), residency, fi.Name)
+	}
+
+	tw.Flush()
+}
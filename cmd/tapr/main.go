@@ -75,8 +75,12 @@ For a list of available subcommands and global flags, run
 `
 
 var commands = map[string]func(*State, ...string){
-	"push": (*State).push,
-	"pull": (*State).pull,
+	"ls":     (*State).ls,
+	"mv":     (*State).mv,
+	"pull":   (*State).pull,
+	"push":   (*State).push,
+	"rm":     (*State).rm,
+	"verify": (*State).verify,
 }
 
 // State is the command state
@@ -160,7 +164,7 @@ func help(args ...string) {
 	}
 
 	if cmd == "" {
-		fmt.Fprintln(os.Stderr, intro)
+		fmt.Fprintf(os.Stderr, "%s\n", intro)
 	} else {
 		// Simplest solution is re-execing.
 		command := exec.Command("tapr", cmd, "-help")
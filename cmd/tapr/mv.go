@@ -0,0 +1,39 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"log"
+
+	"tapr.space"
+)
+
+func (s *State) mv(args ...string) {
+	const help = `
+The mv command renames (moves) a file or directory on the server. The target
+must not exist.
+`
+	fs := flag.NewFlagSet("mv", flag.ExitOnError)
+	s.ParseFlags(fs, args, help, "mv oldpath newpath")
+
+	if fs.NArg() != 2 {
+		usageAndExit(fs)
+	}
+
+	if err := s.Client.Rename(tapr.PathName(fs.Arg(0)), tapr.PathName(fs.Arg(1))); err != nil {
+		log.Fatal(err)
+	}
+}
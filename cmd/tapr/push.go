@@ -30,6 +30,11 @@ The push command stores a file on the server.
 To resume a failed push, add the -resume flag. To append to a previously
 stored file add the -append flag. Note that -resume and -append are mutually
 exclusive.
+
+The push command returns once the server has acknowledged that all data is
+stored safely. If the connection is lost while pushing from a file given
+with -in, the push is resumed from the last offset acknowledged by the
+server; data received by the server after that offset is discarded.
 `
 	fs := flag.NewFlagSet("push", flag.ExitOnError)
 	inFileFlag := fs.String("in", "", "input file (defaults to standard input)")
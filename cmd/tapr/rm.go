@@ -0,0 +1,43 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"log"
+
+	"tapr.space"
+)
+
+func (s *State) rm(args ...string) {
+	const help = `
+The rm command removes files (and empty directories) from the server.
+
+Note that removing a file stored on tape only removes it from the file
+catalog; the space it occupies is not freed until its volumes are reclaimed.
+`
+	fs := flag.NewFlagSet("rm", flag.ExitOnError)
+	s.ParseFlags(fs, args, help, "rm path...")
+
+	if fs.NArg() < 1 {
+		usageAndExit(fs)
+	}
+
+	for _, name := range fs.Args() {
+		if err := s.Client.Remove(tapr.PathName(name)); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"tapr.space"
+)
+
+func (s *State) verify(args ...string) {
+	const help = `
+The verify command compares a local file against the SHA-256 digest that the
+server recorded when the file was pushed.
+
+The digest is only recorded for files pushed in full; files that have been
+appended to cannot be verified.
+`
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	inFileFlag := fs.String("in", "", "local file (defaults to standard input)")
+	s.ParseFlags(fs, args, help, "verify [-in=localfile] name")
+
+	if fs.NArg() != 1 {
+		usageAndExit(fs)
+	}
+
+	name := tapr.PathName(fs.Arg(0))
+
+	fi, err := s.Client.Stat(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rd := os.Stdin
+
+	if *inFileFlag != "" {
+		rd, err = os.Open(*inFileFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer rd.Close()
+	}
+
+	if err := check(fi, rd); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	fmt.Printf("%x  %s: ok\n", fi.Checksum, name)
+}
+
+// check compares the data read from rd against the checksum recorded for
+// the file.
+func check(fi *tapr.FileInfo, rd io.Reader) error {
+	if fi.Checksum == nil {
+		return fmt.Errorf("no checksum recorded for %s", fi.Name)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rd); err != nil {
+		return err
+	}
+
+	if sum := h.Sum(nil); !bytes.Equal(sum, fi.Checksum) {
+		return fmt.Errorf("%s: checksum mismatch (local %x, stored %x)", fi.Name, sum, fi.Checksum)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"tapr.space"
+)
+
+func TestCheck(t *testing.T) {
+	sum := sha256.Sum256([]byte("data"))
+
+	fi := &tapr.FileInfo{Name: "/f", Checksum: sum[:]}
+
+	if err := check(fi, strings.NewReader("data")); err != nil {
+		t.Errorf("matching data: %v", err)
+	}
+
+	if err := check(fi, strings.NewReader("dato")); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("corrupt data: got %v, want checksum mismatch", err)
+	}
+
+	fi.Checksum = nil
+	if err := check(fi, strings.NewReader("data")); err == nil {
+		t.Error("no checksum recorded: got no error")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/inv"
+)
+
+func (s *State) audit(args ...string) {
+	const help = `
+The audit command compares the inventory with the physical state of the
+media changers and prints the differences found: new volumes, volumes found
+in another slot, volumes unexpectedly found in a drive, missing volumes and
+offsite volumes removed from the import/export slots.
+
+Unless -dry-run is given, the inventory is updated to reflect the physical
+state and missing volumes are marked as such.
+`
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report differences without updating the inventory")
+	chgr := fs.String("changer", "", "only audit the named changer")
+	s.ParseFlags(fs, args, help, "audit [-dry-run] [-changer NAME]")
+
+	if fs.NArg() != 0 {
+		usageAndExit(fs)
+	}
+
+	results, err := s.Management.Audit(*chgr, *dryRun)
+	printAuditResults(results)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// printAuditResults prints the differences found by an audit.
+func printAuditResults(results []inv.AuditResult) {
+	tw := tabwriter.NewWriter(os.Stdout, 2, 1, 2, ' ', 0)
+	fmt.Fprintf(tw, "CHANGER\tSERIAL\tDIFF\tFROM\tTO\n")
+
+	for _, res := range results {
+		diffs := []struct {
+			kind string
+			ds   []inv.Difference
+		}{
+			{"new", res.New},
+			{"moved", res.Moved},
+			{"mounted", res.Mounted},
+			{"missing", res.Missing},
+			{"removed", res.Removed},
+		}
+
+		for _, diff := range diffs {
+			for _, d := range diff.ds {
+				fmt.Fprintf(tw, "%s\t%v\t%s\t%s\t%s\n", res.Changer, d.Serial, diff.kind, formatLocation(d.From), formatLocation(d.To))
+			}
+		}
+	}
+
+	tw.Flush()
+}
+
+// formatLocation formats a location for human consumption.
+func formatLocation(loc tape.Location) string {
+	if loc == (tape.Location{}) {
+		return "-"
+	}
+
+	return fmt.Sprintf("%v %d", loc.Category, loc.Addr)
+}
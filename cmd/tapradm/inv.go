@@ -0,0 +1,60 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+)
+
+func (s *State) inv(args ...string) {
+	const help = `
+The inv command manages the inventory database of a tape store.
+
+The migrate subcommand applies any pending schema migrations. Migrations are
+also applied automatically when taprd starts.
+
+The version subcommand prints the current schema version of the inventory
+and the latest version known by the server.
+`
+	fs := flag.NewFlagSet("inv", flag.ExitOnError)
+	s.ParseFlags(fs, args, help, "inv migrate|version")
+
+	if fs.NArg() != 1 {
+		usageAndExit(fs)
+	}
+
+	switch fs.Arg(0) {
+	case "migrate":
+		version, err := s.Management.Migrate()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("inventory schema at version %d\n", version)
+
+	case "version":
+		current, latest, err := s.Management.Version()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("current: %d\nlatest:  %d\n", current, latest)
+
+	default:
+		usageAndExit(fs)
+	}
+}
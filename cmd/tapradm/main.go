@@ -72,7 +72,11 @@ For a list of available subcommands and global flags, run
 `
 
 var commands = map[string]func(*State, ...string){
-	"vol": (*State).vol,
+	"audit": (*State).audit,
+	"inv":   (*State).inv,
+	"queue": (*State).queue,
+	"tx":    (*State).tx,
+	"vol":   (*State).vol,
 }
 
 // State is the command state
@@ -152,7 +156,7 @@ func help(args ...string) {
 	}
 
 	if cmd == "" {
-		fmt.Fprintln(os.Stderr, intro)
+		fmt.Fprintf(os.Stderr, "%s\n", intro)
 	} else {
 		// Simplest solution is re-execing.
 		command := exec.Command("tapradm", cmd, "-help")
@@ -0,0 +1,79 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"tapr.space/store/tape/changer"
+)
+
+func (s *State) queue(args ...string) {
+	const help = `
+The queue command prints, for each media changer, the number of moves
+waiting by priority along with the mean and longest time moves carried out
+at that priority spent waiting. The merged and canceled counts are the
+moves served by an identical queued move and the moves given up by their
+callers before they were carried out.
+`
+	fs := flag.NewFlagSet("queue", flag.ExitOnError)
+	chgr := fs.String("changer", "", "only show the named changer")
+	s.ParseFlags(fs, args, help, "queue [-changer NAME]")
+
+	if fs.NArg() != 0 {
+		usageAndExit(fs)
+	}
+
+	stats, err := s.Management.Queues(*chgr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	prios := []changer.Priority{changer.Recall, changer.Normal, changer.Background}
+
+	tw := tabwriter.NewWriter(os.Stdout, 2, 1, 2, ' ', 0)
+	fmt.Fprintf(tw, "CHANGER\tPRIORITY\tDEPTH\tMOVES\tMEAN WAIT\tMAX WAIT\n")
+	for _, name := range names {
+		st := stats[name]
+		for _, prio := range prios {
+			w := st.Waits[prio]
+			fmt.Fprintf(tw, "%s\t%v\t%d\t%d\t%v\t%v\n", name, prio, st.Depth[prio], w.Count, w.Mean().Round(time.Millisecond), w.Max.Round(time.Millisecond))
+		}
+	}
+	tw.Flush()
+
+	fmt.Println()
+
+	tw = tabwriter.NewWriter(os.Stdout, 2, 1, 2, ' ', 0)
+	fmt.Fprintf(tw, "CHANGER\tOLDEST\tMERGED\tCANCELED\n")
+	for _, name := range names {
+		st := stats[name]
+		fmt.Fprintf(tw, "%s\t%v\t%d\t%d\n", name, st.Oldest.Round(time.Millisecond), st.Merged, st.Canceled)
+	}
+	tw.Flush()
+}
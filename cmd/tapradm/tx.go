@@ -0,0 +1,55 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func (s *State) tx(args ...string) {
+	const help = `
+The tx command prints the open push and pull transactions along with the
+number of bytes transferred so far. Transactions that stay idle past their
+deadline are closed by the server.
+`
+	fs := flag.NewFlagSet("tx", flag.ExitOnError)
+	s.ParseFlags(fs, args, help, "tx")
+
+	if fs.NArg() != 0 {
+		usageAndExit(fs)
+	}
+
+	txs, err := s.Management.Transactions()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 2, 1, 2, ' ', 0)
+	fmt.Fprintf(tw, "TX\tKIND\tOWNER\tCREATED\tDEADLINE\tBYTES\tNAME\n")
+	for _, tx := range txs {
+		deadline := tx.Deadline.Format(time.RFC3339)
+		if tx.Active {
+			deadline = "active"
+		}
+
+		fmt.Fprintf(tw, "%.8s\t%s\t%s\t%s\t%s\t%d\t%s\n", tx.ID, tx.Kind, tx.Owner, tx.Created.Format(time.RFC3339), deadline, tx.Bytes, tx.Name)
+	}
+	tw.Flush()
+}
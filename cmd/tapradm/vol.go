@@ -20,17 +20,39 @@ import (
 	"log"
 	"os"
 	"text/tabwriter"
+	"time"
 
 	"tapr.space/store/tape"
 )
 
+var volCommands = map[string]func(*State, ...string){
+	"history": (*State).volHistory,
+	"import":  (*State).volImport,
+	"export":  (*State).volExport,
+}
+
 func (s *State) vol(args ...string) {
 	const help = `
 The vol command prints a list of known volumes.
+
+Use the history subcommand to print the category transitions of a volume
+and the import and export subcommands to move volumes in and out of the
+library through the import/export slots.
 `
 	fs := flag.NewFlagSet("vol", flag.ExitOnError)
 	longFormat := fs.Bool("l", false, "long format")
-	s.ParseFlags(fs, args, help, "vol [-l]")
+	s.ParseFlags(fs, args, help, "vol [-l] | vol history SERIAL | vol import | vol export SERIAL...")
+
+	if fs.NArg() > 0 {
+		cmd, ok := volCommands[fs.Arg(0)]
+		if !ok {
+			usageAndExit(fs)
+		}
+
+		cmd(s, fs.Args()[1:]...)
+
+		return
+	}
 
 	vols, err := s.Management.Volumes()
 	if err != nil {
@@ -39,14 +61,14 @@ The vol command prints a list of known volumes.
 
 	if *longFormat {
 		tw := tabwriter.NewWriter(os.Stdout, 2, 1, 2, ' ', 0)
-		fmt.Fprintf(tw, "SERIAL\tSLOT\tADDR\tHOME\tCATEGORY\tFLAGS\n")
+		fmt.Fprintf(tw, "SERIAL\tCHANGER\tSLOT\tADDR\tHOME\tCATEGORY\tMOUNTS\tFLAGS\n")
 		for _, vol := range vols {
 			var home string
 			if vol.Home.Category != tape.UnknownSlot {
 				home = fmt.Sprintf("%d", vol.Home.Addr)
 			}
 
-			fmt.Fprintf(tw, "%v\t%v\t%v\t%s\t%v\t%v\n", vol.Serial, vol.Location.Category, vol.Location.Addr, home, vol.Category, tape.FormatVolumeFlags(vol.Flags))
+			fmt.Fprintf(tw, "%v\t%s\t%v\t%v\t%s\t%v\t%d\t%v\n", vol.Serial, vol.Location.Changer, vol.Location.Category, vol.Location.Addr, home, vol.Category, vol.Mounts, tape.FormatVolumeFlags(vol.Flags))
 		}
 		tw.Flush()
 
@@ -57,3 +79,89 @@ The vol command prints a list of known volumes.
 		fmt.Println(vol.Serial)
 	}
 }
+
+func (s *State) volHistory(args ...string) {
+	const help = `
+The vol history command prints the category transitions of a volume.
+`
+	fs := flag.NewFlagSet("vol history", flag.ExitOnError)
+	s.ParseFlags(fs, args, help, "vol history SERIAL")
+
+	if fs.NArg() != 1 {
+		usageAndExit(fs)
+	}
+
+	ts, err := s.Management.History(tape.Serial(fs.Arg(0)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 2, 1, 2, ' ', 0)
+	fmt.Fprintf(tw, "TIME\tFROM\tTO\tREASON\n")
+	for _, t := range ts {
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%s\n", t.Time.Format(time.RFC3339), t.From, t.To, t.Reason)
+	}
+	tw.Flush()
+}
+
+func (s *State) volImport(args ...string) {
+	const help = `
+The vol import command moves the volumes found in the import/export slots to
+free storage slots. New volumes are registered as scratch or cleaning volumes
+depending on their serial prefix.
+`
+	fs := flag.NewFlagSet("vol import", flag.ExitOnError)
+	chgr := fs.String("changer", "", "only import from the named changer")
+	s.ParseFlags(fs, args, help, "vol import [-changer NAME]")
+
+	if fs.NArg() != 0 {
+		usageAndExit(fs)
+	}
+
+	vols, err := s.Management.Import(*chgr)
+	printVolumeLocations(vols)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (s *State) volExport(args ...string) {
+	const help = `
+The vol export command moves the given volumes to free import/export slots
+and marks them offsite.
+`
+	fs := flag.NewFlagSet("vol export", flag.ExitOnError)
+	s.ParseFlags(fs, args, help, "vol export SERIAL...")
+
+	if fs.NArg() == 0 {
+		usageAndExit(fs)
+	}
+
+	var serials []tape.Serial
+	for _, arg := range fs.Args() {
+		serials = append(serials, tape.Serial(arg))
+	}
+
+	vols, err := s.Management.Export(serials...)
+	printVolumeLocations(vols)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// printVolumeLocations prints the location and category of the given
+// volumes.
+func printVolumeLocations(vols []tape.Volume) {
+	if len(vols) == 0 {
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 2, 1, 2, ' ', 0)
+	fmt.Fprintf(tw, "SERIAL\tCHANGER\tSLOT\tADDR\tCATEGORY\n")
+	for _, vol := range vols {
+		fmt.Fprintf(tw, "%v\t%s\t%v\t%v\t%v\n", vol.Serial, vol.Location.Changer, vol.Location.Category, vol.Location.Addr, vol.Category)
+	}
+	tw.Flush()
+}
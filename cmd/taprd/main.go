@@ -22,20 +22,24 @@ import (
 
 	"tapr.space/config"
 	"tapr.space/flags"
+	"tapr.space/rpc/invserver"
 	"tapr.space/rpc/ioserver"
 	"tapr.space/sim"
 	"tapr.space/store"
+	"tapr.space/store/tape/inv"
 
 	// store implementations
 	_ "tapr.space/store/fs/service"
 	_ "tapr.space/store/tape/service"
 
 	// inventory implementations
+	_ "tapr.space/store/tape/inv/embedded"
 	_ "tapr.space/store/tape/inv/postgres"
 
 	// changer implementations
 	_ "tapr.space/store/tape/changer/fake"
 	_ "tapr.space/store/tape/changer/mtx"
+	_ "tapr.space/store/tape/changer/scsi"
 
 	// format implementations
 	_ "tapr.space/format/ltfs"
@@ -73,6 +77,12 @@ func main() {
 		// io api server
 		httpIO := ioserver.New(config.New(), stg)
 		http.Handle("/api/v1/"+name+"/io/", httpIO)
+
+		// inventory api server
+		if p, ok := stg.(inv.Provider); ok {
+			httpInv := invserver.New(config.New(), name, p, httpIO)
+			http.Handle("/api/v1/"+name+"/inv/", httpInv)
+		}
 	}
 
 	fmt.Println("taprd: server ready")
@@ -271,6 +271,25 @@ func (e *Error) Error() string {
 	return b.String()
 }
 
+// Is reports whether err is an *Error of the given Kind.
+// If err is nil then Is returns false.
+func Is(kind Kind, err error) bool {
+	e, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+
+	if e.Kind != Other {
+		return e.Kind == kind
+	}
+
+	if e.Err != nil {
+		return Is(kind, e.Err)
+	}
+
+	return false
+}
+
 // Recreate the errors.New functionality of the standard Go errors package
 // so we can create simple text errors when needed.
 
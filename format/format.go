@@ -16,6 +16,7 @@
 package format // import "tapr.space/format"
 
 import (
+	"tapr.space"
 	"tapr.space/errors"
 	"tapr.space/storage"
 	"tapr.space/store/tape"
@@ -53,6 +54,12 @@ type Unmounter interface {
 	Unmount() error
 }
 
+// Positioner specifies that the format can report the logical block position
+// of a file on the volume.
+type Positioner interface {
+	Position(tapr.PathName) (int64, error)
+}
+
 // Create creates a new storage.Storage implementation using the given format
 // type.
 func Create(cfg tape.FormatConfig) (Formatter, error) {
@@ -27,6 +27,7 @@ import (
 	"strconv"
 	"syscall"
 
+	"tapr.space"
 	"tapr.space/errors"
 	"tapr.space/flags"
 	"tapr.space/format"
@@ -40,6 +41,8 @@ const (
 	ltfsCommand       = "/usr/local/bin/ltfs"
 	fusermountCommand = "/usr/bin/fusermount"
 	mkltfsCommand     = "/usr/local/bin/mkltfs"
+
+	startblockXattr = "user.ltfs.startblock"
 )
 
 func init() {
@@ -60,7 +63,10 @@ type impl struct {
 	*fsdir.Storage
 }
 
-var _ storage.Storage = (*impl)(nil)
+var (
+	_ storage.Storage   = (*impl)(nil)
+	_ format.Positioner = (*impl)(nil)
+)
 
 // New create a new LTFS format.
 func New(cfg tape.FormatConfig) (format.Formatter, error) {
@@ -199,6 +205,19 @@ func (f *impl) Unmount() error {
 	return nil
 }
 
+// Position implements format.Positioner using the virtual extended attribute
+// exposed by LTFS holding the first block of the file on the volume.
+func (f *impl) Position(name tapr.PathName) (int64, error) {
+	buf := make([]byte, 32)
+
+	n, err := syscall.Getxattr(filepath.Join(f.mountpath, string(name)), startblockXattr, buf)
+	if err != nil {
+		return 0, errors.E(name, errors.IO, err)
+	}
+
+	return strconv.ParseInt(string(bytes.TrimSpace(buf[:n])), 10, 64)
+}
+
 func execCmd(cmd *exec.Cmd) ([]byte, error) {
 	var stderr bytes.Buffer
 
@@ -14,10 +14,76 @@
 
 package mgnt
 
-import "tapr.space/store/tape"
+import (
+	"time"
+
+	"tapr.space"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
+)
 
 // Client defines an administrative interface.
 type Client interface {
 	// Status returns a list of known volumes.
 	Volumes() ([]tape.Volume, error)
+
+	// Migrate applies pending inventory schema migrations and returns the
+	// resulting schema version.
+	Migrate() (int, error)
+
+	// Version returns the current inventory schema version and the latest
+	// version known by the server.
+	Version() (current, latest int, err error)
+
+	// History returns the category transitions of a volume, oldest first.
+	History(tape.Serial) ([]tape.Transition, error)
+
+	// Import moves the volumes in the import/export slots of the named
+	// changer (or all changers if empty) into the library and returns the
+	// imported volumes.
+	Import(changer string) ([]tape.Volume, error)
+
+	// Export moves the given volumes to import/export slots and marks them
+	// offsite. The exported volumes are returned even if an error occurs
+	// part way.
+	Export(...tape.Serial) ([]tape.Volume, error)
+
+	// Audit audits the inventory against the named changer (or all
+	// changers if empty) and returns the differences found. Unless dryRun
+	// is set, the inventory is updated to reflect the physical state.
+	Audit(changer string, dryRun bool) ([]inv.AuditResult, error)
+
+	// Queues returns the queue statistics of the named changer (or all
+	// changers if empty), keyed by changer name. Changers that do not
+	// queue moves are left out.
+	Queues(changer string) (map[string]changer.QueueStats, error)
+
+	// Transactions returns the open i/o transactions, oldest first.
+	Transactions() ([]Tx, error)
+}
+
+// A Tx describes an open i/o transaction, that is, a file prepared for a
+// push or pull by a client.
+type Tx struct {
+	// ID is the transaction identifier (hex encoded).
+	ID string
+
+	// Kind is either "push" or "pull".
+	Kind string
+
+	Name  tapr.PathName
+	Owner string
+
+	Created time.Time
+
+	// Deadline is the time at which the transaction is reaped if it stays
+	// idle.
+	Deadline time.Time
+
+	// Bytes is the number of bytes transferred.
+	Bytes int64
+
+	// Active is set if a stream is using the transaction.
+	Active bool
 }
@@ -15,6 +15,12 @@
 package proto // import "tapr.space/proto"
 
 import (
+	"hash/crc32"
+	"os"
+	"time"
+
+	"tapr.space"
+	"tapr.space/errors"
 	"tapr.space/log"
 )
 
@@ -74,3 +80,61 @@ func TaprLogEvent(pb *LogEvent) *log.Event {
 		Message: pb.Message,
 	}
 }
+
+// FileInfoProto converts a tapr.FileInfo to a proto.StatResponse.
+func FileInfoProto(fi *tapr.FileInfo) *StatResponse {
+	pb := &StatResponse{
+		Name:      string(fi.Name),
+		Size:      fi.Size,
+		Mode:      uint32(fi.Mode),
+		IsDir:     fi.IsDir,
+		Checksum:  fi.Checksum,
+		Residency: Residency(fi.Residency),
+		Volumes:   fi.Volumes,
+	}
+
+	if !fi.ModTime.IsZero() {
+		pb.Mtime = fi.ModTime.UnixNano()
+	}
+
+	return pb
+}
+
+// TaprFileInfo converts a proto.StatResponse to a tapr.FileInfo.
+func TaprFileInfo(pb *StatResponse) *tapr.FileInfo {
+	fi := &tapr.FileInfo{
+		Name:      tapr.PathName(pb.Name),
+		Size:      pb.Size,
+		Mode:      os.FileMode(pb.Mode),
+		IsDir:     pb.IsDir,
+		Checksum:  pb.Checksum,
+		Residency: tapr.Residency(pb.Residency),
+		Volumes:   pb.Volumes,
+	}
+
+	if pb.Mtime != 0 {
+		fi.ModTime = time.Unix(0, pb.Mtime)
+	}
+
+	return fi
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// NewChunk returns a Chunk carrying data along with its CRC32C checksum.
+func NewChunk(data []byte) *Chunk {
+	return &Chunk{
+		Data:   data,
+		Crc32C: crc32.Checksum(data, castagnoli),
+	}
+}
+
+// Verify checks the data of the chunk against its CRC32C checksum. A
+// mismatch is reported as an error of kind errors.IO.
+func (c *Chunk) Verify() error {
+	if sum := crc32.Checksum(c.Data, castagnoli); sum != c.Crc32C {
+		return errors.E(errors.IO, errors.Strf("chunk checksum mismatch (got %08x, want %08x)", sum, c.Crc32C))
+	}
+
+	return nil
+}
@@ -17,13 +17,17 @@ package invserver // import "tapr.space/rpc/invserver"
 import (
 	"fmt"
 	"net/http"
+	"sort"
 
 	pb "github.com/golang/protobuf/proto"
 
 	"tapr.space"
 	"tapr.space/errors"
 	"tapr.space/log"
+	taprproto "tapr.space/proto"
 	"tapr.space/rpc"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
 	"tapr.space/store/tape/inv"
 	"tapr.space/store/tape/proto"
 )
@@ -31,21 +35,39 @@ import (
 type server struct {
 	config tapr.Config
 
-	inv inv.Inventory
+	inv   inv.Inventory
+	chgrs map[string]changer.Changer
+	txs   TxLister
 }
 
-// New returns a new http.Handler that presents an inventory server
-// as a service.
-func New(cfg tapr.Config, inv inv.Inventory) http.Handler {
+// A TxLister lists the open i/o transactions of a store.
+type TxLister interface {
+	Transactions() []*taprproto.Transaction
+}
+
+// New returns a new http.Handler that presents the inventory of the named
+// store as a service. The service also lists the open i/o transactions of
+// the store.
+func New(cfg tapr.Config, name string, p inv.Provider, txs TxLister) http.Handler {
 	s := &server{
 		config: cfg,
-		inv:    inv,
+		inv:    p.Inventory(),
+		chgrs:  p.Changers(),
+		txs:    txs,
 	}
 
 	return rpc.NewServer(cfg, rpc.Service{
-		Name: "inv",
+		Name: name + "/inv",
 		Methods: map[string]rpc.Method{
 			"volumes": s.Volumes,
+			"migrate": s.Migrate,
+			"version": s.Version,
+			"history": s.History,
+			"import":  s.Import,
+			"export":  s.Export,
+			"audit":   s.Audit,
+			"queue":   s.Queue,
+			"tx/list": s.TxList,
 		},
 	})
 }
@@ -66,6 +88,216 @@ func (s *server) Volumes(reqBytes []byte) (pb.Message, error) {
 	return resp, nil
 }
 
+func (s *server) Migrate(reqBytes []byte) (pb.Message, error) {
+	op := operation("migrate")
+
+	if err := s.inv.Migrate(); err != nil {
+		op.log(err)
+		return &proto.MigrateResponse{Error: errors.MarshalError(err)}, nil
+	}
+
+	current, _, err := s.inv.Version()
+	if err != nil {
+		op.log(err)
+		return &proto.MigrateResponse{Error: errors.MarshalError(err)}, nil
+	}
+
+	return &proto.MigrateResponse{Version: int64(current)}, nil
+}
+
+func (s *server) Version(reqBytes []byte) (pb.Message, error) {
+	op := operation("version")
+
+	current, latest, err := s.inv.Version()
+	if err != nil {
+		op.log(err)
+		return &proto.VersionResponse{Error: errors.MarshalError(err)}, nil
+	}
+
+	return &proto.VersionResponse{
+		Current: int64(current),
+		Latest:  int64(latest),
+	}, nil
+}
+
+func (s *server) History(reqBytes []byte) (pb.Message, error) {
+	var req proto.HistoryRequest
+	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		return nil, err
+	}
+
+	op := logf("history %q", req.Serial)
+
+	ts, err := s.inv.History(tape.Serial(req.Serial))
+	if err != nil {
+		op.log(err)
+		return &proto.HistoryResponse{Error: errors.MarshalError(err)}, nil
+	}
+
+	return &proto.HistoryResponse{
+		Transitions: proto.TransitionProtos(ts),
+	}, nil
+}
+
+func (s *server) Import(reqBytes []byte) (pb.Message, error) {
+	var req proto.ImportRequest
+	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		return nil, err
+	}
+
+	op := logf("import %q", req.Changer)
+
+	names, err := s.changers(req.Changer)
+	if err != nil {
+		op.log(err)
+		return &proto.ImportResponse{Error: errors.MarshalError(err)}, nil
+	}
+
+	var imported []tape.Volume
+	for _, name := range names {
+		vols, err := s.inv.Import(s.chgrs[name])
+		imported = append(imported, vols...)
+
+		if err != nil {
+			op.log(err)
+			return &proto.ImportResponse{
+				Volumes: proto.VolumeProtos(imported),
+				Error:   errors.MarshalError(err),
+			}, nil
+		}
+	}
+
+	return &proto.ImportResponse{Volumes: proto.VolumeProtos(imported)}, nil
+}
+
+func (s *server) Export(reqBytes []byte) (pb.Message, error) {
+	var req proto.ExportRequest
+	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		return nil, err
+	}
+
+	op := logf("export %q", req.Serials)
+
+	var exported []tape.Volume
+	for _, serial := range req.Serials {
+		vol, err := s.export(tape.Serial(serial))
+		if err != nil {
+			op.log(err)
+			return &proto.ExportResponse{
+				Volumes: proto.VolumeProtos(exported),
+				Error:   errors.MarshalError(err),
+			}, nil
+		}
+
+		exported = append(exported, vol)
+	}
+
+	return &proto.ExportResponse{Volumes: proto.VolumeProtos(exported)}, nil
+}
+
+// export exports a single volume using the changer holding it.
+func (s *server) export(serial tape.Serial) (tape.Volume, error) {
+	vol, err := s.inv.Info(serial)
+	if err != nil {
+		return tape.Volume{}, err
+	}
+
+	chgr, ok := s.chgrs[vol.Location.Changer]
+	if !ok {
+		return tape.Volume{}, errors.E(errors.Invalid, errors.Strf("volume %v is not held by a known changer", serial))
+	}
+
+	if _, err := s.inv.Export(serial, chgr); err != nil {
+		return tape.Volume{}, err
+	}
+
+	return s.inv.Info(serial)
+}
+
+func (s *server) Audit(reqBytes []byte) (pb.Message, error) {
+	var req proto.AuditRequest
+	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		return nil, err
+	}
+
+	op := logf("audit %q (dry-run: %t)", req.Changer, req.DryRun)
+
+	names, err := s.changers(req.Changer)
+	if err != nil {
+		op.log(err)
+		return &proto.AuditResponse{Error: errors.MarshalError(err)}, nil
+	}
+
+	resp := &proto.AuditResponse{}
+	for _, name := range names {
+		res, err := s.inv.Audit(s.chgrs[name], req.DryRun)
+		if err != nil {
+			op.log(err)
+			resp.Error = errors.MarshalError(err)
+			return resp, nil
+		}
+
+		resp.Results = append(resp.Results, proto.AuditResultProto(res))
+	}
+
+	return resp, nil
+}
+
+func (s *server) Queue(reqBytes []byte) (pb.Message, error) {
+	var req proto.QueueRequest
+	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		return nil, err
+	}
+
+	op := logf("queue %q", req.Changer)
+
+	names, err := s.changers(req.Changer)
+	if err != nil {
+		op.log(err)
+		return &proto.QueueResponse{Error: errors.MarshalError(err)}, nil
+	}
+
+	resp := &proto.QueueResponse{}
+	for _, name := range names {
+		// changers that are not queued have nothing to report
+		if st, ok := changer.Stats(s.chgrs[name]); ok {
+			resp.Stats = append(resp.Stats, proto.QueueStatsProto(name, st))
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *server) TxList(reqBytes []byte) (pb.Message, error) {
+	var req taprproto.TxListRequest
+	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		return nil, err
+	}
+
+	return &taprproto.TxListResponse{Txs: s.txs.Transactions()}, nil
+}
+
+// changers returns the sorted names of the changers to operate on; the
+// named changer or all changers if name is empty.
+func (s *server) changers(name string) ([]string, error) {
+	if name != "" {
+		if _, ok := s.chgrs[name]; !ok {
+			return nil, errors.E(errors.NotExist, errors.Strf("unknown changer %q", name))
+		}
+
+		return []string{name}, nil
+	}
+
+	names := make([]string, 0, len(s.chgrs))
+	for name := range s.chgrs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
 func logf(format string, args ...interface{}) operation {
 	s := fmt.Sprintf(format, args...)
 	log.Debug.Print("rpc/invserver: " + s)
@@ -0,0 +1,128 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioserver
+
+import (
+	"os"
+	"path"
+
+	pb "github.com/golang/protobuf/proto"
+
+	"tapr.space"
+	"tapr.space/errors"
+	"tapr.space/proto"
+	"tapr.space/store"
+)
+
+func (s *server) List(reqBytes []byte, done <-chan struct{}) (<-chan pb.Message, error) {
+	op := operation("list")
+
+	var req proto.ListRequest
+
+	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		op.log(err)
+		return nil, err
+	}
+
+	// catch malformed patterns before starting the stream
+	if _, err := path.Match(req.Glob, ""); err != nil {
+		op.log(err)
+		return nil, errors.E(errors.Invalid, err)
+	}
+
+	name := tapr.PathName(req.Prefix)
+	if name == "" {
+		name = "/"
+	}
+
+	fi, err := s.st.Stat(name)
+	if err != nil {
+		op.log(err)
+		return nil, classify(err)
+	}
+
+	out := make(chan pb.Message)
+	go func() {
+		defer close(out)
+
+		send := func(ent *proto.ListEntry) bool {
+			select {
+			case out <- ent:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		visit := func(name tapr.PathName, fi os.FileInfo) bool {
+			if !match(req.Glob, name) {
+				return true
+			}
+
+			return send(&proto.ListEntry{
+				Info: proto.FileInfoProto(store.FileInfo(name, fi)),
+			})
+		}
+
+		if !fi.IsDir() {
+			visit(name, fi)
+			return
+		}
+
+		if err := s.walk(name, req.Recursive, visit); err != nil {
+			op.log(err)
+			send(&proto.ListEntry{Error: errors.MarshalError(classify(err))})
+		}
+	}()
+
+	return out, nil
+}
+
+// walk calls visit for each entry in the named directory, descending into
+// subdirectories if recursive is true. Walking stops if visit returns false.
+func (s *server) walk(dir tapr.PathName, recursive bool, visit func(tapr.PathName, os.FileInfo) bool) error {
+	fis, err := s.st.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range fis {
+		name := tapr.PathName(path.Join(string(dir), fi.Name()))
+
+		if !visit(name, fi) {
+			return nil
+		}
+
+		if recursive && fi.IsDir() {
+			if err := s.walk(name, recursive, visit); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// match reports whether the base name of the path name matches the glob
+// pattern. An empty pattern matches everything.
+func match(glob string, name tapr.PathName) bool {
+	if glob == "" {
+		return true
+	}
+
+	ok, _ := path.Match(glob, path.Base(string(name)))
+
+	return ok
+}
@@ -15,7 +15,11 @@
 package ioserver
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"io"
+	"os"
 
 	pb "github.com/golang/protobuf/proto"
 
@@ -24,35 +28,44 @@ import (
 	"tapr.space/log"
 	"tapr.space/proto"
 	"tapr.space/rpc"
+	"tapr.space/store"
 )
 
 func (s *server) PullPrepare(reqBytes []byte) (pb.Message, error) {
-	_ = operation("pull/prepare")
+	op := operation("pull/prepare")
 
-	var req proto.PushPrepareRequest
+	var req proto.PullPrepareRequest
 
 	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		op.log(err)
 		return nil, err
 	}
 
-	tx := rpc.GenerateTx()
-
-	log.Debug.Printf("rpc/ioserver[pull/prepare (tx: %s)]: %v", tx, req.Name)
-
-	f, err := s.st.Open(tapr.PathName(req.Name))
+	// the file is opened when the stream starts, such that a recall of the
+	// volumes holding it is abandoned if the client goes away
+	fi, err := store.Stat(s.st, tapr.PathName(req.Name))
 	if err != nil {
-		return nil, err
+		op.log(err)
+		return nil, classify(err)
 	}
 
-	if req.Offset != 0 {
-		if _, err := f.Seek(req.Offset, io.SeekStart); err != nil {
-			return nil, err
-		}
+	if fi.IsDir {
+		err := errors.E(tapr.PathName(req.Name), errors.IsDir)
+		op.log(err)
+		return nil, err
 	}
 
-	s.mu.fds[tx] = f
+	tx := s.txs.open(&transaction{
+		kind:  "pull",
+		name:  tapr.PathName(req.Name),
+		owner: req.Owner,
+		start: req.Offset,
+		sum:   fi.Checksum,
+	})
+
+	log.Debug.Printf("rpc/ioserver[pull/prepare (tx: %s)]: %v", tx, req.Name)
 
-	return &proto.PushPrepareResponse{
+	return &proto.PullPrepareResponse{
 		Tx: tx[:],
 	}, nil
 }
@@ -71,37 +84,115 @@ func (s *server) Pull(reqBytes []byte, done <-chan struct{}) (<-chan pb.Message,
 
 	log.Debug.Printf("rpc/ioserver[pull]: (tx: %s)", tx)
 
-	f := s.mu.fds[tx]
+	t, err := s.txs.acquire(tx, "pull", req.Owner)
+	if err != nil {
+		op.log(err)
+		return nil, err
+	}
 
 	out := make(chan pb.Message)
 	go func() {
 		defer close(out)
-		var cnk *proto.Chunk
-		for {
-			buf := make([]byte, 4096)
-			n, err := f.Read(buf)
-			if err != nil {
-				cnk = &proto.Chunk{
-					Error: errors.MarshalError(err),
+
+		// the transaction ends with the stream
+		defer func() {
+			s.txs.release(t)
+			if err := s.txs.close(tx); err != nil {
+				op.log(err)
+			}
+		}()
+
+		send := func(cnk *proto.Chunk) bool {
+			select {
+			case out <- cnk:
+				return true
+			case <-done:
+				log.Debug.Printf("rpc/ioserver[pull]: done closed; pull writer terminating")
+				return false
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		if err := s.open(ctx, t); err != nil {
+			op.log(err)
+			send(&proto.Chunk{Error: errors.MarshalError(classify(err))})
+			return
+		}
+
+		h := sha256.New()
+
+		// a pull starting at an offset digests the data before it as well,
+		// such that the whole file is checked against its checksum
+		if t.start != 0 {
+			if _, err := io.CopyN(h, t.f, t.start); err != nil {
+				if err == io.EOF {
+					err = errors.E(t.name, errors.Invalid, errors.Strf("offset %d is beyond the end of the file", t.start))
 				}
 
-				delete(s.mu.fds, tx)
+				op.log(err)
+				send(&proto.Chunk{Error: errors.MarshalError(err)})
 				return
 			}
+		}
 
-			cnk = &proto.Chunk{
-				Data: buf[:n],
+		for {
+			buf := make([]byte, 4096)
+			n, err := t.f.Read(buf)
+
+			if n > 0 {
+				h.Write(buf[:n])
+
+				if !send(proto.NewChunk(buf[:n])) {
+					return
+				}
+
+				s.txs.transferred(t, n)
 			}
 
-			select {
-			case out <- cnk:
+			if err == io.EOF {
+				break
+			}
 
-			case <-done:
-				log.Debug.Printf("rpc/ioserver[pull]: done closed; pull writer terminating")
+			if err != nil {
+				op.log(err)
+				send(&proto.Chunk{Error: errors.MarshalError(err)})
 				return
 			}
 		}
+
+		last := &proto.Chunk{Last: true, Digest: h.Sum(nil)}
+
+		if t.sum != nil && !bytes.Equal(last.Digest, t.sum) {
+			err := errors.E(t.name, errors.Invalid, errors.Str("contents do not match the recorded checksum"))
+			op.log(err)
+			send(&proto.Chunk{Error: errors.MarshalError(err)})
+			return
+		}
+
+		send(last)
 	}()
 
 	return out, nil
 }
+
+// open opens the file of the pull transaction.
+func (s *server) open(ctx context.Context, t *transaction) error {
+	f, err := store.OpenFileContext(ctx, s.st, t.name, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+
+	t.f = f
+
+	return nil
+}
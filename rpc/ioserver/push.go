@@ -15,9 +15,10 @@
 package ioserver
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"io"
 	"os"
-	"time"
 
 	pb "github.com/golang/protobuf/proto"
 
@@ -26,79 +27,283 @@ import (
 	"tapr.space/log"
 	"tapr.space/proto"
 	"tapr.space/rpc"
+	"tapr.space/storage"
+	"tapr.space/store"
 )
 
+// ackInterval is the number of bytes written to a pushed file between syncs
+// to stable storage. Every sync is acknowledged in the push log.
+const ackInterval = 4 << 20
+
 func (s *server) PushPrepare(reqBytes []byte) (pb.Message, error) {
+	op := operation("push/prepare")
+
 	var req proto.PushPrepareRequest
 
 	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		op.log(err)
 		return nil, err
 	}
 
-	tx := rpc.GenerateTx()
+	name := tapr.PathName(req.Name)
 
-	log.Debug.Printf("rpc/ioserver.PushPrepare (tx: %s): %v", tx, req.Name)
+	appending := req.Append || req.Resume
 
 	flags := os.O_CREATE | os.O_WRONLY
-	if req.Append {
+	if appending {
 		flags |= os.O_APPEND
 	} else {
 		flags |= os.O_TRUNC
 	}
 
-	f, err := s.st.OpenFile(tapr.PathName(req.Name), flags)
+	f, err := s.st.OpenFile(name, flags)
 	if err != nil {
+		op.log(err)
 		return nil, err
 	}
 
-	s.mu.fds[tx] = f
+	// appended data is written at the end of the file
+	var offset int64
+	if appending {
+		fi, err := s.st.Stat(name)
+		if err != nil {
+			f.Close()
+			op.log(err)
+			return nil, err
+		}
+
+		offset = fi.Size()
+	}
+
+	// a resumed push continues exactly at the offset acknowledged to the
+	// client; data written after it was never acknowledged
+	if req.Resume && offset != req.Offset {
+		if offset < req.Offset {
+			f.Close()
+			err := errors.E(name, errors.Invalid, errors.Strf("cannot resume at offset %d; the file ends at offset %d", req.Offset, offset))
+			op.log(err)
+			return nil, err
+		}
+
+		if err := storage.Truncate(f, req.Offset); err != nil {
+			f.Close()
+			op.log(err)
+			return nil, err
+		}
+
+		offset = req.Offset
+	}
+
+	tx := s.txs.open(&transaction{
+		kind:  "push",
+		name:  name,
+		owner: req.Owner,
+		f:     f,
+		start: offset,
+	})
+
+	log.Debug.Printf("rpc/ioserver.PushPrepare (tx: %s): %v at offset %d", tx, req.Name, offset)
 
 	return &proto.PushPrepareResponse{
-		Tx: tx[:],
+		Tx:     tx[:],
+		Offset: offset,
 	}, nil
 }
 
+// Push writes the pushed chunks to the file of the transaction. Each chunk is
+// checked against its CRC32C checksum. The data is synced to stable storage
+// every ackInterval bytes and when the stream ends; the progress is reported
+// by PushLog.
 func (s *server) Push(body io.Reader, done <-chan struct{}) (pb.Message, error) {
-	// read the transaction identifier
-	var tx rpc.Tx
-	if _, err := rpc.ReadFull(body, tx[:], done); err != nil {
+	op := operation("push")
+
+	// the stream starts with the transaction
+	b, err := rpc.ReadMessage(body, done)
+	if err != nil {
+		err = errors.E(errors.IO, err)
+		op.log(err)
 		return nil, err
 	}
 
+	var req proto.PushRequest
+
+	if err := pb.Unmarshal(b, &req); err != nil {
+		op.log(err)
+		return nil, err
+	}
+
+	tx := rpc.MakeTx(req.Tx)
+
 	log.Debug.Printf("rpc/ioserver.Push (tx: %s): starting", tx)
 
+	t, err := s.txs.acquire(tx, "push", req.Owner)
+	if err != nil {
+		op.log(err)
+		return nil, err
+	}
+
+	defer s.txs.release(t)
+
+	// end closes the file (unless already closed) before reporting the
+	// final progress, such that a resumed push sees all data written.
+	end := func(offset int64, err error) {
+		if cerr := s.txs.close(tx); cerr != nil {
+			op.log(cerr)
+		}
+
+		s.txs.ack(t, offset, err, true)
+	}
+
+	fail := func(err error) (pb.Message, error) {
+		op.log(err)
+
+		acked, _, _, _ := s.txs.progress(t)
+		end(acked, err)
+
+		return &proto.PushResponse{Error: errors.MarshalError(err)}, nil
+	}
+
+	// lost reports the last synced offset; a resumed push discards any
+	// data written after it.
+	lost := func(offset int64) (pb.Message, error) {
+		log.Debug.Printf("rpc/ioserver.Push (tx: %s): connection lost at offset %d; closing file", tx, offset)
+
+		acked, _, _, _ := s.txs.progress(t)
+		end(acked, errors.E(errors.IO, errors.Strf("push interrupted at offset %d", acked)))
+
+		return &proto.PushResponse{}, nil
+	}
+
 	stream := make(rpc.ChunkStream)
 
-	go rpc.ReadStream(body, stream, done)
+	go func() {
+		rpc.ReadStream(body, stream, done)
+		stream.Close()
+	}()
+
+	// h digests the data written in this transaction; the digest sent by
+	// the client in the last chunk covers the whole file.
+	h := sha256.New()
+	var digest []byte
+
+	acked, offset := t.start, t.start
 
 	for {
 		select {
-		case cnk := <-stream:
-			f := s.mu.fds[tx]
-			_, err := f.Write(cnk.Data)
+		case cnk, ok := <-stream:
+			if !ok {
+				select {
+				case <-done:
+					return lost(offset)
+				default:
+				}
+
+				log.Debug.Printf("rpc/ioserver.Push (tx: %s): stream ended; closing file", tx)
+
+				return s.finish(t, offset, digest, h.Sum(nil), fail)
+			}
+
+			if cnk.Error != nil {
+				return fail(errors.UnmarshalError(cnk.Error))
+			}
+
+			if err := cnk.Verify(); err != nil {
+				return fail(err)
+			}
+
+			if cnk.Last {
+				digest = cnk.Digest
+			}
+
+			n, err := t.f.Write(cnk.Data)
+			h.Write(cnk.Data[:n])
+			offset += int64(n)
+			s.txs.transferred(t, n)
 			if err != nil {
-				log.Debug.Print(err)
-				return &proto.PushResponse{Error: errors.MarshalError(err)}, nil
+				return fail(err)
 			}
 
-			log.Debug.Printf("rpc/ioserver.Push: received %d bytes", len(cnk.Data))
+			if offset-acked >= ackInterval {
+				if err := storage.Sync(t.f); err != nil {
+					return fail(err)
+				}
+
+				acked = offset
+				s.txs.ack(t, acked, nil, false)
+			}
 
 		case <-done:
-			log.Debug.Printf("rpc/ioserver.Push (tx: %s): done; closing file", tx)
+			return lost(offset)
+		}
+	}
+}
 
-			f := s.mu.fds[tx]
-			delete(s.mu.fds, tx)
-			f.Close()
+// finish completes a push whose stream ended at the given offset. The data
+// is synced and the file closed. If the client sent a digest of the file,
+// it is checked against the data and recorded by the store. A push that
+// started at offset zero is checked against the digest of the data written;
+// otherwise the file is read back.
+func (s *server) finish(t *transaction, offset int64, digest, sum []byte, fail func(error) (pb.Message, error)) (pb.Message, error) {
+	if err := storage.Sync(t.f); err != nil {
+		return fail(err)
+	}
+
+	if digest != nil && t.start == 0 && !bytes.Equal(sum, digest) {
+		return fail(errors.E(t.name, errors.Invalid, errors.Str("contents do not match the checksum sent by the client")))
+	}
+
+	if err := s.txs.close(t.id); err != nil {
+		return fail(err)
+	}
 
-			return &proto.PushResponse{}, nil
+	if digest != nil {
+		if t.start != 0 {
+			if err := s.verify(t.name, digest); err != nil {
+				return fail(err)
+			}
+		}
+
+		if err := store.SetChecksum(s.st, t.name, digest); err != nil {
+			return fail(err)
 		}
 	}
+
+	s.txs.ack(t, offset, nil, true)
+
+	return &proto.PushResponse{}, nil
 }
 
+// verify reads back the named file and checks it against the digest.
+func (s *server) verify(name tapr.PathName, digest []byte) error {
+	f, err := s.st.Open(name)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(h.Sum(nil), digest) {
+		return errors.E(name, errors.Invalid, errors.Str("contents do not match the checksum sent by the client"))
+	}
+
+	return nil
+}
+
+// PushLog streams the progress of a push. Each entry carries the file
+// offset up to which the pushed data is durable. The last entry is marked
+// done when the push completed or carries the error that ended it.
 func (s *server) PushLog(reqBytes []byte, done <-chan struct{}) (<-chan pb.Message, error) {
+	op := operation("push/log")
+
 	var req proto.PushLogRequest
 
 	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		op.log(err)
 		return nil, err
 	}
 
@@ -106,20 +311,49 @@ func (s *server) PushLog(reqBytes []byte, done <-chan struct{}) (<-chan pb.Messa
 
 	log.Debug.Printf("rpc/ioserver.PushLog: (tx: %s)", tx)
 
+	t, err := s.txs.acquire(tx, "push", req.Owner)
+	if err != nil {
+		op.log(err)
+		return nil, err
+	}
+
 	out := make(chan pb.Message)
 	go func() {
 		defer close(out)
+		defer s.txs.release(t)
 
-		var i int64
-
-		ticker := time.NewTicker(1 * time.Second)
+		var seq int64
+		sent := int64(-1)
 
 		for {
-			select {
-			case <-ticker.C:
-				out <- &proto.PushLogEntry{Seq: i}
-				i++
+			acked, finished, changed, err := s.txs.progress(t)
+
+			if acked != sent || finished {
+				ent := &proto.PushLogEntry{
+					Seq:    seq,
+					Offset: acked,
+					Done:   finished && err == nil,
+				}
+
+				if err != nil {
+					ent.Error = errors.MarshalError(err)
+				}
 
+				select {
+				case out <- ent:
+				case <-done:
+					return
+				}
+
+				if finished {
+					return
+				}
+
+				seq, sent = seq+1, acked
+			}
+
+			select {
+			case <-changed:
 			case <-done:
 				log.Debug.Printf("rpc/ioserver.PushLog: done closed; writer terminating")
 				return
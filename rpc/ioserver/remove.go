@@ -0,0 +1,40 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioserver
+
+import (
+	pb "github.com/golang/protobuf/proto"
+
+	"tapr.space"
+	"tapr.space/proto"
+)
+
+func (s *server) Remove(reqBytes []byte) (pb.Message, error) {
+	op := operation("remove")
+
+	var req proto.RemoveRequest
+
+	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		op.log(err)
+		return nil, err
+	}
+
+	if err := s.st.Remove(tapr.PathName(req.Name)); err != nil {
+		op.log(err)
+		return nil, classify(err)
+	}
+
+	return &proto.RemoveResponse{}, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioserver
+
+import (
+	pb "github.com/golang/protobuf/proto"
+
+	"tapr.space"
+	"tapr.space/errors"
+	"tapr.space/proto"
+)
+
+func (s *server) Rename(reqBytes []byte) (pb.Message, error) {
+	op := operation("rename")
+
+	var req proto.RenameRequest
+
+	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		op.log(err)
+		return nil, err
+	}
+
+	// renaming never replaces a file; stores backed by a file system would
+	// silently do so
+	if _, err := s.st.Stat(tapr.PathName(req.Newname)); err == nil {
+		err := errors.E(tapr.PathName(req.Newname), errors.Exist)
+		op.log(err)
+		return nil, err
+	}
+
+	if err := s.st.Rename(tapr.PathName(req.Oldname), tapr.PathName(req.Newname)); err != nil {
+		op.log(err)
+		return nil, classify(err)
+	}
+
+	return &proto.RenameResponse{}, nil
+}
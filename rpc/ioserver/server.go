@@ -17,10 +17,13 @@ package ioserver // import "tapr.space/rpc/ioserver"
 import (
 	"fmt"
 	"net/http"
-	"sync"
+	"os"
+	"syscall"
 
 	"tapr.space"
+	"tapr.space/errors"
 	"tapr.space/log"
+	"tapr.space/proto"
 	"tapr.space/rpc"
 	"tapr.space/store"
 )
@@ -30,31 +33,45 @@ type server struct {
 
 	st store.Store
 
-	mu struct {
-		sync.Mutex
+	// open transactions
+	txs *txTable
+}
 
-		// open files
-		fds map[rpc.Tx]tapr.File
-	}
+// A Server is an http.Handler that presents a storage server as a
+// service.
+type Server struct {
+	http.Handler
+
+	s *server
+}
+
+// Transactions returns the open transactions of the server, oldest first.
+// Listing the transactions is an administrative operation and is offered by
+// the inventory service.
+func (srv *Server) Transactions() []*proto.Transaction {
+	return srv.s.txs.list()
 }
 
-// New returns a new http.Handler that presents a storage server
-// as a service.
-func New(cfg tapr.Config, st store.Store) http.Handler {
+// New returns a new Server that presents a storage server as a service.
+func New(cfg tapr.Config, st store.Store) *Server {
 	s := &server{
 		config: cfg,
 		st:     st,
+		txs:    newTxTable(txTimeout),
 	}
 
-	s.mu.fds = make(map[rpc.Tx]tapr.File)
+	go s.txs.run(reapInterval)
 
-	return rpc.NewServer(cfg, rpc.Service{
+	h := rpc.NewServer(cfg, rpc.Service{
 		Name: st.String() + "/io",
 
 		// one-shot methods
 		Methods: map[string]rpc.Method{
 			"pull/prepare": s.PullPrepare,
 			"push/prepare": s.PushPrepare,
+			"remove":       s.Remove,
+			"rename":       s.Rename,
+			"stat":         s.Stat,
 		},
 
 		// ingress-based (stream in) methods
@@ -64,10 +81,13 @@ func New(cfg tapr.Config, st store.Store) http.Handler {
 
 		// egress-based (stream out) methods
 		Egress: map[string]rpc.Egress{
+			"list":     s.List,
 			"pull":     s.Pull,
 			"push/log": s.PushLog,
 		},
 	})
+
+	return &Server{Handler: h, s: s}
 }
 
 func logf(format string, args ...interface{}) operation {
@@ -81,3 +101,58 @@ type operation string
 func (op operation) log(err error) {
 	logf("%v failed: %v", op, err)
 }
+
+// classify gives errors returned by stores backed by a file system the
+// kind of error clients check for. Other errors are wrapped as well; only
+// an *errors.Error crosses the wire intact.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	kind := kindOf(err)
+	if _, ok := err.(*errors.Error); ok && kind == errors.Other {
+		return err
+	}
+
+	return errors.E(kind, err)
+}
+
+// kindOf returns the kind of error to give err if it has none; Other if
+// err is not a system error.
+func kindOf(err error) errors.Kind {
+	switch e := err.(type) {
+	case *errors.Error:
+		if e.Kind != errors.Other || e.Err == nil {
+			return errors.Other
+		}
+
+		return kindOf(e.Err)
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+
+	// os.IsExist also reports ENOTEMPTY, so look for it first
+	switch {
+	case err == syscall.ENOTEMPTY:
+		return errors.NotEmpty
+	case err == syscall.ENOTDIR:
+		return errors.NotDir
+	case err == syscall.EISDIR:
+		return errors.IsDir
+	case os.IsNotExist(err):
+		return errors.NotExist
+	case os.IsExist(err):
+		return errors.Exist
+	case os.IsPermission(err):
+		return errors.Permission
+	}
+
+	if _, ok := err.(syscall.Errno); ok {
+		return errors.IO
+	}
+
+	return errors.Other
+}
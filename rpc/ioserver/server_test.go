@@ -0,0 +1,583 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/golang/protobuf/proto"
+
+	"tapr.space"
+	"tapr.space/config"
+	"tapr.space/errors"
+	"tapr.space/proto"
+	"tapr.space/rpc"
+	"tapr.space/store"
+	fs "tapr.space/store/fs/service"
+)
+
+// setup returns a server presenting a file system store holding the files
+// /a, /d/b and /d/e/c.
+func setup(t *testing.T) (*server, func()) {
+	dir, err := ioutil.TempDir("", "ioserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := fs.New("test", config.StoreConfig{Embedded: fs.Config{Root: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{"a": "a", "d/b": "bb", "d/e/c": "ccc"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &server{st: st, txs: newTxTable(time.Minute)}
+
+	return s, func() { os.RemoveAll(dir) }
+}
+
+// call invokes a method, returning the error as seen by a client.
+func call(t *testing.T, method func([]byte) (pb.Message, error), req pb.Message) (pb.Message, error) {
+	t.Helper()
+
+	reqBytes, err := pb.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := method(reqBytes)
+	if err != nil {
+		return nil, wire(t, err)
+	}
+
+	return resp, nil
+}
+
+// wire returns err as received by a client.
+func wire(t *testing.T, err error) error {
+	t.Helper()
+
+	if _, ok := err.(*errors.Error); !ok {
+		t.Fatalf("%v (%T) does not carry a kind across the wire", err, err)
+	}
+
+	return errors.UnmarshalError(errors.MarshalError(err))
+}
+
+// list lists the prefix, returning the names listed.
+func list(t *testing.T, s *server, req *proto.ListRequest) ([]tapr.PathName, error) {
+	t.Helper()
+
+	reqBytes, err := pb.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := s.List(reqBytes, make(chan struct{}))
+	if err != nil {
+		return nil, wire(t, err)
+	}
+
+	var names []tapr.PathName
+	for msg := range msgs {
+		ent := msg.(*proto.ListEntry)
+		if len(ent.Error) != 0 {
+			return names, errors.UnmarshalError(ent.Error)
+		}
+
+		names = append(names, tapr.PathName(ent.Info.Name))
+	}
+
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+
+	return names, nil
+}
+
+func TestStat(t *testing.T) {
+	s, cleanup := setup(t)
+	defer cleanup()
+
+	resp, err := call(t, s.Stat, &proto.StatRequest{Name: "/d/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi := proto.TaprFileInfo(resp.(*proto.StatResponse))
+	if fi.Name != "/d/b" || fi.Size != 2 || fi.IsDir || fi.ModTime.IsZero() {
+		t.Errorf("got %+v, want 2 byte file /d/b", fi)
+	}
+
+	resp, err = call(t, s.Stat, &proto.StatRequest{Name: "/d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi := proto.TaprFileInfo(resp.(*proto.StatResponse)); !fi.IsDir {
+		t.Errorf("got %+v, want directory", fi)
+	}
+
+	if _, err := call(t, s.Stat, &proto.StatRequest{Name: "/nope"}); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want error of kind NotExist", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	s, cleanup := setup(t)
+	defer cleanup()
+
+	tests := []struct {
+		req  *proto.ListRequest
+		want []tapr.PathName
+	}{
+		{&proto.ListRequest{}, []tapr.PathName{"/a", "/d"}},
+		{&proto.ListRequest{Recursive: true}, []tapr.PathName{"/a", "/d", "/d/b", "/d/e", "/d/e/c"}},
+		{&proto.ListRequest{Prefix: "/d"}, []tapr.PathName{"/d/b", "/d/e"}},
+		{&proto.ListRequest{Prefix: "/d/b"}, []tapr.PathName{"/d/b"}},
+		{&proto.ListRequest{Recursive: true, Glob: "[ac]"}, []tapr.PathName{"/a", "/d/e/c"}},
+	}
+
+	for _, tt := range tests {
+		got, err := list(t, s, tt.req)
+		if err != nil {
+			t.Errorf("%v: %v", tt.req, err)
+			continue
+		}
+
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.req, got, tt.want)
+			continue
+		}
+
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v: got %v, want %v", tt.req, got, tt.want)
+				break
+			}
+		}
+	}
+
+	if _, err := list(t, s, &proto.ListRequest{Prefix: "/nope"}); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want error of kind NotExist", err)
+	}
+
+	if _, err := list(t, s, &proto.ListRequest{Glob: "["}); !errors.Is(errors.Invalid, err) {
+		t.Errorf("got %v, want error of kind Invalid", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	s, cleanup := setup(t)
+	defer cleanup()
+
+	if _, err := call(t, s.Remove, &proto.RemoveRequest{Name: "/a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := call(t, s.Stat, &proto.StatRequest{Name: "/a"}); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v after removal, want error of kind NotExist", err)
+	}
+
+	if _, err := call(t, s.Remove, &proto.RemoveRequest{Name: "/a"}); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want error of kind NotExist", err)
+	}
+
+	if _, err := call(t, s.Remove, &proto.RemoveRequest{Name: "/d"}); !errors.Is(errors.NotEmpty, err) {
+		t.Errorf("got %v, want error of kind NotEmpty", err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	s, cleanup := setup(t)
+	defer cleanup()
+
+	if _, err := call(t, s.Rename, &proto.RenameRequest{Oldname: "/a", Newname: "/d/a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := call(t, s.Stat, &proto.StatRequest{Name: "/a"}); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v for the old name, want error of kind NotExist", err)
+	}
+
+	if _, err := call(t, s.Stat, &proto.StatRequest{Name: "/d/a"}); err != nil {
+		t.Errorf("got %v for the new name, want file", err)
+	}
+
+	if _, err := call(t, s.Rename, &proto.RenameRequest{Oldname: "/a", Newname: "/f"}); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want error of kind NotExist", err)
+	}
+
+	// renaming onto an existing path leaves both files alone
+	if _, err := call(t, s.Rename, &proto.RenameRequest{Oldname: "/d/a", Newname: "/d/b"}); !errors.Is(errors.Exist, err) {
+		t.Errorf("got %v, want error of kind Exist", err)
+	}
+
+	for name, size := range map[string]int64{"/d/a": 1, "/d/b": 2} {
+		resp, err := call(t, s.Stat, &proto.StatRequest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi := resp.(*proto.StatResponse); fi.Size != size {
+			t.Errorf("%s: got size %d, want %d", name, fi.Size, size)
+		}
+	}
+}
+
+// frame returns the message as framed in a stream.
+func frame(t *testing.T, msg pb.Message) []byte {
+	t.Helper()
+
+	b, err := pb.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(len(b)))
+
+	return append(buf[:], b...)
+}
+
+func TestPushResume(t *testing.T) {
+	s, cleanup := setup(t)
+	defer cleanup()
+
+	const name = "/d/e/c"
+
+	stat := func() int64 {
+		t.Helper()
+
+		resp, err := call(t, s.Stat, &proto.StatRequest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.(*proto.StatResponse).Size
+	}
+
+	resume := func(offset int64) (*proto.PushPrepareResponse, error) {
+		resp, err := call(t, s.PushPrepare, &proto.PushPrepareRequest{Name: name, Append: true, Resume: true, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+
+		return resp.(*proto.PushPrepareResponse), nil
+	}
+
+	if _, err := resume(5); !errors.Is(errors.Invalid, err) {
+		t.Errorf("resuming beyond the end of the file: got %v, want error of kind Invalid", err)
+	}
+
+	resp, err := resume(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Offset != 1 {
+		t.Errorf("resumed at offset %d, want 1", resp.Offset)
+	}
+
+	tx := rpc.MakeTx(resp.Tx)
+
+	s.txs.mu.Lock()
+	tr := s.txs.txs[tx]
+	s.txs.mu.Unlock()
+
+	if size := stat(); size != 1 {
+		t.Errorf("size = %d after resuming at offset 1, want 1", size)
+	}
+
+	// interrupt the push after a chunk that is written but never synced
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	done := make(chan struct{})
+	returned := make(chan struct{})
+	go func() {
+		s.Push(pr, done)
+		close(returned)
+	}()
+
+	if _, err := pw.Write(frame(t, &proto.PushRequest{Tx: tx[:]})); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pw.Write(frame(t, proto.NewChunk([]byte("xyz")))); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.txs.mu.Lock()
+		n := tr.bytes
+		s.txs.mu.Unlock()
+
+		if n == 3 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("chunk was not written")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(done)
+	<-returned
+
+	acked, finished, _, err := s.txs.progress(tr)
+	if acked != 1 || !finished || !errors.Is(errors.IO, err) {
+		t.Errorf("got offset %d, finished %v, error %v; want offset 1 and error of kind IO", acked, finished, err)
+	}
+
+	// the unacknowledged data is discarded when the push is resumed
+	if resp, err = resume(acked); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.txs.close(rpc.MakeTx(resp.Tx)); err != nil {
+		t.Fatal(err)
+	}
+
+	if size := stat(); size != 1 {
+		t.Errorf("size = %d after resuming at offset 1, want 1", size)
+	}
+}
+
+// summed is a store recording the checksums of its files.
+type summed struct {
+	store.Store
+
+	mu   sync.Mutex
+	sums map[tapr.PathName][]byte
+}
+
+func (st *summed) SetChecksum(name tapr.PathName, sum []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.sums[name] = sum
+
+	return nil
+}
+
+func (st *summed) Stat(name tapr.PathName) (os.FileInfo, error) {
+	fi, err := st.Store.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return &summedInfo{FileInfo: fi, info: &tapr.FileInfo{Checksum: st.sums[name]}}, nil
+}
+
+type summedInfo struct {
+	os.FileInfo
+	info *tapr.FileInfo
+}
+
+func (fi *summedInfo) Sys() interface{} {
+	return fi.info
+}
+
+// push pushes the chunks to the named file, returning the error reported
+// by the server.
+func push(t *testing.T, s *server, name tapr.PathName, cnks ...*proto.Chunk) error {
+	t.Helper()
+
+	resp, err := call(t, s.PushPrepare, &proto.PushPrepareRequest{Name: string(name)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	body.Write(frame(t, &proto.PushRequest{Tx: resp.(*proto.PushPrepareResponse).Tx}))
+
+	for _, cnk := range cnks {
+		body.Write(frame(t, cnk))
+	}
+
+	msg, err := s.Push(&body, make(chan struct{}))
+	if err != nil {
+		return wire(t, err)
+	}
+
+	if e := msg.(*proto.PushResponse).Error; e != nil {
+		return errors.UnmarshalError(e)
+	}
+
+	return nil
+}
+
+// pull pulls the named file from the given offset, returning the data and
+// the digest sent by the server.
+func pull(t *testing.T, s *server, name tapr.PathName, offset int64) ([]byte, []byte, error) {
+	t.Helper()
+
+	resp, err := call(t, s.PullPrepare, &proto.PullPrepareRequest{Name: string(name), Offset: offset})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reqBytes, err := pb.Marshal(&proto.PullRequest{Tx: resp.(*proto.PullPrepareResponse).Tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := s.Pull(reqBytes, make(chan struct{}))
+	if err != nil {
+		return nil, nil, wire(t, err)
+	}
+
+	var data []byte
+	for msg := range msgs {
+		cnk := msg.(*proto.Chunk)
+		if cnk.Error != nil {
+			return data, nil, errors.UnmarshalError(cnk.Error)
+		}
+
+		if err := cnk.Verify(); err != nil {
+			t.Fatal(err)
+		}
+
+		data = append(data, cnk.Data...)
+
+		if cnk.Last {
+			return data, cnk.Digest, nil
+		}
+	}
+
+	t.Fatal("pull ended without a last chunk")
+
+	return nil, nil, nil
+}
+
+func TestChunkVerify(t *testing.T) {
+	cnk := proto.NewChunk([]byte("data"))
+	if err := cnk.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	cnk.Data[0] = 'D'
+	if err := cnk.Verify(); !errors.Is(errors.IO, err) {
+		t.Errorf("got %v for a corrupt chunk, want error of kind IO", err)
+	}
+}
+
+func TestPushCorrupt(t *testing.T) {
+	s, cleanup := setup(t)
+	defer cleanup()
+
+	cnk := proto.NewChunk([]byte("data"))
+	cnk.Crc32C++
+
+	if err := push(t, s, "/p", cnk); !errors.Is(errors.IO, err) {
+		t.Errorf("got %v for a corrupt chunk, want error of kind IO", err)
+	}
+}
+
+func TestPushDigest(t *testing.T) {
+	s, cleanup := setup(t)
+	defer cleanup()
+
+	st := &summed{Store: s.st, sums: make(map[tapr.PathName][]byte)}
+	s.st = st
+
+	data := []byte("data")
+	sum := sha256.Sum256(data)
+
+	last := &proto.Chunk{Last: true, Digest: sha256.New().Sum(nil)}
+	if err := push(t, s, "/p", proto.NewChunk(data), last); !errors.Is(errors.Invalid, err) {
+		t.Errorf("got %v for a wrong digest, want error of kind Invalid", err)
+	}
+
+	if st.sums["/p"] != nil {
+		t.Error("wrong digest was recorded")
+	}
+
+	last.Digest = sum[:]
+	if err := push(t, s, "/p", proto.NewChunk(data), last); err != nil {
+		t.Fatal(err)
+	}
+
+	// the recorded digest is reported by stat
+	resp, err := call(t, s.Stat, &proto.StatRequest{Name: "/p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi := proto.TaprFileInfo(resp.(*proto.StatResponse)); !bytes.Equal(fi.Checksum, sum[:]) {
+		t.Errorf("got checksum %x, want %x", fi.Checksum, sum)
+	}
+}
+
+func TestPullDigest(t *testing.T) {
+	s, cleanup := setup(t)
+	defer cleanup()
+
+	st := &summed{Store: s.st, sums: make(map[tapr.PathName][]byte)}
+	s.st = st
+
+	sum := sha256.Sum256([]byte("bb"))
+	st.sums["/d/b"] = sum[:]
+
+	for _, offset := range []int64{0, 1} {
+		data, digest, err := pull(t, s, "/d/b", offset)
+		if err != nil {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+
+		// the digest covers the whole file, also when resuming
+		if string(data) != "bb"[offset:] || !bytes.Equal(digest, sum[:]) {
+			t.Errorf("offset %d: got %q with digest %x, want %q with digest %x", offset, data, digest, "bb"[offset:], sum)
+		}
+	}
+
+	// a stored digest not matching the data is reported
+	st.sums["/d/b"] = sha256.New().Sum(nil)
+
+	for _, offset := range []int64{0, 1} {
+		if _, _, err := pull(t, s, "/d/b", offset); !errors.Is(errors.Invalid, err) {
+			t.Errorf("offset %d: got %v, want error of kind Invalid", offset, err)
+		}
+	}
+
+	if _, _, err := pull(t, s, "/d/b", 3); !errors.Is(errors.Invalid, err) {
+		t.Errorf("pulling beyond the end: got %v, want error of kind Invalid", err)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioserver
+
+import (
+	pb "github.com/golang/protobuf/proto"
+
+	"tapr.space"
+	"tapr.space/proto"
+	"tapr.space/store"
+)
+
+func (s *server) Stat(reqBytes []byte) (pb.Message, error) {
+	op := operation("stat")
+
+	var req proto.StatRequest
+
+	if err := pb.Unmarshal(reqBytes, &req); err != nil {
+		op.log(err)
+		return nil, err
+	}
+
+	fi, err := store.Stat(s.st, tapr.PathName(req.Name))
+	if err != nil {
+		op.log(err)
+		return nil, classify(err)
+	}
+
+	return proto.FileInfoProto(fi), nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioserver
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"tapr.space"
+	"tapr.space/errors"
+	"tapr.space/log"
+	"tapr.space/proto"
+	"tapr.space/rpc"
+)
+
+const (
+	// txTimeout is the time a transaction may stay idle before it is
+	// reaped and its file closed.
+	txTimeout = 10 * time.Minute
+
+	// reapInterval is the interval between checks for idle transactions.
+	reapInterval = time.Minute
+)
+
+// A transaction is a file opened by a prepare call and used by the stream
+// calls that follow it.
+type transaction struct {
+	id      rpc.Tx
+	kind    string
+	name    tapr.PathName
+	owner   string
+	created time.Time
+
+	// the file; a pull opens it when the stream starts
+	f tapr.File
+
+	// file offset at which the transaction started
+	start int64
+
+	// SHA-256 digest of the file as recorded by the store (pulls only)
+	sum []byte
+
+	// protected by txTable.mu
+	deadline time.Time
+	bytes    int64
+	refs     int
+
+	// progress of a push, protected by txTable.mu; changed is closed and
+	// replaced whenever the progress changes.
+	acked    int64
+	err      error
+	finished bool
+	changed  chan struct{}
+}
+
+// closeFile closes the file of the transaction, if opened.
+func (t *transaction) closeFile() error {
+	if t.f == nil {
+		return nil
+	}
+
+	return t.f.Close()
+}
+
+// txTable holds the open transactions of a server.
+type txTable struct {
+	timeout time.Duration
+
+	mu  sync.Mutex
+	txs map[rpc.Tx]*transaction
+}
+
+func newTxTable(timeout time.Duration) *txTable {
+	return &txTable{
+		timeout: timeout,
+		txs:     make(map[rpc.Tx]*transaction),
+	}
+}
+
+// open registers a new transaction. The kind, name, owner, file, start
+// offset and checksum are taken from t; the rest is filled in.
+func (tt *txTable) open(t *transaction) rpc.Tx {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	now := time.Now()
+
+	t.id = rpc.GenerateTx()
+	t.created = now
+	t.deadline = now.Add(tt.timeout)
+	t.acked = t.start
+	t.changed = make(chan struct{})
+
+	tt.txs[t.id] = t
+
+	return t.id
+}
+
+// acquire returns the transaction of the given kind identified by id. Only
+// the owner of the transaction may use it. The transaction is not reaped
+// until it is released again.
+func (tt *txTable) acquire(id rpc.Tx, kind, owner string) (*transaction, error) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	t, ok := tt.txs[id]
+	if !ok {
+		return nil, errors.E(errors.NotExist, errors.Strf("unknown transaction %v", id))
+	}
+
+	if t.kind != kind {
+		return nil, errors.E(errors.Invalid, errors.Strf("transaction %v is a %s transaction", id, t.kind))
+	}
+
+	if t.owner != owner {
+		return nil, errors.E(errors.Permission, errors.Strf("transaction %v is owned by %s", id, t.owner))
+	}
+
+	t.refs++
+
+	return t, nil
+}
+
+// release releases a transaction returned by acquire. The transaction
+// expires if it is not used again within the timeout.
+func (tt *txTable) release(t *transaction) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	t.refs--
+	t.deadline = time.Now().Add(tt.timeout)
+}
+
+// transferred records that n bytes were transferred in the transaction.
+func (tt *txTable) transferred(t *transaction, n int) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	t.bytes += int64(n)
+}
+
+// ack records the progress of a push; the data up to offset is durable.
+// An error or finished ends the push.
+func (tt *txTable) ack(t *transaction, offset int64, err error, finished bool) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	if t.finished {
+		return
+	}
+
+	t.acked, t.err, t.finished = offset, err, finished || err != nil
+
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// progress returns the progress of a push along with a channel that is
+// closed when it changes.
+func (tt *txTable) progress(t *transaction) (acked int64, finished bool, changed <-chan struct{}, err error) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	return t.acked, t.finished, t.changed, t.err
+}
+
+// close ends the transaction identified by id and closes its file.
+func (tt *txTable) close(id rpc.Tx) error {
+	tt.mu.Lock()
+	t, ok := tt.txs[id]
+	delete(tt.txs, id)
+	tt.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return t.closeFile()
+}
+
+// reap ends the transactions that have been idle past their deadline and
+// returns their identifiers.
+func (tt *txTable) reap(now time.Time) []rpc.Tx {
+	tt.mu.Lock()
+	var idle []*transaction
+	for id, t := range tt.txs {
+		if t.refs == 0 && now.After(t.deadline) {
+			idle = append(idle, t)
+			delete(tt.txs, id)
+		}
+	}
+	tt.mu.Unlock()
+
+	ids := make([]rpc.Tx, len(idle))
+	for i, t := range idle {
+		if err := t.closeFile(); err != nil {
+			log.Error.Printf("rpc/ioserver: reaping %s transaction %v (%s): %v", t.kind, t.id, t.name, err)
+		}
+
+		ids[i] = t.id
+	}
+
+	return ids
+}
+
+// run reaps idle transactions at the given interval. It never returns.
+func (tt *txTable) run(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		for _, id := range tt.reap(now) {
+			logf("tx %v: idle; reaped", id)
+		}
+	}
+}
+
+// list returns the open transactions, oldest first.
+func (tt *txTable) list() []*proto.Transaction {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	txs := make([]*proto.Transaction, 0, len(tt.txs))
+	for _, t := range tt.txs {
+		txs = append(txs, &proto.Transaction{
+			Tx:       t.id[:],
+			Kind:     t.kind,
+			Name:     string(t.name),
+			Owner:    t.owner,
+			Created:  t.created.UnixNano(),
+			Deadline: t.deadline.UnixNano(),
+			Bytes:    t.bytes,
+			Active:   t.refs > 0,
+		})
+	}
+
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].Created < txs[j].Created
+	})
+
+	return txs
+}
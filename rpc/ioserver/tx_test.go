@@ -0,0 +1,139 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioserver
+
+import (
+	"testing"
+	"time"
+
+	"tapr.space"
+	"tapr.space/errors"
+)
+
+type nopFile struct {
+	tapr.File
+	closed bool
+}
+
+func (f *nopFile) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestReap(t *testing.T) {
+	tt := newTxTable(time.Minute)
+
+	idle, busy := &nopFile{}, &nopFile{}
+
+	idleTx := tt.open(&transaction{kind: "push", name: "/idle", owner: "test@localhost", f: idle})
+	busyTx := tt.open(&transaction{kind: "pull", name: "/busy", owner: "test@localhost", f: busy})
+
+	if _, err := tt.acquire(busyTx, "pull", "test@localhost"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := tt.reap(time.Now()); len(ids) != 0 {
+		t.Errorf("reaped %v before the deadline", ids)
+	}
+
+	ids := tt.reap(time.Now().Add(2 * time.Minute))
+	if len(ids) != 1 || ids[0] != idleTx {
+		t.Errorf("got %v reaped, want %v", ids, idleTx)
+	}
+
+	if !idle.closed || busy.closed {
+		t.Errorf("got idle closed %v and busy closed %v, want true and false", idle.closed, busy.closed)
+	}
+
+	if _, err := tt.acquire(idleTx, "push", "test@localhost"); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want error of kind NotExist", err)
+	}
+
+	if txs := tt.list(); len(txs) != 1 || !txs[0].Active {
+		t.Errorf("got %v, want a single active transaction", txs)
+	}
+}
+
+func TestAcquireKind(t *testing.T) {
+	tt := newTxTable(time.Minute)
+
+	tx := tt.open(&transaction{kind: "pull", name: "/file", owner: "test@localhost", f: &nopFile{}})
+
+	if _, err := tt.acquire(tx, "push", "test@localhost"); !errors.Is(errors.Invalid, err) {
+		t.Errorf("got %v, want error of kind Invalid", err)
+	}
+}
+
+func TestAcquireOwner(t *testing.T) {
+	tt := newTxTable(time.Minute)
+
+	tx := tt.open(&transaction{kind: "push", name: "/file", owner: "test@localhost", f: &nopFile{}})
+
+	if _, err := tt.acquire(tx, "push", "other@localhost"); !errors.Is(errors.Permission, err) {
+		t.Errorf("got %v, want error of kind Permission", err)
+	}
+}
+
+func TestTransferred(t *testing.T) {
+	tt := newTxTable(time.Minute)
+
+	tx := tt.open(&transaction{kind: "push", name: "/file", owner: "test@localhost", f: &nopFile{}})
+
+	tr, err := tt.acquire(tx, "push", "test@localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt.transferred(tr, 10)
+	tt.transferred(tr, 32)
+	tt.release(tr)
+
+	if txs := tt.list(); len(txs) != 1 || txs[0].Bytes != 42 || txs[0].Active {
+		t.Errorf("got %v, want a single idle transaction with 42 bytes", txs)
+	}
+}
+
+func TestAck(t *testing.T) {
+	tt := newTxTable(time.Minute)
+
+	tx := tt.open(&transaction{kind: "push", name: "/file", owner: "test@localhost", f: &nopFile{}, start: 100})
+
+	tr, err := tt.acquire(tx, "push", "test@localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acked, finished, changed, err := tt.progress(tr)
+	if acked != 100 || err != nil || finished {
+		t.Fatalf("got (%d, %v, %v), want the push to start at offset 100", acked, err, finished)
+	}
+
+	tt.ack(tr, 200, nil, false)
+
+	select {
+	case <-changed:
+	default:
+		t.Fatal("progress change not signalled")
+	}
+
+	tt.ack(tr, 300, errors.Str("write failed"), false)
+
+	// errors end the push; later acknowledgements are ignored
+	tt.ack(tr, 400, nil, true)
+
+	if acked, finished, _, err := tt.progress(tr); acked != 300 || err == nil || !finished {
+		t.Errorf("got (%d, %v, %v), want the push to have failed at offset 300", acked, err, finished)
+	}
+}
@@ -87,8 +87,9 @@ func ReadStream(r io.Reader, stream StreamChan, done <-chan struct{}) {
 
 	for {
 		// read the 4 byte, big-endian encoded int32
-		if _, err := ReadFull(r, msgLen[:], done); err == io.ErrUnexpectedEOF {
-			log.Debug.Print("rpc/ReadStream: unexpected EOF; stream done")
+		// the stream ends cleanly at a message boundary
+		if _, err := ReadFull(r, msgLen[:], done); err == io.EOF || err == io.ErrUnexpectedEOF {
+			log.Debug.Print("rpc/ReadStream: EOF; stream done")
 			return
 		} else if err != nil {
 			stream.Error(errors.E(errors.IO, err))
@@ -222,3 +223,33 @@ func (s LogStream) Error(err error) {
 	log.Debug.Printf("rpc/LogStream.Error: %v", err)
 	s <- proto.PushLogEntry{Error: errors.MarshalError(err)}
 }
+
+// ListStream is an implementation of StreamChan carrying proto.ListEntry.
+type ListStream chan proto.ListEntry
+
+// Send implements StreamChan.
+func (s ListStream) Send(b []byte, done <-chan struct{}) error {
+	var ent proto.ListEntry
+	if err := pb.Unmarshal(b, &ent); err != nil {
+		return err
+	}
+
+	select {
+	case s <- ent:
+	case <-done:
+	}
+
+	return nil
+}
+
+// Close implements StreamChan.
+func (s ListStream) Close() {
+	log.Debug.Print("rpc/ListStream.Close: closing")
+	close(s)
+}
+
+// Error implements StreamChan.
+func (s ListStream) Error(err error) {
+	log.Debug.Printf("rpc/ListStream.Error: %v", err)
+	s <- proto.ListEntry{Error: errors.MarshalError(err)}
+}
@@ -17,6 +17,7 @@
 package fsdir
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -65,3 +66,15 @@ func (s *Storage) MkdirAll(name tapr.PathName) error {
 func (s *Storage) Stat(name tapr.PathName) (os.FileInfo, error) {
 	return os.Stat(filepath.Join(s.root, string(name)))
 }
+
+func (s *Storage) ReadDir(name tapr.PathName) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(filepath.Join(s.root, string(name)))
+}
+
+func (s *Storage) Remove(name tapr.PathName) error {
+	return os.Remove(filepath.Join(s.root, string(name)))
+}
+
+func (s *Storage) Rename(oldpath, newpath tapr.PathName) error {
+	return os.Rename(filepath.Join(s.root, string(oldpath)), filepath.Join(s.root, string(newpath)))
+}
@@ -18,6 +18,7 @@ import (
 	"os"
 
 	"tapr.space"
+	"tapr.space/errors"
 )
 
 // Storage is the storage interface.
@@ -46,4 +47,45 @@ type Storage interface {
 	// used for all directories that MkdirAll creates. If path is already a
 	// directory, MkdirAll does nothing and returns nil.
 	MkdirAll(tapr.PathName) error
+
+	// ReadDir reads the named directory and returns a list of directory
+	// entries sorted by name.
+	ReadDir(tapr.PathName) ([]os.FileInfo, error)
+
+	// Remove removes the named file or (empty) directory.
+	Remove(tapr.PathName) error
+
+	// Rename renames (moves) oldpath to newpath.
+	Rename(oldpath, newpath tapr.PathName) error
+}
+
+// A Syncer is a file that can commit the data written to it to stable
+// storage.
+type Syncer interface {
+	Sync() error
+}
+
+// Sync commits the data written to the file to stable storage if the file
+// implements Syncer. Otherwise, data is assumed to be stable once written.
+func Sync(f tapr.File) error {
+	if s, ok := f.(Syncer); ok {
+		return s.Sync()
+	}
+
+	return nil
+}
+
+// A Truncater is a file that can be truncated.
+type Truncater interface {
+	Truncate(size int64) error
+}
+
+// Truncate changes the size of the file if it implements Truncater.
+// Otherwise, an error of kind errors.Invalid is returned.
+func Truncate(f tapr.File, size int64) error {
+	if t, ok := f.(Truncater); ok {
+		return t.Truncate(size)
+	}
+
+	return errors.E(tapr.PathName(f.Name()), errors.Invalid, errors.Str("file cannot be truncated"))
 }
@@ -20,6 +20,9 @@ package service
 import (
 	"bytes"
 	"os"
+	"path"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -142,6 +145,55 @@ func (s *service) MkdirAll(tapr.PathName) error {
 	return nil
 }
 
+func (s *service) ReadDir(path tapr.PathName) ([]os.FileInfo, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	prefix := strings.TrimSuffix(string(path), "/") + "/"
+
+	var fis []os.FileInfo
+	for name, b := range s.data.mu.blobs {
+		// the store is flat; only files directly below path are listed
+		if rel := strings.TrimPrefix(string(name), prefix); rel != string(name) && !strings.Contains(rel, "/") {
+			fis = append(fis, &fileInfo{file: &file{name: name, buf: bytes.NewBuffer(b)}})
+		}
+	}
+
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+
+	return fis, nil
+}
+
+func (s *service) Remove(path tapr.PathName) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if _, ok := s.data.mu.blobs[path]; !ok {
+		return &os.PathError{Op: "remove", Path: string(path), Err: os.ErrNotExist}
+	}
+
+	delete(s.data.mu.blobs, path)
+
+	return nil
+}
+
+func (s *service) Rename(oldpath, newpath tapr.PathName) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	b, ok := s.data.mu.blobs[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: string(oldpath), New: string(newpath), Err: os.ErrNotExist}
+	}
+
+	delete(s.data.mu.blobs, oldpath)
+	s.data.mu.blobs[newpath] = b
+
+	return nil
+}
+
 func (s *service) Stat(path tapr.PathName) (os.FileInfo, error) {
 	s.data.mu.Lock()
 	defer s.data.mu.Unlock()
@@ -164,8 +216,8 @@ type fileInfo struct {
 }
 
 func (fi *fileInfo) Size() int64        { return int64(fi.file.buf.Len()) }
-func (fi *fileInfo) IsDir() bool        { panic("not implemented for inprocess") }
+func (fi *fileInfo) IsDir() bool        { return false }
 func (fi *fileInfo) Mode() os.FileMode  { return os.ModePerm }
 func (fi *fileInfo) ModTime() time.Time { return time.Unix(0, 0) }
 func (fi *fileInfo) Sys() interface{}   { return fi.file }
-func (fi *fileInfo) Name() string       { return string(fi.file.name) }
+func (fi *fileInfo) Name() string       { return path.Base(string(fi.file.name)) }
@@ -17,6 +17,10 @@
 package store // import "tapr.space/store"
 
 import (
+	"context"
+	"os"
+
+	"tapr.space"
 	"tapr.space/config"
 	"tapr.space/errors"
 	"tapr.space/storage"
@@ -48,6 +52,41 @@ type Store interface {
 	storage.Storage
 }
 
+// A Checksummer is a Store that records the checksums of its files.
+type Checksummer interface {
+	// SetChecksum records the SHA-256 digest of the contents of the named
+	// file. Writing to the file discards the recorded digest.
+	SetChecksum(name tapr.PathName, sum []byte) error
+}
+
+// SetChecksum records the SHA-256 digest of the contents of the named file
+// if the store is a Checksummer.
+func SetChecksum(st Store, name tapr.PathName, sum []byte) error {
+	if cs, ok := st.(Checksummer); ok {
+		return cs.SetChecksum(name, sum)
+	}
+
+	return nil
+}
+
+// A ContextOpener is a Store that ties the work done to open and read or
+// write a file, such as waiting for a volume to be recalled, to a context.
+type ContextOpener interface {
+	// OpenFileContext is like OpenFile, but gives up waiting once ctx is
+	// done.
+	OpenFileContext(ctx context.Context, name tapr.PathName, flag int) (tapr.File, error)
+}
+
+// OpenFileContext opens the named file. If the store is a ContextOpener,
+// the work done for the file is abandoned once ctx is done.
+func OpenFileContext(ctx context.Context, st Store, name tapr.PathName, flag int) (tapr.File, error) {
+	if co, ok := st.(ContextOpener); ok {
+		return co.OpenFileContext(ctx, name, flag)
+	}
+
+	return st.OpenFile(name, flag)
+}
+
 // Create creates a new store using the given named implementation.
 func Create(name string, cfg config.StoreConfig) (Store, error) {
 	const op = "store.Create"
@@ -59,3 +98,38 @@ func Create(name string, cfg config.StoreConfig) (Store, error) {
 
 	return fn(name, cfg)
 }
+
+// Stat returns a tapr.FileInfo describing the named file in the store.
+func Stat(st Store, name tapr.PathName) (*tapr.FileInfo, error) {
+	const op = "store.Stat"
+
+	fi, err := st.Stat(name)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return FileInfo(name, fi), nil
+}
+
+// FileInfo converts the os.FileInfo of the named file to a tapr.FileInfo. A
+// store that knows more about its files than an os.FileInfo conveys, such
+// as the residency of files stored on tape, returns a *tapr.FileInfo from
+// the Sys method of the os.FileInfo; its checksum, residency and volumes
+// are included.
+func FileInfo(name tapr.PathName, fi os.FileInfo) *tapr.FileInfo {
+	info := &tapr.FileInfo{
+		Name:    name,
+		Size:    fi.Size(),
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
+		IsDir:   fi.IsDir(),
+	}
+
+	if sys, ok := fi.Sys().(*tapr.FileInfo); ok {
+		info.Checksum = sys.Checksum
+		info.Residency = sys.Residency
+		info.Volumes = sys.Volumes
+	}
+
+	return info
+}
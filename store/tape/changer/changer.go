@@ -70,3 +70,57 @@ type Changer interface {
 	// Status returns info about the changer and a list of slots.
 	Status() (map[tape.SlotCategory]tape.Slots, error)
 }
+
+type named struct {
+	Changer
+
+	name string
+}
+
+// Named returns a Changer that reports the locations of its slots and
+// volumes as belonging to the named changer.
+func Named(name string, chgr Changer) Changer {
+	return &named{Changer: chgr, name: name}
+}
+
+// String returns the name of the changer.
+func (chgr *named) String() string {
+	return chgr.name
+}
+
+// Status implements Changer.
+func (chgr *named) Status() (map[tape.SlotCategory]tape.Slots, error) {
+	slots, err := chgr.Changer.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	stamp := func(loc *tape.Location) {
+		if *loc != (tape.Location{}) {
+			loc.Changer = chgr.name
+		}
+	}
+
+	for _, ss := range slots {
+		for i := range ss {
+			stamp(&ss[i].Location)
+
+			if vol := ss[i].Volume; vol != nil {
+				stamp(&vol.Location)
+				stamp(&vol.Home)
+			}
+		}
+	}
+
+	return slots, nil
+}
+
+// Name returns the name given to a changer by Named. The empty string is
+// returned for unnamed changers.
+func Name(chgr Changer) string {
+	if n, ok := chgr.(*named); ok {
+		return n.name
+	}
+
+	return ""
+}
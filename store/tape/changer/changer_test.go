@@ -0,0 +1,71 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package changer_test
+
+import (
+	"testing"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/changer/fake"
+)
+
+func TestNamed(t *testing.T) {
+	c, err := fake.New(map[string]interface{}{
+		"transfer": "1",
+		"storage":  "2",
+		"ix":       "1",
+		"volumes":  "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chgr := changer.Named("secondary", c)
+
+	if name := changer.Name(chgr); name != "secondary" {
+		t.Errorf("Name returned %q, want %q", name, "secondary")
+	}
+
+	if name := changer.Name(c); name != "" {
+		t.Errorf("Name of unnamed changer returned %q, want empty name", name)
+	}
+
+	slots, err := chgr.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	for _, cat := range tape.SlotCategories {
+		for _, slot := range slots[cat] {
+			if slot.Location.Changer != "secondary" {
+				t.Errorf("slot %v not stamped with the changer name", slot.Location)
+			}
+
+			if vol := slot.Volume; vol != nil {
+				n++
+
+				if vol.Location.Changer != "secondary" {
+					t.Errorf("volume %v not stamped with the changer name", vol)
+				}
+			}
+		}
+	}
+
+	if n == 0 {
+		t.Error("found no volumes")
+	}
+}
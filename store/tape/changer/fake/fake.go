@@ -37,6 +37,8 @@ type changerImpl struct {
 	mu sync.Mutex
 
 	slots map[tape.SlotCategory]tape.Slots
+
+	faults *faults
 }
 
 var _ changer.Changer = (*changerImpl)(nil)
@@ -70,8 +72,21 @@ func New(opts map[string]interface{}) (changer.Changer, error) {
 		vols = v.([]tape.Volume)
 	}
 
+	// volume serials are prefixed to keep them unique across changers
+	prefix, cleaning := "A", "CLN000L1"
+	if v, ok := opts["prefix"]; ok {
+		prefix = v.(string)
+		cleaning = fmt.Sprintf("CLN%s00L1", prefix)
+	}
+
+	f, err := newFaults(opts)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
 	chgr := changerImpl{
-		slots: make(map[tape.SlotCategory]tape.Slots),
+		slots:  make(map[tape.SlotCategory]tape.Slots),
+		faults: f,
 	}
 
 	slots := make(tape.Slots, sopts["transfer"])
@@ -105,7 +120,7 @@ func New(opts map[string]interface{}) (changer.Changer, error) {
 		if vols == nil {
 			if i < sopts["volumes"] {
 				slot.Volume = &tape.Volume{
-					Serial:   tape.Serial(fmt.Sprintf("%c%05dL7", 'A', i)),
+					Serial:   tape.Serial(fmt.Sprintf("%s%05dL7", prefix, i)),
 					Location: slot.Location,
 				}
 			}
@@ -115,7 +130,7 @@ func New(opts map[string]interface{}) (changer.Changer, error) {
 	}
 
 	slots[len(slots)-1].Volume = &tape.Volume{
-		Serial:   tape.Serial("CLN000L1"),
+		Serial:   tape.Serial(cleaning),
 		Location: slots[len(slots)-1].Location,
 	}
 
@@ -133,16 +148,72 @@ func New(opts map[string]interface{}) (changer.Changer, error) {
 	chgr.slots[tape.ImportExportSlot] = slots
 
 	for i, v := range vols {
-		chgr.slots[v.Location.Category][v.Location.Addr].Volume = &vols[i]
+		slot := chgr.slot(v.Location)
+		if slot == nil {
+			return nil, errors.E(op, errors.Invalid, errors.Strf("volume %v is in unknown slot %v", v.Serial, v.Location))
+		}
+
+		slot.Volume = &vols[i]
 	}
 
 	return &chgr, nil
 }
 
+// slot returns the slot at the given location or nil if there is no such
+// slot. Import/export slots are numbered after the storage slots, so slots
+// are looked up by address rather than by index.
+func (chgr *changerImpl) slot(loc tape.Location) *tape.Slot {
+	slots := chgr.slots[loc.Category]
+	for i := range slots {
+		if slots[i].Addr == loc.Addr {
+			return &slots[i]
+		}
+	}
+
+	return nil
+}
+
+// move returns the source and destination slots of a move. If the robot
+// drops the volume, it is removed from the source slot and an error is
+// returned.
+func (chgr *changerImpl) move(op, kind string, src, dst tape.Location) (*tape.Slot, *tape.Slot, error) {
+	srcSlot, dstSlot := chgr.slot(src), chgr.slot(dst)
+	if srcSlot == nil || dstSlot == nil {
+		return nil, nil, errors.E(op, errors.Invalid, errors.Strf("invalid move from %v to %v", src, dst))
+	}
+
+	if srcSlot.Volume == nil {
+		return nil, nil, errors.E(op, errors.Invalid, errors.Strf("source slot %v is empty", src))
+	}
+
+	if dstSlot.Volume != nil {
+		return nil, nil, errors.E(op, errors.Invalid, errors.Strf("destination slot %v is occupied", dst))
+	}
+
+	if err := chgr.faults.check(op, kind, src, dst); err != nil {
+		return nil, nil, err
+	}
+
+	if chgr.faults.dropped() {
+		serial := srcSlot.Volume.Serial
+		srcSlot.Volume = nil
+
+		return nil, nil, errors.E(op, errors.IO, errors.Strf("dropped %v while moving from %v to %v", serial, src, dst))
+	}
+
+	return srcSlot, dstSlot, nil
+}
+
 func (chgr *changerImpl) Status() (map[tape.SlotCategory]tape.Slots, error) {
 	chgr.mu.Lock()
 	defer chgr.mu.Unlock()
 
+	const op = "tape/fake.Status"
+
+	if err := chgr.faults.check(op, "status"); err != nil {
+		return nil, err
+	}
+
 	sim.Maybe(func(state sim.State) {
 		// simulate status
 		state.Simulate(&sim.NormalDistributedNoise{
@@ -160,8 +231,10 @@ func (chgr *changerImpl) Unload(src, dst tape.Location) error {
 
 	const op = "tape/fake.Unload"
 
-	srcSlot := &chgr.slots[src.Category][src.Addr]
-	dstSlot := &chgr.slots[dst.Category][dst.Addr]
+	srcSlot, dstSlot, err := chgr.move(op, "unload", src, dst)
+	if err != nil {
+		return err
+	}
 
 	sim.Maybe(func(state sim.State) {
 		v := srcSlot.Volume
@@ -194,8 +267,10 @@ func (chgr *changerImpl) Load(src, dst tape.Location) error {
 
 	const op = "tape/fake.Load"
 
-	srcSlot := &chgr.slots[src.Category][src.Addr]
-	dstSlot := &chgr.slots[dst.Category][dst.Addr]
+	srcSlot, dstSlot, err := chgr.move(op, "load", src, dst)
+	if err != nil {
+		return err
+	}
 
 	log.Debug.Printf("%s: loading from %v to %v", op, srcSlot, dstSlot)
 
@@ -230,8 +305,10 @@ func (chgr *changerImpl) Transfer(src, dst tape.Location) error {
 
 	const op = "tape/fake.Transfer"
 
-	srcSlot := &chgr.slots[src.Category][src.Addr]
-	dstSlot := &chgr.slots[dst.Category][dst.Addr]
+	srcSlot, dstSlot, err := chgr.move(op, "transfer", src, dst)
+	if err != nil {
+		return err
+	}
 
 	sim.Maybe(func(state sim.State) {
 		v := srcSlot.Volume
@@ -0,0 +1,175 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake_test
+
+import (
+	"testing"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/changer/fake"
+)
+
+func create(t *testing.T, faults map[string]string) changer.Changer {
+	opts := map[string]interface{}{
+		"transfer": "2",
+		"storage":  "8",
+		"ix":       "2",
+		"volumes":  "6",
+	}
+
+	for k, v := range faults {
+		opts[k] = v
+	}
+
+	chgr, err := fake.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chgr
+}
+
+func storage(addr int) tape.Location {
+	return tape.Location{Addr: tape.Addr(addr), Category: tape.StorageSlot}
+}
+
+func transfer(addr int) tape.Location {
+	return tape.Location{Addr: tape.Addr(addr), Category: tape.TransferSlot}
+}
+
+// cycle loads and unloads each volume in turn and returns the outcome of
+// every move.
+func cycle(chgr changer.Changer) []bool {
+	var outcomes []bool
+	for i := 1; i <= 6; i++ {
+		err := chgr.Load(storage(i), transfer(0))
+		outcomes = append(outcomes, err == nil)
+
+		if err == nil {
+			outcomes = append(outcomes, chgr.Unload(transfer(0), storage(i)) == nil)
+		}
+	}
+
+	return outcomes
+}
+
+func TestDeterministic(t *testing.T) {
+	faults := map[string]string{
+		"seed":        "42",
+		"fail-load":   "0.3",
+		"fail-unload": "0.3",
+	}
+
+	a, b := cycle(create(t, faults)), cycle(create(t, faults))
+	if len(a) != len(b) {
+		t.Fatalf("got %v and %v with the same seed", a, b)
+	}
+
+	failed := 0
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("got %v and %v with the same seed", a, b)
+		}
+
+		if !a[i] {
+			failed++
+		}
+	}
+
+	if failed == 0 {
+		t.Errorf("expected some injected failures, got %v", a)
+	}
+}
+
+func TestNoFaults(t *testing.T) {
+	for i, ok := range cycle(create(t, nil)) {
+		if !ok {
+			t.Errorf("move %d failed without faults configured", i)
+		}
+	}
+}
+
+func TestDrop(t *testing.T) {
+	chgr := create(t, map[string]string{"drop": "1"})
+
+	err := chgr.Load(storage(1), transfer(0))
+	if !errors.Is(errors.IO, err) {
+		t.Fatalf("expected i/o error, got %v", err)
+	}
+
+	slots, err := chgr.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ss := range slots {
+		for _, slot := range ss {
+			if slot.Volume != nil && slot.Volume.Serial == "A00000L7" {
+				t.Errorf("dropped volume found in %v", slot.Location)
+			}
+		}
+	}
+}
+
+func TestStuck(t *testing.T) {
+	chgr := create(t, map[string]string{"stuck": "1", "stuck-ops": "2"})
+
+	for i := 0; i < 2; i++ {
+		if _, err := chgr.Status(); !errors.Is(errors.IO, err) {
+			t.Fatalf("expected stuck robot, got %v", err)
+		}
+	}
+
+	// the robot gets stuck again right away
+	if _, err := chgr.Status(); err == nil {
+		t.Error("expected robot to get stuck again")
+	}
+}
+
+func TestDoorOpen(t *testing.T) {
+	chgr := create(t, map[string]string{"door-open": "1"})
+
+	ie := tape.Location{Addr: 9, Category: tape.ImportExportSlot}
+
+	if err := chgr.Transfer(storage(1), ie); !errors.Is(errors.Transient, err) {
+		t.Errorf("expected open mailslot door, got %v", err)
+	}
+
+	// moves not involving the mailslot are not affected
+	if err := chgr.Transfer(storage(1), storage(7)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestElementInUse(t *testing.T) {
+	chgr := create(t, map[string]string{"element-in-use": "1"})
+
+	if err := chgr.Load(storage(1), transfer(0)); !errors.Is(errors.Transient, err) {
+		t.Errorf("expected element in use, got %v", err)
+	}
+}
+
+func TestInvalidProbability(t *testing.T) {
+	_, err := fake.New(map[string]interface{}{
+		"transfer": "2", "storage": "8", "ix": "2", "volumes": "6",
+		"drop": "1.5",
+	})
+
+	if err == nil {
+		t.Error("expected probability above 1 to be rejected")
+	}
+}
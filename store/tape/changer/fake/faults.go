@@ -0,0 +1,188 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+)
+
+// faults holds the configuration and state of the faults injected by the
+// fake changer. Faults are drawn from a random source seeded by the "seed"
+// option, so a given sequence of operations always sees the same faults.
+//
+// The following options are recognized; probabilities are given as numbers
+// between 0 and 1:
+//
+//	seed              seed of the random source (default 1)
+//	fail-load         probability that a load fails
+//	fail-unload       probability that an unload fails
+//	fail-transfer     probability that a transfer fails
+//	fail-status       probability that a status request fails
+//	element-in-use    probability that a move finds an element in use
+//	drop              probability that the robot drops the volume it moves
+//	door-open         probability that the mailslot door is found open when
+//	                  moving to or from an import/export slot
+//	stuck             probability that the robot gets stuck
+//	stuck-ops         number of operations that fail while the robot is
+//	                  stuck (default 1)
+//	stuck-time        time an operation hangs when the robot gets stuck
+type faults struct {
+	rnd *rand.Rand
+
+	fail map[string]float64
+
+	inUse, drop, door, stuck float64
+
+	stuckOps  int
+	stuckTime time.Duration
+
+	// number of operations left to fail because the robot is stuck
+	stuckLeft int
+}
+
+func newFaults(opts map[string]interface{}) (*faults, error) {
+	f := &faults{
+		fail:     make(map[string]float64),
+		stuckOps: 1,
+	}
+
+	seed := int64(1)
+	if v, ok := opts["seed"]; ok {
+		n, err := strconv.ParseInt(v.(string), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		seed = n
+	}
+
+	f.rnd = rand.New(rand.NewSource(seed))
+
+	probs := map[string]*float64{
+		"element-in-use": &f.inUse,
+		"drop":           &f.drop,
+		"door-open":      &f.door,
+		"stuck":          &f.stuck,
+	}
+
+	for opt, p := range probs {
+		x, err := probability(opts, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		*p = x
+	}
+
+	for _, kind := range []string{"load", "unload", "transfer", "status"} {
+		x, err := probability(opts, "fail-"+kind)
+		if err != nil {
+			return nil, err
+		}
+
+		f.fail[kind] = x
+	}
+
+	if v, ok := opts["stuck-ops"]; ok {
+		n, err := strconv.Atoi(v.(string))
+		if err != nil {
+			return nil, err
+		}
+
+		f.stuckOps = n
+	}
+
+	if v, ok := opts["stuck-time"]; ok {
+		d, err := time.ParseDuration(v.(string))
+		if err != nil {
+			return nil, err
+		}
+
+		f.stuckTime = d
+	}
+
+	return f, nil
+}
+
+// probability returns the probability given by the named option or zero if
+// the option is not set.
+func probability(opts map[string]interface{}, opt string) (float64, error) {
+	v, ok := opts[opt]
+	if !ok {
+		return 0, nil
+	}
+
+	x, err := strconv.ParseFloat(v.(string), 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if x < 0 || x > 1 {
+		return 0, errors.E(errors.Invalid, errors.Strf("the %s option must be a probability between 0 and 1", opt))
+	}
+
+	return x, nil
+}
+
+// happens returns true with probability p. No random number is drawn if p
+// is zero, so enabling one fault does not disturb the sequence of others.
+func (f *faults) happens(p float64) bool {
+	return p > 0 && f.rnd.Float64() < p
+}
+
+// check returns the fault injected into the named operation, if any.
+// Volumes are not dropped here; see dropped.
+func (f *faults) check(op, kind string, locs ...tape.Location) error {
+	if f.stuckLeft == 0 && f.happens(f.stuck) {
+		f.stuckLeft = f.stuckOps
+
+		time.Sleep(f.stuckTime)
+	}
+
+	if f.stuckLeft > 0 {
+		f.stuckLeft--
+		return errors.E(op, errors.IO, errors.Str("robot is stuck"))
+	}
+
+	if f.happens(f.fail[kind]) {
+		return errors.E(op, errors.IO, errors.Strf("injected %s failure", kind))
+	}
+
+	if kind == "status" {
+		return nil
+	}
+
+	for _, loc := range locs {
+		if loc.Category == tape.ImportExportSlot && f.happens(f.door) {
+			return errors.E(op, errors.Transient, errors.Str("mailslot door is open"))
+		}
+	}
+
+	if f.happens(f.inUse) {
+		return errors.E(op, errors.Transient, errors.Str("element is in use"))
+	}
+
+	return nil
+}
+
+// dropped returns true if the robot drops the volume it is moving.
+func (f *faults) dropped() bool {
+	return f.happens(f.drop)
+}
@@ -23,8 +23,11 @@ import (
 	"os/exec"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 
 	"tapr.space/errors"
+	"tapr.space/log"
 	"tapr.space/store/tape"
 	"tapr.space/store/tape/changer"
 )
@@ -34,22 +37,42 @@ func init() {
 }
 
 var (
-	hdrRegexp          = regexp.MustCompile(`\s*Storage Changer\s*(.*):(\d*) Drives, (\d*) Slots \( (\d*) Import/Export \)`)
-	driveRegexp        = regexp.MustCompile(`Data Transfer Element (\d*):(.*)`)
-	driveElementRegexp = regexp.MustCompile(`Full \(Storage Element (\d*) Loaded\):VolumeTag = (.*)`)
-	slotRegexp         = regexp.MustCompile(`\s*Storage Element (\d*):(.*)`)
-	mailSlotRegexp     = regexp.MustCompile(`\s*Storage Element (\d*) IMPORT/EXPORT:(.*)`)
-	slotElementRegexp  = regexp.MustCompile(`Full :VolumeTag=(.*)`)
+	hdrRegexp    = regexp.MustCompile(`^\s*Storage Changer\s*(.*):(\d+) Drives?, (\d+) Slots? \( (\d+) Import/Export \)`)
+	driveRegexp  = regexp.MustCompile(`^\s*Data Transfer Element (\d+):(Empty|Full)(.*)$`)
+	loadedRegexp = regexp.MustCompile(`^\s*\((?:Storage Element (\d+)|Unknown Storage Element) Loaded\)(.*)$`)
+	slotRegexp   = regexp.MustCompile(`^\s*Storage Element (\d+)( IMPORT/EXPORT)?:(Empty|Full)(.*)$`)
 )
 
+// A Changer is a changer.Changer controlled by the mtx command.
+type Changer interface {
+	changer.Changer
+
+	// Inventory makes the changer check all elements for media.
+	Inventory() error
+
+	// First loads the volume in the lowest numbered storage slot into the
+	// drive.
+	First(drive tape.Location) error
+
+	// Next unloads the volume in the drive and loads the volume in the
+	// next storage slot.
+	Next(drive tape.Location) error
+}
+
 type changerImpl struct {
 	path string
 	prog string
+
+	// mtx commands are not safe to run concurrently against the same
+	// changer.
+	mu sync.Mutex
 }
 
-var _ changer.Changer = (*changerImpl)(nil)
+var _ Changer = (*changerImpl)(nil)
 
-// New returns a new mtx tape implementation.
+// New returns a new mtx tape implementation. If the initialize option is
+// "true", the changer is asked to take an inventory of its elements before
+// use.
 func New(opts map[string]interface{}) (changer.Changer, error) {
 	const op = "changer/mtx.New"
 
@@ -58,14 +81,29 @@ func New(opts map[string]interface{}) (changer.Changer, error) {
 		return nil, errors.E(op, errors.Str("the path option must be specified"))
 	}
 
-	return &changerImpl{
+	chgr := &changerImpl{
 		path: path,
 		prog: "/usr/bin/mtx",
-	}, nil
+	}
+
+	if v, ok := opts["prog"].(string); ok {
+		chgr.prog = v
+	}
+
+	if v, _ := opts["initialize"].(string); v == "true" {
+		if err := chgr.Inventory(); err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	return chgr, nil
 }
 
 // do performs the given operation.
 func (chgr *changerImpl) do(args ...string) ([]byte, error) {
+	chgr.mu.Lock()
+	defer chgr.mu.Unlock()
+
 	params := append([]string{"-f", chgr.path}, args...)
 
 	return run(exec.Command(chgr.prog, params...))
@@ -99,11 +137,11 @@ func (chgr *changerImpl) Load(src, dst tape.Location) error {
 	return err
 }
 
-// Unload a volume from a drive and return it to a slot. If slotnum is zero,
-// the volume will be returned to it's home slot.
+// Unload a volume from a drive and return it to a slot. mtx takes the slot
+// before the drive.
 func (chgr *changerImpl) Unload(src, dst tape.Location) error {
 	_, err := chgr.do(
-		"unload", strconv.Itoa(int(src.Addr)), strconv.Itoa(int(dst.Addr)),
+		"unload", strconv.Itoa(int(dst.Addr)), strconv.Itoa(int(src.Addr)),
 	)
 
 	return err
@@ -118,6 +156,27 @@ func (chgr *changerImpl) Transfer(src, dst tape.Location) error {
 	return err
 }
 
+// Inventory implements Changer.
+func (chgr *changerImpl) Inventory() error {
+	_, err := chgr.do("inventory")
+
+	return err
+}
+
+// First implements Changer.
+func (chgr *changerImpl) First(drive tape.Location) error {
+	_, err := chgr.do("first", strconv.Itoa(int(drive.Addr)))
+
+	return err
+}
+
+// Next implements Changer.
+func (chgr *changerImpl) Next(drive tape.Location) error {
+	_, err := chgr.do("next", strconv.Itoa(int(drive.Addr)))
+
+	return err
+}
+
 // Status returns a Status structure with combined information about the status
 // of the library.
 func (chgr *changerImpl) Status() (map[tape.SlotCategory]tape.Slots, error) {
@@ -129,7 +188,85 @@ func (chgr *changerImpl) Status() (map[tape.SlotCategory]tape.Slots, error) {
 	return elements(status)
 }
 
+// geometry is the library geometry reported in the mtx status header.
+type geometry struct {
+	device string
+
+	drives, slots, ix int
+}
+
+// storage returns the number of storage slots. mtx counts import/export
+// slots as storage slots.
+func (g geometry) storage() int {
+	return g.slots - g.ix
+}
+
+// params parses the mtx status header.
+func params(line string) (geometry, error) {
+	matches := hdrRegexp.FindStringSubmatch(line)
+	if matches == nil {
+		return geometry{}, errors.Strf("failed to match mtx status header: %q", line)
+	}
+
+	g := geometry{device: matches[1]}
+
+	for i, n := range []*int{&g.drives, &g.slots, &g.ix} {
+		v, err := strconv.Atoi(matches[i+2])
+		if err != nil {
+			return geometry{}, err
+		}
+
+		*n = v
+	}
+
+	return g, nil
+}
+
+// fields parses the colon separated "key = value" fields following the
+// state of an element. Keys without a value are ignored.
+func fields(s string) map[string]string {
+	fs := make(map[string]string)
+	for _, f := range strings.Split(s, ":") {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		fs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return fs
+}
+
+// volume returns the volume described by the element fields. Libraries that
+// only report alternate volume tags are identified by those.
+func volume(loc tape.Location, fs map[string]string) *tape.Volume {
+	serial := fs["VolumeTag"]
+	if serial == "" {
+		serial = fs["AlternateVolumeTag"]
+	}
+
+	return &tape.Volume{
+		Serial:   tape.Serial(serial),
+		Location: loc,
+	}
+}
+
+// driveSerial returns the drive serial number from the element fields, if
+// reported.
+func driveSerial(fs map[string]string) string {
+	for _, key := range []string{"Serial Number", "SerialNumber", "Serial"} {
+		if v, ok := fs[key]; ok {
+			return v
+		}
+	}
+
+	return ""
+}
+
 func elements(status []byte) (map[tape.SlotCategory]tape.Slots, error) {
+	const op = "changer/mtx.elements"
+
 	elements := map[tape.SlotCategory]tape.Slots{
 		tape.TransferSlot:     make(tape.Slots, 0),
 		tape.StorageSlot:      make(tape.Slots, 0),
@@ -138,161 +275,143 @@ func elements(status []byte) (map[tape.SlotCategory]tape.Slots, error) {
 
 	scanner := bufio.NewScanner(bytes.NewReader(status))
 
-	// skip header
-	scanner.Scan()
+	// the header holds the library geometry
+	var (
+		g   geometry
+		hdr bool
+	)
 
-	// scan elements
-	var matches []string
 	for scanner.Scan() {
 		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
 
-		// match data transfer elements
-		matches = driveRegexp.FindStringSubmatch(line)
-		if matches != nil {
-			elemnum, err := strconv.Atoi(matches[1])
-			if err != nil {
-				return nil, err
-			}
-
-			s := tape.Slot{
-				Location: tape.Location{
-					Addr:     tape.Addr(elemnum),
-					Category: tape.TransferSlot,
-				},
-			}
+		var err error
+		if g, err = params(line); err != nil {
+			return nil, errors.E(op, err)
+		}
 
-			if matches[2] != "Empty" {
-				matches = driveElementRegexp.FindStringSubmatch(matches[2])
-				if matches == nil {
-					return nil, errors.Str("failed to parse transfer element")
-				}
+		hdr = true
 
-				home, err := strconv.Atoi(matches[1])
-				if err != nil {
-					return nil, err
-				}
+		break
+	}
 
-				s.Volume = &tape.Volume{
-					Serial:   tape.Serial(matches[2]),
-					Location: s.Location,
-					Home: tape.Location{
-						Addr: tape.Addr(home),
-						// TODO(kbj): add category
-					},
-				}
-			}
+	if !hdr {
+		return nil, errors.E(op, errors.Str("missing mtx status header"))
+	}
 
-			elements[tape.TransferSlot] = append(elements[tape.TransferSlot], s)
+	// home slots of loaded volumes are resolved when all slots are known
+	var loaded []*tape.Volume
 
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
 
-		// match storage elements
-		matches = slotRegexp.FindStringSubmatch(line)
-		if matches != nil {
+		// match data transfer elements
+		if matches := driveRegexp.FindStringSubmatch(line); matches != nil {
 			elemnum, err := strconv.Atoi(matches[1])
 			if err != nil {
-				return nil, err
+				return nil, errors.E(op, err)
 			}
 
 			s := tape.Slot{
 				Location: tape.Location{
 					Addr:     tape.Addr(elemnum),
-					Category: tape.StorageSlot,
+					Category: tape.TransferSlot,
 				},
 			}
 
-			if matches[2] != "Empty" {
-				match := slotElementRegexp.FindStringSubmatch(matches[2])
-				if match == nil {
-					return nil, errors.Strf("failed to parse slot element: %v", matches[2])
+			rest := matches[3]
+
+			if matches[2] == "Full" {
+				home := 0
+
+				if m := loadedRegexp.FindStringSubmatch(rest); m != nil {
+					if m[1] != "" {
+						if home, err = strconv.Atoi(m[1]); err != nil {
+							return nil, errors.E(op, err)
+						}
+					}
+
+					rest = m[2]
 				}
 
-				s.Volume = &tape.Volume{
-					Serial:   tape.Serial(match[1]),
-					Location: s.Location,
+				s.Volume = volume(s.Location, fields(rest))
+
+				if home != 0 {
+					s.Volume.Home = tape.Location{Addr: tape.Addr(home)}
+					loaded = append(loaded, s.Volume)
 				}
 			}
 
-			elements[tape.StorageSlot] = append(elements[tape.StorageSlot], s)
+			s.DriveSerial = driveSerial(fields(rest))
+
+			elements[tape.TransferSlot] = append(elements[tape.TransferSlot], s)
 
 			continue
 		}
-		// match mailslot elements
-		matches = mailSlotRegexp.FindStringSubmatch(line)
-		if matches != nil {
+
+		// match storage and import/export elements
+		if matches := slotRegexp.FindStringSubmatch(line); matches != nil {
 			elemnum, err := strconv.Atoi(matches[1])
 			if err != nil {
-				return nil, err
+				return nil, errors.E(op, err)
+			}
+
+			cat := tape.StorageSlot
+			if matches[2] != "" {
+				cat = tape.ImportExportSlot
 			}
 
 			s := tape.Slot{
 				Location: tape.Location{
 					Addr:     tape.Addr(elemnum),
-					Category: tape.ImportExportSlot,
+					Category: cat,
 				},
 			}
 
-			if matches[2] != "Empty" {
-				matches = slotElementRegexp.FindStringSubmatch(matches[2])
-				if matches == nil {
-					return nil, errors.Str("failed to parse slot element")
-				}
-
-				s.Volume = &tape.Volume{
-					Serial:   tape.Serial(matches[1]),
-					Location: s.Location,
-				}
+			if matches[3] == "Full" {
+				s.Volume = volume(s.Location, fields(matches[4]))
 			}
 
-			elements[tape.ImportExportSlot] = append(elements[tape.ImportExportSlot], s)
+			elements[cat] = append(elements[cat], s)
 
 			continue
 		}
 
-		return nil, errors.Strf("failed to parse slot")
+		// some versions of mtx print additional information, such as
+		// device identifiers, which is of no use here
+		log.Debug.Printf("%s: ignoring unrecognized line: %q", op, line)
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		return nil, errors.E(op, err)
 	}
 
-	return elements, nil
-}
-
-func params(status []byte) (map[string]int, error) {
-	params := make(map[string]int)
-
-	scanner := bufio.NewScanner(bytes.NewReader(status))
-
-	var err error
-	if scanner.Scan() {
-		line := scanner.Text()
-
-		matches := hdrRegexp.FindStringSubmatch(line)
-		if matches == nil {
-			return nil, errors.Strf("failed to match mtx status header")
-		}
-
-		params["maxDrives"], err = strconv.Atoi(matches[2])
-		if err != nil {
-			return nil, err
-		}
-
-		params["numSlots"], err = strconv.Atoi(matches[3])
-		if err != nil {
-			return nil, err
-		}
+	if len(elements[tape.TransferSlot]) != g.drives || len(elements[tape.StorageSlot]) != g.storage() || len(elements[tape.ImportExportSlot]) != g.ix {
+		return nil, errors.E(op, errors.Strf("found %d drives, %d storage and %d import/export slots; expected %d, %d and %d",
+			len(elements[tape.TransferSlot]), len(elements[tape.StorageSlot]), len(elements[tape.ImportExportSlot]),
+			g.drives, g.storage(), g.ix))
+	}
 
-		params["numMailSlots"], err = strconv.Atoi(matches[4])
-		if err != nil {
-			return nil, err
+	// mtx numbers storage and import/export slots in a single sequence
+	cats := make(map[tape.Addr]tape.SlotCategory)
+	for _, cat := range []tape.SlotCategory{tape.StorageSlot, tape.ImportExportSlot} {
+		for _, s := range elements[cat] {
+			cats[s.Addr] = cat
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	for _, vol := range loaded {
+		if cat, ok := cats[vol.Home.Addr]; ok {
+			vol.Home.Category = cat
+		} else {
+			vol.Home.Category = tape.InvalidSlot
+		}
 	}
 
-	return params, nil
+	return elements, nil
 }
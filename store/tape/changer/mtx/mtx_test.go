@@ -0,0 +1,127 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mtx
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tapr.space/store/tape"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// dump formats the slots in a stable, readable form.
+func dump(slots map[tape.SlotCategory]tape.Slots) []byte {
+	var buf bytes.Buffer
+	for _, cat := range tape.SlotCategories {
+		for _, s := range slots[cat] {
+			fmt.Fprintf(&buf, "%s %d", s.Category, s.Addr)
+
+			if s.DriveSerial != "" {
+				fmt.Fprintf(&buf, " drive=%s", s.DriveSerial)
+			}
+
+			if vol := s.Volume; vol != nil {
+				fmt.Fprintf(&buf, " volume=%q", vol.Serial)
+
+				if vol.Home.Addr != 0 {
+					fmt.Fprintf(&buf, " home=%s:%d", vol.Home.Category, vol.Home.Addr)
+				}
+			}
+
+			buf.WriteByte('\n')
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func TestElements(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) == 0 {
+		t.Fatal("no captured mtx outputs found")
+	}
+
+	for _, file := range files {
+		status, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		slots, err := elements(status)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+
+		got := dump(slots)
+
+		golden := strings.TrimSuffix(file, ".status") + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			continue
+		}
+
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", file, got, want)
+		}
+	}
+}
+
+func TestElementsMismatch(t *testing.T) {
+	// the header promises more slots than listed
+	status := []byte(`  Storage Changer /dev/sg3:1 Drives, 3 Slots ( 0 Import/Export )
+Data Transfer Element 0:Empty
+      Storage Element 1:Empty
+`)
+
+	if _, err := elements(status); err == nil {
+		t.Error("expected an error for a truncated status")
+	}
+
+	if _, err := elements(nil); err == nil {
+		t.Error("expected an error for a missing header")
+	}
+}
+
+func TestParams(t *testing.T) {
+	g, err := params("  Storage Changer /dev/sg4:1 Drives, 24 Slots ( 1 Import/Export )")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := geometry{device: "/dev/sg4", drives: 1, slots: 24, ix: 1}
+	if g != want || g.storage() != 23 {
+		t.Errorf("got %+v, want %+v", g, want)
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package changer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+)
+
+// A Priority orders the moves waiting for a queued changer. Moves with a
+// higher priority are carried out first; moves with equal priority are
+// carried out in the order they were queued.
+type Priority int
+
+// Priorities of changer moves.
+const (
+	// Background is used for housekeeping such as repacking and cleaning.
+	Background Priority = iota
+
+	// Normal is the priority of moves queued without a priority.
+	Normal
+
+	// Recall is used for moves that clients are waiting for.
+	Recall
+)
+
+func (p Priority) String() string {
+	switch p {
+	case Background:
+		return "background"
+	case Normal:
+		return "normal"
+	case Recall:
+		return "recall"
+	}
+
+	return "unknown"
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx carrying the given priority.
+func WithPriority(ctx context.Context, prio Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, prio)
+}
+
+// PriorityFrom returns the priority carried by ctx. Normal is returned if
+// ctx carries no priority.
+func PriorityFrom(ctx context.Context) Priority {
+	if prio, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return prio
+	}
+
+	return Normal
+}
+
+type moveKind int
+
+const (
+	transferMove moveKind = iota
+	loadMove
+	unloadMove
+)
+
+// move identifies a changer move. Identical moves queued at the same time
+// are merged.
+type move struct {
+	kind     moveKind
+	src, dst tape.Location
+}
+
+type request struct {
+	move
+
+	prio Priority
+	seq  uint64
+
+	queued  time.Time
+	started bool
+
+	// number of callers waiting for the move
+	waiters int
+
+	err  error
+	done chan struct{}
+}
+
+// Wait summarizes the time moves of a given priority spent in the queue.
+type Wait struct {
+	// Count is the number of moves carried out.
+	Count int
+
+	// Total and Max are the total and longest time spent waiting.
+	Total, Max time.Duration
+}
+
+// Mean returns the mean time spent waiting.
+func (w Wait) Mean() time.Duration {
+	if w.Count == 0 {
+		return 0
+	}
+
+	return w.Total / time.Duration(w.Count)
+}
+
+// QueueStats describes the state of a queued changer.
+type QueueStats struct {
+	// Depth is the number of moves waiting, by priority.
+	Depth map[Priority]int
+
+	// Oldest is the time the longest waiting move has been queued.
+	Oldest time.Duration
+
+	// Waits summarizes the time carried out moves spent waiting, by
+	// priority.
+	Waits map[Priority]Wait
+
+	// Merged is the number of moves merged with an identical queued move
+	// and Canceled is the number of moves canceled before they were
+	// carried out.
+	Merged, Canceled int
+}
+
+// A Queue serializes the moves of a Changer, carrying out waiting moves in
+// order of priority. A Queue is itself a Changer that queues moves with
+// Normal priority; use Bind to queue moves with another priority.
+type Queue struct {
+	Changer
+
+	mu sync.Mutex
+
+	pending []*request
+	running bool
+	seq     uint64
+
+	waits    map[Priority]Wait
+	merged   int
+	canceled int
+}
+
+// NewQueue returns a Queue of the moves of chgr.
+func NewQueue(chgr Changer) *Queue {
+	return &Queue{
+		Changer: chgr,
+		waits:   make(map[Priority]Wait),
+	}
+}
+
+// Transfer implements Changer.
+func (q *Queue) Transfer(src, dst tape.Location) error {
+	return q.submit(context.Background(), move{transferMove, src, dst})
+}
+
+// Load implements Changer.
+func (q *Queue) Load(src, dst tape.Location) error {
+	return q.submit(context.Background(), move{loadMove, src, dst})
+}
+
+// Unload implements Changer.
+func (q *Queue) Unload(src, dst tape.Location) error {
+	return q.submit(context.Background(), move{unloadMove, src, dst})
+}
+
+// Stats returns the current queue depth and wait times.
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := QueueStats{
+		Depth:    make(map[Priority]int),
+		Waits:    make(map[Priority]Wait),
+		Merged:   q.merged,
+		Canceled: q.canceled,
+	}
+
+	for _, req := range q.pending {
+		stats.Depth[req.prio]++
+
+		if age := time.Since(req.queued); age > stats.Oldest {
+			stats.Oldest = age
+		}
+	}
+
+	for prio, w := range q.waits {
+		stats.Waits[prio] = w
+	}
+
+	return stats
+}
+
+// submit queues the move and waits for it to be carried out. If ctx is done
+// before the move is started, the move is canceled. Once started, a move
+// always runs to completion.
+func (q *Queue) submit(ctx context.Context, mv move) error {
+	const op = "changer/Queue.submit"
+
+	prio := PriorityFrom(ctx)
+
+	q.mu.Lock()
+
+	req := q.find(mv)
+	if req != nil {
+		q.merged++
+		req.waiters++
+
+		if prio > req.prio {
+			req.prio = prio
+		}
+	} else {
+		q.seq++
+
+		req = &request{
+			move:    mv,
+			prio:    prio,
+			seq:     q.seq,
+			queued:  time.Now(),
+			waiters: 1,
+			done:    make(chan struct{}),
+		}
+
+		q.pending = append(q.pending, req)
+	}
+
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+
+	q.mu.Unlock()
+
+	select {
+	case <-req.done:
+		return req.err
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+
+	if req.started {
+		q.mu.Unlock()
+
+		// the robot is already moving; the caller needs to know where the
+		// volume ended up.
+		<-req.done
+		return req.err
+	}
+
+	req.waiters--
+	if req.waiters == 0 {
+		q.remove(req)
+		q.canceled++
+	}
+
+	q.mu.Unlock()
+
+	return errors.E(op, ctx.Err())
+}
+
+// find returns the queued request for an identical move, if any. q.mu MUST
+// be held.
+func (q *Queue) find(mv move) *request {
+	for _, req := range q.pending {
+		if req.move == mv {
+			return req
+		}
+	}
+
+	return nil
+}
+
+// remove removes the request from the queue. q.mu MUST be held.
+func (q *Queue) remove(req *request) {
+	for i := range q.pending {
+		if q.pending[i] == req {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// next removes and returns the waiting request with the highest priority.
+// q.mu MUST be held.
+func (q *Queue) next() *request {
+	var next *request
+	for _, req := range q.pending {
+		if next == nil || req.prio > next.prio || (req.prio == next.prio && req.seq < next.seq) {
+			next = req
+		}
+	}
+
+	q.remove(next)
+
+	return next
+}
+
+// run carries out queued moves until the queue is empty.
+func (q *Queue) run() {
+	for {
+		q.mu.Lock()
+
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+
+		req := q.next()
+		req.started = true
+
+		wait := time.Since(req.queued)
+
+		w := q.waits[req.prio]
+		w.Count++
+		w.Total += wait
+		if wait > w.Max {
+			w.Max = wait
+		}
+		q.waits[req.prio] = w
+
+		q.mu.Unlock()
+
+		switch req.kind {
+		case transferMove:
+			req.err = q.Changer.Transfer(req.src, req.dst)
+		case loadMove:
+			req.err = q.Changer.Load(req.src, req.dst)
+		case unloadMove:
+			req.err = q.Changer.Unload(req.src, req.dst)
+		}
+
+		close(req.done)
+	}
+}
+
+type bound struct {
+	*Queue
+
+	ctx context.Context
+}
+
+func (chgr *bound) Transfer(src, dst tape.Location) error {
+	return chgr.submit(chgr.ctx, move{transferMove, src, dst})
+}
+
+func (chgr *bound) Load(src, dst tape.Location) error {
+	return chgr.submit(chgr.ctx, move{loadMove, src, dst})
+}
+
+func (chgr *bound) Unload(src, dst tape.Location) error {
+	return chgr.submit(chgr.ctx, move{unloadMove, src, dst})
+}
+
+// Bind returns a Changer that queues moves with the priority carried by ctx
+// and cancels them if ctx is done before they are started. If chgr is not
+// queued, chgr is returned.
+func Bind(ctx context.Context, chgr Changer) Changer {
+	switch c := chgr.(type) {
+	case *named:
+		return &named{Changer: Bind(ctx, c.Changer), name: c.name}
+	case *bound:
+		return &bound{Queue: c.Queue, ctx: ctx}
+	case *Queue:
+		return &bound{Queue: c, ctx: ctx}
+	}
+
+	return chgr
+}
+
+// Stats returns the queue statistics of chgr. False is returned if chgr is
+// not queued.
+func Stats(chgr Changer) (QueueStats, bool) {
+	switch c := chgr.(type) {
+	case *named:
+		return Stats(c.Changer)
+	case *bound:
+		return c.Stats(), true
+	case *Queue:
+		return c.Stats(), true
+	}
+
+	return QueueStats{}, false
+}
@@ -0,0 +1,236 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package changer_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+)
+
+// robot is a Changer that records the moves it carries out. Moves block
+// until released.
+type robot struct {
+	mu    sync.Mutex
+	moves []tape.Addr
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func newRobot() *robot {
+	return &robot{
+		started: make(chan struct{}, 16),
+		release: make(chan struct{}),
+	}
+}
+
+func (r *robot) move(src tape.Location) error {
+	r.started <- struct{}{}
+	<-r.release
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.moves = append(r.moves, src.Addr)
+
+	return nil
+}
+
+func (r *robot) Transfer(src, dst tape.Location) error { return r.move(src) }
+func (r *robot) Load(src, dst tape.Location) error     { return r.move(src) }
+func (r *robot) Unload(src, dst tape.Location) error   { return r.move(src) }
+
+func (r *robot) Status() (map[tape.SlotCategory]tape.Slots, error) {
+	return nil, nil
+}
+
+func storage(addr int) tape.Location {
+	return tape.Location{Addr: tape.Addr(addr), Category: tape.StorageSlot}
+}
+
+var drive = tape.Location{Addr: 0, Category: tape.TransferSlot}
+
+// depth waits until the queue holds n moves.
+func depth(t *testing.T, q *changer.Queue, n int) {
+	for i := 0; i < 100; i++ {
+		total := 0
+		for _, d := range q.Stats().Depth {
+			total += d
+		}
+
+		if total == n {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("queue never reached a depth of %d", n)
+}
+
+func TestQueuePriority(t *testing.T) {
+	r := newRobot()
+	q := changer.NewQueue(r)
+
+	var wg sync.WaitGroup
+	submit := func(prio changer.Priority, addr int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx := changer.WithPriority(context.Background(), prio)
+			if err := changer.Bind(ctx, q).Load(storage(addr), drive); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// keep the robot busy while the remaining moves are queued
+	submit(changer.Normal, 1)
+	<-r.started
+
+	submit(changer.Background, 2)
+	depth(t, q, 1)
+	submit(changer.Normal, 3)
+	depth(t, q, 2)
+	submit(changer.Recall, 4)
+	depth(t, q, 3)
+
+	stats := q.Stats()
+	if stats.Depth[changer.Recall] != 1 || stats.Depth[changer.Normal] != 1 || stats.Depth[changer.Background] != 1 {
+		t.Errorf("unexpected depth: %v", stats.Depth)
+	}
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			r.release <- struct{}{}
+		}
+	}()
+
+	wg.Wait()
+
+	want := []tape.Addr{1, 4, 3, 2}
+	for i := range want {
+		if r.moves[i] != want[i] {
+			t.Fatalf("got moves %v, want %v", r.moves, want)
+		}
+	}
+
+	if w := q.Stats().Waits[changer.Background]; w.Count != 1 || w.Max == 0 {
+		t.Errorf("unexpected background wait: %+v", w)
+	}
+}
+
+func TestQueueMerge(t *testing.T) {
+	r := newRobot()
+	q := changer.NewQueue(r)
+
+	var wg sync.WaitGroup
+	load := func(addr int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := q.Load(storage(addr), drive); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	load(1)
+	<-r.started
+
+	load(2)
+	depth(t, q, 1)
+	load(2)
+
+	for i := 0; i < 100 && q.Stats().Merged == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			r.release <- struct{}{}
+		}
+	}()
+
+	wg.Wait()
+
+	if len(r.moves) != 2 {
+		t.Errorf("got moves %v, want the identical moves merged", r.moves)
+	}
+
+	if stats := q.Stats(); stats.Merged != 1 {
+		t.Errorf("got %d merged moves, want 1", stats.Merged)
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	r := newRobot()
+	q := changer.NewQueue(r)
+
+	done := make(chan error)
+	go func() {
+		done <- q.Load(storage(1), drive)
+	}()
+
+	<-r.started
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	canceled := make(chan error)
+	go func() {
+		canceled <- changer.Bind(ctx, q).Load(storage(2), drive)
+	}()
+
+	depth(t, q, 1)
+	cancel()
+
+	if err := <-canceled; err == nil {
+		t.Error("expected canceled move to fail")
+	}
+
+	r.release <- struct{}{}
+
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+
+	if len(r.moves) != 1 || r.moves[0] != 1 {
+		t.Errorf("got moves %v, want only the first move", r.moves)
+	}
+
+	if stats := q.Stats(); stats.Canceled != 1 || len(stats.Depth) != 0 {
+		t.Errorf("unexpected stats after cancel: %+v", stats)
+	}
+}
+
+func TestBindNamed(t *testing.T) {
+	q := changer.NewQueue(newRobot())
+	chgr := changer.Bind(context.Background(), changer.Named("primary", q))
+
+	if name := changer.Name(chgr); name != "primary" {
+		t.Errorf("got name %q, want %q", name, "primary")
+	}
+
+	if _, ok := changer.Stats(chgr); !ok {
+		t.Error("expected bound changer to be queued")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scsi
+
+import (
+	"bytes"
+	"sync"
+
+	"tapr.space/errors"
+)
+
+// An Exchange is a command expected by a Script and the scripted response.
+type Exchange struct {
+	// CDB is the expected command descriptor block.
+	CDB []byte
+
+	// Data is returned for commands reading from the device.
+	Data []byte
+
+	// Status and Sense are the scripted outcome of the command.
+	Status byte
+	Sense  []byte
+}
+
+// A Script is a Transport that replays a recorded sequence of exchanges. It
+// is used to test the changer without a library.
+type Script struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+var _ Transport = (*Script)(nil)
+
+// NewScript returns a Script replaying the given exchanges in order.
+func NewScript(exchanges ...Exchange) *Script {
+	return &Script{exchanges: exchanges}
+}
+
+// Do implements Transport. An error is returned if the command is not the
+// next expected command.
+func (s *Script) Do(cmd *Command) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.exchanges) == 0 {
+		return errors.E(errors.Invalid, errors.Strf("unexpected command % x", cmd.CDB))
+	}
+
+	ex := s.exchanges[0]
+
+	if !bytes.Equal(ex.CDB, cmd.CDB) {
+		return errors.E(errors.Invalid, errors.Strf("unexpected command % x; expected % x", cmd.CDB, ex.CDB))
+	}
+
+	s.exchanges = s.exchanges[1:]
+
+	n := copy(cmd.Data, ex.Data)
+
+	cmd.Resid = len(cmd.Data) - n
+	cmd.Status = ex.Status
+	cmd.Sense = ex.Sense
+
+	return nil
+}
+
+// Remaining returns the number of exchanges not yet replayed.
+func (s *Script) Remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.exchanges)
+}
+
+// Close implements Transport.
+func (s *Script) Close() error {
+	return nil
+}
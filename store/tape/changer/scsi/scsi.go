@@ -12,8 +12,221 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package scsi
+// Package scsi provides a changer.Changer that controls a SCSI media changer
+// natively by issuing SCSI Media Changer Commands (SMC) through a Transport.
+package scsi // import "tapr.space/store/tape/changer/scsi"
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+)
 
 func init() {
-	panic("changer/scsi: not implemented")
+	changer.Register("scsi", New)
+}
+
+// defaultTimeout is the timeout of commands that do not move media.
+const defaultTimeout = 30 * time.Second
+
+// moveTimeout is the timeout of commands that move media or inventory the
+// library.
+const moveTimeout = 15 * time.Minute
+
+type changerImpl struct {
+	// mu serializes commands issued to the device
+	mu sync.Mutex
+
+	t Transport
+
+	// element address assignment (read lazily)
+	eaa *assignment
+}
+
+var _ changer.Changer = (*changerImpl)(nil)
+
+// New returns a new changer.Changer that talks to the SCSI generic device
+// given by the path option. If the initialize option is "true", the changer
+// is asked to take an inventory of its elements before use.
+func New(opts map[string]interface{}) (changer.Changer, error) {
+	const op = "changer/scsi.New"
+
+	path, ok := opts["path"].(string)
+	if !ok {
+		return nil, errors.E(op, errors.Str("the path option must be specified"))
+	}
+
+	t, err := Open(path)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	chgr := NewChanger(t)
+
+	if v, _ := opts["initialize"].(string); v == "true" {
+		if err := chgr.Initialize(); err != nil {
+			t.Close()
+			return nil, errors.E(op, err)
+		}
+	}
+
+	return chgr, nil
+}
+
+// A Changer is a changer.Changer that issues SMC commands through a
+// Transport.
+type Changer interface {
+	changer.Changer
+
+	// Initialize issues INITIALIZE ELEMENT STATUS, causing the changer to
+	// check all elements for media.
+	Initialize() error
+}
+
+// NewChanger returns a Changer that uses the given Transport.
+func NewChanger(t Transport) Changer {
+	return &changerImpl{t: t}
+}
+
+// assignment returns the element address assignment of the changer.
+// chgr.mu MUST be held.
+func (chgr *changerImpl) assignment() (*assignment, error) {
+	if chgr.eaa != nil {
+		return chgr.eaa, nil
+	}
+
+	eaa, err := modeSenseEAA(chgr.t)
+	if err != nil {
+		return nil, err
+	}
+
+	chgr.eaa = eaa
+
+	return eaa, nil
+}
+
+// move moves a medium from src to dst using the first medium transport
+// element.
+func (chgr *changerImpl) move(op string, src, dst tape.Location) error {
+	chgr.mu.Lock()
+	defer chgr.mu.Unlock()
+
+	eaa, err := chgr.assignment()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := moveMedium(chgr.t, eaa.transport.first, src.Addr, dst.Addr); err != nil {
+		return errors.E(fmt.Sprintf("%s[%d -> %d]", op, src.Addr, dst.Addr), err)
+	}
+
+	return nil
+}
+
+// Load implements changer.Changer.
+func (chgr *changerImpl) Load(src, dst tape.Location) error {
+	return chgr.move("changer/scsi.Load", src, dst)
+}
+
+// Unload implements changer.Changer.
+func (chgr *changerImpl) Unload(src, dst tape.Location) error {
+	return chgr.move("changer/scsi.Unload", src, dst)
+}
+
+// Transfer implements changer.Changer.
+func (chgr *changerImpl) Transfer(src, dst tape.Location) error {
+	return chgr.move("changer/scsi.Transfer", src, dst)
+}
+
+// Initialize implements Changer.
+func (chgr *changerImpl) Initialize() error {
+	const op = "changer/scsi.Initialize"
+
+	chgr.mu.Lock()
+	defer chgr.mu.Unlock()
+
+	if err := initializeElementStatus(chgr.t); err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+// Status implements changer.Changer. Slots are reported with the element
+// addresses used by the changer.
+func (chgr *changerImpl) Status() (map[tape.SlotCategory]tape.Slots, error) {
+	const op = "changer/scsi.Status"
+
+	chgr.mu.Lock()
+	defer chgr.mu.Unlock()
+
+	eaa, err := chgr.assignment()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	slots := map[tape.SlotCategory]tape.Slots{
+		tape.TransferSlot:     make(tape.Slots, 0),
+		tape.StorageSlot:      make(tape.Slots, 0),
+		tape.ImportExportSlot: make(tape.Slots, 0),
+	}
+
+	types := []struct {
+		typ   elementType
+		rng   elementRange
+		cat   tape.SlotCategory
+		dvcid bool
+	}{
+		{dataTransferElement, eaa.transfer, tape.TransferSlot, true},
+		{storageElement, eaa.storage, tape.StorageSlot, false},
+		{importExportElement, eaa.ix, tape.ImportExportSlot, false},
+	}
+
+	for _, t := range types {
+		if t.rng.count == 0 {
+			continue
+		}
+
+		elems, err := readElementStatus(chgr.t, t.typ, t.rng, t.dvcid)
+		if err != nil && t.dvcid && errors.Is(errors.Invalid, err) {
+			// the changer does not support reporting device identifiers
+			elems, err = readElementStatus(chgr.t, t.typ, t.rng, false)
+		}
+
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		for _, e := range elems {
+			slot := tape.Slot{
+				Location: tape.Location{
+					Addr:     e.addr,
+					Category: t.cat,
+				},
+				DriveSerial: e.ident,
+			}
+
+			if e.full {
+				slot.Volume = &tape.Volume{
+					Serial:   tape.Serial(e.voltag),
+					Location: slot.Location,
+				}
+
+				if e.svalid {
+					slot.Volume.Home = tape.Location{
+						Addr:     e.source,
+						Category: eaa.category(e.source),
+					}
+				}
+			}
+
+			slots[t.cat] = append(slots[t.cat], slot)
+		}
+	}
+
+	return slots, nil
 }
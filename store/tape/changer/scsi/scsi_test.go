@@ -0,0 +1,319 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scsi_test
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer/scsi"
+)
+
+// eaaPage returns MODE SENSE data holding an element address assignment
+// page with one transport at 1, storage at 1000, ix at 10 and drives at 500.
+func eaaPage(storage, ix, drives int) []byte {
+	page := []byte{0x1d, 0x12}
+	for _, r := range [][2]int{{1, 1}, {1000, storage}, {10, ix}, {500, drives}} {
+		page = append(page, byte(r[0]>>8), byte(r[0]), byte(r[1]>>8), byte(r[1]))
+	}
+
+	page = append(page, 0, 0)
+
+	hdr := []byte{byte(3 + len(page)), 0, 0, 0}
+
+	return append(hdr, page...)
+}
+
+// desc is an element descriptor used to build element status data.
+type desc struct {
+	addr   int
+	full   bool
+	source int
+	voltag string
+	ident  []byte
+}
+
+// elementStatus builds READ ELEMENT STATUS data with a single page.
+func elementStatus(typ byte, dvcid bool, descs ...desc) []byte {
+	dlen := 12 + 36
+	if dvcid {
+		dlen += 4 + 40
+	}
+
+	var page []byte
+	for _, d := range descs {
+		b := make([]byte, dlen)
+		binary.BigEndian.PutUint16(b[0:], uint16(d.addr))
+
+		if d.full {
+			b[2] = 0x01
+		}
+
+		if d.source != 0 {
+			b[9] = 0x80
+			binary.BigEndian.PutUint16(b[10:], uint16(d.source))
+		}
+
+		tag := make([]byte, 32)
+		for i := range tag {
+			tag[i] = ' '
+		}
+
+		copy(tag, d.voltag)
+		copy(b[12:], tag)
+
+		if dvcid {
+			id := b[48:]
+			id[0] = 0x02 // ascii
+			id[1] = 0x01 // t10 vendor identification
+			id[3] = byte(len(d.ident))
+			copy(id[4:], d.ident)
+		}
+
+		page = append(page, b...)
+	}
+
+	phdr := []byte{typ, 0x80, byte(dlen >> 8), byte(dlen), 0, byte(len(page) >> 16), byte(len(page) >> 8), byte(len(page))}
+	page = append(phdr, page...)
+
+	hdr := []byte{0, 0, 0, byte(len(descs)), 0, byte(len(page) >> 16), byte(len(page) >> 8), byte(len(page))}
+	if len(descs) > 0 {
+		binary.BigEndian.PutUint16(hdr[0:], uint16(descs[0].addr))
+	}
+
+	return append(hdr, page...)
+}
+
+// readElementStatus returns the exchanges of a READ ELEMENT STATUS.
+func readElementStatus(typ byte, first, count int, dvcid bool, data []byte) []scsi.Exchange {
+	cdb := func(alloc int) []byte {
+		cdb := []byte{0xb8, 0x10 | typ, byte(first >> 8), byte(first), byte(count >> 8), byte(count), 0, byte(alloc >> 16), byte(alloc >> 8), byte(alloc), 0, 0}
+		if dvcid {
+			cdb[6] = 0x01
+		}
+
+		return cdb
+	}
+
+	return []scsi.Exchange{
+		{CDB: cdb(8), Data: data[:8]},
+		{CDB: cdb(len(data)), Data: data},
+	}
+}
+
+var modeSense = scsi.Exchange{
+	CDB:  []byte{0x1a, 0x08, 0x1d, 0, 255, 0},
+	Data: eaaPage(3, 1, 2),
+}
+
+func t10(vendor, product, serial string) []byte {
+	id := make([]byte, 24)
+	for i := range id {
+		id[i] = ' '
+	}
+
+	copy(id, vendor)
+	copy(id[8:], product)
+
+	return append(id, serial...)
+}
+
+func TestStatus(t *testing.T) {
+	var exchanges []scsi.Exchange
+	exchanges = append(exchanges, modeSense)
+	exchanges = append(exchanges, readElementStatus(4, 500, 2, true, elementStatus(4, true,
+		desc{addr: 500, full: true, source: 1001, voltag: "A00001L7", ident: t10("IBM", "ULT3580-TD7", "1013000653")},
+		desc{addr: 501, ident: t10("IBM", "ULT3580-TD7", "1013000654")},
+	))...)
+	exchanges = append(exchanges, readElementStatus(2, 1000, 3, false, elementStatus(2, false,
+		desc{addr: 1000, full: true, voltag: "A00000L7"},
+		desc{addr: 1001},
+		desc{addr: 1002, full: true, voltag: "CLN000L1"},
+	))...)
+	exchanges = append(exchanges, readElementStatus(3, 10, 1, false, elementStatus(3, false,
+		desc{addr: 10, full: true, voltag: "B00000L7"},
+	))...)
+
+	script := scsi.NewScript(exchanges...)
+	chgr := scsi.NewChanger(script)
+
+	slots, err := chgr.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc := func(addr int, cat tape.SlotCategory) tape.Location {
+		return tape.Location{Addr: tape.Addr(addr), Category: cat}
+	}
+
+	expected := map[tape.SlotCategory]tape.Slots{
+		tape.TransferSlot: {
+			{
+				Location: loc(500, tape.TransferSlot),
+				Volume: &tape.Volume{
+					Serial:   "A00001L7",
+					Location: loc(500, tape.TransferSlot),
+					Home:     loc(1001, tape.StorageSlot),
+				},
+				DriveSerial: "1013000653",
+			},
+			{
+				Location:    loc(501, tape.TransferSlot),
+				DriveSerial: "1013000654",
+			},
+		},
+		tape.StorageSlot: {
+			{
+				Location: loc(1000, tape.StorageSlot),
+				Volume:   &tape.Volume{Serial: "A00000L7", Location: loc(1000, tape.StorageSlot)},
+			},
+			{
+				Location: loc(1001, tape.StorageSlot),
+			},
+			{
+				Location: loc(1002, tape.StorageSlot),
+				Volume:   &tape.Volume{Serial: "CLN000L1", Location: loc(1002, tape.StorageSlot)},
+			},
+		},
+		tape.ImportExportSlot: {
+			{
+				Location: loc(10, tape.ImportExportSlot),
+				Volume:   &tape.Volume{Serial: "B00000L7", Location: loc(10, tape.ImportExportSlot)},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(slots, expected) {
+		t.Errorf("unexpected status:\n got: %+v\nwant: %+v", slots, expected)
+	}
+
+	if n := script.Remaining(); n != 0 {
+		t.Errorf("%d exchanges not replayed", n)
+	}
+}
+
+func TestStatusWithoutDeviceIdentifiers(t *testing.T) {
+	illegal := []byte{0x70, 0, 0x05, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0x24, 0x00}
+
+	var exchanges []scsi.Exchange
+	exchanges = append(exchanges, modeSense)
+	exchanges = append(exchanges, scsi.Exchange{
+		CDB:    []byte{0xb8, 0x14, 0x01, 0xf4, 0, 2, 0x01, 0, 0, 8, 0, 0},
+		Status: scsi.StatusCheckCondition,
+		Sense:  illegal,
+	})
+	exchanges = append(exchanges, readElementStatus(4, 500, 2, false, elementStatus(4, false,
+		desc{addr: 500},
+		desc{addr: 501},
+	))...)
+	exchanges = append(exchanges, readElementStatus(2, 1000, 3, false, elementStatus(2, false))...)
+	exchanges = append(exchanges, readElementStatus(3, 10, 1, false, elementStatus(3, false))...)
+
+	script := scsi.NewScript(exchanges...)
+
+	slots, err := scsi.NewChanger(script).Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(slots[tape.TransferSlot]); n != 2 {
+		t.Errorf("expected 2 transfer slots, got %d", n)
+	}
+
+	for _, slot := range slots[tape.TransferSlot] {
+		if slot.DriveSerial != "" {
+			t.Errorf("unexpected drive serial %q", slot.DriveSerial)
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	move := func(src, dst int) []byte {
+		return []byte{0xa5, 0, 0, 1, byte(src >> 8), byte(src), byte(dst >> 8), byte(dst), 0, 0, 0, 0}
+	}
+
+	script := scsi.NewScript(
+		modeSense,
+		scsi.Exchange{CDB: move(1000, 500)},
+		scsi.Exchange{CDB: move(500, 1000)},
+		scsi.Exchange{CDB: move(1000, 10)},
+		scsi.Exchange{CDB: []byte{0x07, 0, 0, 0, 0, 0}},
+	)
+
+	chgr := scsi.NewChanger(script)
+
+	drive := tape.Location{Addr: 500, Category: tape.TransferSlot}
+	slot := tape.Location{Addr: 1000, Category: tape.StorageSlot}
+	ix := tape.Location{Addr: 10, Category: tape.ImportExportSlot}
+
+	if err := chgr.Load(slot, drive); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chgr.Unload(drive, slot); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chgr.Transfer(slot, ix); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chgr.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := script.Remaining(); n != 0 {
+		t.Errorf("%d exchanges not replayed", n)
+	}
+}
+
+func TestMoveCheckCondition(t *testing.T) {
+	tests := []struct {
+		sense []byte
+		kind  errors.Kind
+	}{
+		// fixed format, illegal request: medium destination element full
+		{[]byte{0x70, 0, 0x05, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0x3b, 0x0d}, errors.Invalid},
+
+		// descriptor format, not ready: becoming ready
+		{[]byte{0x72, 0x02, 0x04, 0x01, 0, 0, 0, 0}, errors.Transient},
+
+		// fixed format, hardware error
+		{[]byte{0x70, 0, 0x04, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0x15, 0x01}, errors.IO},
+	}
+
+	for _, tt := range tests {
+		script := scsi.NewScript(
+			modeSense,
+			scsi.Exchange{
+				CDB:    []byte{0xa5, 0, 0, 1, 0x03, 0xe8, 0x01, 0xf4, 0, 0, 0, 0},
+				Status: scsi.StatusCheckCondition,
+				Sense:  tt.sense,
+			},
+		)
+
+		err := scsi.NewChanger(script).Load(
+			tape.Location{Addr: 1000, Category: tape.StorageSlot},
+			tape.Location{Addr: 500, Category: tape.TransferSlot},
+		)
+
+		if !errors.Is(tt.kind, err) {
+			t.Errorf("sense % x: expected error of kind %v, got %v", tt.sense, tt.kind, err)
+		}
+	}
+}
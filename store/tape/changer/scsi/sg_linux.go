@@ -0,0 +1,136 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scsi
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"tapr.space/errors"
+)
+
+// sgIO is the SG_IO ioctl request.
+const sgIO = 0x2285
+
+// data transfer directions of the SCSI generic driver
+const (
+	sgDxferNone    = -1
+	sgDxferToDev   = -2
+	sgDxferFromDev = -3
+)
+
+// driverSense is the driver status reported along with sense data.
+const driverSense = 0x08
+
+// maxSense is the size of the sense buffer.
+const maxSense = 64
+
+// sgIOHdr mirrors struct sg_io_hdr from <scsi/sg.h>.
+type sgIOHdr struct {
+	interfaceID    int32
+	dxferDirection int32
+	cmdLen         uint8
+	mxSbLen        uint8
+	iovecCount     uint16
+	dxferLen       uint32
+	dxferp         *byte
+	cmdp           *byte
+	sbp            *byte
+	timeout        uint32
+	flags          uint32
+	packID         int32
+	usrPtr         *byte
+	status         uint8
+	maskedStatus   uint8
+	msgStatus      uint8
+	sbLenWr        uint8
+	hostStatus     uint16
+	driverStatus   uint16
+	resid          int32
+	duration       uint32
+	info           uint32
+}
+
+type sg struct {
+	f *os.File
+}
+
+// Open opens the SCSI generic device at path (e.g. /dev/sg3).
+func Open(path string) (Transport, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sg{f: f}, nil
+}
+
+// Do implements Transport.
+func (t *sg) Do(cmd *Command) error {
+	sense := make([]byte, maxSense)
+
+	hdr := sgIOHdr{
+		interfaceID: 'S',
+		cmdLen:      uint8(len(cmd.CDB)),
+		mxSbLen:     maxSense,
+		dxferLen:    uint32(len(cmd.Data)),
+		cmdp:        &cmd.CDB[0],
+		sbp:         &sense[0],
+		timeout:     uint32(cmd.Timeout.Nanoseconds() / 1e6),
+	}
+
+	switch cmd.Dir {
+	case NoData:
+		hdr.dxferDirection = sgDxferNone
+	case FromDevice:
+		hdr.dxferDirection = sgDxferFromDev
+	case ToDevice:
+		hdr.dxferDirection = sgDxferToDev
+	}
+
+	if len(cmd.Data) > 0 {
+		hdr.dxferp = &cmd.Data[0]
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, t.f.Fd(), sgIO, uintptr(unsafe.Pointer(&hdr)))
+
+	runtime.KeepAlive(cmd)
+	runtime.KeepAlive(sense)
+
+	if errno != 0 {
+		return errors.E(errors.Strf("SG_IO: %v", errno))
+	}
+
+	if hdr.hostStatus != 0 {
+		return errors.E(errors.Strf("SG_IO: host status %#x", hdr.hostStatus))
+	}
+
+	if ds := hdr.driverStatus & 0x0f; ds != 0 && ds != driverSense {
+		return errors.E(errors.Strf("SG_IO: driver status %#x", hdr.driverStatus))
+	}
+
+	cmd.Status = hdr.status
+	cmd.Sense = sense[:hdr.sbLenWr]
+	cmd.Resid = int(hdr.resid)
+
+	return nil
+}
+
+// Close implements Transport.
+func (t *sg) Close() error {
+	return t.f.Close()
+}
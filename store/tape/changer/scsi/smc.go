@@ -0,0 +1,317 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scsi
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+)
+
+// operation codes
+const (
+	opInitializeElementStatus = 0x07
+	opModeSense6              = 0x1a
+	opMoveMedium              = 0xa5
+	opReadElementStatus       = 0xb8
+)
+
+// pageElementAddressAssignment is the Element Address Assignment mode page.
+const pageElementAddressAssignment = 0x1d
+
+// elementType is an SMC element type code.
+type elementType byte
+
+const (
+	mediumTransportElement elementType = 1
+	storageElement         elementType = 2
+	importExportElement    elementType = 3
+	dataTransferElement    elementType = 4
+)
+
+// elementRange is a range of element addresses.
+type elementRange struct {
+	first tape.Addr
+	count int
+}
+
+func (r elementRange) contains(addr tape.Addr) bool {
+	return addr >= r.first && int(addr-r.first) < r.count
+}
+
+// assignment is the element address assignment of a changer.
+type assignment struct {
+	transport, storage, ix, transfer elementRange
+}
+
+// category returns the slot category of the element with the given address.
+func (eaa *assignment) category(addr tape.Addr) tape.SlotCategory {
+	switch {
+	case eaa.storage.contains(addr):
+		return tape.StorageSlot
+	case eaa.ix.contains(addr):
+		return tape.ImportExportSlot
+	case eaa.transfer.contains(addr):
+		return tape.TransferSlot
+	}
+
+	return tape.InvalidSlot
+}
+
+// modeSenseEAA reads the Element Address Assignment mode page.
+func modeSenseEAA(t Transport) (*assignment, error) {
+	buf := make([]byte, 255)
+
+	cmd := &Command{
+		// disable block descriptors
+		CDB:     []byte{opModeSense6, 0x08, pageElementAddressAssignment, 0, byte(len(buf)), 0},
+		Dir:     FromDevice,
+		Data:    buf,
+		Timeout: defaultTimeout,
+	}
+
+	if err := do(t, cmd); err != nil {
+		return nil, errors.E("MODE SENSE", err)
+	}
+
+	data := buf[:len(buf)-cmd.Resid]
+	if len(data) < 4 {
+		return nil, errors.E(errors.IO, errors.Str("MODE SENSE: short mode parameter header"))
+	}
+
+	// skip the mode parameter header and any block descriptors
+	page := data[4+int(data[3]):]
+	if len(page) < 18 || page[0]&0x3f != pageElementAddressAssignment {
+		return nil, errors.E(errors.IO, errors.Str("MODE SENSE: invalid element address assignment page"))
+	}
+
+	rng := func(off int) elementRange {
+		return elementRange{
+			first: tape.Addr(binary.BigEndian.Uint16(page[off:])),
+			count: int(binary.BigEndian.Uint16(page[off+2:])),
+		}
+	}
+
+	return &assignment{
+		transport: rng(2),
+		storage:   rng(6),
+		ix:        rng(10),
+		transfer:  rng(14),
+	}, nil
+}
+
+// moveMedium issues MOVE MEDIUM.
+func moveMedium(t Transport, transport, src, dst tape.Addr) error {
+	cdb := make([]byte, 12)
+	cdb[0] = opMoveMedium
+	binary.BigEndian.PutUint16(cdb[2:], uint16(transport))
+	binary.BigEndian.PutUint16(cdb[4:], uint16(src))
+	binary.BigEndian.PutUint16(cdb[6:], uint16(dst))
+
+	cmd := &Command{
+		CDB:     cdb,
+		Dir:     NoData,
+		Timeout: moveTimeout,
+	}
+
+	if err := do(t, cmd); err != nil {
+		return errors.E("MOVE MEDIUM", err)
+	}
+
+	return nil
+}
+
+// initializeElementStatus issues INITIALIZE ELEMENT STATUS.
+func initializeElementStatus(t Transport) error {
+	cmd := &Command{
+		CDB:     []byte{opInitializeElementStatus, 0, 0, 0, 0, 0},
+		Dir:     NoData,
+		Timeout: moveTimeout,
+	}
+
+	if err := do(t, cmd); err != nil {
+		return errors.E("INITIALIZE ELEMENT STATUS", err)
+	}
+
+	return nil
+}
+
+// element is a parsed element descriptor.
+type element struct {
+	addr tape.Addr
+	full bool
+
+	// source storage element address (if svalid)
+	svalid bool
+	source tape.Addr
+
+	// primary volume tag (barcode)
+	voltag string
+
+	// device identifier (drive serial number)
+	ident string
+}
+
+// readElementStatusCDB returns the READ ELEMENT STATUS command descriptor
+// block.
+func readElementStatusCDB(typ elementType, rng elementRange, dvcid bool, alloc int) []byte {
+	cdb := make([]byte, 12)
+	cdb[0] = opReadElementStatus
+
+	// report volume tags
+	cdb[1] = 0x10 | byte(typ)
+
+	binary.BigEndian.PutUint16(cdb[2:], uint16(rng.first))
+	binary.BigEndian.PutUint16(cdb[4:], uint16(rng.count))
+
+	if dvcid {
+		cdb[6] = 0x01
+	}
+
+	cdb[7] = byte(alloc >> 16)
+	cdb[8] = byte(alloc >> 8)
+	cdb[9] = byte(alloc)
+
+	return cdb
+}
+
+// readElementStatus issues READ ELEMENT STATUS for the given range of
+// elements. The size of the report is requested first such that the report
+// can be read in full.
+func readElementStatus(t Transport, typ elementType, rng elementRange, dvcid bool) ([]element, error) {
+	hdr := make([]byte, 8)
+
+	cmd := &Command{
+		CDB:     readElementStatusCDB(typ, rng, dvcid, len(hdr)),
+		Dir:     FromDevice,
+		Data:    hdr,
+		Timeout: defaultTimeout,
+	}
+
+	if err := do(t, cmd); err != nil {
+		return nil, errors.E("READ ELEMENT STATUS", err)
+	}
+
+	size := len(hdr) + int(uint32(hdr[5])<<16|uint32(hdr[6])<<8|uint32(hdr[7]))
+
+	buf := make([]byte, size)
+
+	cmd = &Command{
+		CDB:     readElementStatusCDB(typ, rng, dvcid, len(buf)),
+		Dir:     FromDevice,
+		Data:    buf,
+		Timeout: defaultTimeout,
+	}
+
+	if err := do(t, cmd); err != nil {
+		return nil, errors.E("READ ELEMENT STATUS", err)
+	}
+
+	return parseElementStatus(buf[:len(buf)-cmd.Resid])
+}
+
+// parseElementStatus parses element status data.
+func parseElementStatus(data []byte) ([]element, error) {
+	if len(data) < 8 {
+		return nil, errors.E(errors.IO, errors.Str("short element status header"))
+	}
+
+	n := int(uint32(data[5])<<16 | uint32(data[6])<<8 | uint32(data[7]))
+	if n > len(data)-8 {
+		n = len(data) - 8
+	}
+
+	data = data[8 : 8+n]
+
+	var elems []element
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.E(errors.IO, errors.Str("short element status page header"))
+		}
+
+		pvoltag := data[1]&0x80 != 0
+		avoltag := data[1]&0x40 != 0
+		dlen := int(binary.BigEndian.Uint16(data[2:]))
+		plen := int(uint32(data[5])<<16 | uint32(data[6])<<8 | uint32(data[7]))
+
+		if plen > len(data)-8 {
+			return nil, errors.E(errors.IO, errors.Str("truncated element status page"))
+		}
+
+		if dlen < 12 {
+			return nil, errors.E(errors.IO, errors.Strf("invalid element descriptor length %d", dlen))
+		}
+
+		page := data[8 : 8+plen]
+		data = data[8+plen:]
+
+		for len(page) >= dlen {
+			elems = append(elems, parseElement(page[:dlen], pvoltag, avoltag))
+			page = page[dlen:]
+		}
+	}
+
+	return elems, nil
+}
+
+// volumeTagLength is the length of a volume tag field.
+const volumeTagLength = 36
+
+// parseElement parses a single element descriptor.
+func parseElement(d []byte, pvoltag, avoltag bool) element {
+	e := element{
+		addr:   tape.Addr(binary.BigEndian.Uint16(d[0:])),
+		full:   d[2]&0x01 != 0,
+		svalid: d[9]&0x80 != 0,
+		source: tape.Addr(binary.BigEndian.Uint16(d[10:])),
+	}
+
+	rest := d[12:]
+
+	if pvoltag && len(rest) >= volumeTagLength {
+		e.voltag = trim(rest[:32])
+		rest = rest[volumeTagLength:]
+	}
+
+	if avoltag && len(rest) >= volumeTagLength {
+		rest = rest[volumeTagLength:]
+	}
+
+	// device identification descriptor (DVCID)
+	if len(rest) >= 4 {
+		idtype := rest[1] & 0x0f
+		idlen := int(rest[3])
+
+		if idlen > 0 && len(rest) >= 4+idlen {
+			id := rest[4 : 4+idlen]
+
+			// T10 vendor identification: vendor (8), product (16), serial
+			if idtype == 0x01 && len(id) > 24 {
+				id = id[24:]
+			}
+
+			e.ident = trim(id)
+		}
+	}
+
+	return e
+}
+
+// trim trims space and NUL padding from an ASCII field.
+func trim(p []byte) string {
+	return string(bytes.Trim(p, " \x00"))
+}
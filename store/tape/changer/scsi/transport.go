@@ -0,0 +1,155 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scsi
+
+import (
+	"fmt"
+	"time"
+
+	"tapr.space/errors"
+)
+
+// Direction is the direction of a data transfer.
+type Direction int
+
+// Data transfer directions.
+const (
+	// NoData is used for commands that transfer no data.
+	NoData Direction = iota
+
+	// FromDevice is used for commands that read data from the device.
+	FromDevice
+
+	// ToDevice is used for commands that write data to the device.
+	ToDevice
+)
+
+// SCSI status codes.
+const (
+	StatusGood           byte = 0x00
+	StatusCheckCondition byte = 0x02
+	StatusBusy           byte = 0x08
+)
+
+// A Command is a SCSI command.
+type Command struct {
+	// CDB is the command descriptor block.
+	CDB []byte
+
+	// Dir is the direction of the data transfer.
+	Dir Direction
+
+	// Data is the data buffer.
+	Data []byte
+
+	// Timeout is the maximum time the command may take.
+	Timeout time.Duration
+
+	// Status is the SCSI status returned by the device.
+	Status byte
+
+	// Sense holds the sense data returned by the device (if any).
+	Sense []byte
+
+	// Resid is the number of bytes of Data that were not transferred.
+	Resid int
+}
+
+// A Transport issues SCSI commands to a device.
+type Transport interface {
+	// Do issues the command and waits for it to complete. An error is only
+	// returned if the command could not be delivered; the outcome of the
+	// command is reported in cmd.Status and cmd.Sense.
+	Do(cmd *Command) error
+
+	// Close releases the transport.
+	Close() error
+}
+
+// SenseError is a CHECK CONDITION reported by a device.
+type SenseError struct {
+	Key, ASC, ASCQ byte
+}
+
+func (e *SenseError) Error() string {
+	return fmt.Sprintf("check condition: sense key %#x (%s), asc/ascq %#02x/%#02x", e.Key, senseKeys[e.Key&0x0f], e.ASC, e.ASCQ)
+}
+
+// sense keys
+const (
+	senseNotReady       = 0x02
+	senseIllegalRequest = 0x05
+	senseUnitAttention  = 0x06
+)
+
+var senseKeys = [16]string{
+	"no sense", "recovered error", "not ready", "medium error",
+	"hardware error", "illegal request", "unit attention", "data protect",
+	"blank check", "vendor specific", "copy aborted", "aborted command",
+	"reserved", "volume overflow", "miscompare", "completed",
+}
+
+// parseSense parses fixed and descriptor format sense data.
+func parseSense(sense []byte) *SenseError {
+	if len(sense) < 4 {
+		return nil
+	}
+
+	switch sense[0] & 0x7f {
+	case 0x70, 0x71:
+		if len(sense) < 14 {
+			return &SenseError{Key: sense[2] & 0x0f}
+		}
+
+		return &SenseError{Key: sense[2] & 0x0f, ASC: sense[12], ASCQ: sense[13]}
+
+	case 0x72, 0x73:
+		return &SenseError{Key: sense[1] & 0x0f, ASC: sense[2], ASCQ: sense[3]}
+	}
+
+	return nil
+}
+
+// do issues the command and converts a failed status into an error.
+func do(t Transport, cmd *Command) error {
+	if err := t.Do(cmd); err != nil {
+		return errors.E(errors.IO, err)
+	}
+
+	switch cmd.Status {
+	case StatusGood:
+		return nil
+
+	case StatusCheckCondition:
+		se := parseSense(cmd.Sense)
+		if se == nil {
+			return errors.E(errors.IO, errors.Str("check condition without sense data"))
+		}
+
+		switch se.Key {
+		case senseNotReady, senseUnitAttention:
+			return errors.E(errors.Transient, se)
+		case senseIllegalRequest:
+			return errors.E(errors.Invalid, se)
+		}
+
+		return errors.E(errors.IO, se)
+
+	case StatusBusy:
+		return errors.E(errors.Transient, errors.Str("device busy"))
+	}
+
+	return errors.E(errors.IO, errors.Strf("scsi status %#02x", cmd.Status))
+}
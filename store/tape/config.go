@@ -14,7 +14,11 @@
 
 package tape
 
-import "tapr.space/config"
+import (
+	"time"
+
+	"tapr.space/config"
+)
 
 func init() {
 	config.Register("store/tape", configurator)
@@ -42,6 +46,9 @@ type Config struct {
 	// CleaningPrefix is the prefix that identifies cleaning cartridges.
 	CleaningPrefix string `yaml:"cleaning-prefix"`
 
+	// Cleaning configures the cleaning of drives.
+	Cleaning CleaningConfig
+
 	// Inventory is the inventory database configuration.
 	Inventory struct {
 		Driver  string
@@ -55,11 +62,32 @@ type Config struct {
 	Drives struct {
 		Format FormatConfig
 
+		// MaxWriters is the maximum number of concurrent push sessions
+		// sharing a single write drive (defaults to 1).
+		MaxWriters int `yaml:"max-writers"`
+
 		Read  map[string]DriveConfig
 		Write map[string]DriveConfig
 	}
 }
 
+// CleaningConfig holds the configuration for drive cleaning. Drives are
+// cleaned when they report that they need cleaning or after a number of
+// mounts.
+type CleaningConfig struct {
+	// Interval is the number of mounts after which a drive is cleaned. If
+	// zero, drives are only cleaned when they ask for it.
+	Interval int
+
+	// Uses is the number of cleanings a cleaning cartridge is good for
+	// before it expires (defaults to 50).
+	Uses int
+
+	// Duration is the time allowed for a cleaning cycle to complete
+	// (defaults to 2 minutes).
+	Duration time.Duration
+}
+
 // ChangerConfig holds the configuration for a changer.
 type ChangerConfig struct {
 	Driver  string
@@ -70,6 +98,15 @@ type ChangerConfig struct {
 type DriveConfig struct {
 	Slot int
 	Path string
+
+	// Changer is the name of the changer serving the drive. It may be
+	// omitted if only a single changer is configured.
+	Changer string
+
+	// Device is the st device of the drive (e.g. /dev/nst0) that is polled
+	// for alerts. It must not be the device used by the format. With
+	// emulated devices, it is the path of a virtual tape image. Optional.
+	Device string
 }
 
 type FormatConfig struct {
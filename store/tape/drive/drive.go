@@ -15,27 +15,61 @@
 package drive // import "tapr.space/store/tape/drive"
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
+	"tapr.space"
+	"tapr.space/errors"
 	"tapr.space/flags"
 	"tapr.space/format"
 	"tapr.space/log"
 	"tapr.space/storage"
 	"tapr.space/store/tape"
 	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/drive/st"
 	"tapr.space/store/tape/inv"
 )
 
 // Drive represents a tape drive.
 type Drive struct {
 	devpath string
-	Serial  tape.Serial
 
 	name string
 	loc  tape.Location
 
-	storage.Storage
+	invdb inv.Inventory
+	chgr  changer.Changer
+	fmtr  format.Formatter
+
+	mu sync.RWMutex
+
+	// serial of the mounted volume (if any)
+	serial tape.Serial
+
+	// storage of the mounted volume
+	stg storage.Storage
+
+	// gen is incremented on every volume rollover
+	gen int
+
+	// files currently open on the mounted volume
+	files map[*file]struct{}
+
+	// number of volumes loaded since the drive was last cleaned
+	mounts int
+
+	// set when the drive has asked to be cleaned
+	dirty bool
+
+	// set while a cleaning cartridge is in the drive
+	cleaning bool
+
+	// st device polled for alerts (if any)
+	devmu sync.Mutex
+	dev   st.Device
 }
 
 // New returns a new fake tape drive implementation.
@@ -51,6 +85,7 @@ func New(name string, cfg tape.DriveConfig) (*Drive, error) {
 	loc := tape.Location{
 		Addr:     tape.Addr(cfg.Slot),
 		Category: tape.TransferSlot,
+		Changer:  cfg.Changer,
 	}
 
 	log.Debug.Printf("%s: created", op)
@@ -59,15 +94,50 @@ func New(name string, cfg tape.DriveConfig) (*Drive, error) {
 		devpath: cfg.Path,
 		name:    name,
 		loc:     loc,
+		files:   make(map[*file]struct{}),
+	}
+
+	if cfg.Device != "" {
+		var err error
+		if flags.EmulateDevices {
+			drv.dev, err = st.OpenVirtual(cfg.Device)
+		} else {
+			drv.dev, err = st.Open(cfg.Device)
+		}
+
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
 	}
 
 	return drv, nil
 }
 
+// String implements fmt.Stringer.
+func (drv *Drive) String() string {
+	return drv.name
+}
+
+// Changer returns the name of the changer serving the drive.
+func (drv *Drive) Changer() string {
+	return drv.loc.Changer
+}
+
+// Serial returns the serial of the mounted volume or the empty string if
+// the drive is empty.
+func (drv *Drive) Serial() tape.Serial {
+	drv.mu.RLock()
+	defer drv.mu.RUnlock()
+
+	return drv.serial
+}
+
 // Start the drive.
 func (drv *Drive) Start(invdb inv.Inventory, chgr changer.Changer, fmtr format.Formatter) error {
 	op := fmt.Sprintf("drive/fake.Setup[%s (slot %d) (path %s)]", drv.name, drv.loc.Addr, drv.devpath)
 
+	drv.invdb, drv.chgr, drv.fmtr = invdb, chgr, fmtr
+
 	loaded, serial, err := invdb.Loaded(drv.loc)
 	if err != nil {
 		return err
@@ -75,34 +145,319 @@ func (drv *Drive) Start(invdb inv.Inventory, chgr changer.Changer, fmtr format.F
 
 	if !loaded {
 		log.Debug.Printf("%s: drive is empty, allocating", op)
-		// get a volume from the inventory if we do not already have a
-		// volume mounted
-		serial, err = invdb.Alloc()
-		if err != nil {
+		return drv.alloc()
+	}
+
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+
+	return drv.mount(serial)
+}
+
+// Attach attaches the drive to the store without allocating a volume. If a
+// volume is already loaded, it is mounted.
+func (drv *Drive) Attach(invdb inv.Inventory, chgr changer.Changer, fmtr format.Formatter) error {
+	op := fmt.Sprintf("drive/Drive.Attach[%s (slot %d) (path %s)]", drv.name, drv.loc.Addr, drv.devpath)
+
+	drv.invdb, drv.chgr, drv.fmtr = invdb, chgr, fmtr
+
+	loaded, serial, err := invdb.Loaded(drv.loc)
+	if err != nil {
+		return err
+	}
+
+	if !loaded {
+		log.Debug.Printf("%s: drive is empty", op)
+		return nil
+	}
+
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+
+	return drv.mount(serial)
+}
+
+// Device returns the st device polled for alerts or nil if none is
+// configured.
+func (drv *Drive) Device() st.Device {
+	return drv.dev
+}
+
+// Load loads and mounts the volume with the given serial, unloading any
+// volume currently in the drive. The changer moves are queued with the
+// priority carried by ctx. The drive MUST NOT be in use while loading.
+func (drv *Drive) Load(ctx context.Context, serial tape.Serial) error {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+
+	if drv.cleaning {
+		return errors.E(errors.Transient, errors.Strf("%s is being cleaned", drv.name))
+	}
+
+	return drv.load(ctx, serial)
+}
+
+// Unload unmounts the volume in the drive and returns it to its home slot.
+func (drv *Drive) Unload() error {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+
+	if drv.cleaning {
+		return errors.E(errors.Transient, errors.Strf("%s is being cleaned", drv.name))
+	}
+
+	return drv.unload(context.Background())
+}
+
+// RequestCleaning marks the drive as needing cleaning, for instance because
+// the device raised a cleaning alert.
+func (drv *Drive) RequestCleaning() {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+
+	drv.dirty = true
+}
+
+// Poll polls the device of the drive for alerts. A cleaning alert requests
+// cleaning of the drive.
+func (drv *Drive) Poll() error {
+	op := fmt.Sprintf("drive/Drive.Poll[%s (slot %d) (path %s)]", drv.name, drv.loc.Addr, drv.devpath)
+
+	if drv.dev == nil {
+		return nil
+	}
+
+	drv.devmu.Lock()
+	status, err := drv.dev.Status()
+	drv.devmu.Unlock()
+
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if status.Alerts()&st.Cleaning != 0 {
+		log.Debug.Printf("%s: drive requests cleaning", op)
+		drv.RequestCleaning()
+	}
+
+	return nil
+}
+
+// NeedsCleaning returns true if the drive has asked to be cleaned or if at
+// least interval volumes have been loaded since the last cleaning. An
+// interval of zero disables the latter.
+func (drv *Drive) NeedsCleaning(interval int) bool {
+	drv.mu.RLock()
+	defer drv.mu.RUnlock()
+
+	return drv.dirty || (interval > 0 && drv.mounts >= interval)
+}
+
+// Clean cleans the drive using the given cleaning cartridge. The mounted
+// volume (if any) is unloaded while cleaning and loaded again afterwards,
+// also if cleaning fails. Clean waits for the given duration for the
+// cleaning cycle to complete, or until ctx is done; meanwhile the drive is
+// unlocked but refuses to load volumes. The changer moves are queued with
+// the priority carried by ctx. The drive MUST NOT be in use while cleaning.
+func (drv *Drive) Clean(ctx context.Context, serial tape.Serial, wait time.Duration) error {
+	op := fmt.Sprintf("drive/Drive.Clean[%s (slot %d) (path %s)]", drv.name, drv.loc.Addr, drv.devpath)
+
+	chgr := changer.Bind(ctx, drv.chgr)
+
+	prev, err := drv.startCleaning(ctx, op, serial, chgr)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	// the drive runs the cleaning cycle by itself once the cartridge is
+	// loaded
+	t := time.NewTimer(wait)
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		t.Stop()
+	}
+
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+
+	drv.cleaning = false
+
+	// the cartridge is unloaded and the volume restored even if ctx is done
+	bg := detach(ctx)
+
+	if err := drv.invdb.Unload(serial, tape.Location{}, changer.Bind(bg, drv.chgr)); err != nil {
+		drv.restore(bg, op, prev)
+		return errors.E(op, err)
+	}
+
+	if ctx.Err() != nil {
+		drv.restore(bg, op, prev)
+		return errors.E(op, errors.Transient, errors.Str("cleaning was interrupted"))
+	}
+
+	drv.mounts, drv.dirty = 0, false
+
+	// a virtual device does not run a cleaning cycle; clear the alert like
+	// a drive would
+	if v, ok := drv.dev.(*st.Virtual); ok {
+		drv.devmu.Lock()
+		v.Clear(st.Cleaning)
+		drv.devmu.Unlock()
+	}
+
+	if prev == "" {
+		return nil
+	}
+
+	// loading the volume again does not count against the cleaning
+	// interval
+	if err := drv.load(bg, prev); err != nil {
+		return errors.E(op, err)
+	}
+
+	drv.mounts--
+
+	return nil
+}
+
+// restore loads the volume that was mounted before cleaning started, if
+// any; the load does not count against the cleaning interval. Errors are
+// logged only. drv.mu MUST be held.
+func (drv *Drive) restore(ctx context.Context, op string, prev tape.Serial) {
+	if prev == "" {
+		return
+	}
+
+	if err := drv.load(ctx, prev); err != nil {
+		log.Error.Printf("%s: could not load %v again: %v", op, prev, err)
+		return
+	}
+
+	drv.mounts--
+}
+
+// detach returns a context carrying the priority of ctx that is never done,
+// for changer moves that must be carried out regardless of ctx.
+func detach(ctx context.Context) context.Context {
+	return changer.WithPriority(context.Background(), changer.PriorityFrom(ctx))
+}
+
+// startCleaning unloads the mounted volume and loads the cleaning cartridge
+// into the drive. It returns the serial of the volume that was mounted. If
+// the cleaning cartridge cannot be loaded, that volume is loaded again.
+func (drv *Drive) startCleaning(ctx context.Context, op string, serial tape.Serial, chgr changer.Changer) (tape.Serial, error) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+
+	if drv.cleaning {
+		return "", errors.E(errors.Transient, errors.Strf("%s is already being cleaned", drv.name))
+	}
+
+	prev := drv.serial
+	if err := drv.unload(ctx); err != nil {
+		return "", err
+	}
+
+	log.Debug.Printf("%s: cleaning with %v", op, serial)
+
+	if err := drv.invdb.Load(serial, drv.loc, chgr); err != nil {
+		drv.restore(detach(ctx), op, prev)
+		return "", err
+	}
+
+	drv.cleaning = true
+
+	return prev, nil
+}
+
+// alloc allocates a volume from the inventory and loads it.
+func (drv *Drive) alloc() error {
+	op := fmt.Sprintf("drive/Drive.alloc[%s (slot %d) (path %s)]", drv.name, drv.loc.Addr, drv.devpath)
+
+	// get a volume from the inventory if we do not already have a
+	// volume mounted
+	serial, err := drv.invdb.Alloc(drv.loc.Changer)
+	if err != nil {
+		return err
+	}
+
+	log.Debug.Printf("%s: allocated %v", op, serial)
+
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+
+	return drv.load(context.Background(), serial)
+}
+
+// load loads and mounts the volume. drv.mu MUST be held.
+func (drv *Drive) load(ctx context.Context, serial tape.Serial) error {
+	op := fmt.Sprintf("drive/Drive.Load[%s (slot %d) (path %s)]", drv.name, drv.loc.Addr, drv.devpath)
+
+	if drv.serial == serial {
+		return nil
+	}
+
+	if drv.serial != "" {
+		if err := drv.unload(ctx); err != nil {
 			return err
 		}
+	}
+
+	log.Debug.Printf("%s: loading %v into %v", op, serial, drv.loc)
+
+	if err := drv.invdb.Load(serial, drv.loc, changer.Bind(ctx, drv.chgr)); err != nil {
+		return err
+	}
 
-		log.Debug.Printf("%s: loading %v into %v", op, serial, drv.loc)
+	drv.mounts++
+
+	return drv.mount(serial)
+}
+
+// unload unmounts and unloads the volume. drv.mu MUST be held.
+func (drv *Drive) unload(ctx context.Context) error {
+	op := fmt.Sprintf("drive/Drive.Unload[%s (slot %d) (path %s)]", drv.name, drv.loc.Addr, drv.devpath)
+
+	if drv.serial == "" {
+		return nil
+	}
 
-		if err := invdb.Load(serial, drv.loc, chgr); err != nil {
+	if unmounter, ok := drv.stg.(format.Unmounter); ok {
+		if err := unmounter.Unmount(); err != nil {
 			return err
 		}
 	}
 
-	vol, err := invdb.Info(serial)
+	log.Debug.Printf("%s: unloading %v", op, drv.serial)
+
+	// a zero location returns the volume to its home slot
+	if err := drv.invdb.Unload(drv.serial, tape.Location{}, changer.Bind(ctx, drv.chgr)); err != nil {
+		return err
+	}
+
+	drv.serial = ""
+	drv.stg = nil
+
+	return nil
+}
+
+// mount formats the loaded volume if necessary and mounts it. drv.mu MUST be
+// held.
+func (drv *Drive) mount(serial tape.Serial) error {
+	vol, err := drv.invdb.Info(serial)
 	if err != nil {
 		return err
 	}
 
 	// format the volume if necessary
-	formatted, stg, err := fmtr.Format(drv.devpath, vol)
+	formatted, stg, err := drv.fmtr.Format(drv.devpath, vol)
 	if err != nil {
 		return err
 	}
 
 	if formatted {
-		vol.Category = tape.Filling
-		if err := invdb.Update(vol); err != nil {
+		if err := drv.invdb.Transition(serial, tape.Filling, "formatted in "+drv.name); err != nil {
 			return err
 		}
 	}
@@ -114,8 +469,182 @@ func (drv *Drive) Start(invdb inv.Inventory, chgr changer.Changer, fmtr format.F
 		}
 	}
 
-	drv.Serial = serial
-	drv.Storage = stg
+	drv.serial = serial
+	drv.stg = stg
 
 	return nil
 }
+
+// Create creates the named file on the mounted volume, truncating it if it
+// already exists.
+func (drv *Drive) Create(name tapr.PathName) (tapr.File, error) {
+	return drv.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+}
+
+// Open opens the named file on the mounted volume for reading.
+func (drv *Drive) Open(name tapr.PathName) (tapr.File, error) {
+	return drv.OpenFile(name, os.O_RDONLY)
+}
+
+// Append opens the named file on the mounted volume for appending.
+func (drv *Drive) Append(name tapr.PathName) (tapr.File, error) {
+	return drv.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY)
+}
+
+// OpenFile opens the named file on the mounted volume with the specified
+// flag. Files opened for writing are recorded in the inventory as residing
+// on the mounted volume.
+func (drv *Drive) OpenFile(name tapr.PathName, flag int) (tapr.File, error) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+
+	if drv.stg == nil {
+		return nil, errNotMounted(name)
+	}
+
+	var base int64
+	if writable(flag) {
+		var fresh bool
+		var err error
+		if base, fresh, err = drv.extent(name, flag); err != nil {
+			return nil, err
+		}
+
+		// a new extent never carries data left on the volume under the
+		// same name
+		if fresh {
+			flag = (flag | os.O_CREATE | os.O_TRUNC) &^ os.O_EXCL
+		}
+	}
+
+	f, err := drv.stg.OpenFile(name, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	fp := &file{
+		File: f,
+		drv:  drv,
+		name: name,
+		flag: flag,
+		base: base,
+	}
+
+	drv.files[fp] = struct{}{}
+
+	return fp, nil
+}
+
+// extent prepares the inventory for writing to the named file and returns
+// the file offset of the extent on the mounted volume and whether the extent
+// is new. Appending continues the last extent of the file if it is stored
+// under the name of the file on the mounted volume; otherwise a new extent
+// is started at the end of the file. Without O_APPEND, the file is
+// (re)created on the mounted volume. drv.mu MUST be held.
+func (drv *Drive) extent(name tapr.PathName, flag int) (int64, bool, error) {
+	if flag&os.O_APPEND != 0 {
+		ent, err := drv.invdb.Lookup(name)
+		if err == nil {
+			if len(ent.Extents) == 0 {
+				return 0, false, errors.E(name, errors.Internal, errors.Str("file has no extents"))
+			}
+
+			last := ent.Extents[len(ent.Extents)-1]
+			if last.Serial == drv.serial && last.Name == "" {
+				return last.Offset, false, nil
+			}
+
+			// the new extent would truncate an earlier extent stored
+			// under the same name on the mounted volume
+			for _, ext := range ent.Extents {
+				if ext.Serial == drv.serial && ext.Name == "" {
+					return 0, false, errors.E(name, errors.Invalid, errors.Strf("file has an earlier extent on volume %v", drv.serial))
+				}
+			}
+
+			ext := tape.Extent{
+				Serial: drv.serial,
+				Offset: last.Offset + last.Length,
+			}
+
+			if err := drv.invdb.Extend(name, ext); err != nil {
+				return 0, false, err
+			}
+
+			return ext.Offset, true, nil
+		}
+
+		if !errors.Is(errors.NotExist, err) {
+			return 0, false, err
+		}
+	}
+
+	return 0, true, drv.invdb.Create(name, drv.serial)
+}
+
+// Mkdir creates a directory on the mounted volume.
+func (drv *Drive) Mkdir(name tapr.PathName) error {
+	drv.mu.RLock()
+	defer drv.mu.RUnlock()
+
+	if drv.stg == nil {
+		return errNotMounted(name)
+	}
+
+	return drv.stg.Mkdir(name)
+}
+
+// MkdirAll creates a directory on the mounted volume along with any
+// necessary parents.
+func (drv *Drive) MkdirAll(name tapr.PathName) error {
+	drv.mu.RLock()
+	defer drv.mu.RUnlock()
+
+	if drv.stg == nil {
+		return errNotMounted(name)
+	}
+
+	return drv.stg.MkdirAll(name)
+}
+
+// Stat describes the named file on the mounted volume.
+func (drv *Drive) Stat(name tapr.PathName) (os.FileInfo, error) {
+	drv.mu.RLock()
+	defer drv.mu.RUnlock()
+
+	if drv.stg == nil {
+		return nil, errNotMounted(name)
+	}
+
+	return drv.stg.Stat(name)
+}
+
+// ReadDir reads the named directory on the mounted volume.
+func (drv *Drive) ReadDir(name tapr.PathName) ([]os.FileInfo, error) {
+	drv.mu.RLock()
+	defer drv.mu.RUnlock()
+
+	if drv.stg == nil {
+		return nil, errNotMounted(name)
+	}
+
+	return drv.stg.ReadDir(name)
+}
+
+// Writing reports whether the named file is open for writing on the drive.
+func (drv *Drive) Writing(name tapr.PathName) bool {
+	drv.mu.RLock()
+	defer drv.mu.RUnlock()
+
+	for f := range drv.files {
+		if f.name == name && writable(f.flag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writable(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
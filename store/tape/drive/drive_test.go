@@ -0,0 +1,560 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drive_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"tapr.space"
+	"tapr.space/errors"
+	"tapr.space/flags"
+	"tapr.space/storage"
+	"tapr.space/storage/fsdir"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/changer/fake"
+	"tapr.space/store/tape/drive"
+	"tapr.space/store/tape/drive/st"
+	"tapr.space/store/tape/inv"
+	"tapr.space/store/tape/inv/embedded"
+)
+
+// fmtr is a format.Formatter handing out directories of limited capacity as
+// volumes.
+type fmtr struct {
+	mu   sync.Mutex
+	dir  string
+	size int64
+	vols map[tape.Serial]*volume
+}
+
+func (fm *fmtr) Format(devpath string, vol tape.Volume) (bool, storage.Storage, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	v, ok := fm.vols[vol.Serial]
+	if !ok {
+		root := filepath.Join(fm.dir, string(vol.Serial))
+		if err := os.MkdirAll(root, os.ModePerm); err != nil {
+			return false, nil, err
+		}
+
+		v = &volume{Storage: fsdir.New(root), free: fm.size}
+		fm.vols[vol.Serial] = v
+	}
+
+	return vol.Category == tape.Allocated, v, nil
+}
+
+// volume is a storage.Storage that runs out of space.
+type volume struct {
+	storage.Storage
+
+	mu   sync.Mutex
+	free int64
+}
+
+func (v *volume) OpenFile(name tapr.PathName, flag int) (tapr.File, error) {
+	f, err := v.Storage.OpenFile(name, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &volumeFile{File: f, vol: v}, nil
+}
+
+type volumeFile struct {
+	tapr.File
+	vol *volume
+}
+
+func (f *volumeFile) Write(p []byte) (int, error) {
+	f.vol.mu.Lock()
+	defer f.vol.mu.Unlock()
+
+	if int64(len(p)) <= f.vol.free {
+		n, err := f.File.Write(p)
+		f.vol.free -= int64(n)
+		return n, err
+	}
+
+	n, err := f.File.Write(p[:f.vol.free])
+	f.vol.free -= int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	return n, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC}
+}
+
+// refusing is a changer that refuses to load volumes from a given slot.
+type refusing struct {
+	changer.Changer
+
+	mu  sync.Mutex
+	src tape.Location
+}
+
+func (chgr *refusing) refuse(src tape.Location) {
+	chgr.mu.Lock()
+	defer chgr.mu.Unlock()
+
+	chgr.src = src
+}
+
+func (chgr *refusing) Load(src, dst tape.Location) error {
+	chgr.mu.Lock()
+	refused := src == chgr.src
+	chgr.mu.Unlock()
+
+	if refused {
+		return errors.E(errors.IO, errors.Strf("refusing to load from %v", src))
+	}
+
+	return chgr.Changer.Load(src, dst)
+}
+
+// setup returns a started drive with volumes of the given size, its
+// inventory and changer. The drive has a virtual st device.
+func setup(t *testing.T, size int64) (*drive.Drive, inv.Inventory, *refusing, func()) {
+	dir, err := ioutil.TempDir("", "drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invdb, err := embedded.New(map[string]string{
+		"path":            filepath.Join(dir, "inv.json"),
+		"cleaning-prefix": "CLN",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := invdb.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := fake.New(map[string]interface{}{
+		"transfer": "1",
+		"storage":  "8",
+		"ix":       "1",
+		"volumes":  "4",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := &refusing{Changer: c}
+	chgr := changer.Named("primary", rc)
+
+	if _, err := invdb.Audit(chgr, false); err != nil {
+		t.Fatal(err)
+	}
+
+	flags.EmulateDevices = true
+
+	drv, err := drive.New("drive0", tape.DriveConfig{
+		Path:    filepath.Join(dir, "dev"),
+		Changer: "primary",
+		Device:  filepath.Join(dir, "nst0"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fm := &fmtr{dir: dir, size: size, vols: make(map[tape.Serial]*volume)}
+
+	if err := drv.Start(invdb, chgr, fm); err != nil {
+		t.Fatal(err)
+	}
+
+	return drv, invdb, rc, func() { os.RemoveAll(dir) }
+}
+
+func TestRollover(t *testing.T) {
+	drv, invdb, _, cleanup := setup(t, 1000)
+	defer cleanup()
+
+	first := drv.Serial()
+
+	f, err := drv.Create("/small")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reader cannot follow the drive; it must survive both rollovers
+	rd, err := drv.Open("/small")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := drv.Create("/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("abcdefghij"), 250)
+
+	for p := data; len(p) > 0; p = p[100:] {
+		if _, err := w.Write(p[:100]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rd.Read(make([]byte, 10)); !errors.Is(errors.Transient, err) {
+		t.Errorf("read after rollover: got %v, want transient error", err)
+	}
+
+	if err := rd.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ent, err := invdb.Lookup("/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ent.Size != int64(len(data)) {
+		t.Errorf("size = %d, want %d", ent.Size, len(data))
+	}
+
+	if len(ent.Extents) != 3 {
+		t.Fatalf("got %d extents, want 3", len(ent.Extents))
+	}
+
+	var off int64
+	for i, ext := range ent.Extents {
+		if ext.Offset != off {
+			t.Errorf("extent %d: offset = %d, want %d", i, ext.Offset, off)
+		}
+
+		off += ext.Length
+	}
+
+	if off != int64(len(data)) {
+		t.Errorf("extents cover %d bytes, want %d", off, len(data))
+	}
+
+	if ent.Extents[0].Serial != first {
+		t.Errorf("first extent on %v, want %v", ent.Extents[0].Serial, first)
+	}
+
+	for _, ext := range ent.Extents[:2] {
+		vol, err := invdb.Info(ext.Serial)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if vol.Category != tape.Full {
+			t.Errorf("%v: category = %v, want %v", ext.Serial, vol.Category, tape.Full)
+		}
+	}
+}
+
+func TestRolloverShadowed(t *testing.T) {
+	drv, invdb, _, cleanup := setup(t, 1000)
+	defer cleanup()
+
+	ctx := context.Background()
+	first := drv.Serial()
+
+	f, err := drv.Create("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data of /b stays stored as /a on the first volume
+	if err := invdb.Rename("/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	vols, err := invdb.Volumes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var second tape.Serial
+	for _, vol := range vols {
+		if vol.Category == tape.Scratch {
+			second = vol.Serial
+			break
+		}
+	}
+
+	for _, to := range []tape.VolumeCategory{tape.Allocating, tape.Allocated} {
+		if err := invdb.Transition(second, to, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := drv.Load(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first volume is the preferred filling volume, but continuing /a
+	// there would overwrite the data of /b
+	w, err := drv.Create("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("abcdefghij"), 150)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ent, err := invdb.Lookup("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ent.Extents) != 2 {
+		t.Fatalf("got %d extents, want 2", len(ent.Extents))
+	}
+
+	for _, ext := range ent.Extents {
+		if ext.Serial == first {
+			t.Errorf("/a continued on %v holding the data of /b", first)
+		}
+	}
+
+	if err := drv.Load(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := drv.Open("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	got, err := ioutil.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "0123456789" {
+		t.Errorf("data of /b = %q, want %q", got, "0123456789")
+	}
+}
+
+// cartridge is the cleaning cartridge of the fake changer.
+const cartridge = "CLN000L1"
+
+func TestClean(t *testing.T) {
+	drv, invdb, _, cleanup := setup(t, 1000)
+	defer cleanup()
+
+	prev := drv.Serial()
+
+	if drv.NeedsCleaning(0) {
+		t.Fatal("drive needs cleaning before any alert")
+	}
+
+	dev := drv.Device().(*st.Virtual)
+	dev.Raise(st.Cleaning)
+
+	if err := drv.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !drv.NeedsCleaning(0) {
+		t.Fatal("cleaning alert did not request cleaning")
+	}
+
+	ctx := context.Background()
+
+	done := make(chan error)
+	go func() {
+		done <- drv.Clean(ctx, cartridge, 500*time.Millisecond)
+	}()
+
+	// the drive must not stay locked while the cleaning cycle runs
+	deadline := time.Now().Add(5 * time.Second)
+	for drv.Serial() == prev {
+		if time.Now().After(deadline) {
+			t.Fatal("cleaning did not start")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if serial := drv.Serial(); serial != "" {
+		t.Errorf("serial during cleaning = %v, want none", serial)
+	}
+
+	if err := drv.Load(ctx, prev); !errors.Is(errors.Transient, err) {
+		t.Errorf("load during cleaning: got %v, want transient error", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if serial := drv.Serial(); serial != prev {
+		t.Errorf("serial after cleaning = %v, want %v", serial, prev)
+	}
+
+	if drv.NeedsCleaning(0) {
+		t.Error("drive needs cleaning after cleaning")
+	}
+
+	// loading the volume again does not count as a mount
+	if drv.NeedsCleaning(1) {
+		t.Error("loading the volume again counted against the cleaning interval")
+	}
+
+	if err := drv.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	if drv.NeedsCleaning(0) {
+		t.Error("cleaning alert was not cleared")
+	}
+
+	vol, err := invdb.Info(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Mounts != 1 || vol.Location.Category != tape.StorageSlot {
+		t.Errorf("cartridge: mounts = %d, location = %v; want 1 use and back in storage", vol.Mounts, vol.Location)
+	}
+}
+
+func TestCleanCancel(t *testing.T) {
+	drv, invdb, _, cleanup := setup(t, 1000)
+	defer cleanup()
+
+	prev := drv.Serial()
+
+	drv.RequestCleaning()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- drv.Clean(ctx, cartridge, time.Hour)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for drv.Serial() == prev {
+		if time.Now().After(deadline) {
+			t.Fatal("cleaning did not start")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(errors.Transient, err) {
+			t.Errorf("got %v, want transient error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cleaning did not stop when canceled")
+	}
+
+	if serial := drv.Serial(); serial != prev {
+		t.Errorf("serial = %v, want %v", serial, prev)
+	}
+
+	if !drv.NeedsCleaning(0) {
+		t.Error("interrupted cleaning cleared the cleaning request")
+	}
+
+	vol, err := invdb.Info(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Location.Category != tape.StorageSlot {
+		t.Errorf("cartridge location = %v, want storage slot", vol.Location)
+	}
+}
+
+func TestCleanFailure(t *testing.T) {
+	drv, invdb, chgr, cleanup := setup(t, 1000)
+	defer cleanup()
+
+	prev := drv.Serial()
+
+	vol, err := invdb.Info(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chgr.refuse(vol.Location)
+
+	if err := drv.Clean(context.Background(), cartridge, 0); err == nil {
+		t.Fatal("cleaning succeeded with a cartridge that cannot be loaded")
+	}
+
+	// the volume mounted before is back in the drive
+	if serial := drv.Serial(); serial != prev {
+		t.Errorf("serial = %v, want %v", serial, prev)
+	}
+
+	loaded, serial, err := invdb.Loaded(tape.Location{Addr: 0, Category: tape.TransferSlot, Changer: "primary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !loaded || serial != prev {
+		t.Errorf("inventory: loaded = %v, serial = %v; want %v", loaded, serial, prev)
+	}
+
+	if vol, err = invdb.Info(cartridge); err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Location.Category != tape.StorageSlot {
+		t.Errorf("cartridge location = %v, want storage slot", vol.Location)
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drive
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+
+	"tapr.space"
+	"tapr.space/errors"
+	"tapr.space/format"
+	"tapr.space/log"
+	"tapr.space/storage"
+	"tapr.space/store/tape"
+)
+
+// file is a tapr.File opened on the volume mounted in a drive. Files opened
+// for writing follow the drive when it rolls over to a new volume,
+// continuing in a new extent.
+type file struct {
+	tapr.File
+
+	drv  *Drive
+	name tapr.PathName
+	flag int
+
+	// file offset of the extent being written
+	base int64
+}
+
+// Read implements tapr.File.
+func (f *file) Read(p []byte) (int, error) {
+	f.drv.mu.RLock()
+	defer f.drv.mu.RUnlock()
+
+	if f.File == nil {
+		return 0, errRolledOver(f.name)
+	}
+
+	return f.File.Read(p)
+}
+
+// Seek implements tapr.File.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.drv.mu.RLock()
+	defer f.drv.mu.RUnlock()
+
+	if f.File == nil {
+		return 0, errRolledOver(f.name)
+	}
+
+	return f.File.Seek(offset, whence)
+}
+
+// Write implements tapr.File. If the volume becomes full, the drive rolls
+// over to a new volume and the write is continued there.
+func (f *file) Write(p []byte) (int, error) {
+	var written int
+	for {
+		f.drv.mu.RLock()
+		if f.File == nil {
+			f.drv.mu.RUnlock()
+			return written, errRolledOver(f.name)
+		}
+
+		gen := f.drv.gen
+		n, err := f.File.Write(p)
+		f.drv.mu.RUnlock()
+
+		written += n
+
+		if !full(err) {
+			return written, err
+		}
+
+		p = p[n:]
+
+		if err := f.drv.rollover(gen); err != nil {
+			return written, err
+		}
+	}
+}
+
+// Sync implements storage.Syncer. The data written so far is flushed to the
+// volume and the extent being written is recorded in the file catalog.
+func (f *file) Sync() error {
+	f.drv.mu.Lock()
+	defer f.drv.mu.Unlock()
+
+	if f.File == nil {
+		return errRolledOver(f.name)
+	}
+
+	if err := storage.Sync(f.File); err != nil {
+		return err
+	}
+
+	if !writable(f.flag) {
+		return nil
+	}
+
+	_, err := f.drv.record(f)
+
+	return err
+}
+
+// Truncate implements storage.Truncater. Only the extent being written can
+// be truncated; the catalog records its new length.
+func (f *file) Truncate(size int64) error {
+	f.drv.mu.Lock()
+	defer f.drv.mu.Unlock()
+
+	if f.File == nil {
+		return errRolledOver(f.name)
+	}
+
+	if size < f.base {
+		return errors.E(f.name, errors.Invalid, errors.Strf("cannot truncate the extents before offset %d", f.base))
+	}
+
+	if err := storage.Truncate(f.File, size-f.base); err != nil {
+		return err
+	}
+
+	_, err := f.drv.record(f)
+
+	return err
+}
+
+// Close implements tapr.File. Closing a file opened for writing records the
+// final length of the extent being written and updates the file catalog.
+func (f *file) Close() error {
+	f.drv.mu.Lock()
+	defer f.drv.mu.Unlock()
+
+	delete(f.drv.files, f)
+
+	if f.File == nil {
+		return nil
+	}
+
+	if !writable(f.flag) {
+		return f.File.Close()
+	}
+
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+
+	if err := f.drv.seal(f); err != nil {
+		return err
+	}
+
+	return f.drv.commit(f)
+}
+
+// seal records the current length of the extent being written to the file.
+// drv.mu MUST be held.
+func (drv *Drive) seal(f *file) error {
+	ext, err := drv.record(f)
+	if err != nil {
+		return err
+	}
+
+	f.base += ext.Length
+
+	return nil
+}
+
+// record records the extent being written to the file in the catalog with
+// its current length. drv.mu MUST be held.
+func (drv *Drive) record(f *file) (tape.Extent, error) {
+	fi, err := drv.stg.Stat(f.name)
+	if err != nil {
+		return tape.Extent{}, err
+	}
+
+	ext := tape.Extent{
+		Serial: drv.serial,
+		Offset: f.base,
+		Length: fi.Size(),
+	}
+
+	if pos, ok := drv.stg.(format.Positioner); ok {
+		if ext.Position, err = pos.Position(f.name); err != nil {
+			log.Error.Printf("drive/Drive.record: %s: could not get position: %v", f.name, err)
+		}
+	}
+
+	if err := drv.invdb.Extend(f.name, ext); err != nil {
+		return tape.Extent{}, err
+	}
+
+	return ext, nil
+}
+
+// commit updates the catalog entry of a file that has been written. drv.mu
+// MUST be held.
+func (drv *Drive) commit(f *file) error {
+	fi, err := drv.stg.Stat(f.name)
+	if err != nil {
+		return err
+	}
+
+	return drv.invdb.Commit(tape.File{
+		Path:    f.name,
+		Size:    f.base,
+		ModTime: fi.ModTime(),
+	})
+}
+
+// rollover marks the mounted volume full and replaces it with a newly
+// allocated volume. Files being written are split; the data already written
+// remains as an extent on the full volume and the files continue in a new
+// extent on the new volume. If another writer already rolled the drive over
+// since gen was observed, rollover does nothing.
+func (drv *Drive) rollover(gen int) error {
+	op := fmt.Sprintf("drive/Drive.rollover[%s (slot %d) (path %s)]", drv.name, drv.loc.Addr, drv.devpath)
+
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+
+	if drv.gen != gen {
+		return nil
+	}
+
+	log.Info.Printf("%s: volume %v is full; rolling over", op, drv.serial)
+
+	// seal the extents of the files being written; files open for reading
+	// cannot follow the drive and are detached from it.
+	var split []*file
+	for f := range drv.files {
+		if f.File == nil {
+			continue
+		}
+
+		if writable(f.flag) {
+			if err := drv.seal(f); err != nil {
+				return errors.E(op, f.name, err)
+			}
+
+			split = append(split, f)
+		} else {
+			delete(drv.files, f)
+		}
+
+		f.File.Close()
+		f.File = nil
+	}
+
+	if err := drv.invdb.Transition(drv.serial, tape.Full, "end of tape in "+drv.name); err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := drv.unload(context.Background()); err != nil {
+		return errors.E(op, err)
+	}
+
+	// the files must not be continued on a volume where their path names
+	// hold the data of renamed files
+	names := make([]tapr.PathName, len(split))
+	for i, f := range split {
+		names[i] = f.name
+	}
+
+	serial, err := drv.invdb.Alloc(drv.loc.Changer, names...)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := drv.load(context.Background(), serial); err != nil {
+		return errors.E(op, err)
+	}
+
+	drv.gen++
+
+	log.Info.Printf("%s: rolled over to %v; continuing %d file(s)", op, serial, len(split))
+
+	for _, f := range split {
+		if err := drv.continueFile(f); err != nil {
+			return errors.E(op, f.name, err)
+		}
+	}
+
+	return nil
+}
+
+// continueFile opens a new extent of the file on the mounted volume. The
+// extent is recorded before the file is created on the volume, such that
+// the data of a renamed file stored under the same name is never truncated.
+// drv.mu MUST be held.
+func (drv *Drive) continueFile(f *file) error {
+	if err := drv.stg.MkdirAll(tapr.PathName(path.Dir(string(f.name)))); err != nil {
+		return err
+	}
+
+	ext := tape.Extent{
+		Serial: drv.serial,
+		Offset: f.base,
+	}
+
+	if err := drv.invdb.Extend(f.name, ext); err != nil {
+		return err
+	}
+
+	flag := (f.flag | os.O_CREATE | os.O_TRUNC) &^ os.O_EXCL
+
+	nf, err := drv.stg.OpenFile(f.name, flag)
+	if err != nil {
+		return err
+	}
+
+	f.File = nf
+
+	return nil
+}
+
+// full reports whether err signals that the end of the medium was reached.
+func full(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+
+	return err == syscall.ENOSPC
+}
+
+func errNotMounted(name tapr.PathName) error {
+	return errors.E(name, errors.Transient, errors.Str("no volume mounted"))
+}
+
+func errRolledOver(name tapr.PathName) error {
+	return errors.E(name, errors.Transient, errors.Str("volume was rolled over"))
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package st controls tape drives through the Linux SCSI tape (st) driver.
+// A file-backed virtual drive is provided for testing without a drive.
+package st // import "tapr.space/store/tape/drive/st"
+
+import (
+	"io"
+	"strings"
+)
+
+// A Device is a tape drive. Reads and writes transfer whole blocks; a read
+// at a filemark returns io.EOF and positions the tape after the filemark.
+// A Device MUST NOT be used concurrently.
+type Device interface {
+	io.ReadWriteCloser
+
+	// Rewind rewinds the tape to the beginning.
+	Rewind() error
+
+	// Locate positions the tape at the beginning of the given file, that
+	// is, after the given number of filemarks from the beginning of the
+	// tape.
+	Locate(file int) error
+
+	// Space spaces over count filemarks. A positive count moves forward
+	// and positions the tape after the last filemark; a negative count
+	// moves backward and positions the tape before the last filemark.
+	Space(count int) error
+
+	// Position returns the current position of the tape.
+	Position() (Position, error)
+
+	// WriteFilemarks writes count filemarks at the current position.
+	WriteFilemarks(count int) error
+
+	// Eject rewinds and unloads the tape.
+	Eject() error
+
+	// Load loads the tape and positions it at the beginning.
+	Load() error
+
+	// SetBlockSize sets the block size. A size of zero selects variable
+	// block mode.
+	SetBlockSize(size int) error
+
+	// Status returns the status of the drive.
+	Status() (Status, error)
+}
+
+// Position is the position of a tape.
+type Position struct {
+	// Block is the logical block number counted from the beginning of the
+	// tape. Filemarks count as blocks.
+	Block int64
+
+	// File is the number of filemarks passed since the beginning of the
+	// tape.
+	File int
+}
+
+// Flags are the status flags of a drive.
+type Flags uint32
+
+// Status flags as reported by MTIOCGET.
+const (
+	EOF            Flags = 0x80000000 // positioned just after a filemark
+	BOT            Flags = 0x40000000 // at the beginning of the tape
+	EOT            Flags = 0x20000000 // at the end of the tape
+	EOD            Flags = 0x08000000 // at the end of the recorded data
+	WriteProtected Flags = 0x04000000 // the tape is write protected
+	Online         Flags = 0x01000000 // a tape is loaded and ready
+	DoorOpen       Flags = 0x00040000 // no tape is loaded
+	Cleaning       Flags = 0x00008000 // the drive requests cleaning
+)
+
+var flagNames = []struct {
+	flag Flags
+	name string
+}{
+	{EOF, "eof"},
+	{BOT, "bot"},
+	{EOT, "eot"},
+	{EOD, "eod"},
+	{WriteProtected, "write-protected"},
+	{Online, "online"},
+	{DoorOpen, "door-open"},
+	{Cleaning, "cleaning"},
+}
+
+func (f Flags) String() string {
+	var names []string
+	for _, fn := range flagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
+
+// Status is the status of a drive.
+type Status struct {
+	// File is the current file number and Block is the block number within
+	// the file. They are -1 if unknown.
+	File, Block int
+
+	// BlockSize is the block size; zero means variable block mode.
+	BlockSize int
+
+	// Density is the density code of the tape.
+	Density int
+
+	// Flags holds the status flags.
+	Flags Flags
+
+	// Errors is the drive error register.
+	Errors int64
+}
+
+// Alerts returns the flags that need attention from an operator or the
+// service.
+func (s Status) Alerts() Flags {
+	return s.Flags & (WriteProtected | DoorOpen | Cleaning)
+}
+
+// block size and density fields of mt_dsreg
+const (
+	blockSizeMask = 0xffffff
+	densityShift  = 24
+	densityMask   = 0xff
+)
+
+// status decodes the fields returned by MTIOCGET.
+func status(dsreg, gstat, erreg int64, fileno, blkno int32) Status {
+	return Status{
+		File:      int(fileno),
+		Block:     int(blkno),
+		BlockSize: int(dsreg & blockSizeMask),
+		Density:   int((dsreg >> densityShift) & densityMask),
+		Flags:     Flags(uint32(gstat)),
+		Errors:    erreg,
+	}
+}
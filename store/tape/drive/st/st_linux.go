@@ -0,0 +1,198 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package st
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"tapr.space/errors"
+)
+
+// mtop mirrors struct mtop from <sys/mtio.h>.
+type mtop struct {
+	op    int16
+	_     int16
+	count int32
+}
+
+// mtget mirrors struct mtget from <sys/mtio.h>. The size of a C long
+// matches the size of an int on Linux.
+type mtget struct {
+	typ    int
+	resid  int
+	dsreg  int
+	gstat  int
+	erreg  int
+	fileno int32
+	blkno  int32
+}
+
+// mtpos mirrors struct mtpos from <sys/mtio.h>.
+type mtpos struct {
+	blkno int
+}
+
+// ioc encodes an ioctl request number like the _IOC macro.
+func ioc(dir, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 'm'<<8 | nr
+}
+
+// magnetic tape ioctl requests
+var (
+	mtioctop = ioc(1, 1, unsafe.Sizeof(mtop{}))
+	mtiocget = ioc(2, 2, unsafe.Sizeof(mtget{}))
+	mtiocpos = ioc(2, 3, unsafe.Sizeof(mtpos{}))
+)
+
+// magnetic tape operations
+const (
+	mtFSF    = 1  // forward space over filemarks
+	mtBSF    = 2  // backward space over filemarks
+	mtWEOF   = 5  // write filemarks
+	mtREW    = 6  // rewind
+	mtOFFL   = 7  // rewind and unload
+	mtSETBLK = 20 // set block size
+	mtLOAD   = 30 // load
+)
+
+// maxCount is the largest count of a magnetic tape operation.
+const maxCount = 1<<31 - 1
+
+type device struct {
+	f *os.File
+}
+
+// Open opens the tape device at path. The non-rewinding device (e.g.
+// /dev/nst0) should be used so the tape is not rewound on close.
+func Open(path string) (Device, error) {
+	const op = "drive/st.Open"
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return &device{f: f}, nil
+}
+
+func (dev *device) ioctl(req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dev.f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// do performs a magnetic tape operation.
+func (dev *device) do(name string, op int16, count int) error {
+	if count < 0 || count > maxCount {
+		return errors.E(errors.Invalid, errors.Strf("%s: invalid count %d", name, count))
+	}
+
+	arg := mtop{op: op, count: int32(count)}
+	if err := dev.ioctl(mtioctop, unsafe.Pointer(&arg)); err != nil {
+		return errors.E(errors.IO, errors.Strf("%s: %v", name, err))
+	}
+
+	return nil
+}
+
+func (dev *device) Read(p []byte) (int, error) {
+	n, err := dev.f.Read(p)
+	if n == 0 && err == nil {
+		// the st driver returns a zero length read at a filemark
+		return 0, io.EOF
+	}
+
+	return n, err
+}
+
+func (dev *device) Write(p []byte) (int, error) {
+	return dev.f.Write(p)
+}
+
+func (dev *device) Close() error {
+	return dev.f.Close()
+}
+
+func (dev *device) Rewind() error {
+	return dev.do("rewind", mtREW, 1)
+}
+
+func (dev *device) Locate(file int) error {
+	if err := dev.Rewind(); err != nil {
+		return err
+	}
+
+	if file == 0 {
+		return nil
+	}
+
+	return dev.do("locate", mtFSF, file)
+}
+
+func (dev *device) Space(count int) error {
+	switch {
+	case count > 0:
+		return dev.do("space", mtFSF, count)
+	case count < 0:
+		return dev.do("space", mtBSF, -count)
+	}
+
+	return nil
+}
+
+func (dev *device) Position() (Position, error) {
+	var pos mtpos
+	if err := dev.ioctl(mtiocpos, unsafe.Pointer(&pos)); err != nil {
+		return Position{}, errors.E(errors.IO, errors.Strf("read position: %v", err))
+	}
+
+	st, err := dev.Status()
+	if err != nil {
+		return Position{}, err
+	}
+
+	return Position{Block: int64(pos.blkno), File: st.File}, nil
+}
+
+func (dev *device) WriteFilemarks(count int) error {
+	return dev.do("write filemarks", mtWEOF, count)
+}
+
+func (dev *device) Eject() error {
+	return dev.do("eject", mtOFFL, 1)
+}
+
+func (dev *device) Load() error {
+	return dev.do("load", mtLOAD, 1)
+}
+
+func (dev *device) SetBlockSize(size int) error {
+	return dev.do("set block size", mtSETBLK, size)
+}
+
+func (dev *device) Status() (Status, error) {
+	var get mtget
+	if err := dev.ioctl(mtiocget, unsafe.Pointer(&get)); err != nil {
+		return Status{}, errors.E(errors.IO, errors.Strf("get status: %v", err))
+	}
+
+	return status(int64(get.dsreg), int64(get.gstat), int64(get.erreg), get.fileno, get.blkno), nil
+}
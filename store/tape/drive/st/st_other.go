@@ -0,0 +1,26 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package st
+
+import "tapr.space/errors"
+
+// Open opens the tape device at path. Tape devices are only supported on
+// Linux.
+func Open(path string) (Device, error) {
+	return nil, errors.E(errors.Invalid, errors.Str("tape devices are only supported on Linux"))
+}
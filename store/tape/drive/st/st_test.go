@@ -0,0 +1,344 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package st
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tapr.space/errors"
+)
+
+func open(t *testing.T) (*Virtual, string, func()) {
+	dir, err := ioutil.TempDir("", "st")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "tape.img")
+
+	dev, err := OpenVirtual(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dev, path, func() {
+		dev.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// write writes the given files, each a number of blocks terminated by a
+// filemark.
+func write(t *testing.T, dev Device, files ...[]string) {
+	for _, blocks := range files {
+		for _, b := range blocks {
+			if _, err := dev.Write([]byte(b)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := dev.WriteFilemarks(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// read reads blocks until a filemark.
+func read(t *testing.T, dev Device) []string {
+	var blocks []string
+
+	buf := make([]byte, 64)
+	for {
+		n, err := dev.Read(buf)
+		if err == io.EOF {
+			return blocks
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blocks = append(blocks, string(buf[:n]))
+	}
+}
+
+func TestVirtualReadWrite(t *testing.T) {
+	dev, _, cleanup := open(t)
+	defer cleanup()
+
+	write(t, dev, []string{"a", "bb"}, []string{"ccc"}, []string{"dddd", "e", "f"})
+
+	if err := dev.Rewind(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range [][]string{{"a", "bb"}, {"ccc"}, {"dddd", "e", "f"}} {
+		got := read(t, dev)
+		if len(got) != len(want) {
+			t.Fatalf("file %d: got %v, want %v", i, got, want)
+		}
+
+		for j := range want {
+			if got[j] != want[j] {
+				t.Fatalf("file %d: got %v, want %v", i, got, want)
+			}
+		}
+	}
+
+	st, err := dev.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Flags&EOD == 0 || st.File != 3 || st.Block != 0 {
+		t.Errorf("unexpected status at end of data: %+v (%v)", st, st.Flags)
+	}
+}
+
+func TestVirtualPositioning(t *testing.T) {
+	dev, _, cleanup := open(t)
+	defer cleanup()
+
+	write(t, dev, []string{"a", "bb"}, []string{"ccc"}, []string{"dddd", "e"})
+
+	if err := dev.Locate(2); err != nil {
+		t.Fatal(err)
+	}
+
+	pos, err := dev.Position()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pos != (Position{Block: 5, File: 2}) {
+		t.Errorf("got position %+v after locate", pos)
+	}
+
+	if got := read(t, dev); len(got) != 2 || got[0] != "dddd" {
+		t.Errorf("got %v after locate", got)
+	}
+
+	// back over the filemark ending the last file and the one before it
+	if err := dev.Space(-2); err != nil {
+		t.Fatal(err)
+	}
+
+	pos, err = dev.Position()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pos != (Position{Block: 4, File: 1}) {
+		t.Errorf("got position %+v after spacing backward", pos)
+	}
+
+	if err := dev.Space(1); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := dev.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Flags&EOF == 0 || st.File != 2 {
+		t.Errorf("unexpected status after spacing forward: %+v (%v)", st, st.Flags)
+	}
+
+	if err := dev.Locate(4); !errors.Is(errors.IO, err) {
+		t.Errorf("expected i/o error locating past the end of data, got %v", err)
+	}
+
+	if err := dev.Rewind(); err != nil {
+		t.Fatal(err)
+	}
+
+	if st, _ := dev.Status(); st.Flags&BOT == 0 {
+		t.Errorf("expected tape at beginning after rewind, got %v", st.Flags)
+	}
+}
+
+func TestVirtualOverwrite(t *testing.T) {
+	dev, path, cleanup := open(t)
+	defer cleanup()
+
+	write(t, dev, []string{"a"}, []string{"b"}, []string{"c"})
+
+	// writing erases everything after the head
+	if err := dev.Locate(1); err != nil {
+		t.Fatal(err)
+	}
+
+	write(t, dev, []string{"x", "y"})
+
+	if err := dev.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the image survives reopening
+	dev, err := OpenVirtual(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dev.Close()
+
+	if err := dev.Locate(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(t, dev); len(got) != 2 || got[0] != "x" || got[1] != "y" {
+		t.Errorf("got %v after overwrite", got)
+	}
+
+	if err := dev.Space(1); err == nil {
+		t.Error("expected the old files to be erased")
+	}
+}
+
+func TestVirtualTruncatedImage(t *testing.T) {
+	dev, path, cleanup := open(t)
+	defer cleanup()
+
+	write(t, dev, []string{"a"})
+	dev.Close()
+
+	// a record cut short by a crash
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte{blockRecord, 0, 0, 0, 9, 'x'})
+	f.Close()
+
+	dev, err = OpenVirtual(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dev.Close()
+
+	if len(dev.recs) != 2 {
+		t.Errorf("got %d records, want the incomplete record discarded", len(dev.recs))
+	}
+}
+
+func TestVirtualFixedBlocks(t *testing.T) {
+	dev, _, cleanup := open(t)
+	defer cleanup()
+
+	if err := dev.SetBlockSize(4); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dev.Write([]byte("abc")); !errors.Is(errors.Invalid, err) {
+		t.Errorf("expected partial block to be rejected, got %v", err)
+	}
+
+	if _, err := dev.Write([]byte("abcdefghijkl")); err != nil {
+		t.Fatal(err)
+	}
+
+	pos, err := dev.Position()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pos.Block != 3 {
+		t.Errorf("got block %d, want 3", pos.Block)
+	}
+
+	dev.Rewind()
+
+	buf := make([]byte, 8)
+	if n, err := dev.Read(buf); err != nil || string(buf[:n]) != "abcdefgh" {
+		t.Errorf("got %q, %v", buf[:n], err)
+	}
+}
+
+func TestVirtualEjectLoad(t *testing.T) {
+	dev, _, cleanup := open(t)
+	defer cleanup()
+
+	write(t, dev, []string{"a"})
+
+	if err := dev.Eject(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := dev.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Flags&DoorOpen == 0 || st.Flags&Online != 0 {
+		t.Errorf("got flags %v after eject", st.Flags)
+	}
+
+	if _, err := dev.Read(make([]byte, 8)); err == nil {
+		t.Error("expected read without a tape to fail")
+	}
+
+	if err := dev.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(t, dev); len(got) != 1 || got[0] != "a" {
+		t.Errorf("got %v after load", got)
+	}
+}
+
+func TestVirtualAlerts(t *testing.T) {
+	dev, _, cleanup := open(t)
+	defer cleanup()
+
+	dev.Raise(Cleaning | WriteProtected)
+
+	st, err := dev.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Alerts() != Cleaning|WriteProtected {
+		t.Errorf("got alerts %v", st.Alerts())
+	}
+
+	if _, err := dev.Write([]byte("a")); !errors.Is(errors.Permission, err) {
+		t.Errorf("expected write to a protected tape to fail, got %v", err)
+	}
+
+	dev.Clear(WriteProtected)
+
+	if _, err := dev.Write([]byte("a")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	st := status(0x42000400, int64(BOT|Online|Cleaning), 0, 0, 0)
+
+	if st.BlockSize != 1024 || st.Density != 0x42 {
+		t.Errorf("got block size %d and density %#x", st.BlockSize, st.Density)
+	}
+
+	if st.Flags.String() != "bot,online,cleaning" || st.Alerts() != Cleaning {
+		t.Errorf("got flags %v and alerts %v", st.Flags, st.Alerts())
+	}
+}
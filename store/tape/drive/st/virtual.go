@@ -0,0 +1,427 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package st
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+
+	"tapr.space/errors"
+)
+
+// kinds of records in a virtual tape image
+const (
+	blockRecord    byte = 'B'
+	filemarkRecord byte = 'F'
+)
+
+// recordHeaderSize is the size of the kind and length preceding each record.
+const recordHeaderSize = 5
+
+type record struct {
+	off  int64
+	size int
+	mark bool
+}
+
+// Virtual is a Device backed by a file holding a tape image. The image is a
+// sequence of records, each a kind byte and a big endian 32 bit length
+// followed by the data of a block. Filemarks are records of zero length.
+type Virtual struct {
+	f *os.File
+
+	recs []record
+
+	// pos is the index of the record at the tape head
+	pos int
+
+	blockSize int
+	loaded    bool
+
+	// alerts raised by Raise
+	alerts Flags
+}
+
+var _ Device = (*Virtual)(nil)
+
+// OpenVirtual opens the tape image at path as a loaded tape, creating it if
+// it does not exist. An incomplete record at the end of the image, as left
+// by a crash, is discarded.
+func OpenVirtual(path string) (*Virtual, error) {
+	const op = "drive/st.OpenVirtual"
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	dev := &Virtual{f: f, loaded: true}
+
+	if err := dev.index(); err != nil {
+		f.Close()
+		return nil, errors.E(op, err)
+	}
+
+	return dev, nil
+}
+
+// index reads the record headers of the image.
+func (dev *Virtual) index() error {
+	fi, err := dev.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var (
+		off int64
+		hdr [recordHeaderSize]byte
+	)
+
+	for off+recordHeaderSize <= fi.Size() {
+		if _, err := dev.f.ReadAt(hdr[:], off); err != nil {
+			return err
+		}
+
+		size := int(binary.BigEndian.Uint32(hdr[1:]))
+		if off+recordHeaderSize+int64(size) > fi.Size() {
+			break
+		}
+
+		switch hdr[0] {
+		case blockRecord, filemarkRecord:
+		default:
+			return errors.E(errors.Invalid, errors.Strf("invalid record at offset %d", off))
+		}
+
+		dev.recs = append(dev.recs, record{
+			off:  off + recordHeaderSize,
+			size: size,
+			mark: hdr[0] == filemarkRecord,
+		})
+
+		off += recordHeaderSize + int64(size)
+	}
+
+	return dev.f.Truncate(off)
+}
+
+// Raise raises the given alert flags, for instance to have the drive
+// request cleaning.
+func (dev *Virtual) Raise(f Flags) {
+	dev.alerts |= f
+}
+
+// Clear clears the given alert flags.
+func (dev *Virtual) Clear(f Flags) {
+	dev.alerts &^= f
+}
+
+// ready returns an error if no tape is loaded.
+func (dev *Virtual) ready(name string) error {
+	if !dev.loaded {
+		return errors.E(errors.IO, errors.Strf("%s: no tape loaded", name))
+	}
+
+	return nil
+}
+
+// end returns the offset of the end of the record before the tape head.
+func (dev *Virtual) end() int64 {
+	if dev.pos == 0 {
+		return 0
+	}
+
+	r := dev.recs[dev.pos-1]
+
+	return r.off + int64(r.size)
+}
+
+// append writes a record at the tape head, erasing everything after it.
+func (dev *Virtual) append(kind byte, data []byte) error {
+	if dev.alerts&WriteProtected != 0 {
+		return errors.E(errors.Permission, errors.Str("tape is write protected"))
+	}
+
+	off := dev.end()
+
+	buf := make([]byte, recordHeaderSize+len(data))
+	buf[0] = kind
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(data)))
+	copy(buf[recordHeaderSize:], data)
+
+	if err := dev.f.Truncate(off); err != nil {
+		return err
+	}
+
+	if _, err := dev.f.WriteAt(buf, off); err != nil {
+		return err
+	}
+
+	dev.recs = append(dev.recs[:dev.pos], record{
+		off:  off + recordHeaderSize,
+		size: len(data),
+		mark: kind == filemarkRecord,
+	})
+
+	dev.pos++
+
+	return nil
+}
+
+// Read implements Device.
+func (dev *Virtual) Read(p []byte) (int, error) {
+	if err := dev.ready("read"); err != nil {
+		return 0, err
+	}
+
+	if dev.pos == len(dev.recs) {
+		return 0, io.EOF
+	}
+
+	if dev.recs[dev.pos].mark {
+		dev.pos++
+		return 0, io.EOF
+	}
+
+	if dev.blockSize == 0 {
+		r := dev.recs[dev.pos]
+		if len(p) < r.size {
+			return 0, errors.E(errors.Invalid, errors.Strf("read: buffer of %d bytes too small for block of %d bytes", len(p), r.size))
+		}
+
+		n, err := dev.f.ReadAt(p[:r.size], r.off)
+		if err != nil {
+			return n, err
+		}
+
+		dev.pos++
+
+		return n, nil
+	}
+
+	if len(p) < dev.blockSize {
+		return 0, errors.E(errors.Invalid, errors.Strf("read: buffer of %d bytes too small for block size %d", len(p), dev.blockSize))
+	}
+
+	// read whole blocks until the buffer is full or a filemark is reached
+	var n int
+	for n+dev.blockSize <= len(p) && dev.pos < len(dev.recs) && !dev.recs[dev.pos].mark {
+		r := dev.recs[dev.pos]
+		if r.size != dev.blockSize {
+			return n, errors.E(errors.IO, errors.Strf("read: block of %d bytes in fixed block mode of size %d", r.size, dev.blockSize))
+		}
+
+		if _, err := dev.f.ReadAt(p[n:n+r.size], r.off); err != nil {
+			return n, err
+		}
+
+		n += r.size
+		dev.pos++
+	}
+
+	return n, nil
+}
+
+// Write implements Device. In variable block mode, each write is a block.
+// In fixed block mode, p must hold a whole number of blocks.
+func (dev *Virtual) Write(p []byte) (int, error) {
+	if err := dev.ready("write"); err != nil {
+		return 0, err
+	}
+
+	if dev.blockSize == 0 {
+		if err := dev.append(blockRecord, p); err != nil {
+			return 0, err
+		}
+
+		return len(p), nil
+	}
+
+	if len(p)%dev.blockSize != 0 {
+		return 0, errors.E(errors.Invalid, errors.Strf("write: %d bytes is not a multiple of the block size %d", len(p), dev.blockSize))
+	}
+
+	for n := 0; n < len(p); n += dev.blockSize {
+		if err := dev.append(blockRecord, p[n:n+dev.blockSize]); err != nil {
+			return n, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close implements Device.
+func (dev *Virtual) Close() error {
+	return dev.f.Close()
+}
+
+// Rewind implements Device.
+func (dev *Virtual) Rewind() error {
+	if err := dev.ready("rewind"); err != nil {
+		return err
+	}
+
+	dev.pos = 0
+
+	return nil
+}
+
+// Locate implements Device.
+func (dev *Virtual) Locate(file int) error {
+	if err := dev.Rewind(); err != nil {
+		return err
+	}
+
+	return dev.Space(file)
+}
+
+// Space implements Device. Spacing past the end of the data or the
+// beginning of the tape leaves the tape there and returns an error.
+func (dev *Virtual) Space(count int) error {
+	if err := dev.ready("space"); err != nil {
+		return err
+	}
+
+	for ; count > 0; count-- {
+		for {
+			if dev.pos == len(dev.recs) {
+				return errors.E(errors.IO, errors.Str("space: end of data"))
+			}
+
+			dev.pos++
+
+			if dev.recs[dev.pos-1].mark {
+				break
+			}
+		}
+	}
+
+	for ; count < 0; count++ {
+		for {
+			if dev.pos == 0 {
+				return errors.E(errors.IO, errors.Str("space: beginning of tape"))
+			}
+
+			dev.pos--
+
+			if dev.recs[dev.pos].mark {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Position implements Device.
+func (dev *Virtual) Position() (Position, error) {
+	if err := dev.ready("read position"); err != nil {
+		return Position{}, err
+	}
+
+	pos := Position{Block: int64(dev.pos)}
+	for _, r := range dev.recs[:dev.pos] {
+		if r.mark {
+			pos.File++
+		}
+	}
+
+	return pos, nil
+}
+
+// WriteFilemarks implements Device.
+func (dev *Virtual) WriteFilemarks(count int) error {
+	if err := dev.ready("write filemarks"); err != nil {
+		return err
+	}
+
+	for ; count > 0; count-- {
+		if err := dev.append(filemarkRecord, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Eject implements Device.
+func (dev *Virtual) Eject() error {
+	if err := dev.ready("eject"); err != nil {
+		return err
+	}
+
+	dev.loaded, dev.pos = false, 0
+
+	return nil
+}
+
+// Load implements Device.
+func (dev *Virtual) Load() error {
+	dev.loaded, dev.pos = true, 0
+
+	return nil
+}
+
+// SetBlockSize implements Device.
+func (dev *Virtual) SetBlockSize(size int) error {
+	if size < 0 {
+		return errors.E(errors.Invalid, errors.Strf("set block size: invalid size %d", size))
+	}
+
+	dev.blockSize = size
+
+	return nil
+}
+
+// Status implements Device.
+func (dev *Virtual) Status() (Status, error) {
+	st := Status{
+		File:      -1,
+		Block:     -1,
+		BlockSize: dev.blockSize,
+		Flags:     dev.alerts,
+	}
+
+	if !dev.loaded {
+		st.Flags |= DoorOpen
+		return st, nil
+	}
+
+	st.Flags |= Online
+	st.File, st.Block = 0, 0
+
+	for _, r := range dev.recs[:dev.pos] {
+		if r.mark {
+			st.File++
+			st.Block = 0
+		} else {
+			st.Block++
+		}
+	}
+
+	switch {
+	case dev.pos == 0:
+		st.Flags |= BOT
+	case dev.recs[dev.pos-1].mark:
+		st.Flags |= EOF
+	}
+
+	if dev.pos == len(dev.recs) {
+		st.Flags |= EOD
+	}
+
+	return st, nil
+}
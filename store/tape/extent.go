@@ -0,0 +1,87 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tape
+
+import (
+	"fmt"
+
+	"tapr.space"
+)
+
+// An Extent is a contiguous part of a file stored on a single volume. A file
+// that spans multiple volumes is stored as an ordered list of extents, each
+// stored on its volume under the path name of the file (or under the name
+// recorded in the extent if the file has since been renamed).
+type Extent struct {
+	// Serial is the serial of the volume holding the extent.
+	Serial Serial
+
+	// Offset is the offset of the extent within the file.
+	Offset int64
+
+	// Length is the number of bytes in the extent.
+	Length int64
+
+	// Position is the logical block position of the extent on the volume,
+	// or zero if the format does not report it.
+	Position int64
+
+	// Name is the path name of the extent data on the volume if it differs
+	// from the path name of the file, which is the case for renamed files.
+	Name tapr.PathName
+}
+
+// Path returns the path name of the extent data on the volume, given the
+// path name of the file.
+func (ext Extent) Path(name tapr.PathName) tapr.PathName {
+	if ext.Name != "" {
+		return ext.Name
+	}
+
+	return name
+}
+
+func (ext Extent) String() string {
+	return fmt.Sprintf("[%v %d+%d]", ext.Serial, ext.Offset, ext.Length)
+}
+
+// Extents is a list of extents ordered by offset.
+type Extents []Extent
+
+// Size returns the total size of the file described by the extents.
+func (exts Extents) Size() int64 {
+	if len(exts) == 0 {
+		return 0
+	}
+
+	last := exts[len(exts)-1]
+
+	return last.Offset + last.Length
+}
+
+// Find returns the index of the extent holding the given file offset.
+// Offsets beyond the end of the file belong to the last extent.
+func (exts Extents) Find(offset int64) int {
+	i := 0
+	for j, ext := range exts {
+		if ext.Offset > offset {
+			break
+		}
+
+		i = j
+	}
+
+	return i
+}
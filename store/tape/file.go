@@ -0,0 +1,42 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tape
+
+import (
+	"time"
+
+	"tapr.space"
+)
+
+// A File is an entry in the file catalog of a tape store.
+type File struct {
+	// Path is the path name of the file.
+	Path tapr.PathName
+
+	// Size is the size of the file in bytes.
+	Size int64
+
+	// ModTime is the time the file was last written.
+	ModTime time.Time
+
+	// Checksum is the SHA-256 digest of the file contents (if known).
+	Checksum []byte
+
+	// Dataset is the id of the dataset the file belongs to (zero if none).
+	Dataset int
+
+	// Extents are the extents of the file, ordered by offset.
+	Extents Extents
+}
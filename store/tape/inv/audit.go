@@ -0,0 +1,169 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inv
+
+import (
+	"sort"
+	"strings"
+
+	"tapr.space/bitmask"
+	"tapr.space/store/tape"
+)
+
+// A Difference is a volume whose recorded location differs from the
+// location observed by an audit.
+type Difference struct {
+	Serial tape.Serial
+
+	// From is the location recorded in the inventory. It is the zero
+	// Location for volumes new to the inventory.
+	From tape.Location
+
+	// To is the location observed in the changer. It is the zero Location
+	// for volumes no longer seen by the changer.
+	To tape.Location
+}
+
+// An AuditResult holds the differences between the inventory and the
+// physical state of a changer.
+type AuditResult struct {
+	// Changer is the name of the audited changer.
+	Changer string
+
+	// New holds the volumes unknown to the inventory.
+	New []Difference
+
+	// Moved holds the volumes found in another slot than recorded.
+	Moved []Difference
+
+	// Mounted holds the volumes unexpectedly found in a drive.
+	Mounted []Difference
+
+	// Missing holds the volumes recorded in the changer, but not found.
+	Missing []Difference
+
+	// Removed holds the offsite volumes that have been taken out of the
+	// import/export slots.
+	Removed []Difference
+}
+
+// Empty returns true if the audit found no differences.
+func (res AuditResult) Empty() bool {
+	return len(res.New)+len(res.Moved)+len(res.Mounted)+len(res.Missing)+len(res.Removed) == 0
+}
+
+// Diff compares the volumes recorded in the inventory with the slots
+// reported by the named changer. Only volumes recorded as located in the
+// named changer may be reported missing; volumes in transit are ignored.
+// Volumes being transferred (flagged with tape.StatusTransfering) are
+// never reported, since the move in progress (or its recovery) owns their
+// location.
+func Diff(changer string, vols []tape.Volume, slots map[tape.SlotCategory]tape.Slots) AuditResult {
+	res := AuditResult{Changer: changer}
+
+	known := make(map[tape.Serial]tape.Volume, len(vols))
+	for _, vol := range vols {
+		known[vol.Serial] = vol
+	}
+
+	seen := make(map[tape.Serial]bool)
+	for _, cat := range tape.SlotCategories {
+		for _, slot := range slots[cat] {
+			if slot.Volume == nil {
+				continue
+			}
+
+			serial := slot.Volume.Serial
+			seen[serial] = true
+
+			vol, ok := known[serial]
+			if !ok {
+				res.New = append(res.New, Difference{Serial: serial, To: slot.Location})
+				continue
+			}
+
+			if vol.Location == slot.Location || bitmask.IsSet(vol.Flags, tape.StatusTransfering) {
+				continue
+			}
+
+			diff := Difference{Serial: serial, From: vol.Location, To: slot.Location}
+			if slot.Category == tape.TransferSlot {
+				res.Mounted = append(res.Mounted, diff)
+			} else {
+				res.Moved = append(res.Moved, diff)
+			}
+		}
+	}
+
+	for _, vol := range vols {
+		if seen[vol.Serial] || vol.Location.Changer != changer || vol.Location == (tape.Location{}) {
+			continue
+		}
+
+		if bitmask.IsSet(vol.Flags, tape.StatusTransfering) {
+			continue
+		}
+
+		diff := Difference{Serial: vol.Serial, From: vol.Location}
+
+		if bitmask.IsSet(vol.Flags, tape.StatusOffsite) {
+			res.Removed = append(res.Removed, diff)
+			continue
+		}
+
+		if vol.Category == tape.Missing {
+			continue
+		}
+
+		res.Missing = append(res.Missing, diff)
+	}
+
+	for _, diffs := range [][]Difference{res.New, res.Moved, res.Mounted, res.Missing, res.Removed} {
+		sort.Slice(diffs, func(i, j int) bool {
+			return diffs[i].Serial < diffs[j].Serial
+		})
+	}
+
+	return res
+}
+
+// Classify returns the category of a volume new to the inventory.
+func Classify(serial tape.Serial, cleaningPrefix string) tape.VolumeCategory {
+	if cleaningPrefix != "" && strings.HasPrefix(string(serial), cleaningPrefix) {
+		return tape.Cleaning
+	}
+
+	return tape.Scratch
+}
+
+// Found returns the category a missing volume resumes when found again,
+// that is, the category it had before it went missing according to its
+// history.
+func Found(serial tape.Serial, history []tape.Transition, cleaningPrefix string) tape.VolumeCategory {
+	for i := len(history) - 1; i >= 0; i-- {
+		t := history[i]
+		if t.To != tape.Missing {
+			continue
+		}
+
+		if t.From != tape.UnknownVolume && tape.CheckTransition(tape.Missing, t.From) == nil {
+			return t.From
+		}
+
+		break
+	}
+
+	return Classify(serial, cleaningPrefix)
+}
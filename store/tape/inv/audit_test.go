@@ -0,0 +1,136 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inv_test
+
+import (
+	"reflect"
+	"testing"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/inv"
+)
+
+func loc(addr int, cat tape.SlotCategory) tape.Location {
+	return tape.Location{Addr: tape.Addr(addr), Category: cat, Changer: "primary"}
+}
+
+func slot(l tape.Location, serial tape.Serial) tape.Slot {
+	s := tape.Slot{Location: l}
+	if serial != "" {
+		s.Volume = &tape.Volume{Serial: serial, Location: l}
+	}
+
+	return s
+}
+
+func TestDiff(t *testing.T) {
+	vols := []tape.Volume{
+		// where it should be
+		{Serial: "A00000L7", Location: loc(1, tape.StorageSlot), Category: tape.Full},
+
+		// moved between storage slots
+		{Serial: "A00001L7", Location: loc(2, tape.StorageSlot), Category: tape.Full},
+
+		// found in a drive
+		{Serial: "A00002L7", Location: loc(3, tape.StorageSlot), Category: tape.Filling},
+
+		// gone
+		{Serial: "A00003L7", Location: loc(4, tape.StorageSlot), Category: tape.Scratch},
+
+		// already missing
+		{Serial: "A00004L7", Category: tape.Missing},
+
+		// offsite and picked up from the mailslot
+		{Serial: "A00005L7", Location: loc(9, tape.ImportExportSlot), Category: tape.Full, Flags: tape.StatusOffsite},
+
+		// being moved to a drive
+		{Serial: "A00006L7", Location: loc(7, tape.StorageSlot), Category: tape.Filling, Flags: tape.StatusTransfering},
+
+		// being moved and not yet seen anywhere
+		{Serial: "A00007L7", Location: loc(8, tape.StorageSlot), Category: tape.Filling, Flags: tape.StatusTransfering},
+
+		// in another changer
+		{Serial: "B00000L7", Location: tape.Location{Addr: 1, Category: tape.StorageSlot, Changer: "secondary"}},
+	}
+
+	slots := map[tape.SlotCategory]tape.Slots{
+		tape.TransferSlot: {
+			slot(loc(0, tape.TransferSlot), "A00002L7"),
+			slot(loc(1, tape.TransferSlot), "A00006L7"),
+		},
+		tape.StorageSlot: {
+			slot(loc(1, tape.StorageSlot), "A00000L7"),
+			slot(loc(2, tape.StorageSlot), ""),
+			slot(loc(5, tape.StorageSlot), "A00001L7"),
+			slot(loc(6, tape.StorageSlot), "CLN000L1"),
+		},
+		tape.ImportExportSlot: {
+			slot(loc(9, tape.ImportExportSlot), ""),
+		},
+	}
+
+	want := inv.AuditResult{
+		Changer: "primary",
+		New:     []inv.Difference{{Serial: "CLN000L1", To: loc(6, tape.StorageSlot)}},
+		Moved:   []inv.Difference{{Serial: "A00001L7", From: loc(2, tape.StorageSlot), To: loc(5, tape.StorageSlot)}},
+		Mounted: []inv.Difference{{Serial: "A00002L7", From: loc(3, tape.StorageSlot), To: loc(0, tape.TransferSlot)}},
+		Missing: []inv.Difference{{Serial: "A00003L7", From: loc(4, tape.StorageSlot)}},
+		Removed: []inv.Difference{{Serial: "A00005L7", From: loc(9, tape.ImportExportSlot)}},
+	}
+
+	got := inv.Diff("primary", vols, slots)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff returned\n%+v\nwant\n%+v", got, want)
+	}
+
+	if got.Empty() {
+		t.Error("Empty returned true for an audit with differences")
+	}
+
+	if res := inv.Diff("primary", vols[:1], map[tape.SlotCategory]tape.Slots{tape.StorageSlot: slots[tape.StorageSlot][:1]}); !res.Empty() {
+		t.Errorf("Diff of matching state returned %+v", res)
+	}
+}
+
+func TestFound(t *testing.T) {
+	tests := []struct {
+		serial  tape.Serial
+		history []tape.Transition
+		want    tape.VolumeCategory
+	}{
+		{"A00000L7", nil, tape.Scratch},
+		{"CLN000L1", nil, tape.Cleaning},
+		{"A00000L7", []tape.Transition{
+			{From: tape.UnknownVolume, To: tape.Scratch},
+			{From: tape.Scratch, To: tape.Allocating},
+			{From: tape.Allocating, To: tape.Allocated},
+			{From: tape.Allocated, To: tape.Filling},
+			{From: tape.Filling, To: tape.Full},
+			{From: tape.Full, To: tape.Missing},
+		}, tape.Full},
+
+		// allocating volumes may not resume their life cycle
+		{"A00000L7", []tape.Transition{
+			{From: tape.Scratch, To: tape.Allocating},
+			{From: tape.Allocating, To: tape.Missing},
+		}, tape.Scratch},
+	}
+
+	for _, tt := range tests {
+		if got := inv.Found(tt.serial, tt.history, "CLN"); got != tt.want {
+			t.Errorf("Found(%v, %v) = %v, want %v", tt.serial, tt.history, got, tt.want)
+		}
+	}
+}
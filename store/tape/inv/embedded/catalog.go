@@ -0,0 +1,237 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedded
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"tapr.space"
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+)
+
+// file returns a copy of the catalog entry; the caller may modify it freely.
+func file(f *tape.File) tape.File {
+	cp := *f
+	cp.Checksum = append([]byte(nil), f.Checksum...)
+	cp.Extents = append(tape.Extents(nil), f.Extents...)
+
+	return cp
+}
+
+func (e *embedded) Create(path tapr.PathName, serial tape.Serial) error {
+	const op = "inv/embedded.Create"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := e.volume(op, serial); err != nil {
+		return err
+	}
+
+	if e.shadowed(path, serial) {
+		return errors.E(op, path, errors.Exist, errors.Strf("path name is in use by a renamed file on volume %v", serial))
+	}
+
+	f, ok := e.st.Files[path]
+	if !ok {
+		f = &tape.File{Path: path}
+		e.st.Files[path] = f
+	}
+
+	f.Size = 0
+	f.ModTime = time.Now()
+	f.Checksum = nil
+	f.Extents = tape.Extents{{Serial: serial}}
+
+	e.touchFile(path)
+
+	return e.save()
+}
+
+func (e *embedded) Extend(path tapr.PathName, ext tape.Extent) error {
+	const op = "inv/embedded.Extend"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f, ok := e.st.Files[path]
+	if !ok {
+		return errors.E(op, path, errors.NotExist)
+	}
+
+	if _, err := e.volume(op, ext.Serial); err != nil {
+		return err
+	}
+
+	i := sort.Search(len(f.Extents), func(i int) bool {
+		return f.Extents[i].Offset >= ext.Offset
+	})
+
+	switch {
+	case i < len(f.Extents) && f.Extents[i].Offset == ext.Offset:
+		f.Extents[i] = ext
+	default:
+		if e.shadowed(path, ext.Serial) {
+			return errors.E(op, path, errors.Exist, errors.Strf("path name is in use by a renamed file on volume %v", ext.Serial))
+		}
+
+		f.Extents = append(f.Extents, tape.Extent{})
+		copy(f.Extents[i+1:], f.Extents[i:])
+		f.Extents[i] = ext
+	}
+
+	e.touchFile(path)
+
+	return e.save()
+}
+
+func (e *embedded) Commit(ent tape.File) error {
+	const op = "inv/embedded.Commit"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f, ok := e.st.Files[ent.Path]
+	if !ok {
+		return errors.E(op, ent.Path, errors.NotExist)
+	}
+
+	f.Size = ent.Size
+	f.ModTime = ent.ModTime
+	f.Checksum = append([]byte(nil), ent.Checksum...)
+
+	if ent.Dataset != 0 {
+		f.Dataset = ent.Dataset
+	}
+
+	e.touchFile(ent.Path)
+
+	return e.save()
+}
+
+func (e *embedded) Lookup(path tapr.PathName) (tape.File, error) {
+	const op = "inv/embedded.Lookup"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f, ok := e.st.Files[path]
+	if !ok {
+		return tape.File{}, errors.E(op, path, errors.NotExist)
+	}
+
+	return file(f), nil
+}
+
+func (e *embedded) List(prefix tapr.PathName) ([]tape.File, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var fs []tape.File
+	for path, f := range e.st.Files {
+		if strings.HasPrefix(string(path), string(prefix)) {
+			fs = append(fs, file(f))
+		}
+	}
+
+	sort.Slice(fs, func(i, j int) bool {
+		return fs[i].Path < fs[j].Path
+	})
+
+	return fs, nil
+}
+
+func (e *embedded) Rename(oldpath, newpath tapr.PathName) error {
+	const op = "inv/embedded.Rename"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f, ok := e.st.Files[oldpath]
+	if !ok {
+		return errors.E(op, oldpath, errors.NotExist)
+	}
+
+	if _, exists := e.st.Files[newpath]; exists {
+		return errors.E(op, newpath, errors.Exist)
+	}
+
+	delete(e.st.Files, oldpath)
+
+	// the data stays where it is on the volumes
+	for i := range f.Extents {
+		ext := &f.Extents[i]
+		switch ext.Name {
+		case "":
+			ext.Name = oldpath
+		case newpath:
+			ext.Name = ""
+		}
+	}
+
+	f.Path = newpath
+	e.st.Files[newpath] = f
+
+	e.touchFile(oldpath)
+	e.touchFile(newpath)
+
+	return e.save()
+}
+
+func (e *embedded) Remove(path tapr.PathName) error {
+	const op = "inv/embedded.Remove"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.st.Files[path]; !ok {
+		return errors.E(op, path, errors.NotExist)
+	}
+
+	delete(e.st.Files, path)
+
+	e.touchFile(path)
+
+	return e.save()
+}
+
+// shadowed reports whether the data of a renamed file is stored under the
+// given path name on the volume. e.mu MUST be held.
+func (e *embedded) shadowed(path tapr.PathName, serial tape.Serial) bool {
+	for _, f := range e.st.Files {
+		for _, ext := range f.Extents {
+			if ext.Serial == serial && ext.Name == path {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// shadowedAny reports whether any of the path names is shadowed on the
+// volume. e.mu MUST be held.
+func (e *embedded) shadowedAny(paths []tapr.PathName, serial tape.Serial) bool {
+	for _, path := range paths {
+		if e.shadowed(path, serial) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,310 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedded
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+
+	"tapr.space"
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/inv"
+)
+
+// defaultCheckpoint is the default number of changes appended to the change
+// log before the inventory file is rewritten.
+const defaultCheckpoint = 1000
+
+// A change is a line of the change log. It holds the parts of the state
+// modified by a single operation, each in its entirety.
+type change struct {
+	// Seq is the sequence number of the change.
+	Seq int64
+
+	Volumes     []*tape.Volume    `json:",omitempty"`
+	Files       []*tape.File      `json:",omitempty"`
+	Removed     []tapr.PathName   `json:",omitempty"`
+	Transitions []tape.Transition `json:",omitempty"`
+	Intents     []*inv.Intent     `json:",omitempty"`
+	Settled     []int64           `json:",omitempty"`
+	NextIntent  int64             `json:",omitempty"`
+}
+
+// dirty tracks the parts of the state modified since the last save.
+type dirty struct {
+	volumes     map[tape.Serial]bool
+	files       map[tapr.PathName]bool
+	intents     map[int64]bool
+	transitions []tape.Transition
+}
+
+// touch marks the volume as modified. e.mu MUST be held.
+func (e *embedded) touch(serial tape.Serial) {
+	if e.dirty.volumes == nil {
+		e.dirty.volumes = make(map[tape.Serial]bool)
+	}
+
+	e.dirty.volumes[serial] = true
+}
+
+// touchFile marks the catalog entry as modified or removed. e.mu MUST be
+// held.
+func (e *embedded) touchFile(path tapr.PathName) {
+	if e.dirty.files == nil {
+		e.dirty.files = make(map[tapr.PathName]bool)
+	}
+
+	e.dirty.files[path] = true
+}
+
+// touchIntent marks the intent as recorded or removed. e.mu MUST be held.
+func (e *embedded) touchIntent(id int64) {
+	if e.dirty.intents == nil {
+		e.dirty.intents = make(map[int64]bool)
+	}
+
+	e.dirty.intents[id] = true
+}
+
+// changes returns the change holding the modified parts of the state.
+// e.mu MUST be held.
+func (e *embedded) changes() *change {
+	c := &change{Transitions: e.dirty.transitions}
+
+	for serial := range e.dirty.volumes {
+		if vol, ok := e.st.Volumes[serial]; ok {
+			cp := *vol
+			c.Volumes = append(c.Volumes, &cp)
+		}
+	}
+
+	for path := range e.dirty.files {
+		if f, ok := e.st.Files[path]; ok {
+			cp := file(f)
+			c.Files = append(c.Files, &cp)
+		} else {
+			c.Removed = append(c.Removed, path)
+		}
+	}
+
+	for id := range e.dirty.intents {
+		if in, ok := e.st.Intents[id]; ok {
+			cp := *in
+			c.Intents = append(c.Intents, &cp)
+		} else {
+			c.Settled = append(c.Settled, id)
+		}
+	}
+
+	if len(c.Intents) > 0 {
+		c.NextIntent = e.st.NextIntent
+	}
+
+	return c
+}
+
+// empty returns true if the change modifies nothing.
+func (c *change) empty() bool {
+	return len(c.Volumes) == 0 && len(c.Files) == 0 && len(c.Removed) == 0 &&
+		len(c.Transitions) == 0 && len(c.Intents) == 0 && len(c.Settled) == 0
+}
+
+// apply applies the change to the state.
+func (st *state) apply(c *change) {
+	for _, vol := range c.Volumes {
+		st.Volumes[vol.Serial] = vol
+	}
+
+	for _, path := range c.Removed {
+		delete(st.Files, path)
+	}
+
+	for _, f := range c.Files {
+		st.Files[f.Path] = f
+	}
+
+	if len(c.Transitions) > 0 && st.History == nil {
+		st.History = make(map[tape.Serial][]tape.Transition)
+	}
+
+	for _, t := range c.Transitions {
+		st.History[t.Serial] = append(st.History[t.Serial], t)
+	}
+
+	if len(c.Intents) > 0 && st.Intents == nil {
+		st.Intents = make(map[int64]*inv.Intent)
+	}
+
+	for _, in := range c.Intents {
+		st.Intents[in.ID] = in
+	}
+
+	for _, id := range c.Settled {
+		delete(st.Intents, id)
+	}
+
+	if c.NextIntent > st.NextIntent {
+		st.NextIntent = c.NextIntent
+	}
+
+	st.Seq = c.Seq
+}
+
+// save persists the modifications made to the state since the last save by
+// appending them to the change log. Once enough changes have been logged,
+// the inventory file is rewritten and the log is truncated. e.mu MUST be
+// held.
+func (e *embedded) save() error {
+	c := e.changes()
+	if c.empty() {
+		return nil
+	}
+
+	if e.logged >= e.checkpointAfter || e.broken {
+		return e.checkpoint()
+	}
+
+	c.Seq = e.st.Seq + 1
+
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if _, err := e.log.Write(append(buf, '\n')); err != nil {
+		// a partially written change must not be followed by others
+		e.broken = true
+		return err
+	}
+
+	if err := e.log.Sync(); err != nil {
+		e.broken = true
+		return err
+	}
+
+	e.st.Seq = c.Seq
+	e.logged++
+	e.dirty = dirty{}
+
+	return nil
+}
+
+// checkpoint writes the complete inventory to disk and truncates the change
+// log. The file is replaced atomically such that a crash leaves either the
+// old or the new inventory; changes already contained in the inventory file
+// are skipped when the log is replayed. e.mu MUST be held.
+func (e *embedded) checkpoint() error {
+	buf, err := json.MarshalIndent(e.st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := e.path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, e.path); err != nil {
+		return err
+	}
+
+	e.dirty = dirty{}
+
+	if err := e.log.Truncate(0); err != nil {
+		return err
+	}
+
+	if err := e.log.Sync(); err != nil {
+		return err
+	}
+
+	e.logged, e.broken = 0, false
+
+	return nil
+}
+
+// replay opens the change log and applies the changes not yet contained in
+// the inventory file. A change only partially written before a crash is
+// discarded.
+func (e *embedded) replay() error {
+	f, err := os.OpenFile(e.path+".log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	var (
+		r   = bufio.NewReader(f)
+		off int64
+	)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// torn write
+				if err := f.Truncate(off); err != nil {
+					f.Close()
+					return err
+				}
+			}
+
+			break
+		}
+
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		var c change
+		if err := json.Unmarshal(bytes.TrimSpace(line), &c); err != nil {
+			f.Close()
+			return errors.E(errors.Invalid, errors.Strf("corrupt change log %s at offset %d: %v", f.Name(), off, err))
+		}
+
+		off += int64(len(line))
+
+		if c.Seq <= e.st.Seq {
+			continue
+		}
+
+		e.st.apply(&c)
+		e.logged++
+	}
+
+	e.log = f
+
+	return nil
+}
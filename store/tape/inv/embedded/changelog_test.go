@@ -0,0 +1,281 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedded_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
+	"tapr.space/store/tape/inv/embedded"
+)
+
+// snapshot is the observable state of an inventory.
+type snapshot struct {
+	Volumes []tape.Volume
+	Files   []tape.File
+	History map[tape.Serial][]tape.Transition
+}
+
+func observe(t *testing.T, invdb inv.Inventory) snapshot {
+	t.Helper()
+
+	vols, err := invdb.Volumes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := invdb.List("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the monotonic clock reading and location do not survive a round-trip
+	for i := range fs {
+		fs[i].ModTime = fs[i].ModTime.Round(0).UTC()
+	}
+
+	snap := snapshot{
+		Volumes: vols,
+		Files:   fs,
+		History: make(map[tape.Serial][]tape.Transition),
+	}
+
+	for _, vol := range vols {
+		ts, err := invdb.History(vol.Serial)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range ts {
+			ts[i].Time = ts[i].Time.Round(0).UTC()
+		}
+
+		snap.History[vol.Serial] = ts
+	}
+
+	return snap
+}
+
+// exercise changes every part of the inventory.
+func exercise(t *testing.T, invdb inv.Inventory, chgr changer.Changer) {
+	t.Helper()
+
+	steps := []func() error{
+		func() error { return invdb.Load("A00000L7", drive, chgr) },
+		func() error { return invdb.Transition("A00000L7", tape.Allocating, "test") },
+		func() error { return invdb.Create("/a", "A00000L7") },
+		func() error { return invdb.Extend("/a", tape.Extent{Serial: "A00000L7", Length: 10}) },
+		func() error { return invdb.Commit(tape.File{Path: "/a", Size: 10, Checksum: []byte{1, 2}}) },
+		func() error { return invdb.Create("/b", "A00000L7") },
+		func() error { return invdb.Rename("/a", "/c") },
+		func() error { return invdb.Remove("/b") },
+		func() error { return invdb.Unload("A00000L7", tape.Location{Changer: "primary"}, chgr) },
+	}
+
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestChangeLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	invdb := open(t, dir, nil)
+	chgr := library(t, nil)
+
+	if _, err := invdb.Audit(chgr, false); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := ioutil.ReadFile(filepath.Join(dir, "inv.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exercise(t, invdb, chgr)
+
+	// the changes went to the log; the inventory file was left alone
+	after, err := ioutil.ReadFile(filepath.Join(dir, "inv.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(before, after) {
+		t.Error("inventory file rewritten before a checkpoint")
+	}
+
+	want := observe(t, invdb)
+
+	if got := observe(t, open(t, dir, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("reopened inventory differs:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	// reopening checkpointed the log; the state is in the inventory file
+	if fi, err := os.Stat(filepath.Join(dir, "inv.json.log")); err != nil || fi.Size() != 0 {
+		t.Errorf("change log after checkpoint: %v, %v; want empty", fi, err)
+	}
+
+	if got := observe(t, open(t, dir, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("inventory differs after checkpoint:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	invdb := open(t, dir, map[string]string{"checkpoint": "4"})
+	chgr := library(t, nil)
+
+	if _, err := invdb.Audit(chgr, false); err != nil {
+		t.Fatal(err)
+	}
+
+	exercise(t, invdb, chgr)
+
+	// at most four changes are logged at any time
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "inv.json.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := bytes.Count(buf, []byte("\n")); n > 4 {
+		t.Errorf("%d changes logged, want at most 4", n)
+	}
+
+	want := observe(t, invdb)
+
+	if got := observe(t, open(t, dir, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("reopened inventory differs:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestChangeLogTorn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	invdb := open(t, dir, nil)
+	chgr := library(t, nil)
+
+	if _, err := invdb.Audit(chgr, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := invdb.Create("/a", "A00000L7"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := observe(t, invdb)
+
+	// a crash while logging a change leaves part of it behind
+	logpath := filepath.Join(dir, "inv.json.log")
+
+	f, err := os.OpenFile(logpath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteString(`{"Seq":42,"Files":[{"Pa`); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	invdb, err = embedded.New(map[string]string{
+		"path":            filepath.Join(dir, "inv.json"),
+		"cleaning-prefix": "CLN",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := observe(t, invdb); !reflect.DeepEqual(got, want) {
+		t.Errorf("inventory differs after a torn change:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	// later changes are not appended to the partial change
+	if err := invdb.Remove("/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	want = observe(t, invdb)
+
+	if got := observe(t, open(t, dir, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("reopened inventory differs:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestChangeLogReplayed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	invdb := open(t, dir, nil)
+	chgr := library(t, nil)
+
+	if _, err := invdb.Audit(chgr, false); err != nil {
+		t.Fatal(err)
+	}
+
+	exercise(t, invdb, chgr)
+
+	logpath := filepath.Join(dir, "inv.json.log")
+
+	buf, err := ioutil.ReadFile(logpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := observe(t, invdb)
+
+	// a crash after a checkpoint, but before the log was truncated, leaves
+	// changes already contained in the inventory file in the log
+	if err := invdb.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(logpath, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := observe(t, open(t, dir, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("inventory differs after replaying checkpointed changes:\ngot  %+v\nwant %+v", got, want)
+	}
+}
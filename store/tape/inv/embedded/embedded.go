@@ -0,0 +1,510 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package embedded implements a file-backed inv.Inventory that requires no
+// database server. The complete inventory is kept in memory. Every change
+// is appended to a change log and the log is folded into the inventory file
+// once it has grown long enough.
+package embedded // import "tapr.space/store/tape/inv/embedded"
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"tapr.space"
+	"tapr.space/bitmask"
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
+)
+
+func init() {
+	inv.Register("embedded", New)
+}
+
+// state is the persisted state of the inventory.
+type state struct {
+	// Version is the schema version of the state.
+	Version int
+
+	Volumes map[tape.Serial]*tape.Volume
+	Files   map[tapr.PathName]*tape.File
+
+	// History holds the transitions of each volume, oldest first.
+	History map[tape.Serial][]tape.Transition
+
+	// Intents holds the changer moves in progress by id.
+	Intents map[int64]*inv.Intent
+
+	// NextIntent is the id of the most recently recorded intent.
+	NextIntent int64
+
+	// Seq is the sequence number of the last change contained in the
+	// state.
+	Seq int64
+}
+
+func newState() *state {
+	return &state{
+		Volumes: make(map[tape.Serial]*tape.Volume),
+		Files:   make(map[tapr.PathName]*tape.File),
+	}
+}
+
+type embedded struct {
+	path string
+
+	mu sync.Mutex
+	st *state
+
+	// the change log and the number of changes logged since the last
+	// checkpoint
+	log    *os.File
+	logged int
+
+	// number of changes logged between checkpoints
+	checkpointAfter int
+
+	// parts of the state modified since the last save
+	dirty dirty
+
+	// set if a change could not be logged; the next save checkpoints
+	broken bool
+
+	prefixCleaning string
+}
+
+var _ inv.Inventory = (*embedded)(nil)
+
+// New returns a new file-backed inventory implementation. The inventory is
+// stored in the file given by the path option; the file is created if it
+// does not exist. Changes are logged to the file with a ".log" suffix added
+// until the number given by the optional checkpoint option (default 1000)
+// has been logged; then the inventory file is rewritten.
+func New(opts map[string]string) (inv.Inventory, error) {
+	const op = "inv/embedded.New"
+
+	requiredOpts := []string{
+		"path", "cleaning-prefix",
+	}
+
+	for _, opt := range requiredOpts {
+		if _, ok := opts[opt]; !ok {
+			return nil, errors.E(op, errors.Strf("the %s option must be specified", opt))
+		}
+	}
+
+	e := &embedded{
+		path:            opts["path"],
+		st:              newState(),
+		checkpointAfter: defaultCheckpoint,
+		prefixCleaning:  opts["cleaning-prefix"],
+	}
+
+	if s, ok := opts["checkpoint"]; ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, errors.E(op, errors.Invalid, errors.Strf("invalid checkpoint option %q", s))
+		}
+
+		e.checkpointAfter = n
+	}
+
+	if err := os.MkdirAll(filepath.Dir(e.path), os.ModePerm); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	buf, err := ioutil.ReadFile(e.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.E(op, err)
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(buf, e.st); err != nil {
+			return nil, errors.E(op, errors.Invalid, errors.Strf("corrupt inventory %s: %v", e.path, err))
+		}
+	}
+
+	if e.st.Volumes == nil {
+		e.st.Volumes = make(map[tape.Serial]*tape.Volume)
+	}
+
+	if e.st.Files == nil {
+		e.st.Files = make(map[tapr.PathName]*tape.File)
+	}
+
+	if err := e.replay(); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return e, nil
+}
+
+// volume returns the named volume. e.mu MUST be held.
+func (e *embedded) volume(op string, serial tape.Serial) (*tape.Volume, error) {
+	vol, ok := e.st.Volumes[serial]
+	if !ok {
+		return nil, errors.E(op, errors.NotExist, errors.Strf("unknown volume %v", serial))
+	}
+
+	return vol, nil
+}
+
+// occupant returns the volume at the given location (if any). e.mu MUST be
+// held.
+func (e *embedded) occupant(loc tape.Location) *tape.Volume {
+	for _, vol := range e.st.Volumes {
+		if vol.Location == loc {
+			return vol
+		}
+	}
+
+	return nil
+}
+
+// move updates the location of a volume once the changer has moved it.
+func (e *embedded) move(serial tape.Serial, fn func(vol *tape.Volume)) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	vol, ok := e.st.Volumes[serial]
+	if !ok {
+		return errors.E(errors.NotExist, errors.Strf("unknown volume %v", serial))
+	}
+
+	fn(vol)
+
+	e.touch(serial)
+
+	return e.save()
+}
+
+func (e *embedded) Volumes() ([]tape.Volume, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	vs := make([]tape.Volume, 0, len(e.st.Volumes))
+	for _, vol := range e.st.Volumes {
+		vs = append(vs, *vol)
+	}
+
+	sort.Slice(vs, func(i, j int) bool {
+		return vs[i].Serial < vs[j].Serial
+	})
+
+	return vs, nil
+}
+
+func (e *embedded) Audit(chgr changer.Changer, dryRun bool) (inv.AuditResult, error) {
+	const op = "inv/embedded.Audit"
+
+	slots, err := chgr.Status()
+	if err != nil {
+		return inv.AuditResult{}, errors.E(op, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// volumes with a pending intent are left to the recovery of the move
+	pending := make(map[tape.Serial]bool, len(e.st.Intents))
+	for _, in := range e.st.Intents {
+		pending[in.Serial] = true
+	}
+
+	vols := make([]tape.Volume, 0, len(e.st.Volumes))
+	for _, vol := range e.st.Volumes {
+		v := *vol
+		if pending[v.Serial] {
+			bitmask.Set(&v.Flags, tape.StatusTransfering)
+		}
+
+		vols = append(vols, v)
+	}
+
+	res := inv.Diff(changer.Name(chgr), vols, slots)
+	if dryRun || res.Empty() {
+		return res, nil
+	}
+
+	for _, d := range res.New {
+		vol := &tape.Volume{
+			Serial:   d.Serial,
+			Location: d.To,
+			Category: inv.Classify(d.Serial, e.prefixCleaning),
+		}
+
+		if d.To.Category == tape.TransferSlot {
+			bitmask.Set(&vol.Flags, tape.StatusMounted)
+		}
+
+		e.st.Volumes[d.Serial] = vol
+
+		e.record(d.Serial, tape.UnknownVolume, vol.Category, "discovered by audit")
+	}
+
+	for _, d := range append(res.Moved, res.Mounted...) {
+		vol := e.st.Volumes[d.Serial]
+
+		vol.Location = d.To
+		vol.Home = tape.Location{}
+
+		bitmask.Clear(&vol.Flags, tape.StatusTransfering)
+		bitmask.Clear(&vol.Flags, tape.StatusMounted)
+
+		if d.To.Category == tape.TransferSlot {
+			bitmask.Set(&vol.Flags, tape.StatusMounted)
+
+			if d.From.Category == tape.StorageSlot {
+				vol.Home = d.From
+			}
+		}
+
+		if vol.Category == tape.Missing {
+			to := inv.Found(d.Serial, e.st.History[d.Serial], e.prefixCleaning)
+			e.record(d.Serial, tape.Missing, to, "found by audit")
+			vol.Category = to
+		}
+	}
+
+	for _, d := range res.Missing {
+		vol := e.st.Volumes[d.Serial]
+
+		vol.Location, vol.Home = tape.Location{}, tape.Location{}
+
+		bitmask.Clear(&vol.Flags, tape.StatusTransfering)
+		bitmask.Clear(&vol.Flags, tape.StatusMounted)
+
+		e.record(d.Serial, vol.Category, tape.Missing, "not found by audit")
+		vol.Category = tape.Missing
+	}
+
+	for _, d := range res.Removed {
+		e.st.Volumes[d.Serial].Location = tape.Location{}
+	}
+
+	for _, ds := range [][]inv.Difference{res.New, res.Moved, res.Mounted, res.Missing, res.Removed} {
+		for _, d := range ds {
+			e.touch(d.Serial)
+		}
+	}
+
+	if err := e.save(); err != nil {
+		return res, errors.E(op, err)
+	}
+
+	return res, nil
+}
+
+func (e *embedded) Load(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/embedded.Load"
+
+	in, err := e.begin(op, inv.LoadMove, serial, dst, func(vol *tape.Volume, dst *tape.Location) error {
+		if vol.Location.Category != tape.StorageSlot && vol.Location.Category != tape.ImportExportSlot {
+			return errors.Strf("invalid source slot for load operation")
+		}
+
+		if dst.Category != tape.TransferSlot {
+			return errors.Strf("invalid destination slot for load operation")
+		}
+
+		if vol.Location.Changer != dst.Changer {
+			return errors.E(errors.Invalid, errors.Strf("volume %v is in changer %q, not %q", serial, vol.Location.Changer, dst.Changer))
+		}
+
+		if occ := e.occupant(*dst); occ != nil {
+			return errors.E(errors.Invalid, errors.Strf("destination slot is occupied by %v", occ.Serial))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return e.carry(op, in, chgr, chgr.Load)
+}
+
+func (e *embedded) Unload(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/embedded.Unload"
+
+	in, err := e.begin(op, inv.UnloadMove, serial, dst, func(vol *tape.Volume, dst *tape.Location) error {
+		if dst.Addr == 0 {
+			// return to home slot
+			*dst = vol.Home
+		}
+
+		if vol.Location.Changer != dst.Changer {
+			return errors.E(errors.Invalid, errors.Strf("volume %v is in changer %q, not %q", serial, vol.Location.Changer, dst.Changer))
+		}
+
+		if vol.Location.Category != tape.TransferSlot {
+			return errors.Strf("invalid source slot for unload operation")
+		}
+
+		if dst.Category != tape.StorageSlot && dst.Category != tape.ImportExportSlot {
+			return errors.Strf("invalid destination slot for unload operation")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return e.carry(op, in, chgr, chgr.Unload)
+}
+
+func (e *embedded) Transfer(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/embedded.Transfer"
+
+	in, err := e.begin(op, inv.TransferMove, serial, dst, func(vol *tape.Volume, dst *tape.Location) error {
+		if vol.Location.Category != tape.StorageSlot && vol.Location.Category != tape.ImportExportSlot {
+			return errors.Strf("invalid source slot for transfer operation")
+		}
+
+		if dst.Category != tape.StorageSlot && dst.Category != tape.ImportExportSlot {
+			return errors.Strf("invalid destination slot for transfer")
+		}
+
+		if vol.Location.Changer != dst.Changer {
+			return errors.E(errors.Invalid, errors.Strf("volume %v is in changer %q, not %q", serial, vol.Location.Changer, dst.Changer))
+		}
+
+		if occ := e.occupant(*dst); occ != nil {
+			return errors.E(errors.Invalid, errors.Strf("destination slot is occupied by %v", occ.Serial))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return e.carry(op, in, chgr, chgr.Transfer)
+}
+
+func (e *embedded) Loaded(loc tape.Location) (bool, tape.Serial, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	occ := e.occupant(tape.Location{Addr: loc.Addr, Category: tape.TransferSlot, Changer: loc.Changer})
+	if occ == nil {
+		return false, "", nil
+	}
+
+	return true, occ.Serial, nil
+}
+
+func (e *embedded) Info(serial tape.Serial) (tape.Volume, error) {
+	const op = "inv/embedded.Info"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	vol, err := e.volume(op, serial)
+	if err != nil {
+		return tape.Volume{}, err
+	}
+
+	return *vol, nil
+}
+
+func (e *embedded) Update(vol tape.Volume) error {
+	const op = "inv/embedded.Update"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	v, err := e.volume(op, vol.Serial)
+	if err != nil {
+		return err
+	}
+
+	if err := tape.CheckTransition(v.Category, vol.Category); err != nil {
+		return errors.E(op, err)
+	}
+
+	if v.Category != vol.Category {
+		e.record(vol.Serial, v.Category, vol.Category, "volume updated")
+	}
+
+	*v = vol
+
+	e.touch(vol.Serial)
+
+	return e.save()
+}
+
+func (e *embedded) Alloc(changer string, paths ...tapr.PathName) (tape.Serial, error) {
+	const op = "inv/embedded.Alloc"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var candidates []*tape.Volume
+	for _, vol := range e.st.Volumes {
+		if vol.Category != tape.Filling && vol.Category != tape.Scratch {
+			continue
+		}
+
+		if vol.Location.Category != tape.StorageSlot || vol.Location.Changer != changer {
+			continue
+		}
+
+		if e.shadowedAny(paths, vol.Serial) {
+			continue
+		}
+
+		candidates = append(candidates, vol)
+	}
+
+	if len(candidates) == 0 {
+		return "", errors.E(op, errors.NotExist, errors.Strf("no filling or scratch volumes available in changer %q", changer))
+	}
+
+	// prefer filling volumes over scratch volumes
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Category != b.Category {
+			return a.Category == tape.Filling
+		}
+
+		return a.Serial < b.Serial
+	})
+
+	vol := candidates[0]
+
+	if vol.Category != tape.Filling {
+		vol.Category = tape.Allocating
+
+		e.record(vol.Serial, tape.Scratch, tape.Allocating, "allocated for writing")
+		e.touch(vol.Serial)
+
+		if err := e.save(); err != nil {
+			return "", errors.E(op, err)
+		}
+	}
+
+	return vol.Serial, nil
+}
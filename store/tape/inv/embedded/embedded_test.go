@@ -0,0 +1,349 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedded_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/changer/fake"
+	"tapr.space/store/tape/inv"
+	"tapr.space/store/tape/inv/embedded"
+)
+
+// setup returns an audited inventory and a fake changer injecting the
+// given faults.
+func setup(t *testing.T, faults map[string]string) (inv.Inventory, changer.Changer, func()) {
+	dir, err := ioutil.TempDir("", "embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invdb := open(t, dir, nil)
+	chgr := library(t, faults)
+
+	// the faults only apply to moves, so the audit always succeeds
+	if _, err := invdb.Audit(chgr, false); err != nil {
+		t.Fatal(err)
+	}
+
+	return invdb, chgr, func() { os.RemoveAll(dir) }
+}
+
+// library returns a fake changer holding four volumes and injecting the
+// given faults.
+func library(t *testing.T, faults map[string]string) changer.Changer {
+	t.Helper()
+
+	opts := map[string]interface{}{
+		"transfer": "2",
+		"storage":  "8",
+		"ix":       "2",
+		"volumes":  "4",
+	}
+
+	for k, v := range faults {
+		opts[k] = v
+	}
+
+	c, err := fake.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return changer.Named("primary", c)
+}
+
+// open opens and migrates the inventory stored in dir with the given extra
+// options.
+func open(t *testing.T, dir string, opts map[string]string) inv.Inventory {
+	t.Helper()
+
+	o := map[string]string{
+		"path":            filepath.Join(dir, "inv.json"),
+		"cleaning-prefix": "CLN",
+	}
+
+	for k, v := range opts {
+		o[k] = v
+	}
+
+	invdb, err := embedded.New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := invdb.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return invdb
+}
+
+var drive = tape.Location{Addr: 0, Category: tape.TransferSlot, Changer: "primary"}
+
+func TestLoadFailure(t *testing.T) {
+	invdb, chgr, cleanup := setup(t, map[string]string{"fail-load": "1"})
+	defer cleanup()
+
+	if err := invdb.Load("A00000L7", drive, chgr); err == nil {
+		t.Fatal("expected load to fail")
+	}
+
+	vol, err := invdb.Info("A00000L7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := tape.Location{Addr: 1, Category: tape.StorageSlot, Changer: "primary"}
+	if vol.Location != want || vol.Flags != 0 || vol.Mounts != 0 {
+		t.Errorf("got %v, want volume back in %v", vol, want)
+	}
+}
+
+func TestLoadDropped(t *testing.T) {
+	invdb, chgr, cleanup := setup(t, map[string]string{"drop": "1"})
+	defer cleanup()
+
+	if err := invdb.Load("A00000L7", drive, chgr); err == nil {
+		t.Fatal("expected load to fail")
+	}
+
+	vol, err := invdb.Info("A00000L7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Category != tape.Missing || vol.Location != (tape.Location{}) {
+		t.Errorf("got %v, want missing volume", vol)
+	}
+
+	ins, err := invdb.Recover(chgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ins) != 0 {
+		t.Errorf("got %d intents left after a failed move", len(ins))
+	}
+}
+
+func TestLoadUnload(t *testing.T) {
+	invdb, chgr, cleanup := setup(t, nil)
+	defer cleanup()
+
+	if err := invdb.Load("A00000L7", drive, chgr); err != nil {
+		t.Fatal(err)
+	}
+
+	vol, err := invdb.Info("A00000L7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Location != drive || vol.Mounts != 1 {
+		t.Errorf("got %v, want volume mounted in %v", vol, drive)
+	}
+
+	if err := invdb.Unload("A00000L7", tape.Location{Changer: "primary"}, chgr); err != nil {
+		t.Fatal(err)
+	}
+
+	vol, err = invdb.Info("A00000L7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Location.Category != tape.StorageSlot || vol.Location.Addr != 1 {
+		t.Errorf("got %v, want volume back in its home slot", vol)
+	}
+}
+
+func TestRename(t *testing.T) {
+	invdb, _, cleanup := setup(t, nil)
+	defer cleanup()
+
+	if err := invdb.Create("/a", "A00000L7"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := invdb.Rename("/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	ent, err := invdb.Lookup("/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ent.Extents[0].Path(ent.Path); got != "/a" {
+		t.Errorf("got data stored as %v, want /a", got)
+	}
+
+	// the data of /b is still stored as /a on the volume
+	if err := invdb.Create("/a", "A00000L7"); !errors.Is(errors.Exist, err) {
+		t.Errorf("got %v, want error of kind Exist", err)
+	}
+
+	if err := invdb.Create("/a", "A00001L7"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := invdb.Rename("/b", "/a"); !errors.Is(errors.Exist, err) {
+		t.Errorf("got %v, want error of kind Exist", err)
+	}
+
+	if err := invdb.Remove("/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// renaming the file back clears the on-volume name
+	if err := invdb.Rename("/b", "/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	ent, err = invdb.Lookup("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ent.Extents[0].Name != "" {
+		t.Errorf("got on-volume name %v, want none", ent.Extents[0].Name)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	invdb, _, cleanup := setup(t, nil)
+	defer cleanup()
+
+	const serial = tape.Serial("A00000L7")
+
+	base, err := invdb.History(serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		to     tape.VolumeCategory
+		reason string
+	}{
+		{tape.Allocating, "allocating"},
+		{tape.Allocated, "formatted"},
+		{tape.Allocated, "no change"},
+	}
+
+	for _, step := range steps {
+		if err := invdb.Transition(serial, step.to, step.reason); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// rejected transitions are not recorded
+	if err := invdb.Transition(serial, tape.Full, "skipping filling"); !errors.Is(errors.Invalid, err) {
+		t.Errorf("got %v, want error of kind Invalid", err)
+	}
+
+	ts, err := invdb.History(serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ts) != len(base)+2 {
+		t.Fatalf("got %d transitions, want %d", len(ts), len(base)+2)
+	}
+
+	want := []tape.Transition{
+		{Serial: serial, From: tape.Scratch, To: tape.Allocating, Reason: "allocating"},
+		{Serial: serial, From: tape.Allocating, To: tape.Allocated, Reason: "formatted"},
+	}
+
+	for i, tr := range ts[len(base):] {
+		if tr.Time.IsZero() {
+			t.Errorf("transition %d has no time", i)
+		}
+
+		tr.Time = want[i].Time
+		if tr != want[i] {
+			t.Errorf("transition %d: got %v, want %v", i, tr, want[i])
+		}
+	}
+
+	vol, err := invdb.Info(serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Category != tape.Allocated {
+		t.Errorf("got category %v, want %v", vol.Category, tape.Allocated)
+	}
+
+	if _, err := invdb.History("X00000L7"); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want error of kind NotExist", err)
+	}
+}
+
+// auditing is a changer auditing the inventory right after each load,
+// before the inventory learns the outcome of the move.
+type auditing struct {
+	changer.Changer
+
+	invdb inv.Inventory
+	res   inv.AuditResult
+	err   error
+}
+
+func (a *auditing) Load(src, dst tape.Location) error {
+	if err := a.Changer.Load(src, dst); err != nil {
+		return err
+	}
+
+	a.res, a.err = a.invdb.Audit(a.Changer, false)
+
+	return nil
+}
+
+func TestAuditPending(t *testing.T) {
+	invdb, chgr, cleanup := setup(t, nil)
+	defer cleanup()
+
+	a := &auditing{Changer: chgr, invdb: invdb}
+
+	if err := invdb.Load("A00000L7", drive, changer.Named("primary", a)); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.err != nil {
+		t.Fatal(a.err)
+	}
+
+	if !a.res.Empty() {
+		t.Errorf("audit during a move returned %+v", a.res)
+	}
+
+	vol, err := invdb.Info("A00000L7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	home := tape.Location{Addr: 1, Category: tape.StorageSlot, Changer: "primary"}
+	if vol.Location != drive || vol.Home != home || vol.Mounts != 1 {
+		t.Errorf("got %v, want volume mounted in %v from %v", vol, drive, home)
+	}
+}
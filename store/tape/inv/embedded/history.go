@@ -0,0 +1,81 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedded
+
+import (
+	"time"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+)
+
+// record records a volume transition in the history. e.mu MUST be held.
+func (e *embedded) record(serial tape.Serial, from, to tape.VolumeCategory, reason string) {
+	if e.st.History == nil {
+		e.st.History = make(map[tape.Serial][]tape.Transition)
+	}
+
+	t := tape.Transition{
+		Serial: serial,
+		From:   from,
+		To:     to,
+		Time:   time.Now(),
+		Reason: reason,
+	}
+
+	e.st.History[serial] = append(e.st.History[serial], t)
+	e.dirty.transitions = append(e.dirty.transitions, t)
+}
+
+func (e *embedded) Transition(serial tape.Serial, to tape.VolumeCategory, reason string) error {
+	const op = "inv/embedded.Transition"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	vol, err := e.volume(op, serial)
+	if err != nil {
+		return err
+	}
+
+	if err := tape.CheckTransition(vol.Category, to); err != nil {
+		return errors.E(op, err)
+	}
+
+	if vol.Category == to {
+		return nil
+	}
+
+	e.record(serial, vol.Category, to, reason)
+
+	vol.Category = to
+
+	e.touch(serial)
+
+	return e.save()
+}
+
+func (e *embedded) History(serial tape.Serial) ([]tape.Transition, error) {
+	const op = "inv/embedded.History"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := e.volume(op, serial); err != nil {
+		return nil, err
+	}
+
+	return append([]tape.Transition(nil), e.st.History[serial]...), nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedded
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"tapr.space/bitmask"
+	"tapr.space/errors"
+	"tapr.space/log"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
+)
+
+// begin records the intent to move a volume to dst and marks the volume as
+// in transit. The check function validates the move and may adjust the
+// destination. e.mu MUST NOT be held.
+func (e *embedded) begin(op string, kind inv.MoveKind, serial tape.Serial, dst tape.Location, check func(vol *tape.Volume, dst *tape.Location) error) (inv.Intent, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	vol, err := e.volume(op, serial)
+	if err != nil {
+		return inv.Intent{}, err
+	}
+
+	if err := check(vol, &dst); err != nil {
+		return inv.Intent{}, errors.E(op, err)
+	}
+
+	if e.st.Intents == nil {
+		e.st.Intents = make(map[int64]*inv.Intent)
+	}
+
+	e.st.NextIntent++
+
+	in := &inv.Intent{
+		ID:     e.st.NextIntent,
+		Kind:   kind,
+		Serial: serial,
+		Src:    vol.Location,
+		Dst:    dst,
+		Home:   vol.Home,
+		Flags:  vol.Flags,
+		Time:   time.Now(),
+	}
+
+	e.st.Intents[in.ID] = in
+
+	// the volume is in transit until the move is settled; a loaded volume
+	// claims its home slot right away.
+	if kind == inv.LoadMove {
+		vol.Home = vol.Location
+	}
+
+	vol.Location = tape.Location{}
+	bitmask.Set(&vol.Flags, tape.StatusTransfering)
+
+	e.touch(serial)
+	e.touchIntent(in.ID)
+
+	if err := e.save(); err != nil {
+		return inv.Intent{}, errors.E(op, err)
+	}
+
+	return *in, nil
+}
+
+// carry carries out the move recorded by the intent. If the move fails, the
+// inventory is settled according to the physical state of the changer.
+func (e *embedded) carry(op string, in inv.Intent, chgr changer.Changer, move func(src, dst tape.Location) error) error {
+	if err := move(in.Src, in.Dst); err != nil {
+		if _, serr := e.settle(in, chgr); serr != nil {
+			log.Error.Printf("%s: could not settle failed %s of %v: %v", op, in.Kind, in.Serial, serr)
+		}
+
+		return errors.E(op, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.apply(in, inv.Completed, in.Dst)
+}
+
+// settle resolves the outcome of the move recorded by the intent from the
+// changer status and applies it. e.mu MUST NOT be held.
+func (e *embedded) settle(in inv.Intent, chgr changer.Changer) (inv.Outcome, error) {
+	slots, err := chgr.Status()
+	if err != nil {
+		return 0, err
+	}
+
+	outcome, loc := inv.Resolve(in, slots)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return outcome, e.apply(in, outcome, loc)
+}
+
+// apply updates the volume moved according to the outcome of the move and
+// removes the intent. e.mu MUST be held.
+func (e *embedded) apply(in inv.Intent, outcome inv.Outcome, loc tape.Location) error {
+	vol, ok := e.st.Volumes[in.Serial]
+	if !ok {
+		return errors.E(errors.NotExist, errors.Strf("unknown volume %v", in.Serial))
+	}
+
+	switch outcome {
+	case inv.Completed, inv.Relocated:
+		vol.Location = loc
+		vol.Home, vol.Flags = in.Settled(loc)
+
+		if outcome == inv.Completed && in.Kind == inv.LoadMove {
+			vol.Mounts++
+
+			if vol.Category == tape.Allocating {
+				vol.Category = tape.Allocated
+
+				e.record(vol.Serial, tape.Allocating, tape.Allocated, "loaded for formatting")
+			}
+		}
+
+	case inv.Aborted:
+		vol.Location, vol.Home, vol.Flags = in.Src, in.Home, in.Flags
+
+	case inv.Lost:
+		vol.Location, vol.Home, vol.Flags = tape.Location{}, tape.Location{}, in.Flags
+
+		bitmask.Clear(&vol.Flags, tape.StatusTransfering)
+		bitmask.Clear(&vol.Flags, tape.StatusMounted)
+
+		if vol.Category != tape.Missing {
+			e.record(vol.Serial, vol.Category, tape.Missing, fmt.Sprintf("lost during %s", in.Kind))
+
+			vol.Category = tape.Missing
+		}
+	}
+
+	delete(e.st.Intents, in.ID)
+
+	e.touch(in.Serial)
+	e.touchIntent(in.ID)
+
+	return e.save()
+}
+
+func (e *embedded) Recover(chgr changer.Changer) ([]inv.Intent, error) {
+	const op = "inv/embedded.Recover"
+
+	name := changer.Name(chgr)
+
+	e.mu.Lock()
+
+	var ins []inv.Intent
+	for _, in := range e.st.Intents {
+		if in.Src.Changer == name {
+			ins = append(ins, *in)
+		}
+	}
+
+	e.mu.Unlock()
+
+	sort.Slice(ins, func(i, j int) bool {
+		return ins[i].ID < ins[j].ID
+	})
+
+	for i, in := range ins {
+		outcome, err := e.settle(in, chgr)
+		if err != nil {
+			return ins[:i], errors.E(op, err)
+		}
+
+		log.Printf("%s: interrupted %s of %v from %v to %v %v", op, in.Kind, in.Serial, in.Src, in.Dst, outcome)
+	}
+
+	return ins, nil
+}
+
+// pending returns true if the location is the source or destination of a
+// move in progress. e.mu MUST be held.
+func (e *embedded) pending(loc tape.Location) bool {
+	for _, in := range e.st.Intents {
+		if in.Src == loc || in.Dst == loc {
+			return true
+		}
+	}
+
+	return false
+}
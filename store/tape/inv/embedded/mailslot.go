@@ -0,0 +1,204 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedded
+
+import (
+	"fmt"
+
+	"tapr.space/bitmask"
+	"tapr.space/errors"
+	"tapr.space/log"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
+)
+
+func (e *embedded) Import(chgr changer.Changer) ([]tape.Volume, error) {
+	const op = "inv/embedded.Import"
+
+	slots, err := chgr.Status()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	var imported []tape.Volume
+	for _, slot := range slots[tape.ImportExportSlot] {
+		e.mu.Lock()
+
+		ok, err := e.register(slot)
+		if err != nil || !ok {
+			e.mu.Unlock()
+
+			if err != nil {
+				return imported, errors.E(op, err)
+			}
+
+			continue
+		}
+
+		dst, err := e.free(slots[tape.StorageSlot], tape.StorageSlot)
+		e.mu.Unlock()
+
+		if err != nil {
+			return imported, errors.E(op, err)
+		}
+
+		if err := e.Transfer(slot.Volume.Serial, dst, chgr); err != nil {
+			return imported, errors.E(op, err)
+		}
+
+		vol, err := e.Info(slot.Volume.Serial)
+		if err != nil {
+			return imported, errors.E(op, err)
+		}
+
+		imported = append(imported, vol)
+	}
+
+	return imported, nil
+}
+
+// register brings the inventory up to date with the given import/export
+// slot and returns true if the volume in the slot should be imported.
+// e.mu MUST be held.
+func (e *embedded) register(slot tape.Slot) (bool, error) {
+	// offsite volumes no longer in the slot they were exported to have been
+	// removed from the library.
+	for _, vol := range e.st.Volumes {
+		if vol.Location != slot.Location {
+			continue
+		}
+
+		if slot.Volume == nil || slot.Volume.Serial != vol.Serial {
+			vol.Location = tape.Location{}
+			e.touch(vol.Serial)
+		}
+	}
+
+	if slot.Volume == nil {
+		return false, e.save()
+	}
+
+	serial := slot.Volume.Serial
+	reason := fmt.Sprintf("imported from mailslot %d", slot.Addr)
+
+	vol, ok := e.st.Volumes[serial]
+	if !ok {
+		vol = &tape.Volume{
+			Serial:   serial,
+			Category: inv.Classify(serial, e.prefixCleaning),
+		}
+
+		e.st.Volumes[serial] = vol
+
+		e.record(serial, tape.UnknownVolume, vol.Category, reason)
+	} else if bitmask.IsSet(vol.Flags, tape.StatusOffsite) {
+		if vol.Location == slot.Location {
+			// exported, but not yet removed from the mailslot
+			log.Debug.Printf("inv/embedded.Import: %v is awaiting removal from mailslot %d", serial, slot.Addr)
+			return false, nil
+		}
+
+		bitmask.Clear(&vol.Flags, tape.StatusOffsite)
+
+		e.record(serial, vol.Category, vol.Category, reason)
+	}
+
+	vol.Location = slot.Location
+
+	e.touch(serial)
+
+	return true, e.save()
+}
+
+func (e *embedded) Export(serial tape.Serial, chgr changer.Changer) (tape.Location, error) {
+	const op = "inv/embedded.Export"
+
+	slots, err := chgr.Status()
+	if err != nil {
+		return tape.Location{}, errors.E(op, err)
+	}
+
+	e.mu.Lock()
+
+	vol, err := e.volume(op, serial)
+	if err != nil {
+		e.mu.Unlock()
+		return tape.Location{}, err
+	}
+
+	if vol.Location.Category != tape.StorageSlot {
+		e.mu.Unlock()
+		return tape.Location{}, errors.E(op, errors.Invalid, errors.Strf("volume %v is not in a storage slot", serial))
+	}
+
+	if vol.Category == tape.Allocating || vol.Category == tape.Allocated {
+		e.mu.Unlock()
+		return tape.Location{}, errors.E(op, errors.Invalid, errors.Strf("volume %v is being allocated", serial))
+	}
+
+	dst, err := e.free(slots[tape.ImportExportSlot], tape.ImportExportSlot)
+	e.mu.Unlock()
+
+	if err != nil {
+		return tape.Location{}, errors.E(op, err)
+	}
+
+	if err := e.Transfer(serial, dst, chgr); err != nil {
+		return tape.Location{}, errors.E(op, err)
+	}
+
+	err = e.move(serial, func(vol *tape.Volume) {
+		bitmask.Set(&vol.Flags, tape.StatusOffsite)
+
+		e.record(serial, vol.Category, vol.Category, fmt.Sprintf("exported to mailslot %d", dst.Addr))
+	})
+
+	if err != nil {
+		return tape.Location{}, errors.E(op, err)
+	}
+
+	return dst, nil
+}
+
+// free returns the first of the given slots that is neither occupied, the
+// home of a mounted volume nor part of a pending move. e.mu MUST be held.
+func (e *embedded) free(slots tape.Slots, category tape.SlotCategory) (tape.Location, error) {
+	for _, slot := range slots {
+		if slot.Category != category || slot.Volume != nil {
+			continue
+		}
+
+		if e.occupant(slot.Location) != nil || e.homeOf(slot.Location) != nil || e.pending(slot.Location) {
+			continue
+		}
+
+		return slot.Location, nil
+	}
+
+	return tape.Location{}, errors.E(errors.NotExist, errors.Strf("no free %v slots", category))
+}
+
+// homeOf returns the volume having the given location as its home (if
+// any). e.mu MUST be held.
+func (e *embedded) homeOf(loc tape.Location) *tape.Volume {
+	for _, vol := range e.st.Volumes {
+		if vol.Home == loc {
+			return vol
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,279 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedded_test
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"tapr.space/bitmask"
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/changer/fake"
+	"tapr.space/store/tape/inv"
+)
+
+// operator is a changer whose import/export slots are tended by an
+// operator. Volumes in a slot taken out by the operator are hidden from the
+// status until they are put back in.
+type operator struct {
+	changer.Changer
+
+	mu  sync.Mutex
+	out map[tape.Addr]bool
+}
+
+func (op *operator) Status() (map[tape.SlotCategory]tape.Slots, error) {
+	slots, err := op.Changer.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	// the slots are owned by the fake changer; hide volumes in a copy
+	status := make(map[tape.SlotCategory]tape.Slots, len(slots))
+	for cat, ss := range slots {
+		status[cat] = ss
+	}
+
+	ix := make(tape.Slots, len(slots[tape.ImportExportSlot]))
+	for i, slot := range slots[tape.ImportExportSlot] {
+		if op.out[slot.Addr] {
+			slot.Volume = nil
+		}
+
+		ix[i] = slot
+	}
+
+	status[tape.ImportExportSlot] = ix
+
+	return status, nil
+}
+
+// take takes the volume out of the import/export slot.
+func (op *operator) take(addr tape.Addr) {
+	op.mu.Lock()
+	op.out[addr] = true
+	op.mu.Unlock()
+}
+
+// insert puts the volume back into the import/export slot.
+func (op *operator) insert(addr tape.Addr) {
+	op.mu.Lock()
+	delete(op.out, addr)
+	op.mu.Unlock()
+}
+
+// mailslots returns an audited inventory of a library with eight storage
+// slots, holding the given volumes, and two import/export slots (addresses
+// 9 and 10) tended by an operator. The import/export slots are audited as
+// taken out.
+func mailslots(t *testing.T, vols []tape.Volume) (inv.Inventory, changer.Changer, *operator, func()) {
+	dir, err := ioutil.TempDir("", "embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invdb := open(t, dir, nil)
+
+	c, err := fake.New(map[string]interface{}{
+		"transfer": "2",
+		"storage":  "8",
+		"ix":       "2",
+		"volumes":  "0",
+		"vols":     vols,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op := &operator{Changer: c, out: map[tape.Addr]bool{9: true, 10: true}}
+	chgr := changer.Named("primary", op)
+
+	if _, err := invdb.Audit(chgr, false); err != nil {
+		t.Fatal(err)
+	}
+
+	return invdb, chgr, op, func() { os.RemoveAll(dir) }
+}
+
+func storageSlot(addr tape.Addr) tape.Location {
+	return tape.Location{Addr: addr, Category: tape.StorageSlot}
+}
+
+func ixSlot(addr tape.Addr) tape.Location {
+	return tape.Location{Addr: addr, Category: tape.ImportExportSlot}
+}
+
+func TestImportUnknown(t *testing.T) {
+	invdb, chgr, op, cleanup := mailslots(t, []tape.Volume{
+		{Serial: "A00000L7", Location: storageSlot(1)},
+		{Serial: "B00000L7", Location: ixSlot(9)},
+		{Serial: "CLN001L1", Location: ixSlot(10)},
+	})
+	defer cleanup()
+
+	if _, err := invdb.Info("B00000L7"); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("got %v, want volume unknown before import", err)
+	}
+
+	op.insert(9)
+	op.insert(10)
+
+	imported, err := invdb.Import(chgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(imported) != 2 {
+		t.Fatalf("imported %d volumes, want 2", len(imported))
+	}
+
+	want := map[tape.Serial]tape.VolumeCategory{
+		"B00000L7": tape.Scratch,
+		"CLN001L1": tape.Cleaning,
+	}
+
+	for _, vol := range imported {
+		if vol.Category != want[vol.Serial] {
+			t.Errorf("%v: got category %v, want %v", vol.Serial, vol.Category, want[vol.Serial])
+		}
+
+		// slot 1 is occupied and slot 8 holds the cleaning cartridge
+		if vol.Location.Category != tape.StorageSlot || vol.Location.Addr == 1 || vol.Location.Addr == 8 {
+			t.Errorf("%v: got location %v, want a free storage slot", vol.Serial, vol.Location)
+		}
+
+		ts, err := invdb.History(vol.Serial)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ts) != 1 || ts[0].From != tape.UnknownVolume || ts[0].To != want[vol.Serial] {
+			t.Errorf("%v: got history %v, want a single transition to %v", vol.Serial, ts, want[vol.Serial])
+		}
+	}
+
+	// the mailslots are empty now
+	if imported, err := invdb.Import(chgr); err != nil || len(imported) != 0 {
+		t.Errorf("second import: got %v, %v; want nothing imported", imported, err)
+	}
+}
+
+func TestReimportOffsite(t *testing.T) {
+	invdb, chgr, op, cleanup := mailslots(t, []tape.Volume{
+		{Serial: "A00000L7", Location: storageSlot(1)},
+		{Serial: "A00001L7", Location: storageSlot(2)},
+	})
+	defer cleanup()
+
+	op.insert(9)
+	op.insert(10)
+
+	dst, err := invdb.Export("A00000L7", chgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// exported volumes are not imported again until they have been taken
+	// out of the mailslot
+	if imported, err := invdb.Import(chgr); err != nil || len(imported) != 0 {
+		t.Fatalf("import before removal: got %v, %v; want nothing imported", imported, err)
+	}
+
+	vol, err := invdb.Info("A00000L7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Location != dst || !bitmask.IsSet(vol.Flags, tape.StatusOffsite) {
+		t.Fatalf("got %v, want offsite volume in %v", vol, dst)
+	}
+
+	op.take(dst.Addr)
+
+	if imported, err := invdb.Import(chgr); err != nil || len(imported) != 0 {
+		t.Fatalf("import after removal: got %v, %v; want nothing imported", imported, err)
+	}
+
+	vol, err = invdb.Info("A00000L7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Location != (tape.Location{}) || !bitmask.IsSet(vol.Flags, tape.StatusOffsite) {
+		t.Fatalf("got %v, want offsite volume outside the library", vol)
+	}
+
+	// the volume is brought back
+	op.insert(dst.Addr)
+
+	imported, err := invdb.Import(chgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(imported) != 1 || imported[0].Serial != "A00000L7" {
+		t.Fatalf("got %v, want A00000L7 imported", imported)
+	}
+
+	vol = imported[0]
+	if vol.Location.Category != tape.StorageSlot || bitmask.IsSet(vol.Flags, tape.StatusOffsite) {
+		t.Errorf("got %v, want onsite volume in a storage slot", vol)
+	}
+
+	if vol.Category != tape.Scratch {
+		t.Errorf("got category %v, want the category kept", vol.Category)
+	}
+
+	ts, err := invdb.History("A00000L7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(ts); n != 3 || ts[1].Reason != "exported to mailslot 9" || ts[2].Reason != "imported from mailslot 9" {
+		t.Errorf("got history %v, want discovery, export and import", ts)
+	}
+}
+
+func TestExportFull(t *testing.T) {
+	invdb, chgr, cleanup := setup(t, nil)
+	defer cleanup()
+
+	for _, serial := range []tape.Serial{"A00000L7", "A00001L7"} {
+		if _, err := invdb.Export(serial, chgr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := invdb.Export("A00002L7", chgr); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("got %v, want error of kind NotExist", err)
+	}
+
+	vol, err := invdb.Info("A00002L7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := tape.Location{Addr: 3, Category: tape.StorageSlot, Changer: "primary"}
+	if vol.Location != want || vol.Flags != 0 {
+		t.Errorf("got %v, want volume left in %v", vol, want)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedded
+
+import (
+	"tapr.space/errors"
+	"tapr.space/log"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/inv"
+)
+
+// migrations is the ordered list of state migrations; migrations[i]
+// migrates the state from version i to version i+1. Migrations are
+// forward-only; never change a released migration, append a new one.
+var migrations = []func(st *state) error{
+	// version 1: initial version
+	func(st *state) error { return nil },
+
+	// version 2: volume history
+	func(st *state) error {
+		if st.History == nil {
+			st.History = make(map[tape.Serial][]tape.Transition)
+		}
+
+		return nil
+	},
+
+	// version 3: locations belong to a changer; previously only the
+	// "primary" changer was used
+	func(st *state) error {
+		for _, vol := range st.Volumes {
+			for _, loc := range []*tape.Location{&vol.Location, &vol.Home} {
+				if *loc != (tape.Location{}) && loc.Changer == "" {
+					loc.Changer = "primary"
+				}
+			}
+		}
+
+		return nil
+	},
+
+	// version 4: changer moves are journaled
+	func(st *state) error {
+		if st.Intents == nil {
+			st.Intents = make(map[int64]*inv.Intent)
+		}
+
+		return nil
+	},
+}
+
+// Reset resets the inventory database.
+func (e *embedded) Reset() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.st = newState()
+
+	return e.migrate()
+}
+
+// Migrate implements inv.Inventory.
+func (e *embedded) Migrate() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.migrate()
+}
+
+// migrate applies all pending migrations. e.mu MUST be held.
+func (e *embedded) migrate() error {
+	const op = "inv/embedded.Migrate"
+
+	if e.st.Version > len(migrations) {
+		return errors.E(op, errors.Invalid, errors.Strf("inventory version %d is newer than the latest known version %d", e.st.Version, len(migrations)))
+	}
+
+	for v := e.st.Version; v < len(migrations); v++ {
+		log.Info.Printf("%s: migrating inventory from version %d to %d", op, v, v+1)
+
+		if err := migrations[v](e.st); err != nil {
+			return errors.E(op, errors.Strf("migration %d failed: %v", v+1, err))
+		}
+
+		e.st.Version = v + 1
+	}
+
+	// migrations may change any part of the state
+	return e.checkpoint()
+}
+
+// Version implements inv.Inventory.
+func (e *embedded) Version() (int, int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.st.Version, len(migrations), nil
+}
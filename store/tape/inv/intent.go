@@ -0,0 +1,130 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inv
+
+import (
+	"time"
+
+	"tapr.space/bitmask"
+	"tapr.space/store/tape"
+)
+
+// A MoveKind identifies the kind of changer move.
+type MoveKind string
+
+// Kinds of changer moves.
+const (
+	LoadMove     MoveKind = "load"
+	UnloadMove   MoveKind = "unload"
+	TransferMove MoveKind = "transfer"
+)
+
+// An Intent records a changer move before it is carried out. The intent is
+// removed once the inventory reflects the outcome of the move; intents that
+// remain after a crash are replayed by Recover.
+type Intent struct {
+	ID     int64
+	Kind   MoveKind
+	Serial tape.Serial
+
+	// Src and Dst are the source and destination of the move.
+	Src, Dst tape.Location
+
+	// Home and Flags are the home location and flags of the volume before
+	// the move.
+	Home  tape.Location
+	Flags uint32
+
+	// Time is the time the intent was recorded.
+	Time time.Time
+}
+
+// An Outcome describes where a volume ended up after a move.
+type Outcome int
+
+// Outcomes of a move.
+const (
+	// Completed means the volume reached the destination.
+	Completed Outcome = iota
+
+	// Aborted means the volume is still in the source slot.
+	Aborted
+
+	// Relocated means the volume ended up in a third location.
+	Relocated
+
+	// Lost means the changer no longer sees the volume.
+	Lost
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Completed:
+		return "completed"
+	case Aborted:
+		return "aborted"
+	case Relocated:
+		return "relocated"
+	case Lost:
+		return "lost"
+	}
+
+	panic("unknown outcome")
+}
+
+// Resolve determines the outcome of the move recorded by the intent from
+// the slots reported by the changer. For relocated volumes, the location of
+// the volume is returned.
+func Resolve(in Intent, slots map[tape.SlotCategory]tape.Slots) (Outcome, tape.Location) {
+	for _, cat := range tape.SlotCategories {
+		for _, slot := range slots[cat] {
+			if slot.Volume == nil || slot.Volume.Serial != in.Serial {
+				continue
+			}
+
+			switch slot.Location {
+			case in.Dst:
+				return Completed, slot.Location
+			case in.Src:
+				return Aborted, slot.Location
+			}
+
+			return Relocated, slot.Location
+		}
+	}
+
+	return Lost, tape.Location{}
+}
+
+// Settled returns the home location and flags of a volume that ended up in
+// the given location after the move recorded by the intent.
+func (in Intent) Settled(loc tape.Location) (home tape.Location, flags uint32) {
+	flags = in.Flags
+	bitmask.Clear(&flags, tape.StatusTransfering)
+
+	if loc.Category != tape.TransferSlot {
+		bitmask.Clear(&flags, tape.StatusMounted)
+		return tape.Location{}, flags
+	}
+
+	bitmask.Set(&flags, tape.StatusMounted)
+
+	// a volume loaded from a slot returns there when unloaded
+	if in.Src.Category != tape.TransferSlot {
+		return in.Src, flags
+	}
+
+	return in.Home, flags
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inv_test
+
+import (
+	"testing"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/inv"
+)
+
+func TestResolve(t *testing.T) {
+	in := inv.Intent{
+		Kind:   inv.LoadMove,
+		Serial: "A00000L7",
+		Src:    loc(1, tape.StorageSlot),
+		Dst:    loc(1, tape.TransferSlot),
+	}
+
+	for _, tc := range []struct {
+		name    string
+		slots   map[tape.SlotCategory]tape.Slots
+		outcome inv.Outcome
+		loc     tape.Location
+	}{
+		{
+			name: "completed",
+			slots: map[tape.SlotCategory]tape.Slots{
+				tape.StorageSlot:  {slot(loc(1, tape.StorageSlot), "")},
+				tape.TransferSlot: {slot(loc(1, tape.TransferSlot), "A00000L7")},
+			},
+			outcome: inv.Completed,
+			loc:     loc(1, tape.TransferSlot),
+		},
+		{
+			name: "aborted",
+			slots: map[tape.SlotCategory]tape.Slots{
+				tape.StorageSlot:  {slot(loc(1, tape.StorageSlot), "A00000L7")},
+				tape.TransferSlot: {slot(loc(1, tape.TransferSlot), "")},
+			},
+			outcome: inv.Aborted,
+			loc:     loc(1, tape.StorageSlot),
+		},
+		{
+			name: "relocated",
+			slots: map[tape.SlotCategory]tape.Slots{
+				tape.StorageSlot:      {slot(loc(1, tape.StorageSlot), "")},
+				tape.ImportExportSlot: {slot(loc(9, tape.ImportExportSlot), "A00000L7")},
+			},
+			outcome: inv.Relocated,
+			loc:     loc(9, tape.ImportExportSlot),
+		},
+		{
+			name: "lost",
+			slots: map[tape.SlotCategory]tape.Slots{
+				tape.StorageSlot:  {slot(loc(1, tape.StorageSlot), "")},
+				tape.TransferSlot: {slot(loc(1, tape.TransferSlot), "")},
+			},
+			outcome: inv.Lost,
+		},
+	} {
+		outcome, l := inv.Resolve(in, tc.slots)
+		if outcome != tc.outcome || l != tc.loc {
+			t.Errorf("%s: got %v at %v, want %v at %v", tc.name, outcome, l, tc.outcome, tc.loc)
+		}
+	}
+}
+
+func TestSettled(t *testing.T) {
+	in := inv.Intent{
+		Kind:  inv.LoadMove,
+		Src:   loc(1, tape.StorageSlot),
+		Dst:   loc(1, tape.TransferSlot),
+		Flags: tape.StatusTransfering,
+	}
+
+	home, flags := in.Settled(in.Dst)
+	if home != in.Src || flags != tape.StatusMounted {
+		t.Errorf("load: got home %v and flags %b", home, flags)
+	}
+
+	home, flags = in.Settled(in.Src)
+	if home != (tape.Location{}) || flags != 0 {
+		t.Errorf("aborted load: got home %v and flags %b", home, flags)
+	}
+
+	// a transfer between drives keeps the original home
+	in = inv.Intent{
+		Kind:  inv.TransferMove,
+		Src:   loc(1, tape.TransferSlot),
+		Dst:   loc(2, tape.TransferSlot),
+		Home:  loc(5, tape.StorageSlot),
+		Flags: tape.StatusMounted | tape.StatusTransfering,
+	}
+
+	home, flags = in.Settled(in.Dst)
+	if home != in.Home || flags != tape.StatusMounted {
+		t.Errorf("transfer: got home %v and flags %b", home, flags)
+	}
+}
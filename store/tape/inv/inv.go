@@ -50,11 +50,21 @@ func Create(name string, cfg map[string]string) (Inventory, error) {
 	return fn(cfg)
 }
 
+// A Provider is a store backed by an inventory.
+type Provider interface {
+	// Inventory returns the inventory of the store.
+	Inventory() Inventory
+
+	// Changers returns the media changers of the store by name.
+	Changers() map[string]changer.Changer
+}
+
 // An Inventory tracks volumes in a tape store. An inventory MUST be safe for
 // concurrent use.
 type Inventory interface {
 	// Load transfers a volume to a drive in the context of
-	// the given tape.
+	// the given tape. The volume and the drive must belong to the same
+	// changer.
 	Load(tape.Serial, tape.Location, changer.Changer) error
 
 	// Unload transfers a volume from a drive in the context of
@@ -65,13 +75,36 @@ type Inventory interface {
 	// the given tape.
 	Transfer(tape.Serial, tape.Location, changer.Changer) error
 
-	// Audit performs an inventory audit by inspecting the physical state of the
-	// backing tape and makes sure the inventory reflects that state.
-	Audit(changer.Changer) error
-
-	// Alloc allocates a filling (or scratch) volume from the inventory
-	// and mounts it into the device at the given destination.
-	Alloc() (tape.Serial, error)
+	// Import moves the volumes found in the import/export slots of the
+	// changer to free storage slots. Unknown volumes are registered as
+	// scratch or cleaning volumes by their serial prefix and volumes
+	// returning from offsite are brought back into the library. The
+	// imported volumes are returned.
+	Import(changer.Changer) ([]tape.Volume, error)
+
+	// Export moves a volume from its storage slot to a free import/export
+	// slot and marks it offsite. The import/export slot is returned and
+	// recorded in the volume history.
+	Export(tape.Serial, changer.Changer) (tape.Location, error)
+
+	// Recover replays the moves of the changer that were interrupted,
+	// for instance by a crash, and repairs the inventory according to the
+	// physical state reported by the changer. The replayed intents are
+	// returned.
+	Recover(changer.Changer) ([]Intent, error)
+
+	// Audit performs an inventory audit by inspecting the physical state of
+	// the changer and returns the differences found. Unless dryRun is set,
+	// the inventory is updated to reflect the physical state: new volumes
+	// are registered, moved volumes are relocated and volumes no longer
+	// seen by the changer are marked missing.
+	Audit(chgr changer.Changer, dryRun bool) (AuditResult, error)
+
+	// Alloc allocates a filling (or scratch) volume from the storage slots
+	// of the named changer. Volumes on which any of the given path names is
+	// in use by the data of a renamed file are skipped, such that the files
+	// can be continued on the allocated volume.
+	Alloc(changer string, paths ...tapr.PathName) (tape.Serial, error)
 
 	// Loaded returns whether or not the given drive is loaded.
 	Loaded(tape.Location) (bool, tape.Serial, error)
@@ -79,20 +112,66 @@ type Inventory interface {
 	// Status returns a list of known volumes.
 	Volumes() ([]tape.Volume, error)
 
-	// Update updates volume information
+	// Update updates volume information. A change of category is subject to
+	// the volume state machine as for Transition.
 	Update(tape.Volume) error
 
+	// Transition moves a volume to the given category. Moves not permitted
+	// by the volume state machine are rejected with an error of kind
+	// errors.Invalid. Every transition is recorded in the volume history
+	// along with the reason.
+	Transition(serial tape.Serial, to tape.VolumeCategory, reason string) error
+
+	// History returns the recorded transitions of a volume, oldest first.
+	History(tape.Serial) ([]tape.Transition, error)
+
 	// Info retrieves info about a volume.
 	Info(tape.Serial) (tape.Volume, error)
 
-	// Reset resets the inventory database.
+	// Reset resets the inventory database, discarding all records, and
+	// migrates it to the latest schema version.
 	Reset() error
 
-	// Lookup looks up the given path name and returns a list of volumes that
-	// contains the file/directory.
-	Lookup(tapr.PathName) (tape.Volume, error)
-
-	// Create creates a new node in the directory tree located on the volume
-	// associated with the given volume serial.
-	Create(path tapr.PathName, serial string) error
+	// Migrate applies all pending schema migrations in order. Migrations are
+	// forward-only and never discard records.
+	Migrate() error
+
+	// Version returns the current schema version of the inventory database
+	// and the latest version known by the implementation.
+	Version() (current, latest int, err error)
+
+	// Lookup looks up the given path name in the file catalog. If the path
+	// name is unknown, an error of kind errors.NotExist is returned.
+	Lookup(tapr.PathName) (tape.File, error)
+
+	// List returns the catalog entries with path names beginning with the
+	// given prefix, ordered by path name.
+	List(prefix tapr.PathName) ([]tape.File, error)
+
+	// Create creates a new catalog entry for a file written to the volume
+	// associated with the given volume serial. If the entry already exists,
+	// its extents are replaced by a single empty extent on the given volume.
+	// If the path name is still in use on the volume by the data of a renamed
+	// file, an error of kind errors.Exist is returned.
+	Create(path tapr.PathName, serial tape.Serial) error
+
+	// Extend records an extent of the file. An existing extent with the same
+	// offset is replaced. If a new extent is recorded on a volume where the
+	// path name is still in use by the data of a renamed file, an error of
+	// kind errors.Exist is returned.
+	Extend(path tapr.PathName, ext tape.Extent) error
+
+	// Commit updates the size, modification time, checksum and dataset of an
+	// existing catalog entry. The extents of the entry are left untouched and
+	// a zero dataset keeps the current dataset.
+	Commit(tape.File) error
+
+	// Rename renames a catalog entry. The data of the file is not moved on
+	// the volumes; instead the extents record the path name the data is
+	// stored under. If newpath already exists, an error of kind errors.Exist
+	// is returned.
+	Rename(oldpath, newpath tapr.PathName) error
+
+	// Remove removes a catalog entry along with its extents.
+	Remove(tapr.PathName) error
 }
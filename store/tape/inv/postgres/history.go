@@ -0,0 +1,113 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+)
+
+// execer is implemented by both *sqlx.DB and *sqlx.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// record records a volume transition in the history.
+func record(db execer, serial tape.Serial, from, to tape.VolumeCategory, reason string) error {
+	stmt := `
+		INSERT INTO volume_history (serial, old_category, new_category, reason)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err := db.Exec(stmt, serial, from, to, reason)
+
+	return err
+}
+
+func (p *postgres) Transition(serial tape.Serial, to tape.VolumeCategory, reason string) error {
+	const op = "inv/postgres.Transition"
+
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	var from tape.VolumeCategory
+	if err := tx.Get(&from, `SELECT category FROM volumes WHERE serial = $1 FOR UPDATE`, serial); err != nil {
+		if err == sql.ErrNoRows {
+			err = errors.E(errors.NotExist, errors.Strf("unknown volume %v", serial))
+		}
+
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	if err := tape.CheckTransition(from, to); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	if from == to {
+		return commit(op, tx)
+	}
+
+	if _, err := tx.Exec(`UPDATE volumes SET category = $1 WHERE serial = $2`, to, serial); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	if err := record(tx, serial, from, to, reason); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	return commit(op, tx)
+}
+
+type rtransition struct {
+	Time   time.Time           `db:"time"`
+	From   tape.VolumeCategory `db:"old_category"`
+	To     tape.VolumeCategory `db:"new_category"`
+	Reason string              `db:"reason"`
+}
+
+func (p *postgres) History(serial tape.Serial) ([]tape.Transition, error) {
+	const op = "inv/postgres.History"
+
+	var rs []rtransition
+
+	stmt := `
+		SELECT time, old_category, new_category, reason
+		FROM volume_history
+		WHERE serial = $1
+		ORDER BY time
+	`
+
+	if err := p.db.Select(&rs, stmt, serial); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	ts := make([]tape.Transition, len(rs))
+	for i, r := range rs {
+		ts[i] = tape.Transition{
+			Serial: serial,
+			From:   r.From,
+			To:     r.To,
+			Time:   r.Time,
+			Reason: r.Reason,
+		}
+	}
+
+	return ts, nil
+}
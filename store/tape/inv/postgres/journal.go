@@ -0,0 +1,277 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"tapr.space/bitmask"
+	"tapr.space/errors"
+	"tapr.space/log"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
+)
+
+type rintent struct {
+	ID     int64         `db:"id"`
+	Kind   inv.MoveKind  `db:"kind"`
+	Serial tape.Serial   `db:"serial"`
+	Src    tape.Location `db:"src"`
+	Dst    tape.Location `db:"dst"`
+	Home   tape.Location `db:"home"`
+	Flags  uint32        `db:"flags"`
+	Time   time.Time     `db:"time"`
+}
+
+// nullable returns nil for the zero location such that it is stored as
+// NULL. Otherwise it returns a pointer to the location; only *tape.Location
+// implements driver.Valuer.
+func nullable(loc tape.Location) interface{} {
+	if loc == (tape.Location{}) {
+		return nil
+	}
+
+	return &loc
+}
+
+// intentArgs returns the arguments recording the intent in volume_intents.
+func intentArgs(in inv.Intent) []interface{} {
+	return []interface{}{in.Kind, in.Serial, &in.Src, &in.Dst, nullable(in.Home), fmt.Sprintf("%b", in.Flags)}
+}
+
+// placeArgs returns the arguments placing the volume at loc with the given
+// home and flags.
+func placeArgs(serial tape.Serial, loc, home tape.Location, flags uint32) []interface{} {
+	return []interface{}{nullable(loc), nullable(home), fmt.Sprintf("%b", flags), serial}
+}
+
+// begin records the intent to move a volume to dst and marks the volume as
+// in transit. The check function validates the move and may adjust the
+// destination.
+func (p *postgres) begin(op string, kind inv.MoveKind, serial tape.Serial, dst tape.Location, check func(r *rvol, dst *tape.Location) error) (inv.Intent, error) {
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return inv.Intent{}, errors.E(op, err)
+	}
+
+	var r rvol
+	if err := tx.Get(&r, `SELECT serial, location, home, category, flags, mounts FROM volumes WHERE serial = $1 FOR UPDATE`, serial); err != nil {
+		if err == sql.ErrNoRows {
+			err = errors.E(errors.NotExist, errors.Strf("unknown volume %v", serial))
+		}
+
+		return inv.Intent{}, rollback(op, tx, errors.E(op, err))
+	}
+
+	if err := check(&r, &dst); err != nil {
+		return inv.Intent{}, rollback(op, tx, errors.E(op, err))
+	}
+
+	in := inv.Intent{
+		Kind:   kind,
+		Serial: serial,
+		Src:    r.Location,
+		Dst:    dst,
+		Home:   r.Home,
+		Flags:  r.Flags,
+	}
+
+	stmt := `
+		INSERT INTO volume_intents (kind, serial, src, dst, home, flags)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, time
+	`
+
+	row := tx.QueryRowx(stmt, intentArgs(in)...)
+	if err := row.Scan(&in.ID, &in.Time); err != nil {
+		return inv.Intent{}, rollback(op, tx, errors.E(op, err))
+	}
+
+	// the volume is in transit until the move is settled; a loaded volume
+	// claims its home slot right away.
+	home := in.Home
+	if kind == inv.LoadMove {
+		home = in.Src
+	}
+
+	flags := in.Flags
+	bitmask.Set(&flags, tape.StatusTransfering)
+
+	stmt = `
+		UPDATE volumes
+		SET
+			location = NULL,
+			home = $1,
+			flags = $2
+		WHERE serial = $3
+	`
+
+	if _, err := tx.Exec(stmt, nullable(home), fmt.Sprintf("%b", flags), serial); err != nil {
+		return inv.Intent{}, rollback(op, tx, errors.E(op, err))
+	}
+
+	if err := commit(op, tx); err != nil {
+		return inv.Intent{}, errors.E(op, err)
+	}
+
+	return in, nil
+}
+
+// carry carries out the move recorded by the intent. If the move fails, the
+// inventory is settled according to the physical state of the changer.
+func (p *postgres) carry(op string, in inv.Intent, chgr changer.Changer, move func(src, dst tape.Location) error) error {
+	if err := move(in.Src, in.Dst); err != nil {
+		if _, serr := p.settle(op, in, chgr); serr != nil {
+			log.Error.Printf("%s: could not settle failed %s of %v: %v", op, in.Kind, in.Serial, serr)
+		}
+
+		return errors.E(op, err)
+	}
+
+	return p.apply(op, in, inv.Completed, in.Dst)
+}
+
+// settle resolves the outcome of the move recorded by the intent from the
+// changer status and applies it.
+func (p *postgres) settle(op string, in inv.Intent, chgr changer.Changer) (inv.Outcome, error) {
+	slots, err := chgr.Status()
+	if err != nil {
+		return 0, err
+	}
+
+	outcome, loc := inv.Resolve(in, slots)
+
+	return outcome, p.apply(op, in, outcome, loc)
+}
+
+// apply updates the volume moved according to the outcome of the move and
+// removes the intent.
+func (p *postgres) apply(op string, in inv.Intent, outcome inv.Outcome, loc tape.Location) error {
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	var category tape.VolumeCategory
+	if err := tx.Get(&category, `SELECT category FROM volumes WHERE serial = $1 FOR UPDATE`, in.Serial); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	stmt := `
+		UPDATE volumes
+		SET
+			location = $1,
+			home = $2,
+			flags = $3
+		WHERE serial = $4
+	`
+
+	switch outcome {
+	case inv.Completed, inv.Relocated:
+		home, flags := in.Settled(loc)
+		if _, err := tx.Exec(stmt, placeArgs(in.Serial, loc, home, flags)...); err != nil {
+			return rollback(op, tx, errors.E(op, err))
+		}
+
+		if outcome == inv.Completed && in.Kind == inv.LoadMove {
+			if _, err := tx.Exec(`UPDATE volumes SET mounts = mounts + 1 WHERE serial = $1`, in.Serial); err != nil {
+				return rollback(op, tx, errors.E(op, err))
+			}
+
+			if category == tape.Allocating {
+				if _, err := tx.Exec(`UPDATE volumes SET category = 'allocated' WHERE serial = $1`, in.Serial); err != nil {
+					return rollback(op, tx, errors.E(op, err))
+				}
+
+				if err := record(tx, in.Serial, tape.Allocating, tape.Allocated, "loaded for formatting"); err != nil {
+					return rollback(op, tx, errors.E(op, err))
+				}
+			}
+		}
+
+	case inv.Aborted:
+		if _, err := tx.Exec(stmt, placeArgs(in.Serial, in.Src, in.Home, in.Flags)...); err != nil {
+			return rollback(op, tx, errors.E(op, err))
+		}
+
+	case inv.Lost:
+		flags := in.Flags
+		bitmask.Clear(&flags, tape.StatusTransfering)
+		bitmask.Clear(&flags, tape.StatusMounted)
+
+		if _, err := tx.Exec(stmt, placeArgs(in.Serial, tape.Location{}, tape.Location{}, flags)...); err != nil {
+			return rollback(op, tx, errors.E(op, err))
+		}
+
+		if category != tape.Missing {
+			if _, err := tx.Exec(`UPDATE volumes SET category = 'missing' WHERE serial = $1`, in.Serial); err != nil {
+				return rollback(op, tx, errors.E(op, err))
+			}
+
+			if err := record(tx, in.Serial, category, tape.Missing, fmt.Sprintf("lost during %s", in.Kind)); err != nil {
+				return rollback(op, tx, errors.E(op, err))
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM volume_intents WHERE id = $1`, in.ID); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	return commit(op, tx)
+}
+
+func (p *postgres) Recover(chgr changer.Changer) ([]inv.Intent, error) {
+	const op = "inv/postgres.Recover"
+
+	var rs []rintent
+
+	stmt := `
+		SELECT id, kind, serial, src, dst, home, flags, time
+		FROM volume_intents
+		WHERE (src).changer = $1
+		ORDER BY id
+	`
+
+	if err := p.db.Select(&rs, stmt, changer.Name(chgr)); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	ins := make([]inv.Intent, len(rs))
+	for i, r := range rs {
+		ins[i] = inv.Intent{
+			ID:     r.ID,
+			Kind:   r.Kind,
+			Serial: r.Serial,
+			Src:    r.Src,
+			Dst:    r.Dst,
+			Home:   r.Home,
+			Flags:  r.Flags,
+			Time:   r.Time,
+		}
+
+		outcome, err := p.settle(op, ins[i], chgr)
+		if err != nil {
+			return ins[:i], errors.E(op, err)
+		}
+
+		log.Printf("%s: interrupted %s of %v from %v to %v %v", op, ins[i].Kind, ins[i].Serial, ins[i].Src, ins[i].Dst, outcome)
+	}
+
+	return ins, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/inv"
+)
+
+// convert runs the arguments through the conversion done by database/sql
+// before they are handed to the driver.
+func convert(t *testing.T, args []interface{}) []driver.Value {
+	vs := make([]driver.Value, len(args))
+	for i, arg := range args {
+		v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			t.Fatalf("argument %d (%T): %v", i, arg, err)
+		}
+
+		vs[i] = v
+	}
+
+	return vs
+}
+
+func TestIntentArgs(t *testing.T) {
+	in := inv.Intent{
+		Kind:   inv.LoadMove,
+		Serial: "A00000L7",
+		Src:    tape.Location{Addr: 1, Category: tape.StorageSlot, Changer: "primary"},
+		Dst:    tape.Location{Addr: 2, Category: tape.TransferSlot, Changer: "primary"},
+		Flags:  1,
+	}
+
+	vs := convert(t, intentArgs(in))
+
+	if want := `(1,storage,"primary")`; vs[2] != want {
+		t.Errorf("src = %v, want %v", vs[2], want)
+	}
+
+	if want := `(2,transfer,"primary")`; vs[3] != want {
+		t.Errorf("dst = %v, want %v", vs[3], want)
+	}
+
+	if vs[4] != nil {
+		t.Errorf("home = %v, want NULL", vs[4])
+	}
+}
+
+func TestPlaceArgs(t *testing.T) {
+	loc := tape.Location{Addr: 2, Category: tape.TransferSlot, Changer: "primary"}
+	home := tape.Location{Addr: 1, Category: tape.StorageSlot, Changer: "primary"}
+
+	vs := convert(t, placeArgs("A00000L7", loc, home, 0))
+
+	if want := `(2,transfer,"primary")`; vs[0] != want {
+		t.Errorf("location = %v, want %v", vs[0], want)
+	}
+
+	if want := `(1,storage,"primary")`; vs[1] != want {
+		t.Errorf("home = %v, want %v", vs[1], want)
+	}
+
+	vs = convert(t, placeArgs("A00000L7", tape.Location{}, tape.Location{}, 0))
+
+	if vs[0] != nil || vs[1] != nil {
+		t.Errorf("location, home = %v, %v, want NULL", vs[0], vs[1])
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"tapr.space/bitmask"
+	"tapr.space/errors"
+	"tapr.space/log"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
+)
+
+func (p *postgres) Import(chgr changer.Changer) ([]tape.Volume, error) {
+	const op = "inv/postgres.Import"
+
+	slots, err := chgr.Status()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	var imported []tape.Volume
+	for _, slot := range slots[tape.ImportExportSlot] {
+		ok, err := p.register(slot)
+		if err != nil {
+			return imported, errors.E(op, err)
+		}
+
+		if !ok {
+			continue
+		}
+
+		dst, err := p.free(slots[tape.StorageSlot], tape.StorageSlot)
+		if err != nil {
+			return imported, errors.E(op, err)
+		}
+
+		if err := p.Transfer(slot.Volume.Serial, dst, chgr); err != nil {
+			return imported, errors.E(op, err)
+		}
+
+		vol, err := p.Info(slot.Volume.Serial)
+		if err != nil {
+			return imported, errors.E(op, err)
+		}
+
+		imported = append(imported, vol)
+	}
+
+	return imported, nil
+}
+
+// register brings the inventory up to date with the given import/export
+// slot and returns true if the volume in the slot should be imported.
+func (p *postgres) register(slot tape.Slot) (bool, error) {
+	const op = "inv/postgres.register"
+
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+
+	var serial tape.Serial
+	if slot.Volume != nil {
+		serial = slot.Volume.Serial
+	}
+
+	// offsite volumes no longer in the slot they were exported to have been
+	// removed from the library.
+	_, err = tx.Exec(`
+		UPDATE volumes
+		SET location = NULL
+		WHERE location = ($1, $2, $3) AND serial <> $4
+	`, slot.Addr, slot.Category, slot.Changer, serial)
+
+	if err != nil {
+		return false, rollback(op, tx, err)
+	}
+
+	if slot.Volume == nil {
+		return false, commit(op, tx)
+	}
+
+	reason := fmt.Sprintf("imported from mailslot %d", slot.Addr)
+
+	var r rvol
+	err = tx.Get(&r, `
+		SELECT serial, location, home, category, flags, mounts
+		FROM volumes
+		WHERE serial = $1
+		FOR UPDATE
+	`, serial)
+
+	switch {
+	case err == sql.ErrNoRows:
+		category := inv.Classify(serial, p.prefixCleaning)
+
+		_, err = tx.Exec(`
+			INSERT INTO volumes (serial, location, category, flags)
+			VALUES ($1, ($2, $3, $4), $5, $6)
+		`, serial, slot.Addr, slot.Category, slot.Changer, category, fmt.Sprintf("%b", 0))
+
+		if err != nil {
+			return false, rollback(op, tx, err)
+		}
+
+		if err := record(tx, serial, tape.UnknownVolume, category, reason); err != nil {
+			return false, rollback(op, tx, err)
+		}
+
+		return true, commit(op, tx)
+
+	case err != nil:
+		return false, rollback(op, tx, err)
+	}
+
+	if bitmask.IsSet(r.Flags, tape.StatusOffsite) {
+		if r.Location == slot.Location {
+			// exported, but not yet removed from the mailslot
+			log.Debug.Printf("%s: %v is awaiting removal from mailslot %d", op, serial, slot.Addr)
+			return false, commit(op, tx)
+		}
+
+		bitmask.Clear(&r.Flags, tape.StatusOffsite)
+
+		if err := record(tx, serial, r.Category, r.Category, reason); err != nil {
+			return false, rollback(op, tx, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE volumes
+		SET
+			location = ($1, $2, $3),
+			flags = $4
+		WHERE serial = $5
+	`, slot.Addr, slot.Category, slot.Changer, fmt.Sprintf("%b", r.Flags), serial)
+
+	if err != nil {
+		return false, rollback(op, tx, err)
+	}
+
+	return true, commit(op, tx)
+}
+
+func (p *postgres) Export(serial tape.Serial, chgr changer.Changer) (tape.Location, error) {
+	const op = "inv/postgres.Export"
+
+	slots, err := chgr.Status()
+	if err != nil {
+		return tape.Location{}, errors.E(op, err)
+	}
+
+	vol, err := p.Info(serial)
+	if err != nil {
+		return tape.Location{}, errors.E(op, err)
+	}
+
+	if vol.Location.Category != tape.StorageSlot {
+		return tape.Location{}, errors.E(op, errors.Invalid, errors.Strf("volume %v is not in a storage slot", serial))
+	}
+
+	if vol.Category == tape.Allocating || vol.Category == tape.Allocated {
+		return tape.Location{}, errors.E(op, errors.Invalid, errors.Strf("volume %v is being allocated", serial))
+	}
+
+	dst, err := p.free(slots[tape.ImportExportSlot], tape.ImportExportSlot)
+	if err != nil {
+		return tape.Location{}, errors.E(op, err)
+	}
+
+	if err := p.Transfer(serial, dst, chgr); err != nil {
+		return tape.Location{}, errors.E(op, err)
+	}
+
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return tape.Location{}, errors.E(op, err)
+	}
+
+	var r rvol
+	if err := tx.Get(&r, `SELECT serial, location, home, category, flags, mounts FROM volumes WHERE serial = $1 FOR UPDATE`, serial); err != nil {
+		return tape.Location{}, rollback(op, tx, errors.E(op, err))
+	}
+
+	bitmask.Set(&r.Flags, tape.StatusOffsite)
+
+	if _, err := tx.Exec(`UPDATE volumes SET flags = $1 WHERE serial = $2`, fmt.Sprintf("%b", r.Flags), serial); err != nil {
+		return tape.Location{}, rollback(op, tx, errors.E(op, err))
+	}
+
+	if err := record(tx, serial, r.Category, r.Category, fmt.Sprintf("exported to mailslot %d", dst.Addr)); err != nil {
+		return tape.Location{}, rollback(op, tx, errors.E(op, err))
+	}
+
+	if err := commit(op, tx); err != nil {
+		return tape.Location{}, errors.E(op, err)
+	}
+
+	return dst, nil
+}
+
+// free returns the first of the given slots that is neither occupied, the
+// home of a mounted volume nor part of a pending move.
+func (p *postgres) free(slots tape.Slots, category tape.SlotCategory) (tape.Location, error) {
+	for _, slot := range slots {
+		if slot.Category != category || slot.Volume != nil {
+			continue
+		}
+
+		var claimed bool
+		err := p.db.Get(&claimed, `
+			SELECT EXISTS (
+				SELECT 1
+				FROM volumes
+				WHERE location = ($1, $2, $3) OR home = ($1, $2, $3)
+			) OR EXISTS (
+				SELECT 1
+				FROM volume_intents
+				WHERE src = ($1, $2, $3) OR dst = ($1, $2, $3)
+			)
+		`, slot.Addr, slot.Category, slot.Changer)
+
+		if err != nil {
+			return tape.Location{}, err
+		}
+
+		if !claimed {
+			return slot.Location, nil
+		}
+	}
+
+	return tape.Location{}, errors.E(errors.NotExist, errors.Strf("no free %v slots", category))
+}
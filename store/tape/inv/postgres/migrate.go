@@ -0,0 +1,196 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"tapr.space/errors"
+	"tapr.space/log"
+)
+
+// latestVersion returns the latest known schema version.
+func latestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// Reset resets the inventory database.
+func (p *postgres) Reset() error {
+	const op = "inv/postgres.Reset"
+
+	for _, stmt := range dropSchema {
+		if _, err := p.db.Exec(stmt); err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	return p.Migrate()
+}
+
+// Migrate applies all pending migrations. A database created before schema
+// migrations were introduced is adopted as version 1 first. Each migration is
+// applied in its own transaction with the migrations table locked such that
+// concurrent servers do not race to migrate.
+func (p *postgres) Migrate() error {
+	const op = "inv/postgres.Migrate"
+
+	if _, err := p.db.Exec(migrationsSchema); err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := p.adopt(op); err != nil {
+		return err
+	}
+
+	current, _, err := p.Version()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	ms, err := pending(current)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	for _, m := range ms {
+		if err := p.migrate(op, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pending returns the migrations to apply to a database at the given schema
+// version.
+func pending(current int) ([]migration, error) {
+	if current > latestVersion() {
+		return nil, errors.E(errors.Invalid, errors.Strf("database schema version %d is newer than the latest known version %d", current, latestVersion()))
+	}
+
+	for i, m := range migrations {
+		if m.version > current {
+			return migrations[i:], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// adopt stamps a database created before schema migrations were introduced
+// as version 1. Such a database has the volumes table of version 1, but its
+// catalog tables may be missing or of an older shape; they are brought up to
+// version 1 in place.
+func (p *postgres) adopt(op string) error {
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if _, err := tx.Exec(`LOCK TABLE schema_migrations IN EXCLUSIVE MODE`); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	var current int
+	if err := tx.Get(&current, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	var legacy bool
+	if err := tx.Get(&legacy, `SELECT to_regclass('volumes') IS NOT NULL`); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	if current != 0 || !legacy {
+		return tx.Rollback()
+	}
+
+	log.Info.Printf("%s: adopting existing database as schema version 1", op)
+
+	for _, stmt := range adoption {
+		if _, err := tx.Exec(stmt); err != nil {
+			return rollback(op, tx, errors.E(op, errors.Strf("adoption failed: %v", err)))
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (1)`); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	return commit(op, tx)
+}
+
+// migrate applies the migration in a transaction unless another server
+// already applied it.
+func (p *postgres) migrate(op string, m migration) error {
+	// values added to an enum cannot be added in a transaction block before
+	// PostgreSQL 12 and cannot be used in the transaction adding them after,
+	// so they are added up front.
+	for _, stmt := range m.enums {
+		if _, err := p.db.Exec(stmt); err != nil {
+			return errors.E(op, errors.Strf("migration %d failed: %v", m.version, err))
+		}
+	}
+
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if _, err := tx.Exec(`LOCK TABLE schema_migrations IN EXCLUSIVE MODE`); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	var current int
+	if err := tx.Get(&current, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	if m.version <= current {
+		return tx.Rollback()
+	}
+
+	log.Info.Printf("%s: migrating schema from version %d to %d", op, current, m.version)
+
+	for _, stmt := range m.stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return rollback(op, tx, errors.E(op, errors.Strf("migration %d failed: %v", m.version, err)))
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, m.version); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	return commit(op, tx)
+}
+
+// Version returns the current and latest schema version.
+func (p *postgres) Version() (int, int, error) {
+	const op = "inv/postgres.Version"
+
+	var current int
+
+	var exists bool
+	if err := p.db.Get(&exists, `SELECT to_regclass('schema_migrations') IS NOT NULL`); err != nil {
+		return 0, 0, errors.E(op, err)
+	}
+
+	if exists {
+		if err := p.db.Get(&current, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`); err != nil {
+			return 0, 0, errors.E(op, err)
+		}
+	}
+
+	return current, latestVersion(), nil
+}
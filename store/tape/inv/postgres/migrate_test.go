@@ -0,0 +1,160 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"tapr.space/errors"
+)
+
+func TestMigrations(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %d has version %d", i+1, m.version)
+		}
+
+		for _, stmt := range m.stmts {
+			if strings.Contains(stmt, "ADD VALUE") {
+				t.Errorf("migration %d adds an enum value in its transaction: %s", m.version, stmt)
+			}
+		}
+
+		for _, stmt := range m.enums {
+			if !strings.Contains(stmt, "IF NOT EXISTS") {
+				t.Errorf("migration %d adds an enum value that is not idempotent: %s", m.version, stmt)
+			}
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	for _, tc := range []struct {
+		current int
+		first   int
+		n       int
+	}{
+		{0, 1, len(migrations)},
+		{1, 2, len(migrations) - 1},
+		{latestVersion(), 0, 0},
+	} {
+		ms, err := pending(tc.current)
+		if err != nil {
+			t.Fatalf("pending(%d): %v", tc.current, err)
+		}
+
+		if len(ms) != tc.n {
+			t.Errorf("pending(%d): got %d migrations, want %d", tc.current, len(ms), tc.n)
+		}
+
+		if tc.n > 0 && ms[0].version != tc.first {
+			t.Errorf("pending(%d): first is %d, want %d", tc.current, ms[0].version, tc.first)
+		}
+	}
+
+	if _, err := pending(latestVersion() + 1); !errors.Is(errors.Invalid, err) {
+		t.Errorf("pending(%d): got %v, want invalid error", latestVersion()+1, err)
+	}
+}
+
+// legacySchema is the schema created by -dbreset before schema migrations
+// were introduced.
+var legacySchema = []string{
+	`CREATE TYPE volume_category AS ENUM (
+		'unknown', 'allocating', 'allocated', 'filling', 'scratch', 'full', 'missing', 'damaged', 'cleaning'
+	)`,
+	`CREATE TYPE slot_category AS ENUM ('transfer', 'storage', 'ix')`,
+	`CREATE TYPE volume_location AS (addr integer, category slot_category)`,
+	`CREATE TABLE volumes (
+		serial text PRIMARY KEY,
+		location volume_location UNIQUE,
+		home volume_location UNIQUE,
+		category volume_category DEFAULT 'scratch',
+		flags bit varying(10)
+	)`,
+	`CREATE TABLE datasets (id integer PRIMARY KEY)`,
+	`CREATE TABLE files (
+		path text PRIMARY KEY,
+		serial text,
+		dataset integer,
+		FOREIGN KEY (serial)  REFERENCES volumes  (serial),
+		FOREIGN KEY (dataset) REFERENCES datasets (id)
+	)`,
+	`INSERT INTO volumes (serial, location, category, flags) VALUES ('A00000L7', (1, 'storage'), 'filling', B'0')`,
+	`INSERT INTO files (path, serial) VALUES ('/a', 'A00000L7')`,
+}
+
+// TestAdopt migrates a legacy database. It needs a scratch database given
+// by TAPR_TEST_POSTGRES (a lib/pq connection string); all of its contents
+// are dropped.
+func TestAdopt(t *testing.T) {
+	dsn := os.Getenv("TAPR_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("TAPR_TEST_POSTGRES not set")
+	}
+
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	p := &postgres{db: db, prefixCleaning: "CLN"}
+
+	for _, stmt := range append(dropSchema, legacySchema...) {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	// a second run must be a no-op
+	for i := 0; i < 2; i++ {
+		if err := p.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current, latest, err := p.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if current != latest {
+		t.Errorf("version = %d, want %d", current, latest)
+	}
+
+	vol, err := p.Info("A00000L7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Location.Changer != "primary" {
+		t.Errorf("location = %v, want changer primary", vol.Location)
+	}
+
+	ent, err := p.Lookup("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ent.Extents) != 1 || ent.Extents[0].Serial != "A00000L7" {
+		t.Errorf("extents = %v, want a single extent on A00000L7", ent.Extents)
+	}
+}
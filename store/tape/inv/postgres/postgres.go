@@ -18,11 +18,11 @@ package postgres // import "tapr.space/store/tape/inv/postgres"
 import (
 	"database/sql"
 	"fmt"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // side-effect: register postgresql driver
+	"github.com/lib/pq" // side-effect: register postgresql driver
 
 	"tapr.space"
 	"tapr.space/bitmask"
@@ -37,6 +37,9 @@ func init() {
 	inv.Register("postgres", New)
 }
 
+// uniqueViolation is the SQLSTATE reported on unique constraint violations.
+const uniqueViolation = "23505"
+
 func rollback(op string, tx *sqlx.Tx, err error) error {
 	log.Error.Printf("%s: transaction roll back due to error: %v", op, err)
 	if err := tx.Rollback(); err != nil {
@@ -61,6 +64,7 @@ type rvol struct {
 	Home     tape.Location       `db:"home"`
 	Category tape.VolumeCategory `db:"category"`
 	Flags    uint32              `db:"flags"`
+	Mounts   int                 `db:"mounts"`
 }
 
 type postgres struct {
@@ -106,7 +110,7 @@ func (p *postgres) Volumes() (vs []tape.Volume, err error) {
 	var rs []rvol
 
 	err = p.db.Select(&rs, `
-		SELECT serial, location, home, category, flags
+		SELECT serial, location, home, category, flags, mounts
 		FROM volumes
 		ORDER BY serial
 	`)
@@ -122,313 +126,583 @@ func (p *postgres) Volumes() (vs []tape.Volume, err error) {
 			Home:     r.Home,
 			Category: r.Category,
 			Flags:    r.Flags,
+			Mounts:   r.Mounts,
 		})
 	}
 
 	return
 }
 
-func (p *postgres) Audit(chgr changer.Changer) (err error) {
+func (p *postgres) Audit(chgr changer.Changer, dryRun bool) (inv.AuditResult, error) {
+	const op = "inv/postgres.Audit"
+
 	slots, err := chgr.Status()
 	if err != nil {
-		return err
+		return inv.AuditResult{}, errors.E(op, err)
+	}
+
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return inv.AuditResult{}, errors.E(op, err)
+	}
+
+	var rs []rvol
+	if err := tx.Select(&rs, `SELECT serial, location, home, category, flags, mounts FROM volumes FOR UPDATE`); err != nil {
+		return inv.AuditResult{}, rollback(op, tx, errors.E(op, err))
+	}
+
+	// volumes with a pending intent are left to the recovery of the move
+	var serials []tape.Serial
+	if err := tx.Select(&serials, `SELECT serial FROM volume_intents`); err != nil {
+		return inv.AuditResult{}, rollback(op, tx, errors.E(op, err))
+	}
+
+	pending := make(map[tape.Serial]bool, len(serials))
+	for _, serial := range serials {
+		pending[serial] = true
+	}
+
+	vols := make(map[tape.Serial]tape.Volume, len(rs))
+	list := make([]tape.Volume, len(rs))
+	for i, r := range rs {
+		list[i] = tape.Volume{
+			Serial:   r.Serial,
+			Location: r.Location,
+			Home:     r.Home,
+			Category: r.Category,
+			Flags:    r.Flags,
+			Mounts:   r.Mounts,
+		}
+
+		vols[r.Serial] = list[i]
+
+		if pending[r.Serial] {
+			bitmask.Set(&list[i].Flags, tape.StatusTransfering)
+		}
 	}
 
-	for _, t := range tape.SlotCategories {
+	res := inv.Diff(changer.Name(chgr), list, slots)
+	if dryRun || res.Empty() {
+		if err := tx.Rollback(); err != nil {
+			return res, errors.E(op, err)
+		}
+
+		return res, nil
+	}
+
+	// vacate the recorded locations first such that volumes may swap slots
+	// without violating the uniqueness of locations
+	for _, diffs := range [][]inv.Difference{res.Moved, res.Mounted, res.Missing, res.Removed} {
+		for _, d := range diffs {
+			if _, err := tx.Exec(`UPDATE volumes SET location = NULL, home = NULL WHERE serial = $1`, d.Serial); err != nil {
+				return res, rollback(op, tx, errors.E(op, err))
+			}
+		}
+	}
+
+	for _, d := range res.New {
+		category := inv.Classify(d.Serial, p.prefixCleaning)
+
 		var flags uint32
-		if t == tape.TransferSlot {
+		if d.To.Category == tape.TransferSlot {
 			bitmask.Set(&flags, tape.StatusMounted)
 		}
 
-		for _, slot := range slots[t] {
-			if v := slot.Volume; v != nil {
-				category := tape.Scratch
-				if strings.HasPrefix(string(v.Serial), p.prefixCleaning) {
-					category = tape.Cleaning
-				}
-
-				_, err = p.db.Exec(`
-					INSERT INTO volumes (
-            			serial, location, category, flags
-          			) VALUES (
-						$1,       -- serial
-						($2, $3), -- location (addr, slot type)
-						$4,       -- category
-						$5        -- flags
-          			) ON CONFLICT (serial) DO
-						UPDATE SET
-							location = ($2, $3),
-							flags = $5
-				`, v.Serial, slot.Addr, slot.Category, category, fmt.Sprintf("%b", flags))
-
-				if err != nil {
-					return
-				}
+		_, err := tx.Exec(`
+			INSERT INTO volumes (serial, location, category, flags)
+			VALUES ($1, ($2, $3, $4), $5, $6)
+		`, d.Serial, d.To.Addr, d.To.Category, d.To.Changer, category, fmt.Sprintf("%b", flags))
+
+		if err != nil {
+			return res, rollback(op, tx, errors.E(op, err))
+		}
+
+		if err := record(tx, d.Serial, tape.UnknownVolume, category, "discovered by audit"); err != nil {
+			return res, rollback(op, tx, errors.E(op, err))
+		}
+	}
+
+	for _, d := range append(res.Moved, res.Mounted...) {
+		vol := vols[d.Serial]
+
+		bitmask.Clear(&vol.Flags, tape.StatusTransfering)
+		bitmask.Clear(&vol.Flags, tape.StatusMounted)
+
+		var home *tape.Location
+		if d.To.Category == tape.TransferSlot {
+			bitmask.Set(&vol.Flags, tape.StatusMounted)
+
+			if d.From.Category == tape.StorageSlot {
+				home = &d.From
+			}
+		}
+
+		_, err := tx.Exec(`
+			UPDATE volumes
+			SET
+				location = ($1, $2, $3),
+				flags = $4
+			WHERE serial = $5
+		`, d.To.Addr, d.To.Category, d.To.Changer, fmt.Sprintf("%b", vol.Flags), d.Serial)
+
+		if err != nil {
+			return res, rollback(op, tx, errors.E(op, err))
+		}
+
+		if home != nil {
+			_, err := tx.Exec(`UPDATE volumes SET home = ($1, $2, $3) WHERE serial = $4`, home.Addr, home.Category, home.Changer, d.Serial)
+			if err != nil {
+				return res, rollback(op, tx, errors.E(op, err))
+			}
+		}
+
+		if vol.Category == tape.Missing {
+			history, err := p.History(d.Serial)
+			if err != nil {
+				return res, rollback(op, tx, errors.E(op, err))
+			}
+
+			to := inv.Found(d.Serial, history, p.prefixCleaning)
+
+			if _, err := tx.Exec(`UPDATE volumes SET category = $1 WHERE serial = $2`, to, d.Serial); err != nil {
+				return res, rollback(op, tx, errors.E(op, err))
+			}
+
+			if err := record(tx, d.Serial, tape.Missing, to, "found by audit"); err != nil {
+				return res, rollback(op, tx, errors.E(op, err))
 			}
 		}
 	}
 
-	return
+	for _, d := range res.Missing {
+		vol := vols[d.Serial]
+
+		bitmask.Clear(&vol.Flags, tape.StatusTransfering)
+		bitmask.Clear(&vol.Flags, tape.StatusMounted)
+
+		_, err := tx.Exec(`
+			UPDATE volumes
+			SET
+				category = 'missing',
+				flags = $1
+			WHERE serial = $2
+		`, fmt.Sprintf("%b", vol.Flags), d.Serial)
+
+		if err != nil {
+			return res, rollback(op, tx, errors.E(op, err))
+		}
+
+		if err := record(tx, d.Serial, vol.Category, tape.Missing, "not found by audit"); err != nil {
+			return res, rollback(op, tx, errors.E(op, err))
+		}
+	}
+
+	if err := commit(op, tx); err != nil {
+		return res, errors.E(op, err)
+	}
+
+	return res, nil
 }
 
-func (p *postgres) Create(path tapr.PathName, serial string) (err error) {
+func (p *postgres) Create(path tapr.PathName, serial tape.Serial) error {
 	const op = "inv/postgres.Create"
 
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return errors.E(op, path, err)
+	}
+
+	var shadowed int
+
 	stmt := `
-		INSERT INTO tree (path, serial)
-		VALUES ($1, $2)
+		SELECT count(*)
+		FROM extents
+		WHERE serial = $1 AND name = $2
 	`
 
-	if _, err = p.db.Exec(stmt, path, serial); err != nil {
-		return err
+	if err := tx.Get(&shadowed, stmt, serial, path); err != nil {
+		return rollback(op, tx, errors.E(op, path, err))
 	}
 
-	return nil
-}
+	if shadowed > 0 {
+		return rollback(op, tx, errors.E(op, path, errors.Exist, errors.Strf("path name is in use by a renamed file on volume %v", serial)))
+	}
 
-func (p *postgres) Lookup(path tapr.PathName) (tape.Volume, error) {
-	const op = "inv/postgres.Lookup"
+	stmt = `
+		INSERT INTO files (path)
+		VALUES ($1)
+		ON CONFLICT (path) DO
+			UPDATE SET
+				size = 0,
+				mtime = now(),
+				checksum = NULL
+	`
 
-	var r rvol
+	if _, err := tx.Exec(stmt, path); err != nil {
+		return rollback(op, tx, errors.E(op, path, err))
+	}
 
-	stmt := `
-		SELECT volume
-		FROM tree
-		JOIN
+	stmt = `
+		DELETE FROM extents
 		WHERE path = $1
 	`
 
-	if err := p.db.Get(&r, stmt, path); err != nil {
-		return tape.Volume{}, err
+	if _, err := tx.Exec(stmt, path); err != nil {
+		return rollback(op, tx, errors.E(op, path, err))
 	}
 
-	return tape.Volume{
-		Serial:   r.Serial,
-		Location: r.Location,
-		Home:     r.Home,
-		Category: r.Category,
-		Flags:    r.Flags,
-	}, nil
-}
+	stmt = `
+		INSERT INTO extents (path, start, length, serial)
+		VALUES ($1, 0, 0, $2)
+	`
 
-func (p *postgres) Load(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
-	const op = "inv/postgres.Load"
+	if _, err := tx.Exec(stmt, path, serial); err != nil {
+		return rollback(op, tx, errors.E(op, path, err))
+	}
 
-	var r rvol
+	return commit(op, tx)
+}
+
+func (p *postgres) Extend(path tapr.PathName, ext tape.Extent) error {
+	const op = "inv/postgres.Extend"
 
 	tx, err := p.db.Beginx()
 	if err != nil {
-		return err
+		return errors.E(op, path, err)
 	}
 
+	var shadowed int
+
 	stmt := `
-		SELECT serial, location, home, category, flags
-		FROM volumes
-		WHERE serial = $1
-		FOR UPDATE
+		SELECT count(*)
+		FROM extents
+		WHERE serial = $1 AND name = $2
+		  AND NOT EXISTS (
+			SELECT 1
+			FROM extents
+			WHERE path = $2 AND start = $3
+		  )
 	`
 
-	if err := tx.Get(&r, stmt, serial); err != nil {
-		return rollback(op, tx, err)
+	if err := tx.Get(&shadowed, stmt, ext.Serial, path, ext.Offset); err != nil {
+		return rollback(op, tx, errors.E(op, path, err))
 	}
 
-	if r.Location.Category != tape.StorageSlot && r.Location.Category != tape.ImportExportSlot {
-		return errors.E(op, errors.Strf("invalid source slot for load operation"))
+	if shadowed > 0 {
+		return rollback(op, tx, errors.E(op, path, errors.Exist, errors.Strf("path name is in use by a renamed file on volume %v", ext.Serial)))
 	}
 
-	if dst.Category != tape.TransferSlot {
-		return errors.E(op, errors.Strf("invalid destination slot for load operation"))
+	stmt = `
+		INSERT INTO extents (path, start, length, serial, position)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (path, start) DO
+			UPDATE SET
+				length = $3,
+				serial = $4,
+				position = $5
+	`
+
+	if _, err := tx.Exec(stmt, path, ext.Offset, ext.Length, ext.Serial, ext.Position); err != nil {
+		return rollback(op, tx, errors.E(op, path, err))
 	}
 
-	bitmask.Set(&r.Flags, tape.StatusTransfering)
-	bitmask.Set(&r.Flags, tape.StatusMounted)
+	return commit(op, tx)
+}
 
-	stmt = `
-		UPDATE volumes
+func (p *postgres) Commit(f tape.File) error {
+	const op = "inv/postgres.Commit"
+
+	stmt := `
+		UPDATE files
 		SET
-			location = NULL,
-			home = ($1, $2),
-			flags = $3
-		WHERE serial = $4
+			size = $1,
+			mtime = $2,
+			checksum = $3,
+			dataset = COALESCE(NULLIF($4, 0), dataset)
+		WHERE path = $5
 	`
 
-	_, err = tx.Exec(stmt, r.Location.Addr, r.Location.Category, fmt.Sprintf("%b", r.Flags), r.Serial)
+	res, err := p.db.Exec(stmt, f.Size, f.ModTime, f.Checksum, f.Dataset, f.Path)
 	if err != nil {
-		return rollback(op, tx, err)
+		return errors.E(op, f.Path, err)
 	}
 
-	if err := commit(op, tx); err != nil {
-		return err
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.E(op, f.Path, errors.NotExist)
 	}
 
-	if err := chgr.Load(r.Location, dst); err != nil {
-		return err
+	return nil
+}
+
+func (p *postgres) Rename(oldpath, newpath tapr.PathName) error {
+	const op = "inv/postgres.Rename"
+
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return errors.E(op, oldpath, err)
 	}
 
-	bitmask.Clear(&r.Flags, tape.StatusTransfering)
+	// the data stays where it is on the volumes
+	stmt := `
+		UPDATE extents
+		SET name = CASE
+			WHEN name IS NULL THEN $1
+			WHEN name = $2 THEN NULL
+			ELSE name
+		END
+		WHERE path = $1
+	`
+
+	if _, err := tx.Exec(stmt, oldpath, newpath); err != nil {
+		return rollback(op, tx, errors.E(op, oldpath, err))
+	}
 
 	stmt = `
-		UPDATE volumes
-		SET
-			location = ($1, $2),
-			category = $3,
-			flags = $4
-		WHERE serial = $5
+		UPDATE files
+		SET path = $1
+		WHERE path = $2
 	`
 
-	if r.Category == tape.Allocating {
-		r.Category = tape.Allocated
+	res, err := tx.Exec(stmt, newpath, oldpath)
+	if err != nil {
+		if err, ok := err.(*pq.Error); ok && err.Code == uniqueViolation {
+			return rollback(op, tx, errors.E(op, newpath, errors.Exist))
+		}
+
+		return rollback(op, tx, errors.E(op, oldpath, err))
 	}
 
-	if _, err := p.db.Exec(stmt, dst.Addr, dst.Category, r.Category, fmt.Sprintf("%b", r.Flags), r.Serial); err != nil {
-		return err
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return rollback(op, tx, errors.E(op, oldpath, errors.NotExist))
 	}
 
-	return nil
+	return commit(op, tx)
 }
 
-func (p *postgres) Unload(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
-	const op = "inv/postgres.Unload"
+func (p *postgres) Remove(path tapr.PathName) error {
+	const op = "inv/postgres.Remove"
 
-	var r rvol
+	stmt := `
+		DELETE FROM files
+		WHERE path = $1
+	`
 
-	tx, err := p.db.Beginx()
+	res, err := p.db.Exec(stmt, path)
 	if err != nil {
-		return err
+		return errors.E(op, path, err)
 	}
 
-	stmt := `
-		SELECT serial, location, home, category, flags
-		FROM volumes
-		WHERE serial = $1
-	`
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.E(op, path, errors.NotExist)
+	}
+
+	return nil
+}
 
-	if err := tx.Get(&r, stmt, serial); err != nil {
-		return rollback(op, tx, err)
+type rfile struct {
+	Path     tapr.PathName `db:"path"`
+	Size     int64         `db:"size"`
+	ModTime  time.Time     `db:"mtime"`
+	Checksum []byte        `db:"checksum"`
+	Dataset  sql.NullInt64 `db:"dataset"`
+}
+
+type rext struct {
+	Path     tapr.PathName  `db:"path"`
+	Serial   tape.Serial    `db:"serial"`
+	Offset   int64          `db:"start"`
+	Length   int64          `db:"length"`
+	Position int64          `db:"position"`
+	Name     sql.NullString `db:"name"`
+}
+
+// files joins the given file rows with their extents.
+func files(rfs []rfile, rs []rext) []tape.File {
+	fs := make([]tape.File, len(rfs))
+	idx := make(map[tapr.PathName]*tape.File, len(rfs))
+
+	for i, rf := range rfs {
+		fs[i] = tape.File{
+			Path:     rf.Path,
+			Size:     rf.Size,
+			ModTime:  rf.ModTime,
+			Checksum: rf.Checksum,
+			Dataset:  int(rf.Dataset.Int64),
+		}
+
+		idx[rf.Path] = &fs[i]
 	}
 
-	if dst.Addr == 0 {
-		// return to home slot
-		dst = r.Home
+	for _, r := range rs {
+		f, ok := idx[r.Path]
+		if !ok {
+			continue
+		}
+
+		f.Extents = append(f.Extents, tape.Extent{
+			Serial:   r.Serial,
+			Offset:   r.Offset,
+			Length:   r.Length,
+			Position: r.Position,
+			Name:     tapr.PathName(r.Name.String),
+		})
 	}
 
-	if r.Location.Category != tape.TransferSlot {
-		return errors.E(op, errors.Strf("invalid source slot for unload operation"))
+	return fs
+}
+
+func (p *postgres) Lookup(path tapr.PathName) (tape.File, error) {
+	const op = "inv/postgres.Lookup"
+
+	var rfs []rfile
+
+	stmt := `
+		SELECT path, size, mtime, checksum, dataset
+		FROM files
+		WHERE path = $1
+	`
+
+	if err := p.db.Select(&rfs, stmt, path); err != nil {
+		return tape.File{}, errors.E(op, path, err)
 	}
 
-	if dst.Category != tape.StorageSlot && dst.Category != tape.ImportExportSlot {
-		return errors.E(op, errors.Strf("invalid destination slot for unload operation"))
+	if len(rfs) == 0 {
+		return tape.File{}, errors.E(op, path, errors.NotExist)
 	}
 
-	bitmask.Clear(&r.Flags, tape.StatusMounted)
-	bitmask.Set(&r.Flags, tape.StatusTransfering)
+	var rs []rext
 
 	stmt = `
-		UPDATE volumes
-		SET
-			location = NULL,
-			flags = $1
-		WHERE serial = $2
+		SELECT path, serial, start, length, position, name
+		FROM extents
+		WHERE path = $1
+		ORDER BY start
 	`
 
-	_, err = tx.Exec(stmt, fmt.Sprintf("%b", r.Flags), r.Serial)
-	if err != nil {
-		return rollback(op, tx, err)
+	if err := p.db.Select(&rs, stmt, path); err != nil {
+		return tape.File{}, errors.E(op, path, err)
 	}
 
-	if err := commit(op, tx); err != nil {
-		return err
+	if len(rs) == 0 {
+		return tape.File{}, errors.E(op, path, errors.Internal, errors.Str("file has no extents"))
 	}
 
-	if err := chgr.Unload(r.Location, dst); err != nil {
-		return err
+	return files(rfs, rs)[0], nil
+}
+
+func (p *postgres) List(prefix tapr.PathName) ([]tape.File, error) {
+	const op = "inv/postgres.List"
+
+	var rfs []rfile
+
+	stmt := `
+		SELECT path, size, mtime, checksum, dataset
+		FROM files
+		WHERE left(path, length($1)) = $1
+		ORDER BY path
+	`
+
+	if err := p.db.Select(&rfs, stmt, prefix); err != nil {
+		return nil, errors.E(op, prefix, err)
 	}
 
-	bitmask.Clear(&r.Flags, tape.StatusTransfering)
+	var rs []rext
 
 	stmt = `
-		UPDATE volumes
-		SET
-			location = $1,
-			home = NULL,
-			flags = $2
-		WHERE serial = $3
+		SELECT path, serial, start, length, position, name
+		FROM extents
+		WHERE left(path, length($1)) = $1
+		ORDER BY path, start
 	`
 
-	if _, err := tx.Exec(stmt, r.Flags, r.Serial); err != nil {
-		return err
+	if err := p.db.Select(&rs, stmt, prefix); err != nil {
+		return nil, errors.E(op, prefix, err)
 	}
 
-	return nil
+	return files(rfs, rs), nil
 }
 
-func (p *postgres) Transfer(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
-	const op = "inv/postgres.Transfer"
+func (p *postgres) Load(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/postgres.Load"
 
-	var r rvol
+	in, err := p.begin(op, inv.LoadMove, serial, dst, func(r *rvol, dst *tape.Location) error {
+		if r.Location.Category != tape.StorageSlot && r.Location.Category != tape.ImportExportSlot {
+			return errors.Strf("invalid source slot for load operation")
+		}
+
+		if dst.Category != tape.TransferSlot {
+			return errors.Strf("invalid destination slot for load operation")
+		}
+
+		if r.Location.Changer != dst.Changer {
+			return errors.E(errors.Invalid, errors.Strf("volume %v is in changer %q, not %q", serial, r.Location.Changer, dst.Changer))
+		}
+
+		return nil
+	})
 
-	tx, err := p.db.Beginx()
 	if err != nil {
 		return err
 	}
 
-	stmt := `
-		SELECT serial, location, home, category, flags
-		FROM volumes
-		WHERE serial = $1
-	`
+	return p.carry(op, in, chgr, chgr.Load)
+}
 
-	if err := tx.Get(&r, stmt, serial); err != nil {
-		return rollback(op, tx, err)
-	}
+func (p *postgres) Unload(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/postgres.Unload"
 
-	if r.Location.Category != tape.StorageSlot && r.Location.Category != tape.ImportExportSlot {
-		return errors.E(op, errors.Strf("invalid source slot for transfer operation"))
-	}
+	in, err := p.begin(op, inv.UnloadMove, serial, dst, func(r *rvol, dst *tape.Location) error {
+		if dst.Addr == 0 {
+			// return to home slot
+			*dst = r.Home
+		}
 
-	if dst.Category != tape.StorageSlot && dst.Category != tape.ImportExportSlot {
-		return errors.E(op, errors.Strf("invalid destination slot for transfer"))
-	}
+		if r.Location.Category != tape.TransferSlot {
+			return errors.Strf("invalid source slot for unload operation")
+		}
 
-	// set transfering flag
-	bitmask.Set(&r.Flags, tape.StatusTransfering)
+		if dst.Category != tape.StorageSlot && dst.Category != tape.ImportExportSlot {
+			return errors.Strf("invalid destination slot for unload operation")
+		}
 
-	stmt = `
-		UPDATE volumes
-		SET
-			location = NULL,
-			flags = $3
-		WHERE serial = $4
-	`
+		if r.Location.Changer != dst.Changer {
+			return errors.E(errors.Invalid, errors.Strf("volume %v is in changer %q, not %q", serial, r.Location.Changer, dst.Changer))
+		}
 
-	_, err = tx.Exec(stmt, r.Location, r.Flags, r.Serial)
-	if err != nil {
-		return rollback(op, tx, err)
-	}
+		return nil
+	})
 
-	if err := commit(op, tx); err != nil {
+	if err != nil {
 		return err
 	}
 
-	if err := chgr.Transfer(r.Location, dst); err != nil {
-		return err
-	}
+	return p.carry(op, in, chgr, chgr.Unload)
+}
 
-	bitmask.Clear(&r.Flags, tape.StatusTransfering)
+func (p *postgres) Transfer(serial tape.Serial, dst tape.Location, chgr changer.Changer) error {
+	const op = "inv/postgres.Transfer"
 
-	stmt = `
-		UPDATE volumes
-		SET
-			location = $1,
-			flags = $2
-		WHERE serial = $3
-	`
+	in, err := p.begin(op, inv.TransferMove, serial, dst, func(r *rvol, dst *tape.Location) error {
+		if r.Location.Category != tape.StorageSlot && r.Location.Category != tape.ImportExportSlot {
+			return errors.Strf("invalid source slot for transfer operation")
+		}
 
-	if _, err := tx.Exec(stmt, dst, r.Flags, r.Serial); err != nil {
+		if dst.Category != tape.StorageSlot && dst.Category != tape.ImportExportSlot {
+			return errors.Strf("invalid destination slot for transfer")
+		}
+
+		if r.Location.Changer != dst.Changer {
+			return errors.E(errors.Invalid, errors.Strf("volume %v is in changer %q, not %q", serial, r.Location.Changer, dst.Changer))
+		}
+
+		return nil
+	})
+
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return p.carry(op, in, chgr, chgr.Transfer)
 }
 
 func (p *postgres) Loaded(loc tape.Location) (loaded bool, serial tape.Serial, err error) {
@@ -438,8 +712,8 @@ func (p *postgres) Loaded(loc tape.Location) (loaded bool, serial tape.Serial, e
 		SELECT serial
 		FROM volumes
 		WHERE
-			location = ($1::integer,slot_category('transfer'))
-	`, loc.Addr)
+			location = ($1::integer, slot_category('transfer'), $2::text)
+	`, loc.Addr, loc.Changer)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -459,7 +733,7 @@ func (p *postgres) Info(serial tape.Serial) (tape.Volume, error) {
 	var r rvol
 
 	err := p.db.Get(&r, `
-		SELECT serial, location, home, category, flags
+		SELECT serial, location, home, category, flags, mounts
 		FROM volumes
 		WHERE serial = $1
 	`, serial)
@@ -474,36 +748,62 @@ func (p *postgres) Info(serial tape.Serial) (tape.Volume, error) {
 		Home:     r.Home,
 		Category: r.Category,
 		Flags:    r.Flags,
+		Mounts:   r.Mounts,
 	}, nil
 }
 
 func (p *postgres) Update(vol tape.Volume) error {
 	const op = "inv/postgres.Update"
 
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	var from tape.VolumeCategory
+	if err := tx.Get(&from, `SELECT category FROM volumes WHERE serial = $1 FOR UPDATE`, vol.Serial); err != nil {
+		if err == sql.ErrNoRows {
+			err = errors.E(errors.NotExist, errors.Strf("unknown volume %v", vol.Serial))
+		}
+
+		return rollback(op, tx, errors.E(op, err))
+	}
+
+	if err := tape.CheckTransition(from, vol.Category); err != nil {
+		return rollback(op, tx, errors.E(op, err))
+	}
+
 	stmt := `
 		UPDATE volumes
 		SET
-			location = ($1, $2),
-			home = ($3, $4),
-			category = $5,
-			flags = $6
-		WHERE serial = $7
+			location = ($1, $2, $3),
+			home = ($4, $5, $6),
+			category = $7,
+			flags = $8,
+			mounts = $9
+		WHERE serial = $10
 	`
 
-	_, err := p.db.Exec(stmt,
-		vol.Location.Addr, vol.Location.Category,
-		vol.Home.Addr, vol.Home.Category,
-		vol.Category, fmt.Sprintf("%b", vol.Flags),
+	_, err = tx.Exec(stmt,
+		vol.Location.Addr, vol.Location.Category, vol.Location.Changer,
+		vol.Home.Addr, vol.Home.Category, vol.Home.Changer,
+		vol.Category, fmt.Sprintf("%b", vol.Flags), vol.Mounts,
 		vol.Serial,
 	)
 	if err != nil {
-		return err
+		return rollback(op, tx, errors.E(op, err))
 	}
 
-	return nil
+	if from != vol.Category {
+		if err := record(tx, vol.Serial, from, vol.Category, "volume updated"); err != nil {
+			return rollback(op, tx, errors.E(op, err))
+		}
+	}
+
+	return commit(op, tx)
 }
 
-func (p *postgres) Alloc() (serial tape.Serial, err error) {
+func (p *postgres) Alloc(changer string, paths ...tapr.PathName) (serial tape.Serial, err error) {
 	const op = "inv/postgres.Alloc"
 
 	tx, err := p.db.Beginx()
@@ -514,16 +814,28 @@ func (p *postgres) Alloc() (serial tape.Serial, err error) {
 	var r rvol
 
 	stmt := `
-		SELECT serial, location, home, category, flags
+		SELECT serial, location, home, category, flags, mounts
 		FROM volumes
 		WHERE category IN ('filling', 'scratch')
 		  AND (location).category = 'storage'
+		  AND (location).changer = $1
+		  AND NOT EXISTS (
+			SELECT 1
+			FROM extents
+			WHERE extents.serial = volumes.serial
+			  AND extents.name = ANY($2)
+		  )
 		ORDER BY category, serial
 		LIMIT 1
 		FOR UPDATE
 	`
 
-	if err := tx.Get(&r, stmt); err != nil {
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = string(path)
+	}
+
+	if err := tx.Get(&r, stmt, changer, pq.Array(names)); err != nil {
 		return serial, rollback(op, tx, err)
 	}
 
@@ -541,6 +853,10 @@ func (p *postgres) Alloc() (serial tape.Serial, err error) {
 		if _, err = tx.Exec(stmt, r.Category, r.Serial); err != nil {
 			return serial, rollback(op, tx, err)
 		}
+
+		if err := record(tx, r.Serial, tape.Scratch, tape.Allocating, "allocated for writing"); err != nil {
+			return serial, rollback(op, tx, err)
+		}
 	}
 
 	if err := commit(op, tx); err != nil {
@@ -549,14 +865,3 @@ func (p *postgres) Alloc() (serial tape.Serial, err error) {
 
 	return serial, nil
 }
-
-// Reset resets the inventory database.
-func (p *postgres) Reset() error {
-	for _, stmt := range resetSchema {
-		if _, err := p.db.Exec(stmt); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
@@ -14,67 +14,255 @@
 
 package postgres
 
-// resetSchema is a list of SQL statements that when executed in sequence
-// will reset the postgres database.
-var resetSchema = []string{
+// dropSchema is a list of SQL statements that when executed in sequence
+// will drop every object in the postgres database.
+var dropSchema = []string{
 	// drop types
 	`DROP TYPE IF EXISTS volume_category CASCADE`,
 	`DROP TYPE IF EXISTS slot_category CASCADE`,
 	`DROP TYPE IF EXISTS volume_location CASCADE`,
 
 	// drop tables
+	`DROP TABLE IF EXISTS volume_intents`,
+	`DROP TABLE IF EXISTS volume_history`,
+	`DROP TABLE IF EXISTS extents`,
+	`DROP TABLE IF EXISTS files`,
+	`DROP TABLE IF EXISTS datasets`,
 	`DROP TABLE IF EXISTS volumes`,
-	`DROP TABLE IF EXISTS tree`,
+	`DROP TABLE IF EXISTS schema_migrations`,
+}
+
+// migrationsSchema creates the table recording the applied migrations.
+const migrationsSchema = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		-- the schema version
+		version integer PRIMARY KEY,
+
+		-- time of application
+		applied timestamp with time zone NOT NULL DEFAULT now()
+	)
+`
+
+// A migration is a list of SQL statements that migrates the schema from the
+// previous version.
+type migration struct {
+	version int
+
+	// enums are statements adding values to enum types. They are executed
+	// outside the migration transaction and must be idempotent.
+	enums []string
+
+	stmts []string
+}
 
-	// create types
-	`CREATE TYPE volume_category AS ENUM (
-		-- order 'filling' before 'scratch'
-		'unknown', 'allocating', 'allocated', 'filling', 'scratch', 'full', 'missing', 'damaged', 'cleaning'
+// adoption is a list of SQL statements that brings a database created before
+// schema migrations were introduced up to version 1. The volumes table and
+// types are unchanged since then; the catalog tables are created or altered
+// in place. Files of the original catalog recorded only the volume they were
+// written to; they become a single extent of unknown length.
+var adoption = []string{
+	`CREATE TABLE IF NOT EXISTS datasets (
+		id integer PRIMARY KEY
 	)`,
 
-	`CREATE TYPE slot_category AS ENUM (
-		'transfer', 'storage', 'ix'
+	`CREATE TABLE IF NOT EXISTS files (
+		path text PRIMARY KEY,
+		dataset integer,
+		FOREIGN KEY (dataset) REFERENCES datasets (id)
 	)`,
 
-	`CREATE TYPE volume_location AS (
-		addr integer,
-		category slot_category
+	`ALTER TABLE files ADD COLUMN IF NOT EXISTS size bigint DEFAULT 0`,
+	`ALTER TABLE files ADD COLUMN IF NOT EXISTS mtime timestamp with time zone NOT NULL DEFAULT now()`,
+	`ALTER TABLE files ADD COLUMN IF NOT EXISTS checksum bytea`,
+
+	`CREATE TABLE IF NOT EXISTS extents (
+		path text,
+		start bigint,
+		length bigint DEFAULT 0,
+		serial text,
+		position bigint DEFAULT 0,
+		PRIMARY KEY (path, start),
+		FOREIGN KEY (path)   REFERENCES files   (path) ON DELETE CASCADE ON UPDATE CASCADE,
+		FOREIGN KEY (serial) REFERENCES volumes (serial)
 	)`,
 
-	// create tables
-	`CREATE TABLE volumes (
-		-- the unique volume serial
-		serial text PRIMARY KEY,
+	`DO $$
+	BEGIN
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'files' AND column_name = 'serial'
+		) THEN
+			INSERT INTO extents (path, start, serial)
+			SELECT path, 0, serial FROM files WHERE serial IS NOT NULL;
 
-		-- volume location (unique, only one volume can occupy a slot)
-		location volume_location UNIQUE,
+			ALTER TABLE files DROP COLUMN serial;
+		END IF;
+	END
+	$$`,
+}
 
-		-- volume home location
-		home volume_location UNIQUE,
+// migrations is the ordered list of schema migrations. Migrations are
+// forward-only; never change a released migration, append a new one.
+var migrations = []migration{
+	{
+		version: 1,
+		stmts: []string{
+			// create types
+			`CREATE TYPE volume_category AS ENUM (
+				-- order 'filling' before 'scratch'
+				'unknown', 'allocating', 'allocated', 'filling', 'scratch', 'full', 'missing', 'damaged', 'cleaning'
+			)`,
 
-		-- volume status
-		category volume_category DEFAULT 'scratch',
+			`CREATE TYPE slot_category AS ENUM (
+				'transfer', 'storage', 'ix'
+			)`,
 
-		-- volume status
-		flags bit varying(10)
-	)`,
+			`CREATE TYPE volume_location AS (
+				addr integer,
+				category slot_category
+			)`,
 
-	`CREATE TABLE datasets (
-		id integer PRIMARY KEY
-	)`,
+			// create tables
+			`CREATE TABLE volumes (
+				-- the unique volume serial
+				serial text PRIMARY KEY,
 
-	`CREATE TABLE files (
-		-- file path
-		path text PRIMARY KEY,
+				-- volume location (unique, only one volume can occupy a slot)
+				location volume_location UNIQUE,
 
-		-- serial
-		serial text,
+				-- volume home location
+				home volume_location UNIQUE,
 
-		-- optional dataset relation
-		dataset integer,
+				-- volume status
+				category volume_category DEFAULT 'scratch',
 
-		-- constraints
-		FOREIGN KEY (serial)  REFERENCES volumes  (serial),
-		FOREIGN KEY (dataset) REFERENCES datasets (id)
-	)`,
+				-- volume status
+				flags bit varying(10)
+			)`,
+
+			`CREATE TABLE datasets (
+				id integer PRIMARY KEY
+			)`,
+
+			`CREATE TABLE files (
+				-- file path
+				path text PRIMARY KEY,
+
+				-- file size
+				size bigint DEFAULT 0,
+
+				-- time of last write
+				mtime timestamp with time zone NOT NULL DEFAULT now(),
+
+				-- checksum of the file contents
+				checksum bytea,
+
+				-- optional dataset relation
+				dataset integer,
+
+				-- constraints
+				FOREIGN KEY (dataset) REFERENCES datasets (id)
+			)`,
+
+			`CREATE TABLE extents (
+				-- file path
+				path text,
+
+				-- offset of the extent within the file
+				start bigint,
+
+				-- length of the extent
+				length bigint DEFAULT 0,
+
+				-- serial of the volume holding the extent
+				serial text,
+
+				-- logical block position of the extent on the volume
+				position bigint DEFAULT 0,
+
+				-- constraints
+				PRIMARY KEY (path, start),
+				FOREIGN KEY (path)   REFERENCES files   (path) ON DELETE CASCADE ON UPDATE CASCADE,
+				FOREIGN KEY (serial) REFERENCES volumes (serial)
+			)`,
+		},
+	},
+	{
+		version: 2,
+		stmts: []string{
+			`CREATE TABLE volume_history (
+				-- volume serial
+				serial text REFERENCES volumes (serial) ON DELETE CASCADE,
+
+				-- time of the transition
+				time timestamp with time zone NOT NULL DEFAULT now(),
+
+				-- categories before and after the transition
+				old_category volume_category NOT NULL,
+				new_category volume_category NOT NULL,
+
+				-- reason for the transition
+				reason text NOT NULL DEFAULT ''
+			)`,
+
+			`CREATE INDEX volume_history_serial ON volume_history (serial, time)`,
+		},
+	},
+	{
+		version: 3,
+		stmts: []string{
+			// locations are qualified by the name of the changer holding
+			// the volume; existing volumes belong to the primary changer.
+			`ALTER TYPE volume_location ADD ATTRIBUTE changer text`,
+
+			`UPDATE volumes SET location.changer = 'primary' WHERE location IS NOT NULL`,
+			`UPDATE volumes SET home.changer = 'primary' WHERE home IS NOT NULL`,
+		},
+	},
+	{
+		version: 4,
+		enums: []string{
+			// worn out cleaning cartridges
+			`ALTER TYPE volume_category ADD VALUE IF NOT EXISTS 'expired'`,
+		},
+		stmts: []string{
+			// number of times the volume has been loaded
+			`ALTER TABLE volumes ADD COLUMN mounts integer NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 5,
+		stmts: []string{
+			`CREATE TABLE volume_intents (
+				id serial PRIMARY KEY,
+
+				-- the kind of move ('load', 'unload' or 'transfer')
+				kind text NOT NULL,
+
+				-- the volume being moved
+				serial text REFERENCES volumes (serial) ON DELETE CASCADE,
+
+				-- source and destination of the move
+				src volume_location NOT NULL,
+				dst volume_location NOT NULL,
+
+				-- home location and flags of the volume before the move
+				home volume_location,
+				flags bit varying(10),
+
+				-- time the move was started
+				time timestamp with time zone NOT NULL DEFAULT now()
+			)`,
+		},
+	},
+	{
+		version: 6,
+		stmts: []string{
+			// path name of the extent data on the volume if the file has
+			// been renamed since it was written
+			`ALTER TABLE extents ADD COLUMN name text`,
+
+			`CREATE INDEX extents_name ON extents (serial, name) WHERE name IS NOT NULL`,
+		},
+	},
 }
@@ -14,7 +14,14 @@
 
 package proto // import "tapr.space/store/tape/proto"
 
-import "tapr.space/store/tape"
+import (
+	"sort"
+	"time"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
+)
 
 // To regenerate the protocol buffer output for this package, run
 //      go generate
@@ -29,6 +36,7 @@ func VolumeProto(v tape.Volume) *Volume {
 		Home:     LocationProto(v.Home),
 		Category: VolumeCategoryProto(v.Category),
 		Flags:    uint32(v.Flags),
+		Mounts:   int64(v.Mounts),
 	}
 }
 
@@ -58,6 +66,7 @@ func TaprVolume(pb *Volume) tape.Volume {
 		Home:     TaprLocation(pb.Home),
 		Category: TaprVolumeCategory(pb.Category),
 		Flags:    pb.Flags,
+		Mounts:   int(pb.Mounts),
 	}
 }
 
@@ -82,6 +91,8 @@ func VolumeCategoryProto(s tape.VolumeCategory) Volume_Category {
 		return Volume_UNKNOWN
 	case tape.Allocating:
 		return Volume_ALLOCATING
+	case tape.Allocated:
+		return Volume_ALLOCATED
 	case tape.Filling:
 		return Volume_FILLING
 	case tape.Scratch:
@@ -94,6 +105,8 @@ func VolumeCategoryProto(s tape.VolumeCategory) Volume_Category {
 		return Volume_DAMAGED
 	case tape.Cleaning:
 		return Volume_CLEANING
+	case tape.Expired:
+		return Volume_EXPIRED
 	default:
 		panic("unknown volume category")
 	}
@@ -106,6 +119,8 @@ func TaprVolumeCategory(pb Volume_Category) tape.VolumeCategory {
 		return tape.UnknownVolume
 	case Volume_ALLOCATING:
 		return tape.Allocating
+	case Volume_ALLOCATED:
+		return tape.Allocated
 	case Volume_FILLING:
 		return tape.Filling
 	case Volume_SCRATCH:
@@ -118,6 +133,8 @@ func TaprVolumeCategory(pb Volume_Category) tape.VolumeCategory {
 		return tape.Damaged
 	case Volume_CLEANING:
 		return tape.Cleaning
+	case Volume_EXPIRED:
+		return tape.Expired
 	default:
 		panic("unknown volume category")
 	}
@@ -128,6 +145,7 @@ func LocationProto(loc tape.Location) *Location {
 	return &Location{
 		Addr:     int64(loc.Addr),
 		Category: loc.Category.String(),
+		Changer:  loc.Changer,
 	}
 }
 
@@ -136,5 +154,187 @@ func TaprLocation(pb *Location) tape.Location {
 	return tape.Location{
 		Addr:     tape.Addr(pb.Addr),
 		Category: tape.ToSlotCategory(pb.Category),
+		Changer:  pb.Changer,
 	}
 }
+
+// TransitionProto converts a tape.Transition to a proto.Transition.
+func TransitionProto(t tape.Transition) *Transition {
+	return &Transition{
+		Serial: string(t.Serial),
+		From:   VolumeCategoryProto(t.From),
+		To:     VolumeCategoryProto(t.To),
+		Time:   t.Time.UnixNano(),
+		Reason: t.Reason,
+	}
+}
+
+// TransitionProtos converts a slice of tape.Transition to a slice of
+// proto.Transition.
+func TransitionProtos(ts []tape.Transition) []*Transition {
+	if len(ts) == 0 {
+		return nil
+	}
+
+	pbs := make([]*Transition, len(ts))
+	for i := range pbs {
+		pbs[i] = TransitionProto(ts[i])
+	}
+
+	return pbs
+}
+
+// TaprTransition converts a proto.Transition to a tape.Transition.
+func TaprTransition(pb *Transition) tape.Transition {
+	return tape.Transition{
+		Serial: tape.Serial(pb.Serial),
+		From:   TaprVolumeCategory(pb.From),
+		To:     TaprVolumeCategory(pb.To),
+		Time:   time.Unix(0, pb.Time),
+		Reason: pb.Reason,
+	}
+}
+
+// TaprTransitions converts a slice of proto.Transition to a slice of
+// tape.Transition.
+func TaprTransitions(pbs []*Transition) []tape.Transition {
+	if len(pbs) == 0 {
+		return nil
+	}
+
+	ts := make([]tape.Transition, len(pbs))
+	for i := range ts {
+		ts[i] = TaprTransition(pbs[i])
+	}
+
+	return ts
+}
+
+// DifferenceProtos converts a slice of inv.Difference to a slice of
+// proto.Difference.
+func DifferenceProtos(ds []inv.Difference) []*Difference {
+	if len(ds) == 0 {
+		return nil
+	}
+
+	pbs := make([]*Difference, len(ds))
+	for i, d := range ds {
+		pbs[i] = &Difference{
+			Serial: string(d.Serial),
+			From:   LocationProto(d.From),
+			To:     LocationProto(d.To),
+		}
+	}
+
+	return pbs
+}
+
+// TaprDifferences converts a slice of proto.Difference to a slice of
+// inv.Difference.
+func TaprDifferences(pbs []*Difference) []inv.Difference {
+	if len(pbs) == 0 {
+		return nil
+	}
+
+	ds := make([]inv.Difference, len(pbs))
+	for i, pb := range pbs {
+		ds[i] = inv.Difference{
+			Serial: tape.Serial(pb.Serial),
+			From:   TaprLocation(pb.From),
+			To:     TaprLocation(pb.To),
+		}
+	}
+
+	return ds
+}
+
+// AuditResultProto converts an inv.AuditResult to a proto.AuditResult.
+func AuditResultProto(res inv.AuditResult) *AuditResult {
+	return &AuditResult{
+		Changer: res.Changer,
+		New:     DifferenceProtos(res.New),
+		Moved:   DifferenceProtos(res.Moved),
+		Mounted: DifferenceProtos(res.Mounted),
+		Missing: DifferenceProtos(res.Missing),
+		Removed: DifferenceProtos(res.Removed),
+	}
+}
+
+// TaprAuditResult converts a proto.AuditResult to an inv.AuditResult.
+func TaprAuditResult(pb *AuditResult) inv.AuditResult {
+	return inv.AuditResult{
+		Changer: pb.Changer,
+		New:     TaprDifferences(pb.New),
+		Moved:   TaprDifferences(pb.Moved),
+		Mounted: TaprDifferences(pb.Mounted),
+		Missing: TaprDifferences(pb.Missing),
+		Removed: TaprDifferences(pb.Removed),
+	}
+}
+
+// QueueStatsProto converts the changer.QueueStats of the named changer to a
+// proto.QueueStats.
+func QueueStatsProto(name string, st changer.QueueStats) *QueueStats {
+	prios := make(map[changer.Priority]bool)
+	for prio := range st.Depth {
+		prios[prio] = true
+	}
+
+	for prio := range st.Waits {
+		prios[prio] = true
+	}
+
+	pb := &QueueStats{
+		Changer:  name,
+		Oldest:   int64(st.Oldest),
+		Merged:   int64(st.Merged),
+		Canceled: int64(st.Canceled),
+	}
+
+	for prio := range prios {
+		w := st.Waits[prio]
+		pb.Priorities = append(pb.Priorities, &QueueWait{
+			Priority: int32(prio),
+			Depth:    int64(st.Depth[prio]),
+			Count:    int64(w.Count),
+			Total:    int64(w.Total),
+			Max:      int64(w.Max),
+		})
+	}
+
+	// highest priority first
+	sort.Slice(pb.Priorities, func(i, j int) bool {
+		return pb.Priorities[i].Priority > pb.Priorities[j].Priority
+	})
+
+	return pb
+}
+
+// TaprQueueStats converts a proto.QueueStats to a changer.QueueStats.
+func TaprQueueStats(pb *QueueStats) changer.QueueStats {
+	st := changer.QueueStats{
+		Depth:    make(map[changer.Priority]int),
+		Oldest:   time.Duration(pb.Oldest),
+		Waits:    make(map[changer.Priority]changer.Wait),
+		Merged:   int(pb.Merged),
+		Canceled: int(pb.Canceled),
+	}
+
+	for _, w := range pb.Priorities {
+		prio := changer.Priority(w.Priority)
+
+		if w.Depth != 0 {
+			st.Depth[prio] = int(w.Depth)
+		}
+
+		if w.Count != 0 {
+			st.Waits[prio] = changer.Wait{
+				Count: int(w.Count),
+				Total: time.Duration(w.Total),
+				Max:   time.Duration(w.Max),
+			}
+		}
+	}
+
+	return st
+}
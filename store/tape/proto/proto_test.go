@@ -0,0 +1,167 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proto_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/inv"
+	"tapr.space/store/tape/proto"
+)
+
+// maxEnum bounds the search for defined enumeration values.
+const maxEnum = 64
+
+// volumeCategories returns the volume categories known by the tape package,
+// that is, the categories with a name.
+func volumeCategories() (cats []tape.VolumeCategory) {
+	for i := 0; i < maxEnum; i++ {
+		cat := tape.VolumeCategory(i)
+		if named(func() { _ = cat.String() }) {
+			cats = append(cats, cat)
+		}
+	}
+
+	return cats
+}
+
+// slotCategories returns the slot categories known by the tape package.
+func slotCategories() (cats []tape.SlotCategory) {
+	for i := 0; i < maxEnum; i++ {
+		cat := tape.SlotCategory(i)
+		if named(func() { _ = cat.String() }) {
+			cats = append(cats, cat)
+		}
+	}
+
+	return cats
+}
+
+// named reports whether fn returns without panicking.
+func named(fn func()) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	fn()
+
+	return true
+}
+
+func TestVolumeCategories(t *testing.T) {
+	cats := volumeCategories()
+
+	if len(cats) != len(proto.Volume_Category_name) {
+		t.Fatalf("tape defines %d volume categories, proto defines %d", len(cats), len(proto.Volume_Category_name))
+	}
+
+	for _, cat := range cats {
+		pb := proto.VolumeCategoryProto(cat)
+
+		if int32(pb) != int32(cat) {
+			t.Errorf("%v: proto value %d (%v), expected %d", cat, pb, pb, cat)
+		}
+
+		if got := proto.TaprVolumeCategory(pb); got != cat {
+			t.Errorf("%v: round-trip returned %v", cat, got)
+		}
+	}
+}
+
+func TestVolumeRoundTrip(t *testing.T) {
+	flags := []uint32{
+		0,
+		tape.StatusTransfering,
+		tape.StatusMounted,
+		tape.StatusNeedsCleaning,
+		tape.StatusFormatted,
+		tape.StatusOffsite,
+		tape.StatusTransfering | tape.StatusMounted | tape.StatusNeedsCleaning | tape.StatusFormatted | tape.StatusOffsite,
+	}
+
+	var tests []tape.Volume
+	for _, cat := range volumeCategories() {
+		for _, slot := range slotCategories() {
+			for _, f := range flags {
+				tests = append(tests, tape.Volume{
+					Serial:   "A00000L7",
+					Location: tape.Location{Addr: 42, Category: slot, Changer: "primary"},
+					Home:     tape.Location{Addr: 7, Category: tape.StorageSlot, Changer: "primary"},
+					Category: cat,
+					Flags:    f,
+					Mounts:   3,
+				})
+			}
+		}
+	}
+
+	for _, vol := range tests {
+		if got := proto.TaprVolume(proto.VolumeProto(vol)); got != vol {
+			t.Errorf("round-trip of %v returned %v", vol.String(), got.String())
+		}
+	}
+}
+
+func TestAuditResultRoundTrip(t *testing.T) {
+	storage := tape.Location{Addr: 7, Category: tape.StorageSlot, Changer: "primary"}
+	transfer := tape.Location{Addr: 1, Category: tape.TransferSlot, Changer: "primary"}
+
+	res := inv.AuditResult{
+		Changer: "primary",
+		New:     []inv.Difference{{Serial: "A00001L7", To: storage}},
+		Mounted: []inv.Difference{{Serial: "A00002L7", From: storage, To: transfer}},
+		Missing: []inv.Difference{{Serial: "A00003L7", From: storage}},
+	}
+
+	if got := proto.TaprAuditResult(proto.AuditResultProto(res)); !reflect.DeepEqual(got, res) {
+		t.Errorf("round-trip of %+v returned %+v", res, got)
+	}
+}
+
+func TestQueueStatsRoundTrip(t *testing.T) {
+	st := changer.QueueStats{
+		Depth: map[changer.Priority]int{
+			changer.Recall:     2,
+			changer.Background: 1,
+		},
+		Oldest: 3 * time.Second,
+		Waits: map[changer.Priority]changer.Wait{
+			changer.Recall: {Count: 4, Total: 10 * time.Second, Max: 5 * time.Second},
+			changer.Normal: {Count: 1, Total: time.Second, Max: time.Second},
+		},
+		Merged:   5,
+		Canceled: 1,
+	}
+
+	pb := proto.QueueStatsProto("primary", st)
+
+	if pb.Changer != "primary" {
+		t.Errorf("changer is %q, expected %q", pb.Changer, "primary")
+	}
+
+	if len(pb.Priorities) != 3 || pb.Priorities[0].Priority != int32(changer.Recall) {
+		t.Errorf("priorities are %v, expected recall, normal and background", pb.Priorities)
+	}
+
+	if got := proto.TaprQueueStats(pb); !reflect.DeepEqual(got, st) {
+		t.Errorf("round-trip of %+v returned %+v", st, got)
+	}
+}
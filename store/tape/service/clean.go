@@ -0,0 +1,166 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tapr.space/errors"
+	"tapr.space/log"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/drive"
+)
+
+const (
+	// defaultCleaningUses is the number of cleanings a cleaning cartridge
+	// is good for if not configured.
+	defaultCleaningUses = 50
+
+	// defaultCleaningDuration is the time allowed for a cleaning cycle if
+	// not configured.
+	defaultCleaningDuration = 2 * time.Minute
+
+	// cleaningCheckInterval is how often drives are checked for whether
+	// they need cleaning.
+	cleaningCheckInterval = time.Minute
+)
+
+// cleaner cleans drives that ask for it, by raising a cleaning alert, or that
+// have reached the configured number of mounts since their last cleaning.
+type cleaner struct {
+	svc *service
+	cfg tape.CleaningConfig
+}
+
+func newCleaner(svc *service, cfg tape.CleaningConfig) *cleaner {
+	if cfg.Uses == 0 {
+		cfg.Uses = defaultCleaningUses
+	}
+
+	if cfg.Duration == 0 {
+		cfg.Duration = defaultCleaningDuration
+	}
+
+	return &cleaner{svc: svc, cfg: cfg}
+}
+
+// run periodically cleans the drives in need of cleaning. Drives are
+// cleaned one at a time.
+func (c *cleaner) run() {
+	for range time.Tick(cleaningCheckInterval) {
+		c.check()
+	}
+}
+
+// check polls the drives for alerts and cleans those in need of cleaning.
+func (c *cleaner) check() {
+	const op = "store/tape/service.cleaner"
+
+	for _, drv := range c.svc.allDrives() {
+		if err := drv.Poll(); err != nil {
+			log.Error.Printf("%s: could not poll %v: %v", op, drv, err)
+		}
+
+		if !drv.NeedsCleaning(c.cfg.Interval) {
+			continue
+		}
+
+		if err := c.clean(drv); err != nil {
+			log.Error.Printf("%s: could not clean %v: %v", op, drv, err)
+		}
+	}
+}
+
+// clean drains the drive, cleans it and puts it back in service.
+func (c *cleaner) clean(drv *drive.Drive) error {
+	op := fmt.Sprintf("store/tape/service.clean[%v]", drv)
+
+	serial, err := c.cartridge(drv.Changer())
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	log.Debug.Printf("%s: draining drive", op)
+
+	var release func()
+	if c.svc.drives[drv.String()] == drv {
+		release = c.svc.sched.drain(drv)
+	} else {
+		release = c.svc.recall.drain(drv)
+	}
+
+	defer release()
+
+	// nobody waits for a cleaning, but once the cartridge is in the drive
+	// it must be taken out again, so the moves are never canceled
+	ctx := changer.WithPriority(context.Background(), changer.Background)
+	if err := drv.Clean(ctx, serial, c.cfg.Duration); err != nil {
+		return errors.E(op, err)
+	}
+
+	vol, err := c.svc.inv.Info(serial)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if vol.Mounts >= c.cfg.Uses {
+		reason := fmt.Sprintf("worn out after %d cleanings", vol.Mounts)
+		if err := c.svc.inv.Transition(serial, tape.Expired, reason); err != nil {
+			return errors.E(op, err)
+		}
+
+		log.Printf("%s: cleaning cartridge %v has expired", op, serial)
+
+		return nil
+	}
+
+	log.Debug.Printf("%s: cleaning cartridge %v has %d uses left", op, serial, c.cfg.Uses-vol.Mounts)
+
+	return nil
+}
+
+// cartridge returns a usable cleaning cartridge in the named changer. The
+// most worn cartridge is used first such that cartridges are used up one at
+// a time.
+func (c *cleaner) cartridge(changer string) (tape.Serial, error) {
+	vols, err := c.svc.inv.Volumes()
+	if err != nil {
+		return "", err
+	}
+
+	var best *tape.Volume
+	for i, vol := range vols {
+		if vol.Category != tape.Cleaning || vol.Location.Category != tape.StorageSlot || vol.Location.Changer != changer {
+			continue
+		}
+
+		if vol.Mounts >= c.cfg.Uses {
+			continue
+		}
+
+		if best == nil || vol.Mounts > best.Mounts {
+			best = &vols[i]
+		}
+	}
+
+	if best == nil {
+		return "", errors.E(errors.NotExist, errors.Strf("no usable cleaning cartridge in changer %q", changer))
+	}
+
+	return best.Serial, nil
+}
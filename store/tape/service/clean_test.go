@@ -0,0 +1,129 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/drive/st"
+)
+
+// cartridge is the cleaning cartridge of the fake changer.
+const cartridge = "CLN000L1"
+
+func TestCleaner(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1, cleaning: tape.CleaningConfig{Uses: 2}})
+	defer cleanup()
+
+	drv := s.sched.drives[0]
+	prev := drv.Serial()
+
+	// a drive in use is drained before it is cleaned
+	_, release, err := s.sched.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drv.Device().(*st.Virtual).Raise(st.Cleaning)
+
+	isBlocked, done := blocked(s.clean.check)
+	if !isBlocked {
+		t.Fatal("drive was cleaned while in use")
+	}
+
+	release()
+	wait(t, done)
+
+	if drv.NeedsCleaning(0) {
+		t.Error("drive needs cleaning after cleaning")
+	}
+
+	if serial := drv.Serial(); serial != prev {
+		t.Errorf("serial after cleaning = %v, want %v", serial, prev)
+	}
+
+	// the drive is in service again
+	if _, release, err := s.sched.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	} else {
+		release()
+	}
+
+	// the cartridge expires when used up
+	drv.Device().(*st.Virtual).Raise(st.Cleaning)
+	s.clean.check()
+
+	vol, err := s.inv.Info(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Category != tape.Expired || vol.Mounts != 2 {
+		t.Errorf("cartridge: category = %v, mounts = %d; want %v after 2 uses", vol.Category, vol.Mounts, tape.Expired)
+	}
+
+	drv.RequestCleaning()
+
+	if err := s.clean.clean(drv); !errors.Is(errors.NotExist, err) {
+		t.Errorf("clean without a usable cartridge: got %v, want not exist error", err)
+	}
+
+	if !drv.NeedsCleaning(0) {
+		t.Error("drive no longer needs cleaning after a failed cleaning")
+	}
+}
+
+func TestCleanerInterval(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1, cleaning: tape.CleaningConfig{Interval: 2}})
+	defer cleanup()
+
+	// the write drive loaded a volume when it was started
+	rdrv := s.recall.drives[0]
+
+	for _, serial := range []tape.Serial{"A00004L7", "A00005L7"} {
+		if rdrv.NeedsCleaning(2) {
+			t.Fatalf("read drive needs cleaning before loading %v", serial)
+		}
+
+		_, release, err := s.recall.acquire(context.Background(), serial, "primary")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		release()
+	}
+
+	if !rdrv.NeedsCleaning(2) {
+		t.Fatal("read drive does not need cleaning after 2 mounts")
+	}
+
+	s.clean.check()
+
+	if rdrv.NeedsCleaning(2) {
+		t.Error("read drive needs cleaning after cleaning")
+	}
+
+	vol, err := s.inv.Info(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Mounts != 1 {
+		t.Errorf("cartridge mounts = %d, want 1", vol.Mounts)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"sync"
+
+	"tapr.space/errors"
+	"tapr.space/log"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/drive"
+)
+
+// recaller mounts volumes on read drives on demand. Clients reading from the
+// same volume share a single mount and a drive is only reused for another
+// volume when nobody is reading from it.
+type recaller struct {
+	drives []*drive.Drive
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// number of open files per drive
+	users map[*drive.Drive]int
+
+	// volumes currently being loaded and the drives they are loaded into
+	loading map[tape.Serial]*drive.Drive
+
+	// drives not accepting new users
+	draining map[*drive.Drive]bool
+}
+
+func newRecaller(drives []*drive.Drive) *recaller {
+	r := &recaller{
+		drives:   drives,
+		users:    make(map[*drive.Drive]int),
+		loading:  make(map[tape.Serial]*drive.Drive),
+		draining: make(map[*drive.Drive]bool),
+	}
+
+	r.cond = sync.NewCond(&r.mu)
+
+	return r
+}
+
+// acquire returns a read drive with the volume identified by serial mounted,
+// loading the volume if necessary. Only drives served by the named changer
+// are considered for loading the volume; if changer is empty, any drive is.
+// The wait for a drive and the changer moves are bound to ctx; a client is
+// waiting for them, so they jump the queue. The returned function MUST be
+// called when the caller is done using the drive.
+func (r *recaller) acquire(ctx context.Context, serial tape.Serial, changer string) (*drive.Drive, func(), error) {
+	const op = "store/tape/service.acquire"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.serves(changer) {
+		return nil, nil, errors.E(op, errors.Invalid, errors.Strf("no read drives configured for changer %q", changer))
+	}
+
+	for {
+		if drv := r.mounted(serial); drv != nil {
+			// the volume is available again once the drive is done
+			if r.draining[drv] {
+				if err := waitCond(ctx, r.cond); err != nil {
+					return nil, nil, errors.E(op, serial, err)
+				}
+
+				continue
+			}
+
+			r.users[drv]++
+			return drv, r.releaser(drv), nil
+		}
+
+		// somebody else is already loading the volume, wait for them
+		if _, ok := r.loading[serial]; ok {
+			if err := waitCond(ctx, r.cond); err != nil {
+				return nil, nil, errors.E(op, serial, err)
+			}
+
+			continue
+		}
+
+		drv := r.idle(changer)
+		if drv == nil {
+			log.Debug.Printf("%s: all read drives busy; waiting to recall %v", op, serial)
+			if err := waitCond(ctx, r.cond); err != nil {
+				return nil, nil, errors.E(op, serial, err)
+			}
+
+			continue
+		}
+
+		r.loading[serial] = drv
+
+		r.mu.Unlock()
+		err := drv.Load(withRecall(ctx), serial)
+		r.mu.Lock()
+
+		delete(r.loading, serial)
+		r.cond.Broadcast()
+
+		if err != nil {
+			return nil, nil, errors.E(op, err)
+		}
+
+		r.users[drv]++
+
+		return drv, r.releaser(drv), nil
+	}
+}
+
+// mounted returns the drive holding the given volume, if any. r.mu MUST be
+// held.
+func (r *recaller) mounted(serial tape.Serial) *drive.Drive {
+	for _, drv := range r.drives {
+		if r.busy(drv) {
+			continue
+		}
+
+		if drv.Serial() == serial {
+			return drv
+		}
+	}
+
+	return nil
+}
+
+// serves returns true if any read drive is served by the named changer.
+func (r *recaller) serves(changer string) bool {
+	for _, drv := range r.drives {
+		if changer == "" || drv.Changer() == changer {
+			return true
+		}
+	}
+
+	return false
+}
+
+// idle returns an unused drive served by the named changer, preferring empty
+// drives. r.mu MUST be held.
+func (r *recaller) idle(changer string) *drive.Drive {
+	var candidate *drive.Drive
+	for _, drv := range r.drives {
+		if changer != "" && drv.Changer() != changer {
+			continue
+		}
+
+		if r.busy(drv) || r.draining[drv] || r.users[drv] > 0 {
+			continue
+		}
+
+		if drv.Serial() == "" {
+			return drv
+		}
+
+		if candidate == nil {
+			candidate = drv
+		}
+	}
+
+	return candidate
+}
+
+// busy returns true if a volume is being loaded into the drive. r.mu MUST
+// be held.
+func (r *recaller) busy(drv *drive.Drive) bool {
+	for _, d := range r.loading {
+		if d == drv {
+			return true
+		}
+	}
+
+	return false
+}
+
+// drain stops handing out the given drive and blocks until the drive is no
+// longer in use. The returned function MUST be called to hand out the drive
+// again.
+func (r *recaller) drain(drv *drive.Drive) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.draining[drv] = true
+
+	for r.users[drv] > 0 || r.busy(drv) {
+		r.cond.Wait()
+	}
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		delete(r.draining, drv)
+		r.cond.Broadcast()
+	}
+}
+
+func (r *recaller) releaser(drv *drive.Drive) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			r.users[drv]--
+			if r.users[drv] == 0 {
+				r.cond.Broadcast()
+			}
+		})
+	}
+}
+
+// withRecall returns a copy of ctx carrying the recall priority.
+func withRecall(ctx context.Context) context.Context {
+	return changer.WithPriority(ctx, changer.Recall)
+}
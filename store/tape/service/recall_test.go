@@ -0,0 +1,200 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/drive"
+)
+
+func TestRecallMerge(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 2, limit: 1})
+	defer cleanup()
+
+	const serial = tape.Serial("A00004L7")
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		drvs = make(map[*drive.Drive]bool)
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			drv, release, err := s.recall.acquire(context.Background(), serial, "primary")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			defer release()
+
+			mu.Lock()
+			drvs[drv] = true
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	if len(drvs) != 1 {
+		t.Errorf("volume recalled into %d drives, want 1", len(drvs))
+	}
+
+	vol, err := s.inv.Info(serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Mounts != 1 {
+		t.Errorf("volume mounted %d times, want 1", vol.Mounts)
+	}
+}
+
+func TestRecallBusy(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1})
+	defer cleanup()
+
+	_, release, err := s.recall.acquire(context.Background(), "A00004L7", "primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the only read drive is in use; another volume must wait
+	isBlocked, done := blocked(func() {
+		_, release, err := s.recall.acquire(context.Background(), "A00005L7", "primary")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		release()
+	})
+
+	if !isBlocked {
+		t.Fatal("volume recalled into a drive in use")
+	}
+
+	release()
+	wait(t, done)
+
+	if serial := s.recall.drives[0].Serial(); serial != "A00005L7" {
+		t.Errorf("read drive holds %v, want A00005L7", serial)
+	}
+}
+
+func TestRecallCancel(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1})
+	defer cleanup()
+
+	_, release, err := s.recall.acquire(context.Background(), "A00004L7", "primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var cerr error
+	isBlocked, done := blocked(func() {
+		_, _, cerr = s.recall.acquire(ctx, "A00005L7", "primary")
+	})
+
+	if !isBlocked {
+		t.Fatal("volume recalled into a drive in use")
+	}
+
+	cancel()
+	wait(t, done)
+
+	if cerr == nil {
+		t.Fatal("canceled recall succeeded")
+	}
+
+	if serial := s.recall.drives[0].Serial(); serial != "A00004L7" {
+		t.Errorf("read drive holds %v after a canceled recall, want A00004L7", serial)
+	}
+}
+
+func TestRecallDrain(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1})
+	defer cleanup()
+
+	const serial = tape.Serial("A00004L7")
+
+	drv, release, err := s.recall.acquire(context.Background(), serial, "primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resume func()
+	isBlocked, drained := blocked(func() { resume = s.recall.drain(drv) })
+	if !isBlocked {
+		t.Fatal("drive drained while in use")
+	}
+
+	release()
+	wait(t, drained)
+
+	// the mounted volume is not handed out while the drive is drained
+	isBlocked, done := blocked(func() {
+		_, release, err := s.recall.acquire(context.Background(), serial, "primary")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		release()
+	})
+
+	if !isBlocked {
+		t.Fatal("drained drive handed out")
+	}
+
+	resume()
+	wait(t, done)
+}
+
+func TestRecallChanger(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1})
+	defer cleanup()
+
+	const serial = tape.Serial("A00004L7")
+
+	// no read drive is served by the secondary changer
+	if _, _, err := s.recall.acquire(context.Background(), serial, "secondary"); !errors.Is(errors.Invalid, err) {
+		t.Fatalf("got %v, want invalid", err)
+	}
+
+	drv, release, err := s.recall.acquire(context.Background(), serial, "primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer release()
+
+	if drv.Changer() != "primary" {
+		t.Errorf("volume recalled into a drive of changer %q", drv.Changer())
+	}
+}
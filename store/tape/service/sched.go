@@ -0,0 +1,276 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"tapr.space"
+	"tapr.space/errors"
+	"tapr.space/log"
+	"tapr.space/storage"
+	"tapr.space/store/tape/drive"
+)
+
+// scheduler assigns write drives to push sessions. Sessions are spread over
+// the drives by the number of sessions on each drive and, for drives with
+// equally many sessions, by the number of bytes written by those sessions.
+// If all drives are saturated, sessions are queued until a drive frees up.
+type scheduler struct {
+	drives []*drive.Drive
+
+	// maximum number of concurrent sessions per drive
+	limit int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// number of open sessions per drive
+	sessions map[*drive.Drive]int
+
+	// bytes written by open sessions per drive
+	inflight map[*drive.Drive]int64
+
+	// number of sessions waiting for a drive
+	waiting int
+
+	// drives not accepting new sessions
+	draining map[*drive.Drive]bool
+}
+
+func newScheduler(drives map[string]*drive.Drive, limit int) *scheduler {
+	if limit < 1 {
+		limit = 1
+	}
+
+	var names []string
+	for name := range drives {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	sched := &scheduler{
+		limit:    limit,
+		sessions: make(map[*drive.Drive]int),
+		inflight: make(map[*drive.Drive]int64),
+		draining: make(map[*drive.Drive]bool),
+	}
+
+	for _, name := range names {
+		sched.drives = append(sched.drives, drives[name])
+	}
+
+	sched.cond = sync.NewCond(&sched.mu)
+
+	return sched
+}
+
+// acquire blocks until a write drive is available and returns it. The wait
+// is given up if ctx is done. The returned function MUST be called when the
+// session ends.
+func (sched *scheduler) acquire(ctx context.Context) (*drive.Drive, func(), error) {
+	const op = "store/tape/service.scheduler.acquire"
+
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	if len(sched.drives) == 0 {
+		return nil, nil, errors.E(op, errors.Invalid, errors.Str("no write drives configured"))
+	}
+
+	for {
+		if drv := sched.pick(); drv != nil {
+			sched.sessions[drv]++
+			return drv, sched.releaser(drv), nil
+		}
+
+		if err := sched.wait(ctx, op); err != nil {
+			return nil, nil, errors.E(op, err)
+		}
+	}
+}
+
+// acquireDrive is like acquire, but blocks until the given drive is
+// available.
+func (sched *scheduler) acquireDrive(ctx context.Context, drv *drive.Drive) (func(), error) {
+	const op = "store/tape/service.scheduler.acquireDrive"
+
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	for sched.sessions[drv] >= sched.limit || sched.draining[drv] {
+		if err := sched.wait(ctx, op); err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	sched.sessions[drv]++
+
+	return sched.releaser(drv), nil
+}
+
+// pick returns the least loaded drive that can accept another session.
+// sched.mu MUST be held.
+func (sched *scheduler) pick() *drive.Drive {
+	var best *drive.Drive
+	for _, drv := range sched.drives {
+		if sched.sessions[drv] >= sched.limit || sched.draining[drv] {
+			continue
+		}
+
+		if best == nil || sched.less(drv, best) {
+			best = drv
+		}
+	}
+
+	return best
+}
+
+// less reports whether drive a is less loaded than drive b. sched.mu MUST
+// be held.
+func (sched *scheduler) less(a, b *drive.Drive) bool {
+	if sched.sessions[a] != sched.sessions[b] {
+		return sched.sessions[a] < sched.sessions[b]
+	}
+
+	return sched.inflight[a] < sched.inflight[b]
+}
+
+// wait waits for a session to end or ctx to be done. sched.mu MUST be
+// held.
+func (sched *scheduler) wait(ctx context.Context, op string) error {
+	sched.waiting++
+	log.Debug.Printf("%s: all write drives busy; queued (waiting: %d)", op, sched.waiting)
+	err := waitCond(ctx, sched.cond)
+	sched.waiting--
+
+	return err
+}
+
+// waitCond is like cond.Wait, but also returns when ctx is done, in which case
+// the error of ctx is returned. The lock of cond MUST be held.
+func waitCond(ctx context.Context, cond *sync.Cond) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if ctx.Done() == nil {
+		cond.Wait()
+		return nil
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			// the waiter holds the lock until it is waiting
+			cond.L.Lock()
+			cond.Broadcast()
+			cond.L.Unlock()
+		case <-stop:
+		}
+	}()
+
+	cond.Wait()
+
+	return ctx.Err()
+}
+
+// drain stops assigning sessions to the given drive and blocks until the
+// open sessions on the drive have ended. The returned function MUST be
+// called to resume assigning sessions to the drive.
+func (sched *scheduler) drain(drv *drive.Drive) func() {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	sched.draining[drv] = true
+
+	for sched.sessions[drv] > 0 {
+		sched.cond.Wait()
+	}
+
+	return func() {
+		sched.mu.Lock()
+		defer sched.mu.Unlock()
+
+		delete(sched.draining, drv)
+		sched.cond.Broadcast()
+	}
+}
+
+// account records that n bytes have been written to the drive.
+func (sched *scheduler) account(drv *drive.Drive, n int64) {
+	sched.mu.Lock()
+	sched.inflight[drv] += n
+	sched.mu.Unlock()
+}
+
+func (sched *scheduler) releaser(drv *drive.Drive) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			sched.mu.Lock()
+			defer sched.mu.Unlock()
+
+			sched.sessions[drv]--
+			if sched.sessions[drv] == 0 {
+				sched.inflight[drv] = 0
+			}
+
+			sched.cond.Broadcast()
+		})
+	}
+}
+
+// session is a tapr.File written as part of a push session. It accounts
+// the bytes written to the drive and ends the session when closed.
+type session struct {
+	tapr.File
+
+	sched   *scheduler
+	drv     *drive.Drive
+	release func()
+}
+
+// Write implements tapr.File.
+func (s *session) Write(p []byte) (int, error) {
+	n, err := s.File.Write(p)
+	s.sched.account(s.drv, int64(n))
+
+	return n, err
+}
+
+// Sync implements storage.Syncer.
+func (s *session) Sync() error {
+	return storage.Sync(s.File)
+}
+
+// Truncate implements storage.Truncater.
+func (s *session) Truncate(size int64) error {
+	return storage.Truncate(s.File, size)
+}
+
+// Close implements tapr.File.
+func (s *session) Close() error {
+	defer s.release()
+
+	return s.File.Close()
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSchedulerSpread(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 2, limit: 2})
+	defer cleanup()
+
+	a, release, err := s.sched.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer release()
+
+	b, release, err := s.sched.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer release()
+
+	if a == b {
+		t.Errorf("sessions both assigned to %v while another drive is idle", a)
+	}
+}
+
+func TestSchedulerLimit(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, limit: 2})
+	defer cleanup()
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		_, release, err := s.sched.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		releases = append(releases, release)
+	}
+
+	isBlocked, done := blocked(func() {
+		_, release, err := s.sched.acquire(context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		release()
+	})
+
+	if !isBlocked {
+		t.Fatal("session assigned to a saturated drive")
+	}
+
+	releases[0]()
+	wait(t, done)
+
+	releases[1]()
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, limit: 1})
+	defer cleanup()
+
+	drv, release, err := s.sched.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var aerr, derr error
+
+	isBlocked, done := blocked(func() {
+		_, _, aerr = s.sched.acquire(ctx)
+		_, derr = s.sched.acquireDrive(ctx, drv)
+	})
+
+	if !isBlocked {
+		t.Fatal("session assigned to a saturated drive")
+	}
+
+	cancel()
+	wait(t, done)
+
+	if aerr == nil || derr == nil {
+		t.Errorf("canceled sessions: acquire: %v, acquireDrive: %v; want errors", aerr, derr)
+	}
+
+	s.sched.mu.Lock()
+	defer s.sched.mu.Unlock()
+
+	if s.sched.waiting != 0 || s.sched.sessions[drv] != 1 {
+		t.Errorf("waiting = %d, sessions = %d; want 0 and 1", s.sched.waiting, s.sched.sessions[drv])
+	}
+}
+
+func TestSchedulerDrain(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 2, limit: 1})
+	defer cleanup()
+
+	drv, release, err := s.sched.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resume func()
+	isBlocked, drained := blocked(func() { resume = s.sched.drain(drv) })
+	if !isBlocked {
+		t.Fatal("drive drained while in use")
+	}
+
+	release()
+	wait(t, drained)
+
+	// sessions go to the other drive while drv is drained
+	for i := 0; i < 2; i++ {
+		other, release, err := s.sched.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if other == drv {
+			t.Fatal("session assigned to a drained drive")
+		}
+
+		release()
+	}
+
+	resume()
+
+	if _, err := s.sched.acquireDrive(context.Background(), drv); err != nil {
+		t.Fatal(err)
+	}
+}
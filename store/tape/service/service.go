@@ -15,11 +15,18 @@
 package service
 
 import (
+	"context"
 	"os"
+	"path"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"tapr.space"
+	"tapr.space/bitmask"
 	"tapr.space/config"
+	"tapr.space/errors"
 	"tapr.space/flags"
 	"tapr.space/format"
 	"tapr.space/log"
@@ -38,13 +45,22 @@ type service struct {
 	name string
 
 	inv    inv.Inventory
-	chgr   changer.Changer
+	chgrs  map[string]changer.Changer
 	drives map[string]*drive.Drive
 
+	recall *recaller
+	sched  *scheduler
+	clean  *cleaner
+
 	fmtr format.Formatter
 }
 
-var _ store.Store = (*service)(nil)
+var (
+	_ store.Store         = (*service)(nil)
+	_ store.Checksummer   = (*service)(nil)
+	_ store.ContextOpener = (*service)(nil)
+	_ inv.Provider        = (*service)(nil)
+)
 
 // New creates a new store.Store service.
 func New(name string, _cfg config.StoreConfig) (store.Store, error) {
@@ -52,7 +68,11 @@ func New(name string, _cfg config.StoreConfig) (store.Store, error) {
 	cfg := _cfg.Embedded.(tape.Config)
 
 	// setup inventory
-	invOpts := cfg.Inventory.Options
+	invOpts := make(map[string]string)
+	for k, v := range cfg.Inventory.Options {
+		invOpts[k] = v
+	}
+
 	invOpts["cleaning-prefix"] = cfg.CleaningPrefix
 	invdb, err := inv.Create(cfg.Inventory.Driver, invOpts)
 	if err != nil {
@@ -67,34 +87,72 @@ func New(name string, _cfg config.StoreConfig) (store.Store, error) {
 		}
 	}
 
-	// setup changer
-	chgrOpts := make(map[string]interface{})
-	for k, v := range cfg.Changers["primary"].Options {
-		chgrOpts[k] = v
+	// bring the inventory schema up to date
+	if err := invdb.Migrate(); err != nil {
+		log.Fatal(err)
 	}
 
-	chgrOpts["cleaning-prefix"] = cfg.CleaningPrefix
-
+	// setup changers
+	var vols []tape.Volume
 	if flags.EmulateDevices {
-		vols, err := invdb.Volumes()
+		vols, err = invdb.Volumes()
 		if err != nil {
 			log.Fatal(err)
 		}
-
-		chgrOpts["vols"] = vols
 	}
 
-	chgr, err := changer.Create(cfg.Changers["primary"].Driver, chgrOpts)
-	if err != nil {
-		log.Fatal(err)
-	}
+	chgrs := make(map[string]changer.Changer)
+	for chgrName, chgrCfg := range cfg.Changers {
+		chgrOpts := make(map[string]interface{})
+		for k, v := range chgrCfg.Options {
+			chgrOpts[k] = v
+		}
+
+		chgrOpts["cleaning-prefix"] = cfg.CleaningPrefix
+
+		if flags.EmulateDevices {
+			// only pass on the volumes held by this changer
+			var held []tape.Volume
+			for _, v := range vols {
+				if v.Location.Changer == chgrName {
+					held = append(held, v)
+				}
+			}
+
+			chgrOpts["vols"] = held
+		}
 
-	// perform an audit if requested
-	if flags.Audit {
-		log.Debug.Printf("%s: auditing inventory", op)
-		if err := invdb.Audit(chgr); err != nil {
+		chgr, err := changer.Create(chgrCfg.Driver, chgrOpts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// moves are queued so that recalls are not stuck behind background
+		// work
+		chgrs[chgrName] = changer.Named(chgrName, changer.NewQueue(chgr))
+
+		// settle moves interrupted by a crash before anything else touches
+		// the changer
+		ins, err := invdb.Recover(chgrs[chgrName])
+		if err != nil {
 			log.Fatal(err)
 		}
+
+		if len(ins) > 0 {
+			log.Printf("%s: recovered %d interrupted moves (changer %s)", op, len(ins), chgrName)
+		}
+
+		// perform an audit if requested
+		if flags.Audit {
+			log.Debug.Printf("%s: auditing inventory (changer %s)", op, chgrName)
+			res, err := invdb.Audit(chgrs[chgrName], false)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			log.Debug.Printf("%s: audit of changer %s: %d new, %d moved, %d mounted, %d missing, %d removed", op, chgrName,
+				len(res.New), len(res.Moved), len(res.Mounted), len(res.Missing), len(res.Removed))
+		}
 	}
 
 	fmtr, err := format.Create(cfg.Drives.Format)
@@ -105,8 +163,13 @@ func New(name string, _cfg config.StoreConfig) (store.Store, error) {
 	// setup drives
 	var wg sync.WaitGroup
 	drvs := make(map[string]*drive.Drive)
-	for name, cfg := range cfg.Drives.Write {
-		drv, err := drive.New(name, cfg)
+	for name, dcfg := range cfg.Drives.Write {
+		chgr, err := resolveChanger(name, &dcfg, chgrs)
+		if err != nil {
+			log.Fatal(errors.E(op, err))
+		}
+
+		drv, err := drive.New(name, dcfg)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -124,47 +187,563 @@ func New(name string, _cfg config.StoreConfig) (store.Store, error) {
 		}()
 	}
 
+	var readers []*drive.Drive
+	for name, dcfg := range cfg.Drives.Read {
+		chgr, err := resolveChanger(name, &dcfg, chgrs)
+		if err != nil {
+			log.Fatal(errors.E(op, err))
+		}
+
+		drv, err := drive.New(name, dcfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		readers = append(readers, drv)
+
+		wg.Add(1)
+
+		go func() {
+			if err := drv.Attach(invdb, chgr, fmtr); err != nil {
+				log.Fatal(err)
+			}
+
+			wg.Done()
+		}()
+	}
+
 	wg.Wait()
 
 	log.Debug.Printf("%s: drives ready", op)
 
-	return &service{
+	s := &service{
 		name:   name,
 		inv:    invdb,
-		chgr:   chgr,
+		chgrs:  chgrs,
 		drives: drvs,
+		recall: newRecaller(readers),
+		sched:  newScheduler(drvs, cfg.Drives.MaxWriters),
 		fmtr:   fmtr,
-	}, nil
+	}
+
+	s.clean = newCleaner(s, cfg.Cleaning)
+	go s.clean.run()
+
+	return s, nil
+}
+
+// resolveChanger returns the changer serving the named drive. If the drive
+// configuration does not name a changer, the only configured changer is
+// used and recorded in cfg.
+func resolveChanger(name string, cfg *tape.DriveConfig, chgrs map[string]changer.Changer) (changer.Changer, error) {
+	if cfg.Changer == "" {
+		if len(chgrs) != 1 {
+			return nil, errors.E(errors.Invalid, errors.Strf("drive %s: changer must be specified when %d changers are configured", name, len(chgrs)))
+		}
+
+		for chgrName := range chgrs {
+			cfg.Changer = chgrName
+		}
+	}
+
+	chgr, ok := chgrs[cfg.Changer]
+	if !ok {
+		return nil, errors.E(errors.Invalid, errors.Strf("drive %s: unknown changer %q", name, cfg.Changer))
+	}
+
+	return chgr, nil
 }
 
 func (s *service) String() string {
 	return s.name
 }
 
+// Inventory implements inv.Provider.
+func (s *service) Inventory() inv.Inventory {
+	return s.inv
+}
+
+// Changers implements inv.Provider.
+func (s *service) Changers() map[string]changer.Changer {
+	return s.chgrs
+}
+
 func (s *service) Create(name tapr.PathName) (tapr.File, error) {
-	panic("not implemented")
+	return s.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
 }
 
 func (s *service) Open(name tapr.PathName) (tapr.File, error) {
-	panic("not implemented")
+	return s.OpenFile(name, os.O_RDONLY)
+}
+
+func (s *service) Append(name tapr.PathName) (tapr.File, error) {
+	return s.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY)
 }
 
 func (s *service) OpenFile(name tapr.PathName, flag int) (tapr.File, error) {
-	return s.drives["write0"].Storage.OpenFile(name, flag)
+	return s.OpenFileContext(context.Background(), name, flag)
 }
 
-func (s *service) Append(name tapr.PathName) (tapr.File, error) {
-	panic("not implemented")
+// OpenFileContext implements store.ContextOpener. Recalls of the volumes
+// holding a file opened for reading are bound to ctx, so they are dropped
+// from the changer queue if the reader goes away before they start. Writers
+// stop waiting for a drive once ctx is done.
+func (s *service) OpenFileContext(ctx context.Context, name tapr.PathName, flag int) (tapr.File, error) {
+	const op = "store/tape/service.OpenFile"
+
+	// read-only access may require a recall of the volumes
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		ent, err := s.inv.Lookup(name)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		sp := &span{
+			ctx:  ctx,
+			svc:  s,
+			name: name,
+			flag: flag,
+			exts: ent.Extents,
+		}
+
+		// open the first extent right away to catch errors early
+		if err := sp.open(); err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		return sp, nil
+	}
+
+	// appending continues the file on the volume holding its last extent if
+	// that volume is being written to; otherwise the file continues in a
+	// new extent on whichever volume the scheduler picks
+	if flag&os.O_APPEND != 0 {
+		ent, err := s.inv.Lookup(name)
+		if err != nil && (!errors.Is(errors.NotExist, err) || flag&os.O_CREATE == 0) {
+			return nil, errors.E(op, err)
+		}
+
+		if err == nil {
+			if len(ent.Extents) == 0 {
+				return nil, errors.E(op, name, errors.Internal, errors.Str("file has no extents"))
+			}
+
+			if drv := s.writing(ent.Extents[len(ent.Extents)-1].Serial); drv != nil {
+				release, err := s.sched.acquireDrive(ctx, drv)
+				if err != nil {
+					return nil, errors.E(op, name, err)
+				}
+
+				f, err := drv.OpenFile(name, flag)
+				if err != nil {
+					release()
+					return nil, err
+				}
+
+				return &session{File: f, sched: s.sched, drv: drv, release: release}, nil
+			}
+		}
+	}
+
+	drv, release, err := s.sched.acquire(ctx)
+	if err != nil {
+		return nil, errors.E(op, name, err)
+	}
+
+	if err := drv.MkdirAll(tapr.PathName(path.Dir(string(name)))); err != nil {
+		release()
+		return nil, errors.E(op, name, err)
+	}
+
+	// the drive records the location of the file in the inventory
+	f, err := drv.OpenFile(name, flag)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	return &session{File: f, sched: s.sched, drv: drv, release: release}, nil
 }
 
 func (s *service) Mkdir(name tapr.PathName) error {
-	panic("not implemented")
+	const op = "store/tape/service.Mkdir"
+
+	// directories are created on all volumes being written to, such that
+	// they exist regardless of which volume a file ends up on.
+	for _, drv := range s.sched.drives {
+		if err := drv.Mkdir(name); err != nil {
+			return errors.E(op, name, err)
+		}
+	}
+
+	return nil
 }
 
 func (s *service) MkdirAll(name tapr.PathName) error {
-	panic("not implemented")
+	const op = "store/tape/service.MkdirAll"
+
+	for _, drv := range s.sched.drives {
+		if err := drv.MkdirAll(name); err != nil {
+			return errors.E(op, name, err)
+		}
+	}
+
+	return nil
 }
 
+// Stat describes the named file from the file catalog, so stating a file
+// never recalls a volume. The Sys method of the returned os.FileInfo returns
+// a *tapr.FileInfo holding the checksum, residency and volumes of the file.
 func (s *service) Stat(name tapr.PathName) (os.FileInfo, error) {
-	panic("not implemented")
+	const op = "store/tape/service.Stat"
+
+	ent, err := s.inv.Lookup(name)
+	if err != nil {
+		if !errors.Is(errors.NotExist, err) {
+			return nil, errors.E(op, err)
+		}
+
+		// directories are not cataloged, but the files in them are
+		ents, lerr := s.inv.List(dirPrefix(name))
+		if lerr != nil || len(ents) == 0 {
+			return nil, errors.E(op, err)
+		}
+
+		return &fileInfo{name: name, dir: true, info: &tapr.FileInfo{}}, nil
+	}
+
+	fi, err := s.fileInfo(ent)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return fi, nil
+}
+
+// SetChecksum implements store.Checksummer. The digest is recorded in the
+// file catalog.
+func (s *service) SetChecksum(name tapr.PathName, sum []byte) error {
+	const op = "store/tape/service.SetChecksum"
+
+	ent, err := s.inv.Lookup(name)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	ent.Checksum = sum
+
+	if err := s.inv.Commit(ent); err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+// ReadDir lists the named directory from the file catalog. Directories are
+// not cataloged themselves; they are implied by the files in them.
+func (s *service) ReadDir(name tapr.PathName) ([]os.FileInfo, error) {
+	const op = "store/tape/service.ReadDir"
+
+	prefix := dirPrefix(name)
+
+	ents, err := s.inv.List(prefix)
+	if err != nil {
+		return nil, errors.E(op, name, err)
+	}
+
+	if len(ents) == 0 {
+		if _, err := s.inv.Lookup(name); err == nil {
+			return nil, errors.E(op, name, errors.NotDir)
+		}
+
+		return nil, errors.E(op, name, errors.NotExist)
+	}
+
+	var fis []os.FileInfo
+	var dir *fileInfo
+	for _, ent := range ents {
+		rel := strings.TrimPrefix(string(ent.Path), string(prefix))
+
+		// files further down the tree imply a subdirectory
+		if i := strings.Index(rel, "/"); i >= 0 {
+			sub := prefix + tapr.PathName(rel[:i])
+			if dir == nil || dir.name != sub {
+				dir = &fileInfo{name: sub, dir: true, info: &tapr.FileInfo{}}
+				fis = append(fis, dir)
+			}
+
+			if ent.ModTime.After(dir.mtime) {
+				dir.mtime = ent.ModTime
+			}
+
+			continue
+		}
+
+		fi, err := s.fileInfo(ent)
+		if err != nil {
+			return nil, errors.E(op, name, err)
+		}
+
+		fis = append(fis, fi)
+	}
+
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+
+	return fis, nil
+}
+
+// Remove removes the named file from the file catalog. The data stays on
+// the volumes until they are reclaimed. Files being written can not be
+// removed.
+func (s *service) Remove(name tapr.PathName) error {
+	const op = "store/tape/service.Remove"
+
+	if err := s.idle(name); err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := s.inv.Remove(name); err != nil {
+		if !errors.Is(errors.NotExist, err) {
+			return errors.E(op, err)
+		}
+
+		// directories exist only as long as they hold files
+		if ents, lerr := s.inv.List(dirPrefix(name)); lerr == nil && len(ents) > 0 {
+			return errors.E(op, name, errors.NotEmpty)
+		}
+
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+// Rename renames a file or a directory in the file catalog. The data is not
+// moved on the volumes. Renaming a directory renames every file in it; the
+// target path names must not exist. Files being written can not be renamed.
+func (s *service) Rename(oldpath, newpath tapr.PathName) error {
+	const op = "store/tape/service.Rename"
+
+	if err := s.idle(oldpath); err != nil {
+		return errors.E(op, err)
+	}
+
+	_, err := s.inv.Lookup(oldpath)
+	if err == nil {
+		if err := s.inv.Rename(oldpath, newpath); err != nil {
+			return errors.E(op, err)
+		}
+
+		return nil
+	}
+
+	if !errors.Is(errors.NotExist, err) {
+		return errors.E(op, err)
+	}
+
+	oldprefix, newprefix := dirPrefix(oldpath), dirPrefix(newpath)
+	if strings.HasPrefix(string(newprefix), string(oldprefix)) {
+		return errors.E(op, newpath, errors.Invalid, errors.Str("cannot move a directory into itself"))
+	}
+
+	ents, err := s.inv.List(oldprefix)
+	if err != nil {
+		return errors.E(op, oldpath, err)
+	}
+
+	if len(ents) == 0 {
+		return errors.E(op, oldpath, errors.NotExist)
+	}
+
+	// check everything up front to avoid leaving a directory half renamed
+	for _, ent := range ents {
+		if err := s.idle(ent.Path); err != nil {
+			return errors.E(op, err)
+		}
+
+		target := newprefix + ent.Path[len(oldprefix):]
+		if _, err := s.inv.Lookup(target); err == nil {
+			return errors.E(op, target, errors.Exist)
+		}
+	}
+
+	// the files renamed so far are moved back if a rename fails
+	for i, ent := range ents {
+		if err := s.inv.Rename(ent.Path, newprefix+ent.Path[len(oldprefix):]); err != nil {
+			for _, done := range ents[:i] {
+				if rerr := s.inv.Rename(newprefix+done.Path[len(oldprefix):], done.Path); rerr != nil {
+					log.Error.Printf("%s: could not move %v back: %v", op, done.Path, rerr)
+				}
+			}
+
+			return errors.E(op, err)
+		}
+	}
+
+	return nil
+}
+
+// idle returns an error if the named file is open for writing.
+func (s *service) idle(name tapr.PathName) error {
+	for _, drv := range s.drives {
+		if drv.Writing(name) {
+			return errors.E(name, errors.Invalid, errors.Str("file is open for writing"))
+		}
+	}
+
+	return nil
+}
+
+// dirPrefix returns the prefix of the path names of the files in the named
+// directory.
+func dirPrefix(name tapr.PathName) tapr.PathName {
+	return tapr.PathName(strings.TrimSuffix(string(name), "/") + "/")
+}
+
+// fileInfo describes a cataloged file.
+func (s *service) fileInfo(ent tape.File) (*fileInfo, error) {
+	fi := &fileInfo{
+		name:  ent.Path,
+		size:  ent.Extents.Size(),
+		mtime: ent.ModTime,
+		info:  &tapr.FileInfo{Checksum: ent.Checksum},
+	}
+
+	if ent.Size > fi.size {
+		fi.size = ent.Size
+	}
+
+	// a file being written grows beyond what has been cataloged
+	if len(ent.Extents) > 0 {
+		last := ent.Extents[len(ent.Extents)-1]
+		if drv := s.writing(last.Serial); drv != nil {
+			if dfi, err := drv.Stat(last.Path(ent.Path)); err == nil {
+				fi.size, fi.mtime = last.Offset+dfi.Size(), dfi.ModTime()
+			}
+		}
+	}
+
+	if err := s.residency(fi.info, ent.Extents); err != nil {
+		return nil, err
+	}
+
+	return fi, nil
+}
+
+// residency fills in the volumes holding the extents and the residency of
+// the file. The residency of a file is that of its least accessible volume.
+func (s *service) residency(info *tapr.FileInfo, exts tape.Extents) error {
+	seen := make(map[tape.Serial]bool)
+	for _, ext := range exts {
+		if seen[ext.Serial] {
+			continue
+		}
+
+		seen[ext.Serial] = true
+
+		vol, err := s.inv.Info(ext.Serial)
+		if err != nil {
+			return err
+		}
+
+		info.Volumes = append(info.Volumes, string(vol.Serial))
+
+		var r tapr.Residency
+		switch {
+		case bitmask.IsSet(vol.Flags, tape.StatusOffsite),
+			vol.Category == tape.Missing,
+			vol.Location.Category != tape.StorageSlot && vol.Location.Category != tape.TransferSlot:
+			r = tapr.Offline
+		case vol.Location.Category == tape.StorageSlot:
+			r = tapr.Nearline
+		default:
+			r = tapr.Online
+		}
+
+		if r > info.Residency {
+			info.Residency = r
+		}
+	}
+
+	return nil
+}
+
+// acquire returns a drive holding the volume identified by serial,
+// recalling the volume into a read drive if it is not mounted. The returned
+// function MUST be called when the caller is done using the drive.
+func (s *service) acquire(ctx context.Context, serial tape.Serial) (*drive.Drive, func(), error) {
+	if drv := s.writing(serial); drv != nil {
+		return drv, func() {}, nil
+	}
+
+	vol, err := s.inv.Info(serial)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.recall.acquire(ctx, serial, vol.Location.Changer)
+}
+
+// fileInfo is an os.FileInfo for a cataloged file that may span multiple
+// extents.
+type fileInfo struct {
+	name  tapr.PathName
+	size  int64
+	mtime time.Time
+	dir   bool
+
+	info *tapr.FileInfo
+}
+
+// Name implements os.FileInfo.
+func (fi *fileInfo) Name() string {
+	return path.Base(string(fi.name))
+}
+
+// Size implements os.FileInfo.
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+// Mode implements os.FileInfo.
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+
+	return 0644
+}
+
+// ModTime implements os.FileInfo.
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.mtime
+}
+
+// IsDir implements os.FileInfo.
+func (fi *fileInfo) IsDir() bool {
+	return fi.dir
+}
+
+// Sys implements os.FileInfo. It returns a *tapr.FileInfo.
+func (fi *fileInfo) Sys() interface{} {
+	return fi.info
+}
+
+// allDrives returns the write drives followed by the read drives.
+func (s *service) allDrives() []*drive.Drive {
+	drvs := append([]*drive.Drive(nil), s.sched.drives...)
+	return append(drvs, s.recall.drives...)
+}
+
+// writing returns the write drive that has the given volume mounted, if any.
+func (s *service) writing(serial tape.Serial) *drive.Drive {
+	for _, drv := range s.drives {
+		if drv.Serial() == serial {
+			return drv
+		}
+	}
+
+	return nil
 }
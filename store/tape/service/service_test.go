@@ -0,0 +1,539 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"tapr.space"
+	"tapr.space/errors"
+	"tapr.space/flags"
+	"tapr.space/storage"
+	"tapr.space/storage/fsdir"
+	"tapr.space/store/tape"
+	"tapr.space/store/tape/changer"
+	"tapr.space/store/tape/changer/fake"
+	"tapr.space/store/tape/drive"
+	"tapr.space/store/tape/inv"
+	"tapr.space/store/tape/inv/embedded"
+)
+
+// fmtr is a format.Formatter handing out directories of limited capacity as
+// volumes.
+type fmtr struct {
+	mu   sync.Mutex
+	dir  string
+	size int64
+	vols map[tape.Serial]*volume
+}
+
+func (fm *fmtr) Format(devpath string, vol tape.Volume) (bool, storage.Storage, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	v, ok := fm.vols[vol.Serial]
+	if !ok {
+		root := filepath.Join(fm.dir, string(vol.Serial))
+		if err := os.MkdirAll(root, os.ModePerm); err != nil {
+			return false, nil, err
+		}
+
+		v = &volume{Storage: fsdir.New(root), free: fm.size}
+		fm.vols[vol.Serial] = v
+	}
+
+	return vol.Category == tape.Allocated, v, nil
+}
+
+// volume is a storage.Storage that runs out of space.
+type volume struct {
+	storage.Storage
+
+	mu   sync.Mutex
+	free int64
+}
+
+func (v *volume) OpenFile(name tapr.PathName, flag int) (tapr.File, error) {
+	f, err := v.Storage.OpenFile(name, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &volumeFile{File: f, vol: v}, nil
+}
+
+type volumeFile struct {
+	tapr.File
+	vol *volume
+}
+
+func (f *volumeFile) Write(p []byte) (int, error) {
+	f.vol.mu.Lock()
+	defer f.vol.mu.Unlock()
+
+	if int64(len(p)) <= f.vol.free {
+		n, err := f.File.Write(p)
+		f.vol.free -= int64(n)
+		return n, err
+	}
+
+	n, err := f.File.Write(p[:f.vol.free])
+	f.vol.free -= int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	return n, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC}
+}
+
+// testConfig configures the service created by setup.
+type testConfig struct {
+	// number of write and read drives
+	writers, readers int
+
+	// maximum number of sessions per write drive
+	limit int
+
+	// capacity of the volumes
+	size int64
+
+	cleaning tape.CleaningConfig
+}
+
+// setup returns a service backed by an embedded inventory and a queued fake
+// changer holding six volumes and a cleaning cartridge. The drives have
+// virtual st devices.
+func setup(t *testing.T, cfg testConfig) (*service, func()) {
+	dir, err := ioutil.TempDir("", "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invdb, err := embedded.New(map[string]string{
+		"path":            filepath.Join(dir, "inv.json"),
+		"cleaning-prefix": "CLN",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := invdb.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := fake.New(map[string]interface{}{
+		"transfer": fmt.Sprint(cfg.writers + cfg.readers),
+		"storage":  "8",
+		"ix":       "1",
+		"volumes":  "6",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chgr := changer.Named("primary", changer.NewQueue(c))
+
+	if _, err := invdb.Audit(chgr, false); err != nil {
+		t.Fatal(err)
+	}
+
+	flags.EmulateDevices = true
+
+	fm := &fmtr{dir: dir, size: cfg.size, vols: make(map[tape.Serial]*volume)}
+
+	newDrive := func(name string, slot int) *drive.Drive {
+		drv, err := drive.New(name, tape.DriveConfig{
+			Slot:    slot,
+			Path:    filepath.Join(dir, name),
+			Changer: "primary",
+			Device:  filepath.Join(dir, name+".img"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return drv
+	}
+
+	drvs := make(map[string]*drive.Drive)
+	for i := 0; i < cfg.writers; i++ {
+		drv := newDrive(fmt.Sprintf("write%d", i), i)
+		if err := drv.Start(invdb, chgr, fm); err != nil {
+			t.Fatal(err)
+		}
+
+		drvs[drv.String()] = drv
+	}
+
+	var readers []*drive.Drive
+	for i := 0; i < cfg.readers; i++ {
+		drv := newDrive(fmt.Sprintf("read%d", i), cfg.writers+i)
+		if err := drv.Attach(invdb, chgr, fm); err != nil {
+			t.Fatal(err)
+		}
+
+		readers = append(readers, drv)
+	}
+
+	s := &service{
+		name:   "test",
+		inv:    invdb,
+		chgrs:  map[string]changer.Changer{"primary": chgr},
+		drives: drvs,
+		recall: newRecaller(readers),
+		sched:  newScheduler(drvs, cfg.limit),
+		fmtr:   fm,
+	}
+
+	if cfg.cleaning.Duration == 0 {
+		cfg.cleaning.Duration = time.Millisecond
+	}
+
+	s.clean = newCleaner(s, cfg.cleaning)
+
+	return s, func() { os.RemoveAll(dir) }
+}
+
+// blocked returns true if fn does not return within a short while. If it
+// does not, it is left running.
+func blocked(fn func()) (bool, <-chan struct{}) {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return false, done
+	case <-time.After(100 * time.Millisecond):
+		return true, done
+	}
+}
+
+// wait waits for done to be closed, failing the test if it takes too long.
+func wait(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+}
+
+// write creates the named file holding data.
+func write(t *testing.T, s *service, name tapr.PathName, data string, flag int) {
+	t.Helper()
+
+	f, err := s.OpenFile(name, flag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenameAppend(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1, size: 1 << 20})
+	defer cleanup()
+
+	write(t, s, "/x", "abc", os.O_CREATE|os.O_WRONLY)
+
+	if err := s.Rename("/x", "/y"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data of /y is stored as /x; the appended data goes to a new
+	// extent stored as /y
+	write(t, s, "/y", "def", os.O_CREATE|os.O_APPEND|os.O_WRONLY)
+
+	ent, err := s.inv.Lookup("/y")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ent.Size != 6 || len(ent.Extents) != 2 {
+		t.Fatalf("size = %d, extents = %d; want 6 bytes in 2 extents", ent.Size, len(ent.Extents))
+	}
+
+	f, err := s.Open("/y")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "abcdef" {
+		t.Errorf("read %q, want %q", got, "abcdef")
+	}
+}
+
+// failing is an inventory failing to rename a given file.
+type failing struct {
+	inv.Inventory
+	path tapr.PathName
+}
+
+func (invdb *failing) Rename(oldpath, newpath tapr.PathName) error {
+	if oldpath == invdb.path {
+		return errors.E(oldpath, errors.IO, errors.Str("rename failed"))
+	}
+
+	return invdb.Inventory.Rename(oldpath, newpath)
+}
+
+func TestRenameDirectoryFailure(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1, size: 1 << 20})
+	defer cleanup()
+
+	names := []tapr.PathName{"/d/a", "/d/b", "/d/c"}
+	for _, name := range names {
+		write(t, s, name, string(name), os.O_CREATE|os.O_WRONLY)
+	}
+
+	s.inv = &failing{Inventory: s.inv, path: "/d/b"}
+
+	if err := s.Rename("/d", "/e"); !errors.Is(errors.IO, err) {
+		t.Fatalf("got %v, want IO error", err)
+	}
+
+	// the directory is left as it was
+	ents, err := s.inv.List("/d/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ents) != len(names) {
+		t.Errorf("got %d files in /d, want %d", len(ents), len(names))
+	}
+
+	if ents, err = s.inv.List("/e/"); err != nil || len(ents) != 0 {
+		t.Errorf("got %d files in /e (err = %v), want none", len(ents), err)
+	}
+}
+
+func TestAppendFull(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1, size: 1000})
+	defer cleanup()
+
+	write(t, s, "/f", "abc", os.O_CREATE|os.O_WRONLY)
+
+	// fill the volume holding /f; the drive rolls over to a new volume
+	write(t, s, "/big", strings.Repeat("x", 1500), os.O_CREATE|os.O_WRONLY)
+
+	// /f continues in a new extent on the volume being written to
+	write(t, s, "/f", "def", os.O_CREATE|os.O_APPEND|os.O_WRONLY)
+
+	ent, err := s.inv.Lookup("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ent.Extents) != 2 || ent.Extents[0].Serial == ent.Extents[1].Serial {
+		t.Fatalf("got extents %v, want 2 extents on different volumes", ent.Extents)
+	}
+
+	f, err := s.Open("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "abcdef" {
+		t.Errorf("read %q, want %q", got, "abcdef")
+	}
+}
+
+func TestSetChecksum(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1, size: 1 << 20})
+	defer cleanup()
+
+	write(t, s, "/f", "abc", os.O_CREATE|os.O_WRONLY)
+
+	sum := sha256.Sum256([]byte("abc"))
+	if err := s.SetChecksum("/f", sum[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	checksum := func() []byte {
+		t.Helper()
+
+		fi, err := s.Stat("/f")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return fi.Sys().(*tapr.FileInfo).Checksum
+	}
+
+	if got := checksum(); !bytes.Equal(got, sum[:]) {
+		t.Errorf("got checksum %x, want %x", got, sum)
+	}
+
+	// writing to the file discards the recorded digest
+	write(t, s, "/f", "def", os.O_CREATE|os.O_APPEND|os.O_WRONLY)
+
+	if got := checksum(); got != nil {
+		t.Errorf("got checksum %x after appending, want none", got)
+	}
+
+	if err := s.SetChecksum("/g", sum[:]); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v for a missing file, want error of kind NotExist", err)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1, size: 1 << 20})
+	defer cleanup()
+
+	if err := s.MkdirAll("/a/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	write(t, s, "/a/b/c", "abc", os.O_CREATE|os.O_WRONLY)
+	write(t, s, "/a/d", "de", os.O_CREATE|os.O_WRONLY)
+
+	fis, err := s.ReadDir("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, fi := range fis {
+		got = append(got, fmt.Sprintf("%s %v %d", fi.Name(), fi.IsDir(), fi.Size()))
+	}
+
+	want := []string{"b true 0", "d false 2"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ReadDir returned %v, want %v", got, want)
+	}
+
+	if fi, err := s.Stat("/a/b"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat of implied directory returned %v, %v", fi, err)
+	}
+
+	if _, err := s.Stat("/x"); !errors.Is(errors.NotExist, err) {
+		t.Errorf("Stat of missing file returned %v, want not exist", err)
+	}
+
+	if _, err := s.ReadDir("/a/d"); !errors.Is(errors.NotDir, err) {
+		t.Errorf("ReadDir of file returned %v, want not a directory", err)
+	}
+
+	if err := s.Remove("/a"); !errors.Is(errors.NotEmpty, err) {
+		t.Errorf("Remove of directory with files returned %v, want not empty", err)
+	}
+
+	if err := s.Rename("/a", "/a/e"); !errors.Is(errors.Invalid, err) {
+		t.Errorf("Rename into itself returned %v, want invalid", err)
+	}
+
+	if err := s.Rename("/a", "/z"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := s.Open("/z/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "abc" {
+		t.Errorf("read %q from renamed file, want %q", data, "abc")
+	}
+
+	if err := s.Remove("/z/d"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Stat("/z/d"); !errors.Is(errors.NotExist, err) {
+		t.Errorf("Stat of removed file returned %v, want not exist", err)
+	}
+}
+
+func TestResolveChanger(t *testing.T) {
+	primary := changer.Named("primary", nil)
+	secondary := changer.Named("secondary", nil)
+
+	one := map[string]changer.Changer{"primary": primary}
+	two := map[string]changer.Changer{"primary": primary, "secondary": secondary}
+
+	tests := []struct {
+		chgrs   map[string]changer.Changer
+		changer string
+		want    changer.Changer
+	}{
+		{one, "", primary},
+		{one, "primary", primary},
+		{one, "secondary", nil},
+		{two, "", nil},
+		{two, "secondary", secondary},
+	}
+
+	for _, tt := range tests {
+		cfg := tape.DriveConfig{Changer: tt.changer}
+
+		got, err := resolveChanger("d0", &cfg, tt.chgrs)
+		if tt.want == nil {
+			if !errors.Is(errors.Invalid, err) {
+				t.Errorf("changer %q of %d: got %v, want invalid", tt.changer, len(tt.chgrs), err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("changer %q of %d: %v", tt.changer, len(tt.chgrs), err)
+			continue
+		}
+
+		if got != tt.want || cfg.Changer != changer.Name(tt.want) {
+			t.Errorf("changer %q of %d: got %v (%q), want %v", tt.changer, len(tt.chgrs), got, cfg.Changer, tt.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"io"
+
+	"tapr.space"
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+)
+
+// span is a read-only tapr.File reading a file stored as one or more
+// extents. The volumes holding the extents are mounted in order as the
+// extents are reached.
+type span struct {
+	// ctx bounds the recalls of the volumes
+	ctx context.Context
+
+	svc  *service
+	name tapr.PathName
+	flag int
+	exts tape.Extents
+
+	// index of the current extent
+	i int
+
+	// current offset within the file
+	off int64
+
+	// the opened part of the current extent (if any)
+	part    tapr.File
+	release func()
+
+	// rd reads the rest of the current extent from part
+	rd io.Reader
+}
+
+// open opens the part of the current extent and positions it at the
+// current offset.
+func (sp *span) open() error {
+	if sp.i >= len(sp.exts) {
+		return errors.E(sp.name, errors.Internal, errors.Str("file has no extents"))
+	}
+
+	ext := sp.exts[sp.i]
+
+	drv, release, err := sp.svc.acquire(sp.ctx, ext.Serial)
+	if err != nil {
+		return err
+	}
+
+	f, err := drv.OpenFile(ext.Path(sp.name), sp.flag)
+	if err != nil {
+		release()
+		return err
+	}
+
+	if rel := sp.off - ext.Offset; rel != 0 {
+		if _, err := f.Seek(rel, io.SeekStart); err != nil {
+			f.Close()
+			release()
+			return err
+		}
+	}
+
+	sp.part, sp.release = f, release
+	sp.bound()
+
+	return nil
+}
+
+// bound limits reads from the opened part to the remainder of the current
+// extent, so that data stored past the extent on the volume is never
+// returned as part of the file.
+func (sp *span) bound() {
+	ext := sp.exts[sp.i]
+	sp.rd = io.LimitReader(sp.part, ext.Offset+ext.Length-sp.off)
+}
+
+// close closes the part of the current extent (if open) and releases the
+// drive it was read from.
+func (sp *span) close() error {
+	if sp.part == nil {
+		return nil
+	}
+
+	err := sp.part.Close()
+	sp.release()
+
+	sp.part, sp.release, sp.rd = nil, nil, nil
+
+	return err
+}
+
+// Name implements tapr.File.
+func (sp *span) Name() string {
+	return string(sp.name)
+}
+
+// Read implements tapr.File.
+func (sp *span) Read(p []byte) (int, error) {
+	for {
+		if sp.part == nil {
+			if err := sp.open(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := sp.rd.Read(p)
+		sp.off += int64(n)
+
+		if err == io.EOF && sp.i < len(sp.exts)-1 {
+			// continue with the next extent
+			if err := sp.close(); err != nil {
+				return n, err
+			}
+
+			sp.i++
+
+			if n > 0 {
+				return n, nil
+			}
+
+			continue
+		}
+
+		return n, err
+	}
+}
+
+// Write implements tapr.File.
+func (sp *span) Write(p []byte) (int, error) {
+	return 0, errors.E(sp.name, errors.Invalid, errors.Str("file is open for reading"))
+}
+
+// Seek implements tapr.File.
+func (sp *span) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += sp.off
+	case io.SeekEnd:
+		offset += sp.exts.Size()
+	}
+
+	if offset < 0 {
+		return sp.off, errors.E(sp.name, errors.Invalid, errors.Str("negative offset"))
+	}
+
+	if len(sp.exts) == 0 {
+		return sp.off, errors.E(sp.name, errors.Internal, errors.Str("file has no extents"))
+	}
+
+	if i := sp.exts.Find(offset); i != sp.i {
+		if err := sp.close(); err != nil {
+			return sp.off, err
+		}
+
+		sp.i = i
+	}
+
+	sp.off = offset
+
+	if sp.part != nil {
+		if _, err := sp.part.Seek(offset-sp.exts[sp.i].Offset, io.SeekStart); err != nil {
+			return sp.off, err
+		}
+
+		sp.bound()
+	}
+
+	return sp.off, nil
+}
+
+// Close implements tapr.File.
+func (sp *span) Close() error {
+	return sp.close()
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"tapr.space"
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+)
+
+func TestSpanRead(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1, size: 1000})
+	defer cleanup()
+
+	const name = tapr.PathName("/a/b")
+
+	data := make([]byte, 2500)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	f, err := s.OpenFile(name, os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ent, err := s.inv.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ent.Extents) != 3 {
+		t.Fatalf("file written as %d extents, want 3", len(ent.Extents))
+	}
+
+	// the first extents are recalled, the last is read from the write drive
+	f, err = s.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes differing from the %d bytes written", len(got), len(data))
+	}
+
+	// seeking back into the first extent recalls its volume again
+	if _, err := f.Seek(900, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 200)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf, data[900:1100]) {
+		t.Error("read across the first extent boundary differs from the data written")
+	}
+}
+
+func TestSpanBound(t *testing.T) {
+	s, cleanup := setup(t, testConfig{writers: 1, readers: 1, limit: 1, size: 1000})
+	defer cleanup()
+
+	const name = tapr.PathName("/a/b")
+
+	data := make([]byte, 2500)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	write(t, s, name, string(data), os.O_CREATE|os.O_WRONLY)
+
+	ent, err := s.inv.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pretend only the first 600 bytes stored on the first volume belong
+	// to the file
+	exts := append(tape.Extents(nil), ent.Extents...)
+	exts[0].Length = 600
+	for i := 1; i < len(exts); i++ {
+		exts[i].Offset -= 400
+	}
+
+	sp := &span{ctx: context.Background(), svc: s, name: name, flag: os.O_RDONLY, exts: exts}
+	defer sp.Close()
+
+	got, err := ioutil.ReadAll(sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := append(append([]byte(nil), data[:600]...), data[1000:]...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("read %d bytes, want the %d bytes of the extents", len(got), len(want))
+	}
+
+	sp = &span{ctx: context.Background(), svc: s, name: name, flag: os.O_RDONLY}
+	if _, err := sp.Read(make([]byte, 1)); !errors.Is(errors.Internal, err) {
+		t.Errorf("got %v, want internal error", err)
+	}
+}
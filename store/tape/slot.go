@@ -17,6 +17,8 @@ package tape
 import (
 	"database/sql/driver"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 // Addr is an element/slot address in a store.
@@ -26,6 +28,9 @@ type Addr int
 type Location struct {
 	Addr     Addr
 	Category SlotCategory
+
+	// Changer is the name of the media changer the location belongs to.
+	Changer string
 }
 
 type SlotMap map[Location]Volume
@@ -58,7 +63,7 @@ var SlotCategories = []SlotCategory{TransferSlot, StorageSlot, ImportExportSlot}
 
 // Value implements the driver.Valuer interface.
 func (loc *Location) Value() (driver.Value, error) {
-	return fmt.Sprintf("(%d,%s)", loc.Addr, loc.Category), nil
+	return fmt.Sprintf(`(%d,%s,"%s")`, loc.Addr, loc.Category, recordQuoter.Replace(loc.Changer)), nil
 }
 
 // Scan implements sql.Scanner.
@@ -69,27 +74,44 @@ func (loc *Location) Scan(src interface{}) error {
 
 	v := src.([]byte)
 
-	var addr int
-	var t string
+	if len(v) < 2 {
+		return fmt.Errorf("invalid location: %q", v)
+	}
 
 	// remove parantheses
-	str := string(v[1 : len(v)-1])
-
-	n, err := fmt.Sscanf(str, "%d,%s", &addr, &t)
-	if err != nil {
-		return err
+	fields := strings.SplitN(string(v[1:len(v)-1]), ",", 3)
+	if len(fields) < 2 {
+		return fmt.Errorf("invalid location: %q", v)
 	}
 
-	if n != 2 {
+	addr, err := strconv.Atoi(fields[0])
+	if err != nil {
 		return err
 	}
 
 	loc.Addr = Addr(addr)
-	loc.Category = ToSlotCategory(t)
+	loc.Category = ToSlotCategory(fields[1])
+	loc.Changer = ""
+
+	if len(fields) == 3 {
+		loc.Changer = fields[2]
+
+		// quoted if the name contains special characters
+		if len(loc.Changer) >= 2 && strings.HasPrefix(loc.Changer, `"`) {
+			loc.Changer = recordUnquoter.Replace(loc.Changer[1 : len(loc.Changer)-1])
+		}
+	}
 
 	return nil
 }
 
+// recordQuoter and recordUnquoter quote and unquote fields of composite
+// values as understood by PostgreSQL.
+var (
+	recordQuoter   = strings.NewReplacer(`"`, `""`, `\`, `\\`)
+	recordUnquoter = strings.NewReplacer(`""`, `"`, `\\`, `\`)
+)
+
 // String implements fmt.Stringer.
 func (cat SlotCategory) String() string {
 	switch cat {
@@ -140,6 +162,10 @@ type Slot struct {
 
 	// Volume returns the volume currently in this slot (if any).
 	Volume *Volume
+
+	// DriveSerial is the serial number of the drive in a data transfer slot
+	// (if reported by the changer).
+	DriveSerial string
 }
 
 func (s *Slot) String() string {
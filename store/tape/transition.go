@@ -0,0 +1,83 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tape
+
+import (
+	"fmt"
+	"time"
+
+	"tapr.space/errors"
+)
+
+// transitions is the volume state machine. It maps a volume category to the
+// categories a volume may move to from there. Any category may move to
+// itself.
+var transitions = map[VolumeCategory][]VolumeCategory{
+	// new volumes are discovered by audits
+	UnknownVolume: {Scratch, Cleaning, Missing, Damaged},
+
+	Scratch:    {Allocating, Missing, Damaged},
+	Allocating: {Allocated, Scratch, Missing, Damaged},
+	Allocated:  {Filling, Scratch, Missing, Damaged},
+	Filling:    {Full, Missing, Damaged},
+
+	// full volumes are reclaimed once emptied
+	Full: {Scratch, Missing, Damaged},
+
+	// missing volumes resume their previous life cycle when found
+	Missing: {Scratch, Allocated, Filling, Full, Cleaning, Expired, Damaged},
+
+	// damaged volumes may be reclaimed after being relabeled
+	Damaged: {Scratch, Missing},
+
+	// cleaning cartridges expire when worn out
+	Cleaning: {Expired, Missing, Damaged},
+	Expired:  {Missing},
+}
+
+// CheckTransition returns an error of kind errors.Invalid if a volume may not
+// move from one category to the other.
+func CheckTransition(from, to VolumeCategory) error {
+	if from == to {
+		return nil
+	}
+
+	for _, cat := range transitions[from] {
+		if cat == to {
+			return nil
+		}
+	}
+
+	return errors.E(errors.Invalid, errors.Strf("illegal volume transition from %v to %v", from, to))
+}
+
+// A Transition records a change of category of a volume.
+type Transition struct {
+	// Serial is the serial of the volume.
+	Serial Serial
+
+	// From and To are the categories before and after the transition.
+	From, To VolumeCategory
+
+	// Time is the time of the transition.
+	Time time.Time
+
+	// Reason describes why the transition took place.
+	Reason string
+}
+
+func (t Transition) String() string {
+	return fmt.Sprintf("[%v %v -> %v (%s)]", t.Serial, t.From, t.To, t.Reason)
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 Klaus Birkelund Abildgaard Jensen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tape_test
+
+import (
+	"testing"
+
+	"tapr.space/errors"
+	"tapr.space/store/tape"
+)
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		from, to tape.VolumeCategory
+		ok       bool
+	}{
+		// life cycle of a data volume
+		{tape.UnknownVolume, tape.Scratch, true},
+		{tape.Scratch, tape.Allocating, true},
+		{tape.Allocating, tape.Allocated, true},
+		{tape.Allocating, tape.Scratch, true},
+		{tape.Allocated, tape.Filling, true},
+		{tape.Allocated, tape.Scratch, true},
+		{tape.Filling, tape.Full, true},
+		{tape.Full, tape.Scratch, true},
+
+		// life cycle of a cleaning cartridge
+		{tape.UnknownVolume, tape.Cleaning, true},
+		{tape.Cleaning, tape.Expired, true},
+
+		// volumes may go missing or be damaged and be found or relabeled
+		{tape.Filling, tape.Missing, true},
+		{tape.Missing, tape.Filling, true},
+		{tape.Missing, tape.Cleaning, true},
+		{tape.Allocated, tape.Damaged, true},
+		{tape.Damaged, tape.Scratch, true},
+		{tape.Expired, tape.Missing, true},
+
+		// staying put is always allowed
+		{tape.Full, tape.Full, true},
+		{tape.Expired, tape.Expired, true},
+
+		// skipping steps
+		{tape.Scratch, tape.Allocated, false},
+		{tape.Scratch, tape.Filling, false},
+		{tape.Allocated, tape.Full, false},
+		{tape.UnknownVolume, tape.Allocated, false},
+
+		// going back
+		{tape.Filling, tape.Allocated, false},
+		{tape.Full, tape.Filling, false},
+		{tape.Filling, tape.Scratch, false},
+
+		// data volumes and cleaning cartridges do not mix
+		{tape.Scratch, tape.Cleaning, false},
+		{tape.Cleaning, tape.Scratch, false},
+		{tape.Full, tape.Expired, false},
+
+		// expired cartridges and damaged volumes are not reused as is
+		{tape.Expired, tape.Cleaning, false},
+		{tape.Expired, tape.Scratch, false},
+		{tape.Damaged, tape.Allocating, false},
+
+		// nothing becomes unknown again
+		{tape.Scratch, tape.UnknownVolume, false},
+		{tape.Missing, tape.UnknownVolume, false},
+	}
+
+	for _, tt := range tests {
+		err := tape.CheckTransition(tt.from, tt.to)
+
+		switch {
+		case tt.ok && err != nil:
+			t.Errorf("%v -> %v: unexpected error: %v", tt.from, tt.to, err)
+		case !tt.ok && err == nil:
+			t.Errorf("%v -> %v: expected an error", tt.from, tt.to)
+		case !tt.ok && !errors.Is(errors.Invalid, err):
+			t.Errorf("%v -> %v: got %v, want an error of kind %v", tt.from, tt.to, err, errors.Invalid)
+		}
+	}
+}
@@ -39,6 +39,7 @@ const (
 	Missing
 	Damaged
 	Cleaning
+	Expired
 )
 
 const (
@@ -54,6 +55,10 @@ const (
 
 	// StatusFormatted is set when the volume has already been formatted
 	StatusFormatted
+
+	// StatusOffsite is set when the volume has been exported from the
+	// library.
+	StatusOffsite
 )
 
 // FormatVolumeFlags formats the flags for human consumption.
@@ -75,6 +80,10 @@ func FormatVolumeFlags(f uint32) string {
 		out = append(out, "formatted")
 	}
 
+	if bitmask.IsSet(f, StatusOffsite) {
+		out = append(out, "offsite")
+	}
+
 	str := strings.Join(out, ",")
 	if str == "" {
 		str = "none"
@@ -99,6 +108,11 @@ type Volume struct {
 
 	// Flags are contains temporary info on the volume.
 	Flags uint32
+
+	// Mounts is the number of times the volume has been loaded into a
+	// drive. For cleaning cartridges, this is the number of cleanings
+	// performed.
+	Mounts int
 }
 
 func (v *Volume) String() string {
@@ -128,6 +142,8 @@ func (cat VolumeCategory) String() string {
 		return "damaged"
 	case Cleaning:
 		return "cleaning"
+	case Expired:
+		return "expired"
 	}
 
 	panic("unknown volume category")
@@ -154,6 +170,8 @@ func ToVolumeCategory(str string) VolumeCategory {
 		return Damaged
 	case "cleaning":
 		return Cleaning
+	case "expired":
+		return Expired
 	default:
 		panic("unknown volume category")
 	}
@@ -19,6 +19,7 @@ package tapr // import "tapr.space"
 
 import (
 	"io"
+	"os"
 	"time"
 )
 
@@ -66,11 +67,69 @@ type Client interface {
 
 	// Stat retrieves basic file info.
 	Stat(name PathName) (*FileInfo, error)
+
+	// List lists the named file or the entries of the named directory. If
+	// recursive is true, the entries of subdirectories are listed as well.
+	// If glob is not empty, only entries with base names matching the
+	// pattern (as interpreted by path.Match) are returned.
+	List(prefix PathName, recursive bool, glob string) ([]*FileInfo, error)
+
+	// Remove removes the named file or (empty) directory.
+	Remove(name PathName) error
+
+	// Rename renames (moves) a file or directory.
+	Rename(oldname, newname PathName) error
 }
 
 // A FileInfo describes a file.
 type FileInfo struct {
-	Size int64
+	// Name is the full path name of the file.
+	Name PathName
+
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	IsDir   bool
+
+	// Checksum is the SHA-256 digest of the file contents (if known).
+	Checksum []byte
+
+	// Residency tells how readily the file can be read.
+	Residency Residency
+
+	// Volumes are the serials of the volumes holding the file (if stored on
+	// tape).
+	Volumes []string
+}
+
+// Residency describes how readily the data of a file can be accessed.
+type Residency int
+
+const (
+	// Online files can be read right away, for instance from disk or from
+	// a mounted volume.
+	Online Residency = iota
+
+	// Nearline files are stored on volumes that must be mounted by a media
+	// changer before they can be read.
+	Nearline
+
+	// Offline files are stored on volumes outside of the library that must
+	// be imported by an operator before they can be read.
+	Offline
+)
+
+func (r Residency) String() string {
+	switch r {
+	case Online:
+		return "online"
+	case Nearline:
+		return "nearline"
+	case Offline:
+		return "offline"
+	}
+
+	return "unknown"
 }
 
 // A NetAddr is the network address of service. It is interpreted by Dialer's